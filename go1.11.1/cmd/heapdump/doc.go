@@ -0,0 +1,72 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+
+Heapdump analyzes heap dumps written by runtime/debug.WriteHeapDump.
+
+Usage:
+
+	go tool heapdump <command> [flags] <dump> [<dump>]
+
+The commands are:
+
+	types   list the kinds of object in the heap, by retained size
+	top     list the objects and roots that retain the most memory
+	path    print a shortest path from a root to an object
+	diff    compare the kinds of object in two dumps
+
+The retained size of an object is the total size of the objects that
+would be freed if it were: the objects it dominates in the object
+graph, itself included. Objects are only reachable through a root: a
+global variable, a stack frame, a finalizer or a pointer held by the
+runtime.
+
+Objects are grouped by type if the program ran with
+GODEBUG=heaptypes=1, which records the type of each object, with
+objects allocated as arrays of a type T listed as []T. Otherwise, and
+for objects allocated before the setting took effect at startup,
+objects are grouped by size and pointer layout, such as
+
+	64 bytes ptr@0,16
+
+for 64-byte objects with pointers at offsets 0 and 16. Objects sampled
+by the heap profile are also listed with their allocation stack by
+top.
+
+Dumps written by runtime/debug.WriteHeapDumpSnapshot, which does not
+stop the program while it writes the dump, are read the same way.
+
+Types
+
+	go tool heapdump types [-n count] dump
+
+Types prints, for the kinds of object with the largest retained size,
+the number of objects, their size and the size they retain. The
+retained size of a kind of object only counts the objects that are not
+dominated by another object of the same kind, so that a linked list
+is not counted many times.
+
+Top
+
+	go tool heapdump top [-n count] dump
+
+Top prints the objects and the roots that retain the most memory.
+
+Path
+
+	go tool heapdump path dump address
+
+Path prints a shortest path from a root to the object containing the
+address, one pointer per line, and the object's immediate dominator.
+
+Diff
+
+	go tool heapdump diff [-n count] old new
+
+Diff prints the kinds of object whose total size changed the most
+between two dumps of the same program.
+
+*/
+package main
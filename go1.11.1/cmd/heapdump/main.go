@@ -0,0 +1,279 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"runtime/debug/heapdump"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: go tool heapdump <command> [flags] <dump> [<dump>]
+
+Commands:
+	types [-n count] dump      kinds of object by retained size
+	top [-n count] dump        objects and roots by retained size
+	path dump address          shortest path from a root to an object
+	diff [-n count] old new    change in the kinds of object
+
+See 'go doc cmd/heapdump' for details.
+`)
+	os.Exit(2)
+}
+
+var commands = map[string]func(args []string){
+	"types": types,
+	"top":   top,
+	"path":  path,
+	"diff":  diff,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("heapdump: ")
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	cmd(os.Args[2:])
+}
+
+// parseFlags parses the flags of a command, which takes nargs dumps
+// or other arguments, and returns the -n flag and the arguments.
+func parseFlags(name string, args []string, nargs int) (int, []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = usage
+	n := fs.Int("n", 20, "number of entries to print")
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		usage()
+	}
+	return *n, fs.Args()
+}
+
+func readDump(name string) (*heapdump.Dump, *heapdump.Graph) {
+	d, err := heapdump.ReadFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d, heapdump.NewGraph(d)
+}
+
+// maxClassFields is the number of pointer offsets spelled out in the
+// kind of an object. Longer layouts are abbreviated with a hash.
+const maxClassFields = 8
+
+// class returns the kind of an object: its type if known, and
+// otherwise its size and pointer layout.
+func class(o *heapdump.Object) string {
+	if name := o.TypeName(); name != "" {
+		return name
+	}
+	if len(o.Fields) == 0 {
+		return fmt.Sprintf("%d bytes noscan", o.Size())
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d bytes ptr@", o.Size())
+	h := fnv.New32a()
+	for i, f := range o.Fields {
+		off := strconv.FormatUint(f.Offset, 10)
+		io.WriteString(h, off+",")
+		if i < maxClassFields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(off)
+		}
+	}
+	if len(o.Fields) > maxClassFields {
+		fmt.Fprintf(&b, ",... (%d pointers, layout %08x)", len(o.Fields), h.Sum32())
+	}
+	return b.String()
+}
+
+type classStats struct {
+	name     string
+	count    int
+	bytes    uint64
+	retained uint64
+}
+
+// classes returns the statistics of the kinds of object in the
+// reachable heap.
+func classes(d *heapdump.Dump, g *heapdump.Graph) map[string]*classStats {
+	m := make(map[string]*classStats)
+	names := make(map[*heapdump.Object]string)
+	for _, o := range d.Objects {
+		if !g.Reachable(o) {
+			continue
+		}
+		name := class(o)
+		names[o] = name
+		c := m[name]
+		if c == nil {
+			c = &classStats{name: name}
+			m[name] = c
+		}
+		c.count++
+		c.bytes += o.Size()
+	}
+	for o, name := range names {
+		if _, dom := g.Dominator(o); dom == nil || names[dom] != name {
+			m[name].retained += g.Retained(o)
+		}
+	}
+	return m
+}
+
+func types(args []string) {
+	n, args := parseFlags("types", args, 1)
+	d, g := readDump(args[0])
+	var list []*classStats
+	for _, c := range classes(d, g) {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].retained != list[j].retained {
+			return list[i].retained > list[j].retained
+		}
+		return list[i].name < list[j].name
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "count\tbytes\tretained\t  kind")
+	for _, c := range list {
+		fmt.Fprintf(w, "%d\t%d\t%d\t  %s\n", c.count, c.bytes, c.retained, c.name)
+	}
+	w.Flush()
+}
+
+func top(args []string) {
+	n, args := parseFlags("top", args, 1)
+	d, g := readDump(args[0])
+
+	roots := append([]*heapdump.Root(nil), g.Roots...)
+	sort.SliceStable(roots, func(i, j int) bool { return g.RootRetained(roots[i]) > g.RootRetained(roots[j]) })
+	if len(roots) > n {
+		roots = roots[:n]
+	}
+	fmt.Println("roots:")
+	for _, r := range roots {
+		fmt.Printf("%12d  %s\n", g.RootRetained(r), r.Name)
+	}
+
+	var objs []*heapdump.Object
+	for _, o := range d.Objects {
+		if g.Reachable(o) {
+			objs = append(objs, o)
+		}
+	}
+	sort.SliceStable(objs, func(i, j int) bool { return g.Retained(objs[i]) > g.Retained(objs[j]) })
+	if len(objs) > n {
+		objs = objs[:n]
+	}
+	fmt.Println("\nobjects:")
+	for _, o := range objs {
+		fmt.Printf("%12d  %#x  %s\n", g.Retained(o), o.Addr, class(o))
+		if a := o.Alloc; a != nil {
+			for _, l := range a.Stack {
+				fmt.Printf("%16s%s\n%20s%s:%d\n", "", l.Func, "", l.File, l.Line)
+			}
+		}
+	}
+}
+
+func path(args []string) {
+	_, args = parseFlags("path", args, 2)
+	d, g := readDump(args[0])
+	addr, err := strconv.ParseUint(args[1], 0, 64)
+	if err != nil {
+		log.Fatalf("bad address %s", args[1])
+	}
+	o := d.FindObject(addr)
+	if o == nil {
+		log.Fatalf("no object at %#x", addr)
+	}
+	root, steps := g.Path(o)
+	if root == nil {
+		log.Fatalf("object %#x is unreachable", o.Addr)
+	}
+	fmt.Println(root.Name)
+	for _, s := range steps {
+		fmt.Printf("\t+%d -> %#x  %s\n", s.Offset, s.Object.Addr, class(s.Object))
+	}
+	switch r, dom := g.Dominator(o); {
+	case dom != nil:
+		fmt.Printf("immediate dominator: %#x  %s\n", dom.Addr, class(dom))
+	case r != nil:
+		fmt.Printf("immediate dominator: %s\n", r.Name)
+	default:
+		fmt.Println("immediate dominator: none, reachable from several roots")
+	}
+}
+
+func diff(args []string) {
+	n, args := parseFlags("diff", args, 2)
+	d0, g0 := readDump(args[0])
+	old := classes(d0, g0)
+	d1, g1 := readDump(args[1])
+	cur := classes(d1, g1)
+
+	type delta struct {
+		name               string
+		count, bytes       int64
+		oldBytes, newBytes uint64
+	}
+	var list []delta
+	for name, c := range cur {
+		o := old[name]
+		if o == nil {
+			o = &classStats{}
+		}
+		list = append(list, delta{name, int64(c.count - o.count), int64(c.bytes) - int64(o.bytes), o.bytes, c.bytes})
+	}
+	for name, o := range old {
+		if cur[name] == nil {
+			list = append(list, delta{name, -int64(o.count), -int64(o.bytes), o.bytes, 0})
+		}
+	}
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if a, b := abs(list[i].bytes), abs(list[j].bytes); a != b {
+			return a > b
+		}
+		return list[i].name < list[j].name
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Δcount\tΔbytes\told bytes\tnew bytes\t  kind")
+	for _, c := range list {
+		if c.bytes == 0 && c.count == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%+d\t%+d\t%d\t%d\t  %s\n", c.count, c.bytes, c.oldBytes, c.newBytes, c.name)
+	}
+	w.Flush()
+}
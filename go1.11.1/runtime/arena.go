@@ -0,0 +1,304 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// User arenas (experimental).
+//
+// A user arena, runtime/debug.Arena, is a region of memory from which a
+// program allocates objects that it frees all at once. Objects are
+// allocated by bumping a pointer through chunks, which are manually
+// managed spans from mheap_.allocManual, so no object is ever swept.
+//
+// Arena memory is not part of the garbage-collected heap, but it may
+// hold pointers into the heap. So that the GC can find them, allocation
+// writes the heap bitmap for each object with heapBitsSetType, as
+// mallocgc does, and the chunks of all live arenas are scanned once per
+// cycle by a root job (markrootUserArenas), like the data and BSS
+// segments. Writes to arena memory after it has been scanned go through
+// the write barrier: bulkBarrierPreWrite uses the heap bitmap of arena
+// chunks, which it ignores for other manual spans. Pointers into arenas
+// are not followed by the GC: an arena stays allocated until freed.
+//
+// Freeing an arena remaps its chunks PROT_NONE, so that any later use
+// of an object in it faults rather than reading or corrupting memory
+// that has been reused, and releases the pages of their heap bitmap.
+// The faulting chunks are kept in a quarantine of up to
+// userArenaQuarantineBytes. Beyond that, the oldest chunks are mapped
+// again and returned to the heap with their spans, so that programs
+// that free many arenas don't run out of address space; a use after
+// free of an object in them is then no longer caught. Where physical
+// pages are larger than the runtime's pages, chunks cannot be remapped
+// on their own and are returned to the heap right away.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// userArenaChunkBytes is the size of the chunks objects are allocated
+// from. Larger objects get a chunk of their own.
+const userArenaChunkBytes = 1 << 20
+
+// userArenaQuarantineBytes is the size of freed chunks kept faulting
+// before their address space is reused.
+const userArenaQuarantineBytes = 64 << 20
+
+// userArena is the runtime side of a runtime/debug.Arena. It is not
+// safe for concurrent use.
+type userArena struct {
+	chunks []*mspan // all chunks, the current one last
+	off    uintptr  // offset of the free space in the current chunk
+	freed  bool
+}
+
+var userArenas struct {
+	lock mutex
+	live mSpanList // chunks of all live arenas
+
+	// quarantine holds freed chunks, oldest first, and quarantined
+	// is their total size. Protected by lock.
+	quarantine  mSpanList
+	quarantined uintptr
+
+	// allocated is the total size of all chunks not returned to
+	// the heap. Protected by mheap_.lock.
+	allocated uint64
+}
+
+//go:linkname newUserArena runtime/debug.newUserArena
+func newUserArena() unsafe.Pointer {
+	return unsafe.Pointer(new(userArena))
+}
+
+// userArenaNew allocates a zeroed object of the type typ points to.
+// typ must be a nil pointer of type *T. The result is a *T.
+//
+//go:linkname userArenaNew runtime/debug.userArenaNew
+func userArenaNew(a unsafe.Pointer, typ interface{}) (r interface{}) {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindPtr {
+		panic(plainError("arena: New of a non-pointer type"))
+	}
+	p := (*userArena)(a).alloc((*ptrtype)(unsafe.Pointer(t)).elem, 1)
+	e := efaceOf(&r)
+	e._type = t
+	e.data = p
+	return
+}
+
+// userArenaMakeSlice allocates a zeroed slice of the type of typ with
+// the given length and capacity.
+//
+//go:linkname userArenaMakeSlice runtime/debug.userArenaMakeSlice
+func userArenaMakeSlice(a unsafe.Pointer, typ interface{}, len, cap int) (r interface{}) {
+	t := efaceOf(&typ)._type
+	if t == nil || t.kind&kindMask != kindSlice {
+		panic(plainError("arena: MakeSlice of a non-slice type"))
+	}
+	if len < 0 || len > cap {
+		panic(plainError("arena: MakeSlice: len out of range"))
+	}
+	ua := (*userArena)(a)
+	elem := (*slicetype)(unsafe.Pointer(t)).elem
+	if elem.size != 0 && uintptr(cap) > maxAlloc/elem.size {
+		panic(plainError("arena: MakeSlice: cap out of range"))
+	}
+	// The slice header goes in the arena too.
+	s := (*slice)(ua.alloc(t, 1))
+	*s = slice{ua.alloc(elem, uintptr(cap)), len, cap}
+	e := efaceOf(&r)
+	e._type = t
+	e.data = unsafe.Pointer(s)
+	return
+}
+
+// alloc returns n zeroed values of type typ.
+func (a *userArena) alloc(typ *_type, n uintptr) unsafe.Pointer {
+	if a.freed {
+		panic(plainError("arena: use of freed arena"))
+	}
+	dataSize := typ.size * n
+	if dataSize == 0 {
+		return unsafe.Pointer(&zerobase)
+	}
+	size, align := dataSize, uintptr(sys.PtrSize)
+	if typ.kind&kindNoPointers == 0 {
+		// heapBitsSetType expects objects of at least two
+		// words, aligned to two words, like the smallest size
+		// classes with pointers.
+		size, align = round(size, 2*sys.PtrSize), 2*sys.PtrSize
+	}
+	off := round(a.off, align)
+	if len(a.chunks) == 0 || off+size > a.chunks[len(a.chunks)-1].npages<<_PageShift {
+		a.grow(size)
+		off = 0
+	}
+	s := a.chunks[len(a.chunks)-1]
+	p := s.base() + off
+	a.off = off + size
+	if typ.kind&kindNoPointers == 0 {
+		if arenaIndex(p+size-1) != arenaIndex(p) {
+			// heapBitsSetType builds the bitmap of objects
+			// that span heap arenas in the object itself.
+			// Don't let the GC scan it meanwhile.
+			lock(&userArenas.lock)
+			heapBitsSetType(p, size, dataSize, typ)
+			unlock(&userArenas.lock)
+		} else {
+			heapBitsSetType(p, size, dataSize, typ)
+		}
+		// The GC may scan the chunk at any time. Make the
+		// bitmap visible before the object can be written.
+		publicationBarrier()
+	}
+	return unsafe.Pointer(p)
+}
+
+// grow adds a chunk that can hold at least size bytes.
+func (a *userArena) grow(size uintptr) {
+	npages := uintptr(userArenaChunkBytes) >> _PageShift
+	if size > userArenaChunkBytes {
+		npages = round(size, _PageSize) >> _PageShift
+	}
+	var s *mspan
+	systemstack(func() {
+		s = mheap_.allocManual(npages, &userArenas.allocated)
+		if s == nil {
+			throw("out of memory allocating arena chunk")
+		}
+		base, nbytes := s.base(), npages<<_PageShift
+		// Chunks are off-heap memory of the runtime.
+		mSysStatInc(&memstats.other_sys, nbytes)
+		if s.needzero != 0 {
+			memclrNoHeapPointers(unsafe.Pointer(base), nbytes)
+			s.needzero = 0
+		}
+		// Mark the whole chunk scalar, as initSpan does for
+		// heap spans.
+		h := heapBitsForAddr(base)
+		for nw := nbytes / sys.PtrSize; nw > 0; {
+			hNext, anw := h.forwardOrBoundary(nw)
+			memclrNoHeapPointers(unsafe.Pointer(h.bitp), anw/wordsPerBitmapByte)
+			h = hNext
+			nw -= anw
+		}
+		s.userArena = true
+
+		lock(&userArenas.lock)
+		userArenas.live.insert(s)
+		unlock(&userArenas.lock)
+	})
+	a.chunks = append(a.chunks, s)
+}
+
+// freeUserArena frees all objects allocated from a. Later uses of them
+// fault.
+//
+//go:linkname freeUserArena runtime/debug.freeUserArena
+func freeUserArena(a unsafe.Pointer) {
+	ua := (*userArena)(a)
+	if ua.freed {
+		panic(plainError("arena: double free"))
+	}
+	ua.freed = true
+	chunks := ua.chunks
+	ua.chunks = nil
+	systemstack(func() {
+		// Once the chunks are off the live list, the GC no
+		// longer scans them.
+		lock(&userArenas.lock)
+		for _, s := range chunks {
+			userArenas.live.remove(s)
+		}
+		unlock(&userArenas.lock)
+
+		if physPageSize > _PageSize {
+			for _, s := range chunks {
+				userArenaRecycle(s)
+			}
+			return
+		}
+		for _, s := range chunks {
+			nbytes := s.npages << _PageShift
+			sysFault(unsafe.Pointer(s.base()), nbytes)
+			mSysStatDec(&memstats.other_sys, nbytes)
+			userArenaReleaseBitmap(s)
+		}
+
+		lock(&userArenas.lock)
+		for _, s := range chunks {
+			userArenas.quarantine.insertBack(s)
+			userArenas.quarantined += s.npages << _PageShift
+		}
+		for userArenas.quarantined > userArenaQuarantineBytes {
+			s := userArenas.quarantine.first
+			userArenas.quarantine.remove(s)
+			nbytes := s.npages << _PageShift
+			userArenas.quarantined -= nbytes
+			unlock(&userArenas.lock)
+			// Map the pages again. The heap accounts them
+			// in heap_sys once it has them back.
+			sysMap(unsafe.Pointer(s.base()), nbytes, &memstats.other_sys)
+			userArenaRecycle(s)
+			lock(&userArenas.lock)
+		}
+		unlock(&userArenas.lock)
+	})
+}
+
+// userArenaReleaseBitmap releases the physical pages of the heap
+// bitmap of chunk s, which is no longer used. The bitmap reads as zero,
+// that is scalar, afterwards. Pages shared with the bitmap of other
+// spans are kept.
+func userArenaReleaseBitmap(s *mspan) {
+	h := heapBitsForAddr(s.base())
+	for nw := s.npages << _PageShift / sys.PtrSize; nw > 0; {
+		hNext, anw := h.forwardOrBoundary(nw)
+		start := round(uintptr(unsafe.Pointer(h.bitp)), physPageSize)
+		end := (uintptr(unsafe.Pointer(h.bitp)) + anw/wordsPerBitmapByte) &^ (physPageSize - 1)
+		if end > start {
+			sysUnused(unsafe.Pointer(start), end-start)
+		}
+		h = hNext
+		nw -= anw
+	}
+}
+
+// userArenaRecycle returns chunk s, which must be mapped, to the heap.
+// The heap takes over the accounting of its memory from other_sys and
+// frees the span.
+func userArenaRecycle(s *mspan) {
+	mSysStatDec(&memstats.other_sys, s.npages<<_PageShift)
+	s.userArena = false
+	mheap_.freeManual(s, &userArenas.allocated)
+}
+
+// markrootUserArenas scans the chunks of all live user arenas.
+//
+//go:nowritebarrier
+func markrootUserArenas(gcw *gcWork) {
+	lock(&userArenas.lock)
+	for s := userArenas.live.first; s != nil; s = s.next {
+		b, n := s.base(), s.npages<<_PageShift
+		h := heapBitsForAddr(b)
+		for i := uintptr(0); i < n; i += sys.PtrSize {
+			if i != 0 {
+				h = h.next()
+			}
+			if !h.isPointer() {
+				continue
+			}
+			p := *(*uintptr)(unsafe.Pointer(b + i))
+			if p == 0 {
+				continue
+			}
+			if obj, span, objIndex := findObject(p, b, i); obj != 0 {
+				greyobject(obj, b, i, span, gcw, objIndex)
+			}
+		}
+		gcw.scanWork += int64(n)
+	}
+	unlock(&userArenas.lock)
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+type arenaNode struct {
+	id    int
+	heap  *[64]byte // points into the heap
+	next  *arenaNode
+	bytes []byte
+}
+
+func TestArena(t *testing.T) {
+	a := debug.NewArena()
+	defer a.Free()
+
+	// Heap objects only reachable from the arena must survive
+	// collections.
+	finalized := make(chan int, 100)
+	var head *arenaNode
+	for i := 0; i < 100; i++ {
+		n := a.New((*arenaNode)(nil)).(*arenaNode)
+		if n.id != 0 || n.heap != nil || n.next != nil {
+			t.Fatalf("arena object not zeroed: %+v", *n)
+		}
+		n.id = i
+		n.heap = new([64]byte)
+		n.heap[0] = byte(i)
+		id := i
+		runtime.SetFinalizer(n.heap, func(*[64]byte) { finalized <- id })
+		n.bytes = a.MakeSlice([]byte(nil), 10, 20).([]byte)
+		n.next = head
+		head = n
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	select {
+	case id := <-finalized:
+		t.Fatalf("object %d referenced from the arena was collected", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+	i := 99
+	for n := head; n != nil; n = n.next {
+		if n.id != i || n.heap[0] != byte(i) || len(n.bytes) != 10 || cap(n.bytes) != 20 {
+			t.Fatalf("arena object %d corrupted", i)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("arena list ended at %d", i)
+	}
+
+	// Large objects get their own chunk.
+	big := a.MakeSlice([]*arenaNode(nil), 1<<18, 1<<18).([]*arenaNode)
+	big[len(big)-1] = head
+	runtime.GC()
+	if big[len(big)-1].id != 99 {
+		t.Fatalf("large arena slice corrupted")
+	}
+}
+
+func TestArenaUseAfterFree(t *testing.T) {
+	a := debug.NewArena()
+	n := a.New((*arenaNode)(nil)).(*arenaNode)
+	n.id = 1
+	a.Free()
+
+	// The object's memory is mapped PROT_NONE, so this faults.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	var read int
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("reading a freed arena object did not fault; read %d", read)
+			}
+		}()
+		read = n.id
+	}()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("writing a freed arena object did not fault")
+			}
+		}()
+		n.id = 2
+	}()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("allocating from a freed arena did not panic")
+			}
+		}()
+		a.New((*arenaNode)(nil))
+	}()
+}
+
+func TestArenaFreeBounded(t *testing.T) {
+	// Each arena gets a chunk of its own. Free enough of them for
+	// the quarantine to fill, then many times more.
+	const chunk = 1 << 20
+	round := func() {
+		for i := 0; i < 2*runtime.UserArenaQuarantineBytes/chunk; i++ {
+			a := debug.NewArena()
+			a.New((*arenaNode)(nil))
+			a.Free()
+		}
+	}
+	round()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	// Without reuse, this would take 2GB of address space, and
+	// the heap metadata for it.
+	for i := 0; i < 16; i++ {
+		round()
+	}
+	runtime.ReadMemStats(&after)
+
+	const slack = 32 << 20
+	for _, s := range []struct {
+		name          string
+		before, after uint64
+	}{
+		{"HeapSys", before.HeapSys, after.HeapSys},
+		{"OtherSys", before.OtherSys, after.OtherSys},
+		{"GCSys", before.GCSys, after.GCSys},
+		{"Sys", before.Sys, after.Sys},
+	} {
+		if s.after > s.before+slack {
+			t.Errorf("%s grew from %d to %d freeing arenas", s.name, s.before, s.after)
+		}
+	}
+}
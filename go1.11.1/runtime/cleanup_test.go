@@ -0,0 +1,159 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cleanupObj struct {
+	id   int
+	next *cleanupObj
+}
+
+func waitCleanups(t *testing.T, ch chan int, n int) []int {
+	var got []int
+	for len(got) < n {
+		select {
+		case v := <-ch:
+			got = append(got, v)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d cleanups, want %d", len(got), n)
+		}
+	}
+	return got
+}
+
+func TestCleanup(t *testing.T) {
+	ch := make(chan int, 10)
+	p := &cleanupObj{id: 1}
+	w := debug.MakeWeak(p)
+	// The object is already freed when its cleanups run.
+	cleanup := func(arg interface{}) {
+		if w.Value().(*cleanupObj) != nil {
+			t.Errorf("object still reachable in its cleanup")
+		}
+		ch <- arg.(int)
+	}
+	for i := 0; i < 3; i++ {
+		runtime.AddCleanup(p, cleanup, i)
+	}
+	stopped := runtime.AddCleanup(p, cleanup, 100)
+	stopped.Stop()
+	stopped.Stop()
+	p = nil
+	runtime.GC()
+	got := waitCleanups(t, ch, 3)
+	seen := make(map[int]bool)
+	for _, v := range got {
+		if v == 100 || seen[v] {
+			t.Errorf("unexpected cleanup %d, got %v", v, got)
+		}
+		seen[v] = true
+	}
+	select {
+	case v := <-ch:
+		t.Errorf("unexpected cleanup %d", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCleanupCycle(t *testing.T) {
+	// A cycle of objects with cleanups is collected in one cycle.
+	ch := make(chan int, 2)
+	a, b := &cleanupObj{id: 1}, &cleanupObj{id: 2}
+	a.next, b.next = b, a
+	runtime.AddCleanup(a, func(arg interface{}) { ch <- arg.(int) }, a.id)
+	runtime.AddCleanup(b, func(arg interface{}) { ch <- arg.(int) }, b.id)
+	a, b = nil, nil
+	runtime.GC()
+	waitCleanups(t, ch, 2)
+}
+
+func TestCleanupFinalizer(t *testing.T) {
+	// Cleanups of an object with a finalizer run once it is
+	// unreachable after the finalizer.
+	ch := make(chan int, 2)
+	p := &cleanupObj{id: 1}
+	runtime.SetFinalizer(p, func(*cleanupObj) { ch <- 1 })
+	runtime.AddCleanup(p, func(interface{}) { ch <- 2 }, nil)
+	p = nil
+	runtime.GC()
+	if got := waitCleanups(t, ch, 1); got[0] != 1 {
+		t.Fatalf("cleanup ran before the finalizer")
+	}
+	runtime.GC()
+	if got := waitCleanups(t, ch, 1); got[0] != 2 {
+		t.Fatalf("got %d, want the cleanup", got[0])
+	}
+}
+
+func TestCleanupParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	block := make(chan struct{})
+	defer close(block)
+	ch := make(chan int, 10)
+	p := &cleanupObj{id: 1}
+	runtime.AddCleanup(p, func(interface{}) { <-block }, nil)
+	for i := 0; i < 5; i++ {
+		runtime.AddCleanup(&cleanupObj{id: i}, func(arg interface{}) { ch <- arg.(int) }, i)
+	}
+	p = nil
+	runtime.GC()
+	// A blocked cleanup does not hold up the others.
+	waitCleanups(t, ch, 5)
+}
+
+func TestCleanupArgIsPtr(t *testing.T) {
+	p := &cleanupObj{id: 1}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("AddCleanup with arg equal to ptr did not panic")
+		}
+	}()
+	runtime.AddCleanup(p, func(interface{}) {}, p)
+}
+
+func TestCleanupBlockedWorkerHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	// A fresh process has a single worker. Keep it busy in a
+	// cleanup queued by one cycle...
+	runtime.GOMAXPROCS(2)
+	started := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+	runtime.AddCleanup(&cleanupObj{id: 1}, func(interface{}) {
+		close(started)
+		<-block
+	}, nil)
+	runtime.GC()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first cleanup did not run")
+	}
+	// ... and check that one queued by a later cycle still runs.
+	done := make(chan struct{})
+	runtime.AddCleanup(&cleanupObj{id: 2}, func(interface{}) { close(done) }, nil)
+	runtime.GC()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second cleanup did not run while the first is blocked")
+	}
+}
+
+func TestCleanupBlockedWorker(t *testing.T) {
+	out, err := runHelper(t, "TestCleanupBlockedWorkerHelper")
+	if err != nil || !strings.Contains(out, "PASS") {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
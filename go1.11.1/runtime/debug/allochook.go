@@ -0,0 +1,110 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"sync"
+	"time"
+)
+
+// An AllocEventKind is the kind of an AllocEvent.
+type AllocEventKind int
+
+const (
+	// AllocEventDropped reports events dropped because the hook
+	// fell behind.
+	AllocEventDropped AllocEventKind = iota
+
+	// AllocEventAlloc reports a sampled allocation.
+	AllocEventAlloc
+
+	// AllocEventFree reports the free of an object whose allocation
+	// was sampled.
+	AllocEventFree
+)
+
+// An AllocEvent describes a sampled allocation or the free of a
+// sampled object.
+type AllocEvent struct {
+	Kind AllocEventKind
+	Time time.Time
+
+	Addr  uintptr   // address of the object
+	Size  uintptr   // size of the object, rounded up to its size class
+	Type  string    // type of the object, empty if it was allocated without one
+	Stack []uintptr // call stack of an allocation, as for runtime.Callers
+
+	Dropped uint64 // for AllocEventDropped, number of events dropped
+}
+
+var allocHook struct {
+	sync.Mutex
+	f       func(AllocEvent)
+	started bool
+}
+
+// SetAllocHook registers f to be called for allocations sampled on
+// average once every rate bytes, and for the frees of the sampled
+// objects, which are found by the garbage collector. It replaces any
+// hook registered before. A nil f or a rate <= 0 removes the hook.
+// The sampling is independent of runtime.MemProfileRate.
+//
+// f does not run on the goroutine that allocated. It runs on a
+// goroutine of its own, one event at a time, in order, so it may
+// allocate, and its own allocations may be sampled. Events are
+// buffered; if f falls behind and the buffer fills, events are
+// dropped and reported to f as an AllocEventDropped event.
+//
+// The frees of objects sampled before the hook was removed are still
+// reported to a later hook.
+func SetAllocHook(rate int, f func(AllocEvent)) {
+	allocHook.Lock()
+	defer allocHook.Unlock()
+	if f == nil || rate <= 0 {
+		f, rate = nil, 0
+	}
+	allocHook.f = f
+	setAllocHookRate(rate)
+	if f != nil && !allocHook.started {
+		allocHook.started = true
+		go allocHookReader()
+	}
+}
+
+func allocHookReader() {
+	var buf []uint64
+	var types []string
+	for {
+		buf, types = buf[:0], types[:0]
+		readAllocHook(&buf, &types)
+		for _, typ := range types {
+			nstk := int(buf[4])
+			e := AllocEvent{
+				Kind: AllocEventKind(buf[0]),
+				Time: time.Unix(0, int64(buf[1])),
+				Addr: uintptr(buf[2]),
+				Size: uintptr(buf[3]),
+				Type: typ,
+			}
+			stk := buf[5 : 5+nstk]
+			buf = buf[5+nstk:]
+			if e.Kind == AllocEventDropped {
+				e.Dropped = stk[0]
+			} else if len(stk) > 0 {
+				e.Stack = make([]uintptr, len(stk))
+				for i, pc := range stk {
+					e.Stack[i] = uintptr(pc)
+				}
+			}
+
+			allocHook.Lock()
+			f := allocHook.f
+			allocHook.Unlock()
+			if f != nil {
+				f(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+// GoroutineAllocs returns the number of bytes and objects allocated so
+// far by the calling goroutine. To find what a request allocates,
+// call it before and after handling the request, on the goroutine
+// that handles it.
+//
+// Bytes are counted as requested, so they add up to less than
+// runtime.MemStats.TotalAlloc, which counts the memory actually used.
+// Allocations made by the runtime on behalf of a goroutine, for
+// example to grow a map or to convert a value to an interface, are
+// charged to it.
+func GoroutineAllocs() (bytes, objects uint64) {
+	return readGoroutineAllocs()
+}
+
+// LabelAllocs is the allocation count of a set of profiling labels.
+type LabelAllocs struct {
+	// Labels is the label set, formatted as a comma-separated
+	// list of key=value pairs sorted by key, e.g. "a=1,b=2". It is
+	// empty for the record that counts all label sets beyond the
+	// limit.
+	Labels  string
+	Bytes   uint64
+	Objects uint64
+}
+
+// SetLabelAllocAccounting turns accounting of allocations by
+// profiling label set (see runtime/pprof.Do) on or off, and returns
+// the previous setting. The initial setting is off.
+//
+// When it is on, the allocations of every goroutine with profiling
+// labels set are also charged to its label set. A goroutine started
+// by a goroutine with labels is charged to the same label set. Only
+// labels set while accounting is on are accounted, and at most 4096
+// distinct label sets are counted separately; allocations with other
+// label sets are counted together under empty labels. So label sets
+// should not include values unique to one request.
+func SetLabelAllocAccounting(enable bool) bool {
+	return setLabelAllocAccounting(enable)
+}
+
+// ReadLabelAllocs appends to allocs the allocation count of every
+// label set seen while label accounting was on, in no particular
+// order, and returns the extended slice.
+func ReadLabelAllocs(allocs []LabelAllocs) []LabelAllocs {
+	var labels []string
+	var counts []uint64
+	readLabelAllocs(&labels, &counts)
+	for i, l := range labels {
+		allocs = append(allocs, LabelAllocs{l, counts[2*i], counts[2*i+1]})
+	}
+	return allocs
+}
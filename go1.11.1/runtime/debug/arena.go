@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "unsafe"
+
+// An Arena is a region of memory from which objects are allocated and
+// then freed all at once with Free. It suits data with a clear end of
+// life, such as the objects decoded for one request, which would
+// otherwise give the garbage collector many objects to find dead.
+//
+// Objects in an arena may point to objects in the garbage-collected
+// heap and are scanned like heap objects, but the garbage collector
+// does not free them: an Arena keeps all its objects allocated until
+// Free is called, whether or not they are still referenced. After Free,
+// any use of an object from the arena faults.
+//
+// Arenas are experimental. An Arena must not be used by several
+// goroutines at once.
+type Arena struct {
+	a unsafe.Pointer
+}
+
+// NewArena returns a new, empty arena.
+func NewArena() *Arena {
+	return &Arena{newUserArena()}
+}
+
+// New allocates a zeroed value of type T in the arena and returns a
+// pointer to it. typ must be a value of type *T, usually nil:
+//
+//	p := a.New((*T)(nil)).(*T)
+func (a *Arena) New(typ interface{}) interface{} {
+	return userArenaNew(a.a, typ)
+}
+
+// MakeSlice allocates a slice of type []T with the given length and
+// capacity in the arena. typ must be a value of type []T, usually nil:
+//
+//	s := a.MakeSlice([]T(nil), 0, 16).([]T)
+//
+// Appending beyond the capacity allocates the new backing array in the
+// garbage-collected heap, as usual.
+func (a *Arena) MakeSlice(typ interface{}, len, cap int) interface{} {
+	return userArenaMakeSlice(a.a, typ, len, cap)
+}
+
+// Free frees all the objects allocated from the arena. Any later use of
+// them, or of the arena, faults or panics.
+func (a *Arena) Free() {
+	freeUserArena(a.a)
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"runtime"
+	"time"
+)
+
+// FinalizerStats describes the finalizers and cleanups queued and run
+// since the program started.
+type FinalizerStats struct {
+	Queued uint64 // finalizers queued to run, including those run
+	Run    uint64 // finalizers run
+
+	// Longest is the longest time a finalizer has run for, and
+	// LongestFunc the name of its function.
+	Longest     time.Duration
+	LongestFunc string
+
+	// Running is the time the finalizer running now has been
+	// running for, and RunningFunc the name of its function. They
+	// are zero if no finalizer is running.
+	Running     time.Duration
+	RunningFunc string
+
+	CleanupsQueued uint64 // cleanups queued to run, including those run
+	CleanupsRun    uint64 // cleanups run
+}
+
+// Pending returns the number of finalizers waiting to run or running.
+// A growing number means finalizers are not keeping up, and the memory
+// of the objects waiting for them is not freed.
+func (s *FinalizerStats) Pending() uint64 {
+	return s.Queued - s.Run
+}
+
+// CleanupsPending returns the number of cleanups waiting to run or
+// running.
+func (s *FinalizerStats) CleanupsPending() uint64 {
+	return s.CleanupsQueued - s.CleanupsRun
+}
+
+// ReadFinalizerStats reads statistics about the finalizer and cleanup
+// queues into stats. It does not stop the world, so the counts may be
+// slightly inconsistent with each other.
+func ReadFinalizerStats(stats *FinalizerStats) {
+	var r [8]uint64
+	readFinalizerStats(&r)
+	stats.Queued = r[0]
+	stats.Run = r[1]
+	stats.Longest = time.Duration(r[2])
+	stats.LongestFunc = funcName(uintptr(r[3]))
+	stats.CleanupsQueued = r[4]
+	stats.CleanupsRun = r[5]
+	stats.Running = time.Duration(r[6])
+	stats.RunningFunc = funcName(uintptr(r[7]))
+}
+
+func funcName(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if f := runtime.FuncForPC(pc); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// SetFinalizerWarning makes the runtime print a warning, with the name
+// of the finalizer's function and the finalizer goroutine's stack, for
+// every finalizer that runs for longer than d. All finalizers run on a
+// single goroutine, so such a finalizer holds up all the others. A zero
+// d, the initial setting unless the GODEBUG environment variable
+// contains finwarn=<milliseconds>, turns warnings off. It returns the
+// previous setting. Finalizers are checked periodically, so warnings
+// may be printed somewhat after the threshold has passed, or not at
+// all for a finalizer that runs a loop without function calls.
+func SetFinalizerWarning(d time.Duration) time.Duration {
+	return time.Duration(setFinalizerWarning(int64(d)))
+}
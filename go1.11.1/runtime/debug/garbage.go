@@ -0,0 +1,333 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GCStats collect information about recent garbage collections.
+type GCStats struct {
+	LastGC         time.Time       // time of last collection
+	NumGC          int64           // number of garbage collections
+	PauseTotal     time.Duration   // total pause for all collections
+	Pause          []time.Duration // pause history, most recent first
+	PauseEnd       []time.Time     // pause end times history, most recent first
+	PauseQuantiles []time.Duration
+}
+
+// ReadGCStats reads statistics about garbage collection into stats.
+// The number of entries in the pause history is system-dependent;
+// stats.Pause slice will be reused if large enough, reallocated otherwise.
+// ReadGCStats may use the full capacity of the stats.Pause slice.
+// If stats.PauseQuantiles is non-empty, ReadGCStats fills it with quantiles
+// summarizing the distribution of pause time. For example, if
+// len(stats.PauseQuantiles) is 5, it will be filled with the minimum,
+// 25%, 50%, 75%, and maximum pause times.
+func ReadGCStats(stats *GCStats) {
+	// Create a buffer with space for at least two copies of the
+	// pause history tracked by the runtime. One will be returned
+	// to the caller and the other will be used as transfer buffer
+	// for end times history and as a temporary buffer for
+	// computing quantiles.
+	const maxPause = len(((*runtime.MemStats)(nil)).PauseNs)
+	if cap(stats.Pause) < 2*maxPause+3 {
+		stats.Pause = make([]time.Duration, 2*maxPause+3)
+	}
+
+	// readGCStats fills in the pause and end times histories (up to
+	// maxPause entries) and then three more: Unix ns time of last GC,
+	// number of GC, and total pause time in nanoseconds. Here we
+	// depend on the fact that time.Duration's native unit is
+	// nanoseconds, so the pauses and the total pause time do not need
+	// any conversion.
+	readGCStats(&stats.Pause)
+	n := len(stats.Pause) - 3
+	stats.LastGC = time.Unix(0, int64(stats.Pause[n]))
+	stats.NumGC = int64(stats.Pause[n+1])
+	stats.PauseTotal = stats.Pause[n+2]
+	n /= 2 // buffer holds pauses and end times
+	stats.Pause = stats.Pause[:n]
+
+	if cap(stats.PauseEnd) < maxPause {
+		stats.PauseEnd = make([]time.Time, 0, maxPause)
+	}
+	stats.PauseEnd = stats.PauseEnd[:0]
+	for _, ns := range stats.Pause[n : n+n] {
+		stats.PauseEnd = append(stats.PauseEnd, time.Unix(0, int64(ns)))
+	}
+
+	if len(stats.PauseQuantiles) > 0 {
+		if n == 0 {
+			for i := range stats.PauseQuantiles {
+				stats.PauseQuantiles[i] = 0
+			}
+		} else {
+			// There's room for a second copy of the data in stats.Pause.
+			// See the allocation at the top of the function.
+			sorted := stats.Pause[n : n+n]
+			copy(sorted, stats.Pause)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			nq := len(stats.PauseQuantiles) - 1
+			for i := 0; i < nq; i++ {
+				stats.PauseQuantiles[i] = sorted[len(sorted)*i/nq]
+			}
+			stats.PauseQuantiles[nq] = sorted[len(sorted)-1]
+		}
+	}
+}
+
+// SetGCPercent sets the garbage collection target percentage:
+// a collection is triggered when the ratio of freshly allocated data
+// to live data remaining after the previous collection reaches this percentage.
+// SetGCPercent returns the previous setting.
+// The initial setting is the value of the GOGC environment variable
+// at startup, or 100 if the variable is not set.
+// A negative percentage disables garbage collection.
+func SetGCPercent(percent int) int {
+	return int(setGCPercent(int32(percent)))
+}
+
+// FreeOSMemory forces a garbage collection followed by an
+// attempt to return as much memory to the operating system
+// as possible. (Even if this is not called, the runtime gradually
+// returns memory to the operating system in a background task.)
+func FreeOSMemory() {
+	freeOSMemory()
+}
+
+// SetMaxStack sets the maximum amount of memory that
+// can be used by a single goroutine stack.
+// If any goroutine exceeds this limit while growing its stack,
+// the program crashes.
+// SetMaxStack returns the previous setting.
+// The initial setting is 1 GB on 64-bit systems, 250 MB on 32-bit systems.
+//
+// SetMaxStack is useful mainly for limiting the damage done by
+// goroutines that enter an infinite recursion. It only limits future
+// stack growth.
+func SetMaxStack(bytes int) int {
+	return setMaxStack(bytes)
+}
+
+// SetMaxThreads sets the maximum number of operating system
+// threads that the Go program can use. If it attempts to use more than
+// this many, the program crashes.
+// SetMaxThreads returns the previous setting.
+// The initial setting is 10,000 threads.
+//
+// The limit controls the number of operating system threads, not the number
+// of goroutines. A Go program creates a new thread only when a goroutine
+// is ready to run but all the existing threads are blocked in system calls, cgo calls,
+// or are locked to other goroutines due to use of runtime.LockOSThread.
+//
+// SetMaxThreads is useful mainly for limiting the damage done by
+// programs that create an unbounded number of threads. The idea is
+// to take down the program before it takes down the operating system.
+func SetMaxThreads(threads int) int {
+	return setMaxThreads(threads)
+}
+
+// SetPanicOnFault controls the runtime's behavior when a program faults
+// at an unexpected (that is, nil) address. Such faults are typically caused by
+// bugs such as runtime memory corruption, so the default response is to crash
+// the program. Programs working with memory-mapped files or unsafe
+// manipulation of memory may cause faults at non-nil addresses in less
+// dramatic situations; SetPanicOnFault allows such programs to request
+// that the runtime trigger only a panic, not a crash.
+// SetPanicOnFault applies only to the current goroutine.
+// It returns the previous setting.
+func SetPanicOnFault(enabled bool) bool {
+	return setPanicOnFault(enabled)
+}
+
+// WriteHeapDump writes a description of the heap and the objects in
+// it to the given file descriptor.
+//
+// WriteHeapDump suspends the execution of all goroutines until the heap
+// dump is completely written.  Thus, the file descriptor must not be
+// connected to a pipe or socket whose other end is in the same Go
+// process; instead, use a temporary file or network socket.
+//
+// The heap dump format is defined at https://golang.org/s/go15heapdump.
+func WriteHeapDump(fd uintptr)
+
+// SetTraceback sets the amount of detail printed by the runtime in
+// the traceback it prints before exiting due to an unrecovered panic
+// or an internal runtime error.
+// The level argument takes the same values as the GOTRACEBACK
+// environment variable. For example, SetTraceback("all") ensure
+// that the program prints all goroutines when it crashes.
+// See the package runtime documentation for details.
+// If SetTraceback is called with a level lower than that of the
+// environment variable, the call is ignored.
+func SetTraceback(level string)
+
+// SetMemoryLimit sets a soft limit on the memory used by the runtime
+// and returns the previous limit. A negative limit does not change
+// the limit and can be used to read it. The initial setting is
+// math.MaxInt64, meaning no limit, unless the GOMEMLIMIT environment
+// variable is set.
+//
+// The limit covers the Go heap and all other memory mapped by the
+// runtime, such as goroutine stacks and runtime metadata, but not
+// memory returned to the operating system or mapped outside the Go
+// runtime, for example by C code. It is the sum of
+// runtime.MemStats.Sys, less HeapReleased.
+//
+// The limit is soft: no allocation fails because of it. As memory use
+// approaches the limit, the garbage collector runs more often than
+// the GC percentage calls for, and free memory is returned to the
+// operating system sooner. This applies even when the garbage
+// collector is otherwise disabled with SetGCPercent(-1). If the live
+// heap does not fit under the limit, the garbage collector does not
+// use more than about half of the CPU to try to make it fit, and
+// memory use exceeds the limit instead.
+func SetMemoryLimit(limit int64) int64 {
+	return setMemoryLimit(limit)
+}
+
+// GCCycle describes a completed garbage collection cycle. It holds the
+// information printed by GODEBUG=gctrace=1 and a little more.
+type GCCycle struct {
+	Num    uint32 // cycle number, as in runtime.MemStats.NumGC
+	Forced bool   // cycle was forced by the application
+	Procs  int    // GOMAXPROCS during the cycle
+
+	Start time.Time     // start of the cycle
+	End   time.Time     // end of the cycle
+	Pause time.Duration // total stop-the-world pause
+
+	// Wall-clock durations of the phases: stop-the-world sweep
+	// termination, concurrent mark and scan, and stop-the-world
+	// mark termination.
+	SweepTerm time.Duration
+	Mark      time.Duration
+	MarkTerm  time.Duration
+
+	// CPU time spent in the phases. Mark CPU time is broken down
+	// into time spent in mutator assists, in dedicated and
+	// fractional background mark workers, and in idle mark
+	// workers. AssistCPUPerP breaks down AssistCPU by P.
+	SweepTermCPU  time.Duration
+	AssistCPU     time.Duration
+	BackgroundCPU time.Duration
+	IdleCPU       time.Duration
+	MarkTermCPU   time.Duration
+	AssistCPUPerP []time.Duration
+
+	// Heap sizes: at the start of the cycle, at the start of mark
+	// termination, and the heap marked live; and the goal heap
+	// size for the cycle.
+	HeapStart uint64
+	HeapEnd   uint64
+	HeapLive  uint64
+	HeapGoal  uint64
+
+	// TriggerRatio is the heap growth over HeapLive, as a
+	// fraction, at which the pacer chose to start the next cycle.
+	TriggerRatio float64
+
+	// GCCPUFraction is runtime.MemStats.GCCPUFraction at the end of
+	// the cycle.
+	GCCPUFraction float64
+}
+
+// gcCycleFields is the number of fixed fields in a record filled in by
+// readGCCycles. It must match the runtime.
+const gcCycleFields = 21
+
+// ReadGCCycles appends to cycles a description of each of the most
+// recent garbage collection cycles, oldest first, and returns the
+// extended slice. The runtime keeps the last 256 cycles.
+func ReadGCCycles(cycles []GCCycle) []GCCycle {
+	return readGCCyclesAfter(cycles, 0)
+}
+
+func readGCCyclesAfter(cycles []GCCycle, after uint32) []GCCycle {
+	var buf []uint64
+	readGCCycles(&buf, after)
+	for len(buf) >= gcCycleFields {
+		f := buf[:gcCycleFields]
+		nprocs := int(f[gcCycleFields-1])
+		per := buf[gcCycleFields : gcCycleFields+nprocs]
+		buf = buf[gcCycleFields+nprocs:]
+
+		c := GCCycle{
+			Num:           uint32(f[0]),
+			Forced:        f[1] != 0,
+			Procs:         int(f[2]),
+			Start:         time.Unix(0, int64(f[3])),
+			End:           time.Unix(0, int64(f[4])),
+			Pause:         time.Duration(f[5]),
+			SweepTerm:     time.Duration(f[6]),
+			Mark:          time.Duration(f[7]),
+			MarkTerm:      time.Duration(f[8]),
+			SweepTermCPU:  time.Duration(f[9]),
+			AssistCPU:     time.Duration(f[10]),
+			BackgroundCPU: time.Duration(f[11]),
+			IdleCPU:       time.Duration(f[12]),
+			MarkTermCPU:   time.Duration(f[13]),
+			HeapStart:     f[14],
+			HeapEnd:       f[15],
+			HeapLive:      f[16],
+			HeapGoal:      f[17],
+			TriggerRatio:  math.Float64frombits(f[18]),
+			GCCPUFraction: math.Float64frombits(f[19]),
+			AssistCPUPerP: make([]time.Duration, nprocs),
+		}
+		for i, t := range per {
+			c.AssistCPUPerP[i] = time.Duration(t)
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles
+}
+
+var gcCycleCallback struct {
+	sync.Mutex
+	f       func(GCCycle)
+	started bool
+}
+
+// SetGCCycleCallback registers f to be called after each garbage
+// collection cycle with a description of the cycle. f runs on a
+// goroutine of its own, one cycle at a time, in order. If f falls so far
+// behind that the runtime no longer keeps a cycle, that cycle is
+// skipped. A nil f removes the callback.
+func SetGCCycleCallback(f func(GCCycle)) {
+	gcCycleCallback.Lock()
+	gcCycleCallback.f = f
+	if f != nil && !gcCycleCallback.started {
+		gcCycleCallback.started = true
+		go gcCycleNotifier()
+	}
+	gcCycleCallback.Unlock()
+}
+
+func gcCycleNotifier() {
+	var last uint32
+	if c := ReadGCCycles(nil); len(c) > 0 {
+		last = c[len(c)-1].Num
+	}
+	var cycles []GCCycle
+	for {
+		waitGCCycle(last)
+		cycles = readGCCyclesAfter(cycles[:0], last)
+		for _, c := range cycles {
+			last = c.Num
+			gcCycleCallback.Lock()
+			f := gcCycleCallback.f
+			gcCycleCallback.Unlock()
+			if f != nil {
+				f(c)
+			}
+		}
+	}
+}
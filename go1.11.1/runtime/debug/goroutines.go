@@ -0,0 +1,72 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+// A GoroutineLimitPolicy selects what a go statement does when it
+// would exceed a limit set by SetMaxGoroutines or
+// SetGoroutineLabelLimit.
+type GoroutineLimitPolicy int
+
+const (
+	// BlockOnLimit blocks the goroutine executing the go statement
+	// until the new goroutine fits in the limits. The new goroutine
+	// exists, but does not start running, in the meantime.
+	BlockOnLimit GoroutineLimitPolicy = iota
+
+	// PanicOnLimit makes the go statement panic without starting
+	// the new goroutine.
+	PanicOnLimit
+
+	// ReportOnLimit starts the new goroutine anyway and calls the
+	// function registered with SetGoroutineLimitReporter, once each
+	// time the number of goroutines rises above the limit.
+	ReportOnLimit
+)
+
+// SetMaxGoroutines sets the maximum number of live goroutines the
+// program may have and the policy applied when a go statement would
+// exceed it. It returns the previous limit. A limit of 0, the initial
+// setting, means no limit.
+//
+// Goroutines started by the runtime itself are not counted, and the
+// check is made when a goroutine is created, so the number reported
+// by runtime.NumGoroutine may briefly exceed the limit by the number
+// of goroutines blocked in a go statement.
+//
+// SetMaxGoroutines is useful mainly for limiting the damage done by
+// programs that create an unbounded number of goroutines, in the
+// same way SetMaxThreads limits the number of threads.
+func SetMaxGoroutines(n int, policy GoroutineLimitPolicy) int {
+	return setMaxGoroutines(n, int(policy))
+}
+
+// SetGoroutineLimitReporter registers f to be called by a go
+// statement that pushes the number of goroutines above a limit while
+// the ReportOnLimit policy is in effect. f receives the number of live
+// goroutines and the global limit. f runs on the goroutine executing
+// the go statement and should return quickly. A nil f disables
+// reporting.
+func SetGoroutineLimitReporter(f func(n, max int)) {
+	setGoroutineLimitReporter(f)
+}
+
+// SetGoroutineLabelLimit limits the number of live goroutines created
+// with the profiling label key=value (see runtime/pprof.Do) to n, and
+// returns the previous limit for that label. A goroutine is charged
+// to the labels it had when it was created; changing the labels of a
+// running goroutine does not affect the count. The policy set by
+// SetMaxGoroutines applies. A limit of 0 removes the limit.
+//
+// At most 64 distinct label limits can be set; SetGoroutineLabelLimit
+// panics if there are more.
+func SetGoroutineLabelLimit(key, value string, n int) int {
+	return setGoroutineLabelLimit(key, value, n)
+}
+
+// GoroutineLabelCount returns the number of running goroutines charged
+// to the label key=value, or -1 if no limit was ever set for it.
+func GoroutineLabelCount(key, value string) int {
+	return goroutineLabelCount(key, value)
+}
@@ -0,0 +1,34 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "time"
+
+// WriteHeapDumpSnapshot writes a heap dump to fd in the format of
+// WriteHeapDump, which the runtime/debug/heapdump package reads, but
+// without stopping the world for the whole dump.
+//
+// It is not an incremental dump written alongside the garbage
+// collector's concurrent mark phase. It stops the world to fork the
+// process. The child process, whose memory is a copy-on-write snapshot
+// of the program's, writes the dump while the program continues to
+// run, and WriteHeapDumpSnapshot returns once it is written. Until
+// then, memory use grows by the memory the program writes to, up to
+// the size of the snapshot.
+//
+// The pause is that of fork, in which the kernel copies the page
+// tables of the process, so it grows with the memory mapped: it is
+// short next to WriteHeapDump's, but not constant. The runtime's heap
+// lock is held across the fork.
+//
+// If timeout is positive and the dump is not written by then, for
+// example because fd is a pipe no one reads, the child process is
+// killed and WriteHeapDumpSnapshot returns an error. What was written
+// to fd by then is not a complete dump.
+//
+// WriteHeapDumpSnapshot is only implemented on Linux on 386 and amd64.
+func WriteHeapDumpSnapshot(fd uintptr, timeout time.Duration) error {
+	return writeHeapDumpSnapshot(fd, timeout)
+}
@@ -0,0 +1,408 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import "fmt"
+
+// A RootKind says where a root's pointers are held.
+type RootKind int
+
+const (
+	RootData      RootKind = iota // the data segment
+	RootBSS                       // the BSS segment
+	RootFrame                     // a stack frame
+	RootGoroutine                 // a goroutine's context, defers and panics
+	RootFinalizer                 // a finalizer, which keeps alive what its object points to
+	RootOther                     // other pointers held by the runtime
+)
+
+// A Root holds pointers that the garbage collector treats as live.
+type Root struct {
+	Kind  RootKind
+	Name  string
+	Frame *Frame // for RootFrame
+	Refs  []Ref
+}
+
+// Roots returns the roots of the dump. For roots that are not blocks
+// of memory, such as a goroutine's context, Ref.Offset is 0.
+func (d *Dump) Roots() []*Root {
+	var roots []*Root
+	if s := d.Data; s != nil {
+		roots = append(roots, &Root{Kind: RootData, Name: "data segment", Refs: d.Refs(s.Data, s.Fields)})
+	}
+	if s := d.BSS; s != nil {
+		roots = append(roots, &Root{Kind: RootBSS, Name: "bss segment", Refs: d.Refs(s.Data, s.Fields)})
+	}
+	gs := make(map[uint64]*Root)
+	for _, g := range d.Goroutines {
+		for _, f := range g.Frames {
+			roots = append(roots, &Root{
+				Kind:  RootFrame,
+				Name:  fmt.Sprintf("goroutine %d: %s", g.ID, f.Func),
+				Frame: f,
+				Refs:  d.Refs(f.Data, f.Fields),
+			})
+		}
+		r := &Root{Kind: RootGoroutine, Name: fmt.Sprintf("goroutine %d", g.ID)}
+		r.Refs = d.appendRef(r.Refs, g.Ctxt)
+		gs[g.Addr] = r
+		roots = append(roots, r)
+	}
+	for _, df := range d.Defers {
+		if r := gs[df.Goroutine]; r != nil {
+			r.Refs = d.appendRef(d.appendRef(r.Refs, df.Addr), df.Fn)
+		}
+	}
+	for _, p := range d.Panics {
+		if r := gs[p.Goroutine]; r != nil {
+			r.Refs = d.appendRef(d.appendRef(r.Refs, p.Addr), p.Data)
+		}
+	}
+	for _, f := range d.Finalizers {
+		r := &Root{Kind: RootFinalizer}
+		if f.Queued {
+			// The finalizer is about to run with the object.
+			r.Name = fmt.Sprintf("queued finalizer for %#x", f.Obj)
+			r.Refs = d.appendRef(r.Refs, f.Obj)
+		} else {
+			// The object is only reachable through the
+			// finalizer once it is otherwise unreachable, so
+			// the finalizer only keeps alive what the object
+			// points to.
+			r.Name = fmt.Sprintf("finalizer for %#x", f.Obj)
+			if o := d.FindObject(f.Obj); o != nil {
+				r.Refs = d.Refs(o.Data, o.Fields)
+			}
+		}
+		r.Refs = d.appendRef(r.Refs, f.Fn)
+		roots = append(roots, r)
+	}
+	for _, o := range d.OtherRoots {
+		r := &Root{Kind: RootOther, Name: o.Description}
+		r.Refs = d.appendRef(r.Refs, o.To)
+		roots = append(roots, r)
+	}
+	return roots
+}
+
+// appendRef appends a Ref for the pointer p to refs if p points into
+// a heap object.
+func (d *Dump) appendRef(refs []Ref, p uint64) []Ref {
+	if o := d.FindObject(p); o != nil {
+		refs = append(refs, Ref{To: o, ToOffset: p - o.Addr})
+	}
+	return refs
+}
+
+// A Graph is the object graph of a dump: the heap objects and the
+// roots, and the pointers between them.
+//
+// The graph has a pseudo-root, whose successors are the roots, and
+// NewGraph computes its dominator tree: an object dominates another if
+// every path from the pseudo-root to the other object passes through
+// it. The retained size of an object is the size of the objects it
+// dominates, which would be freed if it were.
+type Graph struct {
+	Dump  *Dump
+	Roots []*Root
+
+	// Nodes are numbered 0 for the pseudo-root, then the roots,
+	// then the objects in the order of Dump.Objects. The
+	// successors of node v are succ[start[v]:start[v+1]].
+	start []int32
+	succ  []int32
+
+	idom     []int32 // immediate dominator of each node, -1 if unreachable
+	retained []uint64
+
+	parent []int32 // in a shortest path tree, computed by Path
+}
+
+// NewGraph returns the object graph of d.
+func NewGraph(d *Dump) *Graph {
+	g := &Graph{Dump: d, Roots: d.Roots()}
+	n := g.nodes()
+	g.start = make([]int32, 0, n+1)
+	add := func(refs []Ref) {
+		g.start = append(g.start, int32(len(g.succ)))
+		for _, r := range refs {
+			g.succ = append(g.succ, g.objectNode(r.To))
+		}
+	}
+	g.start = append(g.start, 0)
+	for i := range g.Roots {
+		g.succ = append(g.succ, int32(1+i))
+	}
+	for _, r := range g.Roots {
+		add(r.Refs)
+	}
+	for _, o := range d.Objects {
+		add(d.Refs(o.Data, o.Fields))
+	}
+	g.start = append(g.start, int32(len(g.succ)))
+	g.dominators()
+	return g
+}
+
+func (g *Graph) nodes() int {
+	return 1 + len(g.Roots) + len(g.Dump.Objects)
+}
+
+func (g *Graph) objectNode(o *Object) int32 {
+	return int32(1 + len(g.Roots) + o.index)
+}
+
+// node returns the root or object of node v, or neither for the
+// pseudo-root.
+func (g *Graph) node(v int32) (*Root, *Object) {
+	if v == 0 {
+		return nil, nil
+	}
+	if i := int(v) - 1; i < len(g.Roots) {
+		return g.Roots[i], nil
+	}
+	return nil, g.Dump.Objects[int(v)-1-len(g.Roots)]
+}
+
+// dominators computes the immediate dominators and retained sizes
+// with the Lengauer-Tarjan algorithm, as described in Appel's Modern
+// Compiler Implementation. Heap graphs can be very deep, for example
+// a long linked list, so nothing recurses.
+func (g *Graph) dominators() {
+	n := g.nodes()
+	const none = -1
+	dfnum := make([]int32, n)
+	vertex := make([]int32, 0, n)
+	parent := make([]int32, n)
+	for i := range dfnum {
+		dfnum[i] = none
+	}
+
+	// Number the nodes in depth-first order.
+	type item struct{ v, next int32 }
+	stack := []item{{0, g.start[0]}}
+	dfnum[0] = 0
+	parent[0] = none
+	vertex = append(vertex, 0)
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next == g.start[top.v+1] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		w := g.succ[top.next]
+		top.next++
+		if dfnum[w] == none {
+			dfnum[w] = int32(len(vertex))
+			vertex = append(vertex, w)
+			parent[w] = top.v
+			stack = append(stack, item{w, g.start[w]})
+		}
+	}
+
+	// Predecessors of the reachable nodes.
+	pstart := make([]int32, n+1)
+	for v := 0; v < n; v++ {
+		if dfnum[v] == none {
+			continue
+		}
+		for _, w := range g.succ[g.start[v]:g.start[v+1]] {
+			pstart[w+1]++
+		}
+	}
+	for v := 0; v < n; v++ {
+		pstart[v+1] += pstart[v]
+	}
+	pred := make([]int32, pstart[n])
+	fill := append([]int32(nil), pstart[:n]...)
+	for v := 0; v < n; v++ {
+		if dfnum[v] == none {
+			continue
+		}
+		for _, w := range g.succ[g.start[v]:g.start[v+1]] {
+			pred[fill[w]] = int32(v)
+			fill[w]++
+		}
+	}
+
+	semi := make([]int32, n)
+	ancestor := make([]int32, n)
+	best := make([]int32, n)
+	samedom := make([]int32, n)
+	idom := make([]int32, n)
+	bucket := make([][]int32, n)
+	for i := range semi {
+		semi[i], ancestor[i], best[i], samedom[i], idom[i] = none, none, int32(i), none, none
+	}
+
+	// ancestorWithLowestSemi returns the ancestor of v in the
+	// spanning forest with the lowest-numbered semidominator,
+	// compressing the path from v as it goes.
+	var path []int32
+	ancestorWithLowestSemi := func(v int32) int32 {
+		path = append(path[:0], v)
+		for x := v; ancestor[ancestor[x]] != none; {
+			x = ancestor[x]
+			path = append(path, x)
+		}
+		for i := len(path) - 2; i >= 0; i-- {
+			u := path[i]
+			a := ancestor[u]
+			b := best[a]
+			ancestor[u] = ancestor[a]
+			if dfnum[semi[b]] < dfnum[semi[best[u]]] {
+				best[u] = b
+			}
+		}
+		return best[v]
+	}
+
+	for i := len(vertex) - 1; i > 0; i-- {
+		w := vertex[i]
+		p := parent[w]
+		s := p
+		for _, v := range pred[pstart[w]:pstart[w+1]] {
+			var s1 int32
+			if dfnum[v] <= dfnum[w] {
+				s1 = v
+			} else {
+				s1 = semi[ancestorWithLowestSemi(v)]
+			}
+			if dfnum[s1] < dfnum[s] {
+				s = s1
+			}
+		}
+		semi[w] = s
+		bucket[s] = append(bucket[s], w)
+		ancestor[w] = p
+		for _, v := range bucket[p] {
+			y := ancestorWithLowestSemi(v)
+			if semi[y] == semi[v] {
+				idom[v] = p
+			} else {
+				samedom[v] = y
+			}
+		}
+		bucket[p] = nil
+	}
+	for _, w := range vertex[1:] {
+		if samedom[w] != none {
+			idom[w] = idom[samedom[w]]
+		}
+	}
+	g.idom = idom
+
+	// A node's dominators precede it in depth-first order, so
+	// summing in reverse order adds up each subtree before its
+	// dominator.
+	g.retained = make([]uint64, n)
+	for i := len(vertex) - 1; i > 0; i-- {
+		w := vertex[i]
+		if _, o := g.node(w); o != nil {
+			g.retained[w] += o.Size()
+		}
+		g.retained[idom[w]] += g.retained[w]
+	}
+}
+
+// Reachable reports whether o is reachable from the roots.
+func (g *Graph) Reachable(o *Object) bool {
+	return g.idom[g.objectNode(o)] >= 0
+}
+
+// Retained returns the retained size of o, the total size of the
+// objects it dominates, itself included. It is 0 if o is unreachable.
+func (g *Graph) Retained(o *Object) uint64 {
+	return g.retained[g.objectNode(o)]
+}
+
+// RootRetained returns the total size of the objects reachable only
+// through r.
+func (g *Graph) RootRetained(r *Root) uint64 {
+	for i, r1 := range g.Roots {
+		if r1 == r {
+			return g.retained[1+i]
+		}
+	}
+	return 0
+}
+
+// Dominator returns the immediate dominator of o, which is an object
+// or a root. Both are nil if o is unreachable or reachable from more
+// than one root.
+func (g *Graph) Dominator(o *Object) (*Root, *Object) {
+	v := g.idom[g.objectNode(o)]
+	if v < 0 {
+		return nil, nil
+	}
+	return g.node(v)
+}
+
+// A Step is a pointer followed on a path from a root.
+type Step struct {
+	Offset uint64  // of the pointer, in the root or previous object
+	Object *Object // the object pointed to
+}
+
+// Path returns a shortest path from a root to o, as the root and the
+// pointers followed from it. It returns a nil root if o is
+// unreachable.
+func (g *Graph) Path(o *Object) (*Root, []Step) {
+	if !g.Reachable(o) {
+		return nil, nil
+	}
+	if g.parent == nil {
+		g.shortestPaths()
+	}
+	var steps []Step
+	w := g.objectNode(o)
+	for {
+		v := g.parent[w]
+		r, from := g.node(v)
+		_, to := g.node(w)
+		var refs []Ref
+		if r != nil {
+			refs = r.Refs
+		} else {
+			refs = g.Dump.Refs(from.Data, from.Fields)
+		}
+		s := Step{Object: to}
+		for _, ref := range refs {
+			if ref.To == to {
+				s.Offset = ref.Offset
+				break
+			}
+		}
+		steps = append(steps, s)
+		if r != nil {
+			for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+				steps[i], steps[j] = steps[j], steps[i]
+			}
+			return r, steps
+		}
+		w = v
+	}
+}
+
+// shortestPaths computes a breadth-first tree of the graph.
+func (g *Graph) shortestPaths() {
+	g.parent = make([]int32, g.nodes())
+	for i := range g.parent {
+		g.parent[i] = -1
+	}
+	g.parent[0] = 0
+	queue := []int32{0}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range g.succ[g.start[v]:g.start[v+1]] {
+			if g.parent[w] < 0 {
+				g.parent[w] = v
+				queue = append(queue, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"runtime/debug/heapdump"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type node struct {
+	next *node
+	val  uintptr
+	pad  [6]uintptr
+}
+
+var list *node
+
+const listLen = 100
+
+//go:noinline
+func makeList() {
+	for i := listLen; i > 0; i-- {
+		list = &node{next: list, val: uintptr(i) * 0x1111}
+	}
+}
+
+func dump(t *testing.T, write func(fd uintptr) error) *heapdump.Dump {
+	f, err := ioutil.TempFile("", "heapdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := write(f.Fd()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	d, err := heapdump.Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	makeList()
+	defer func() { list = nil }()
+	d := dump(t, writeHeapDump)
+
+	if d.Params.PtrSize != int(unsafe.Sizeof(uintptr(0))) || d.Params.GOARCH != runtime.GOARCH {
+		t.Errorf("got params %+v", d.Params)
+	}
+	if d.MemStats == nil || d.MemStats.HeapObjects == 0 {
+		t.Errorf("got memory statistics %+v", d.MemStats)
+	}
+	var found bool
+	for _, g := range d.Goroutines {
+		for _, f := range g.Frames {
+			found = found || f.Func == "runtime/debug/heapdump_test.TestRoundTrip"
+		}
+	}
+	if !found {
+		t.Errorf("no frame of TestRoundTrip in the dump")
+	}
+
+	// Walk the list in the dump.
+	var objs []*heapdump.Object
+	i := 0
+	for n := list; n != nil; n, i = n.next, i+1 {
+		o := d.FindObject(uint64(uintptr(unsafe.Pointer(n))))
+		if o == nil || o.Addr != uint64(uintptr(unsafe.Pointer(n))) || o.Size() < uint64(unsafe.Sizeof(*n)) {
+			t.Fatalf("node %d at %p: got object %+v", i, n, o)
+		}
+		if val := binary.LittleEndian.Uint64(o.Data[d.Params.PtrSize:]); d.Params.PtrSize == 8 && !d.Params.BigEndian && val != uint64(n.val) {
+			t.Errorf("node %d: got value %#x, want %#x", i, val, n.val)
+		}
+		refs := d.Refs(o.Data, o.Fields)
+		if n.next == nil {
+			if len(refs) != 0 {
+				t.Errorf("last node: got pointers %+v", refs)
+			}
+		} else if len(refs) != 1 || refs[0].Offset != 0 || refs[0].To.Addr != uint64(uintptr(unsafe.Pointer(n.next))) {
+			t.Errorf("node %d: got pointers %+v, want one to %p", i, refs, n.next)
+		}
+		objs = append(objs, o)
+	}
+
+	g := heapdump.NewGraph(d)
+	root, path := g.Path(objs[listLen-1])
+	if root == nil || (root.Kind != heapdump.RootData && root.Kind != heapdump.RootBSS) || len(path) != listLen {
+		t.Fatalf("path to last node: got root %+v and %d steps, want a global and %d", root, len(path), listLen)
+	}
+	for i, s := range path {
+		if s.Object != objs[i] {
+			t.Errorf("path step %d: got object %#x, want %#x", i, s.Object.Addr, objs[i].Addr)
+		}
+	}
+	// The list is only reachable through its head, so each node
+	// dominates the rest.
+	if r := g.Retained(objs[0]); r < listLen*uint64(unsafe.Sizeof(node{})) {
+		t.Errorf("head of list retains %d bytes, want at least %d", r, listLen*unsafe.Sizeof(node{}))
+	}
+	for i := 1; i < listLen; i++ {
+		if _, o := g.Dominator(objs[i]); o != objs[i-1] {
+			t.Errorf("node %d: dominator is %+v, want node %d", i, o, i-1)
+		}
+	}
+}
+
+func writeHeapDump(fd uintptr) error {
+	debug.WriteHeapDump(fd)
+	return nil
+}
+
+// listObjects returns the objects of the nodes of list in d.
+func listObjects(t *testing.T, d *heapdump.Dump) []*heapdump.Object {
+	var objs []*heapdump.Object
+	for n := list; n != nil; n = n.next {
+		o := d.FindObject(uint64(uintptr(unsafe.Pointer(n))))
+		if o == nil {
+			t.Fatalf("node at %p not in dump", n)
+		}
+		objs = append(objs, o)
+	}
+	return objs
+}
+
+func TestTypedDump(t *testing.T) {
+	if !strings.Contains(os.Getenv("GODEBUG"), "heaptypes=1") {
+		// Types are only recorded from startup.
+		cmd := exec.Command(os.Args[0], "-test.run=^TestTypedDump$")
+		cmd.Env = append(os.Environ(), "GODEBUG=heaptypes=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+	makeList()
+	defer func() { list = nil }()
+	d := dump(t, writeHeapDump)
+	for i, o := range listObjects(t, d) {
+		if o.Type == nil || !strings.HasSuffix(o.Type.Name, "heapdump_test.node") || o.Type.Size != uint64(unsafe.Sizeof(node{})) {
+			t.Fatalf("node %d: got type %+v", i, o.Type)
+		}
+	}
+	b := make([]uint32, 100)
+	if o := d.FindObject(uint64(uintptr(unsafe.Pointer(&b[0])))); o == nil || o.Type == nil || o.Type.Name != "uint32" {
+		t.Errorf("[]uint32: got object %+v", o)
+	}
+	runtime.KeepAlive(b)
+}
+
+func TestSnapshot(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "386") {
+		t.Skipf("heap dump snapshots not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	makeList()
+	defer func() { list = nil }()
+	d := dump(t, func(fd uintptr) error {
+		return debug.WriteHeapDumpSnapshot(fd, time.Minute)
+	})
+	if objs := listObjects(t, d); len(objs) != listLen {
+		t.Errorf("got %d nodes, want %d", len(objs), listLen)
+	}
+}
+
+func TestSnapshotTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "386") {
+		t.Skipf("heap dump snapshots not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	// No one reads the pipe, so the child blocks once it is full.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	start := time.Now()
+	err = debug.WriteHeapDumpSnapshot(w.Fd(), 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("dump to a full pipe succeeded")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("dump returned after %v, want soon after the 100ms timeout", d)
+	}
+}
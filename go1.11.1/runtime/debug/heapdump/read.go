@@ -0,0 +1,575 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package heapdump reads heap dumps written by
+// runtime/debug.WriteHeapDump.
+//
+// The format is described at https://golang.org/s/go15heapdump. A
+// dump is a header followed by records, each a tag and a list of
+// fields. Integers are unsigned varints, strings and memory contents
+// are a length and the bytes, and the pointer fields of a block of
+// memory are listed as (kind, offset) pairs ending with a 0 kind.
+//
+// Read decodes a dump into a Dump, whose objects, roots and the
+// pointers between them form the object graph described by Graph.
+package heapdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+)
+
+const header = "go1.7 heap dump\n"
+
+// Record tags, as in runtime/heapdump.go.
+const (
+	tagEOF             = 0
+	tagObject          = 1
+	tagOtherRoot       = 2
+	tagType            = 3
+	tagGoroutine       = 4
+	tagStackFrame      = 5
+	tagParams          = 6
+	tagFinalizer       = 7
+	tagItab            = 8
+	tagOSThread        = 9
+	tagMemStats        = 10
+	tagQueuedFinalizer = 11
+	tagData            = 12
+	tagBSS             = 13
+	tagDefer           = 14
+	tagPanic           = 15
+	tagMemProf         = 16
+	tagAllocSample     = 17
+	tagTypedObject     = 18
+)
+
+// A FieldKind is the kind of a pointer field.
+type FieldKind int
+
+const (
+	FieldPtr   FieldKind = 1 // a pointer
+	FieldIface FieldKind = 2 // a non-empty interface: itab and data words
+	FieldEface FieldKind = 3 // an empty interface: type and data words
+)
+
+// A Field is a pointer field in a block of memory.
+type Field struct {
+	Kind   FieldKind
+	Offset uint64 // from the start of the block
+}
+
+// Params describes the dumped process.
+type Params struct {
+	BigEndian    bool
+	PtrSize      int
+	HeapStart    uint64 // lowest address of the heap arenas
+	HeapEnd      uint64 // end of the heap arenas
+	GOARCH       string
+	GOEXPERIMENT string
+	NCPU         int
+}
+
+// A Type is a Go type. Types are dumped for the itabs and, if the
+// program ran with GODEBUG=heaptypes=1, for the objects.
+type Type struct {
+	Addr uint64
+	Size uint64
+	Name string
+
+	// PtrInIface reports whether the data word of an interface
+	// holding a value of the type is a pointer.
+	PtrInIface bool
+}
+
+// An Object is an allocated heap object.
+type Object struct {
+	Addr   uint64
+	Data   []byte  // contents, including any padding up to the size class
+	Fields []Field // pointer fields in Data
+
+	// Type is the type the object was allocated with, or nil if it
+	// is not known. An object allocated as an array of n elements
+	// has the element type, and a size of at least n*Type.Size.
+	Type *Type
+
+	// Alloc is the heap profile record of the allocation, if the
+	// allocation was sampled by the heap profile.
+	Alloc *MemProfRecord
+
+	index int // in Dump.Objects
+}
+
+// Size returns the size of the object's heap slot.
+func (o *Object) Size() uint64 {
+	return uint64(len(o.Data))
+}
+
+// TypeName returns the name of the object's type, or "" if it is not
+// known. An object allocated as an array of a type T is a []T.
+func (o *Object) TypeName() string {
+	t := o.Type
+	if t == nil {
+		return ""
+	}
+	if t.Size != 0 && 2*t.Size <= o.Size() {
+		// Size classes round up by less than 2x, so the object
+		// has more than one element.
+		return "[]" + t.Name
+	}
+	return t.Name
+}
+
+// A Goroutine is a goroutine that had not exited.
+type Goroutine struct {
+	Addr       uint64 // of its g
+	SP         uint64 // stack pointer at which it stopped
+	ID         uint64
+	GoPC       uint64 // pc of the go statement that created it
+	Status     uint64 // runtime status, such as _Gwaiting
+	System     bool   // started by the runtime
+	Background bool   // always false
+	WaitSince  int64  // time it started waiting, in nanoseconds; 0 if unknown
+	WaitReason string
+	Ctxt       uint64 // closure context to resume with
+	M          uint64 // OS thread it is locked to or running on, or 0
+	Defer      uint64 // top defer record, or 0
+	Panic      uint64 // top panic record, or 0
+
+	Frames []*Frame // innermost first
+}
+
+// A Frame is a stack frame of a goroutine.
+type Frame struct {
+	Goroutine *Goroutine
+	SP        uint64 // lowest address of the frame
+	Depth     int    // number of frames below it on the stack
+	ChildSP   uint64 // SP of the frame below it, or 0
+	Data      []byte
+	Entry     uint64 // entry pc of the function
+	PC        uint64
+	ContPC    uint64 // pc at which execution continues
+	Func      string
+	Fields    []Field
+}
+
+// An OtherRoot is a pointer held by the runtime.
+type OtherRoot struct {
+	Description string
+	To          uint64
+}
+
+// A Finalizer is a finalizer set with runtime.SetFinalizer.
+type Finalizer struct {
+	Obj    uint64
+	Fn     uint64 // funcval
+	FnPC   uint64 // code pointer of Fn
+	FnType uint64 // type of the function's argument
+	ObjPtr uint64 // pointer type of Obj
+
+	// Queued reports whether the object has become unreachable and
+	// the finalizer is queued to run.
+	Queued bool
+}
+
+// A Defer is a deferred call.
+type Defer struct {
+	Addr      uint64
+	Goroutine uint64
+	SP        uint64 // of the deferring frame
+	PC        uint64 // of the defer statement
+	Fn        uint64 // funcval
+	FnPC      uint64
+	Link      uint64 // next defer record, or 0
+}
+
+// A Panic is an active panic.
+type Panic struct {
+	Addr      uint64
+	Goroutine uint64
+	Type      uint64 // of the argument
+	Data      uint64 // data word of the argument
+	Link      uint64 // next panic record, or 0
+}
+
+// An OSThread is a thread that runs goroutines, an M.
+type OSThread struct {
+	Addr   uint64
+	ID     uint64
+	ProcID uint64 // OS thread ID
+}
+
+// A Segment is the data or BSS segment.
+type Segment struct {
+	Addr   uint64
+	Data   []byte
+	Fields []Field
+}
+
+// A MemProfRecord is a heap profile bucket: the allocations made from
+// one stack with one size.
+type MemProfRecord struct {
+	Bucket uint64 // address of the bucket
+	Size   uint64
+	Stack  []Location // innermost first
+	Allocs uint64
+	Frees  uint64
+}
+
+// A Location is a frame of a MemProfRecord stack.
+type Location struct {
+	Func string
+	File string
+	Line int
+}
+
+// A Dump is a decoded heap dump.
+type Dump struct {
+	Params     Params
+	Types      map[uint64]*Type  // by address
+	Itabs      map[uint64]uint64 // type address by itab address
+	Objects    []*Object         // by address
+	Goroutines []*Goroutine
+	OSThreads  []*OSThread
+	OtherRoots []*OtherRoot
+	Finalizers []*Finalizer
+	Defers     []*Defer
+	Panics     []*Panic
+	Data       *Segment
+	BSS        *Segment
+	MemStats   *runtime.MemStats
+	MemProf    map[uint64]*MemProfRecord // by bucket address
+}
+
+// ReadFile reads the heap dump in the named file.
+func ReadFile(name string) (*Dump, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads a heap dump from r.
+func Read(r io.Reader) (*Dump, error) {
+	rd := &reader{r: bufio.NewReaderSize(r, 64<<10)}
+	var hdr [len(header)]byte
+	if _, err := io.ReadFull(rd.r, hdr[:]); err != nil || string(hdr[:]) != header {
+		return nil, errors.New("heapdump: not a heap dump")
+	}
+	d := &Dump{
+		Types:   make(map[uint64]*Type),
+		Itabs:   make(map[uint64]uint64),
+		MemProf: make(map[uint64]*MemProfRecord),
+	}
+	samples := make(map[uint64]uint64) // object address -> bucket
+	var g *Goroutine
+	for {
+		tag := rd.uvarint()
+		if rd.err != nil {
+			break
+		}
+		switch tag {
+		case tagEOF:
+			return d, d.finish(samples)
+		case tagObject, tagTypedObject:
+			o := &Object{Addr: rd.uvarint()}
+			if tag == tagTypedObject {
+				// The type record precedes the object.
+				o.Type = d.Types[rd.uvarint()]
+			}
+			o.Data = rd.bytes()
+			o.Fields = rd.fields()
+			d.Objects = append(d.Objects, o)
+		case tagOtherRoot:
+			r := &OtherRoot{Description: rd.string()}
+			r.To = rd.uvarint()
+			d.OtherRoots = append(d.OtherRoots, r)
+		case tagType:
+			t := &Type{Addr: rd.uvarint(), Size: rd.uvarint()}
+			t.Name = rd.string()
+			t.PtrInIface = rd.bool()
+			d.Types[t.Addr] = t
+		case tagGoroutine:
+			g = &Goroutine{
+				Addr:       rd.uvarint(),
+				SP:         rd.uvarint(),
+				ID:         rd.uvarint(),
+				GoPC:       rd.uvarint(),
+				Status:     rd.uvarint(),
+				System:     rd.bool(),
+				Background: rd.bool(),
+				WaitSince:  int64(rd.uvarint()),
+				WaitReason: rd.string(),
+				Ctxt:       rd.uvarint(),
+				M:          rd.uvarint(),
+				Defer:      rd.uvarint(),
+				Panic:      rd.uvarint(),
+			}
+			d.Goroutines = append(d.Goroutines, g)
+		case tagStackFrame:
+			// A goroutine's frames follow it.
+			if g == nil {
+				return nil, errors.New("heapdump: stack frame outside a goroutine")
+			}
+			f := &Frame{
+				Goroutine: g,
+				SP:        rd.uvarint(),
+				Depth:     int(rd.uvarint()),
+				ChildSP:   rd.uvarint(),
+				Data:      rd.bytes(),
+				Entry:     rd.uvarint(),
+				PC:        rd.uvarint(),
+				ContPC:    rd.uvarint(),
+				Func:      rd.string(),
+				Fields:    rd.fields(),
+			}
+			g.Frames = append(g.Frames, f)
+		case tagParams:
+			d.Params = Params{
+				BigEndian:    rd.bool(),
+				PtrSize:      int(rd.uvarint()),
+				HeapStart:    rd.uvarint(),
+				HeapEnd:      rd.uvarint(),
+				GOARCH:       rd.string(),
+				GOEXPERIMENT: rd.string(),
+				NCPU:         int(rd.uvarint()),
+			}
+			if p := d.Params.PtrSize; p != 4 && p != 8 {
+				return nil, fmt.Errorf("heapdump: bad pointer size %d", p)
+			}
+		case tagFinalizer, tagQueuedFinalizer:
+			f := &Finalizer{
+				Obj:    rd.uvarint(),
+				Fn:     rd.uvarint(),
+				FnPC:   rd.uvarint(),
+				FnType: rd.uvarint(),
+				ObjPtr: rd.uvarint(),
+				Queued: tag == tagQueuedFinalizer,
+			}
+			d.Finalizers = append(d.Finalizers, f)
+		case tagItab:
+			itab := rd.uvarint()
+			d.Itabs[itab] = rd.uvarint()
+		case tagOSThread:
+			m := &OSThread{Addr: rd.uvarint(), ID: rd.uvarint(), ProcID: rd.uvarint()}
+			d.OSThreads = append(d.OSThreads, m)
+		case tagMemStats:
+			d.MemStats = rd.memStats()
+		case tagData, tagBSS:
+			s := &Segment{Addr: rd.uvarint()}
+			s.Data = rd.bytes()
+			s.Fields = rd.fields()
+			if tag == tagData {
+				d.Data = s
+			} else {
+				d.BSS = s
+			}
+		case tagDefer:
+			df := &Defer{
+				Addr:      rd.uvarint(),
+				Goroutine: rd.uvarint(),
+				SP:        rd.uvarint(),
+				PC:        rd.uvarint(),
+				Fn:        rd.uvarint(),
+				FnPC:      rd.uvarint(),
+				Link:      rd.uvarint(),
+			}
+			d.Defers = append(d.Defers, df)
+		case tagPanic:
+			p := &Panic{
+				Addr:      rd.uvarint(),
+				Goroutine: rd.uvarint(),
+				Type:      rd.uvarint(),
+				Data:      rd.uvarint(),
+			}
+			rd.uvarint() // formerly the defer record
+			p.Link = rd.uvarint()
+			d.Panics = append(d.Panics, p)
+		case tagMemProf:
+			m := &MemProfRecord{Bucket: rd.uvarint(), Size: rd.uvarint()}
+			n := rd.uvarint()
+			for i := uint64(0); i < n && rd.err == nil; i++ {
+				var l Location
+				l.Func = rd.string()
+				l.File = rd.string()
+				l.Line = int(rd.uvarint())
+				m.Stack = append(m.Stack, l)
+			}
+			m.Allocs = rd.uvarint()
+			m.Frees = rd.uvarint()
+			d.MemProf[m.Bucket] = m
+		case tagAllocSample:
+			obj := rd.uvarint()
+			samples[obj] = rd.uvarint()
+		default:
+			return nil, fmt.Errorf("heapdump: unknown record tag %d", tag)
+		}
+	}
+	if rd.err == io.EOF {
+		rd.err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("heapdump: %v", rd.err)
+}
+
+// finish sorts the objects and links them to their allocation
+// samples.
+func (d *Dump) finish(samples map[uint64]uint64) error {
+	if d.Params.PtrSize == 0 {
+		return errors.New("heapdump: missing parameters record")
+	}
+	sort.Slice(d.Objects, func(i, j int) bool { return d.Objects[i].Addr < d.Objects[j].Addr })
+	for i, o := range d.Objects {
+		o.index = i
+	}
+	for addr, b := range samples {
+		if o := d.FindObject(addr); o != nil {
+			o.Alloc = d.MemProf[b]
+		}
+	}
+	return nil
+}
+
+// FindObject returns the object containing addr, or nil if there is
+// none.
+func (d *Dump) FindObject(addr uint64) *Object {
+	i := sort.Search(len(d.Objects), func(i int) bool { return d.Objects[i].Addr > addr }) - 1
+	if i < 0 {
+		return nil
+	}
+	if o := d.Objects[i]; addr < o.Addr+o.Size() {
+		return o
+	}
+	return nil
+}
+
+// A Ref is a pointer to a heap object.
+type Ref struct {
+	Offset   uint64 // of the pointer in the memory holding it
+	To       *Object
+	ToOffset uint64 // of the address pointed to, in To
+}
+
+// Refs returns the pointers to heap objects among the fields of data.
+// The itab or type word of an interface is not followed, as itabs and
+// types are not allocated in the heap.
+func (d *Dump) Refs(data []byte, fields []Field) []Ref {
+	var refs []Ref
+	ptr := uint64(d.Params.PtrSize)
+	for _, f := range fields {
+		off := f.Offset
+		if f.Kind == FieldIface || f.Kind == FieldEface {
+			off += ptr
+		}
+		if off+ptr > uint64(len(data)) {
+			continue
+		}
+		p := d.word(data[off:])
+		if p < d.Params.HeapStart || p >= d.Params.HeapEnd {
+			continue
+		}
+		if o := d.FindObject(p); o != nil {
+			refs = append(refs, Ref{Offset: off, To: o, ToOffset: p - o.Addr})
+		}
+	}
+	return refs
+}
+
+// word decodes a pointer-sized word from the start of b.
+func (d *Dump) word(b []byte) uint64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if d.Params.BigEndian {
+		order = binary.BigEndian
+	}
+	if d.Params.PtrSize == 4 {
+		return uint64(order.Uint32(b))
+	}
+	return order.Uint64(b)
+}
+
+// A reader decodes the fields of records. After an error, it returns
+// zero values and leaves the error in err.
+type reader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	r.err = err
+	return v
+}
+
+func (r *reader) bool() bool {
+	return r.uvarint() != 0
+}
+
+// maxLen bounds the length of a block of memory, so that a corrupt
+// length cannot make bytes allocate without bound.
+const maxLen = 1 << 40
+
+func (r *reader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > maxLen {
+		r.err = fmt.Errorf("length %d too large", n)
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+		return nil
+	}
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) fields() []Field {
+	var fields []Field
+	for {
+		kind := FieldKind(r.uvarint())
+		if r.err != nil || kind == 0 {
+			return fields
+		}
+		if kind > FieldEface {
+			r.err = fmt.Errorf("unknown field kind %d", kind)
+			return nil
+		}
+		fields = append(fields, Field{Kind: kind, Offset: r.uvarint()})
+	}
+}
+
+// memStats decodes the memory statistics, in the order of
+// runtime.dumpmemstats.
+func (r *reader) memStats() *runtime.MemStats {
+	var s runtime.MemStats
+	for _, p := range []*uint64{
+		&s.Alloc, &s.TotalAlloc, &s.Sys, &s.Lookups, &s.Mallocs, &s.Frees,
+		&s.HeapAlloc, &s.HeapSys, &s.HeapIdle, &s.HeapInuse, &s.HeapReleased, &s.HeapObjects,
+		&s.StackInuse, &s.StackSys, &s.MSpanInuse, &s.MSpanSys, &s.MCacheInuse, &s.MCacheSys,
+		&s.BuckHashSys, &s.GCSys, &s.OtherSys, &s.NextGC, &s.LastGC, &s.PauseTotalNs,
+	} {
+		*p = r.uvarint()
+	}
+	for i := range s.PauseNs {
+		s.PauseNs[i] = r.uvarint()
+	}
+	s.NumGC = uint32(r.uvarint())
+	return &s
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,386 linux,amd64
+
+package debug
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+func writeHeapDumpSnapshot(fd uintptr, timeout time.Duration) error {
+	pid := forkHeapDump(fd)
+	if pid < 0 {
+		return os.NewSyscallError("fork", syscall.Errno(-pid))
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	var status syscall.WaitStatus
+	for delay := time.Millisecond; ; {
+		options := syscall.WNOHANG
+		if deadline.IsZero() {
+			options = 0
+		}
+		wpid, err := syscall.Wait4(int(pid), &status, options, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("wait4", err)
+		}
+		if wpid == int(pid) {
+			break
+		}
+		if time.Now().After(deadline) {
+			// The child may be blocked writing to fd. Kill it
+			// and reap it, so that it does not linger as a
+			// zombie.
+			syscall.Kill(int(pid), syscall.SIGKILL)
+			for {
+				_, err := syscall.Wait4(int(pid), &status, 0, nil)
+				if err != syscall.EINTR {
+					break
+				}
+			}
+			return fmt.Errorf("debug: heap dump process %d killed after %v", pid, timeout)
+		}
+		time.Sleep(delay)
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+	if !status.Exited() || status.ExitStatus() != 0 {
+		return fmt.Errorf("debug: heap dump process %d failed: wait status %#x", pid, uint32(status))
+	}
+	return nil
+}
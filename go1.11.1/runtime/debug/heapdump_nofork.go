@@ -0,0 +1,17 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux !386,!amd64
+
+package debug
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+func writeHeapDumpSnapshot(fd uintptr, timeout time.Duration) error {
+	return errors.New("debug: WriteHeapDumpSnapshot not implemented on " + runtime.GOOS + "/" + runtime.GOARCH)
+}
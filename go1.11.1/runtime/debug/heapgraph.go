@@ -0,0 +1,189 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"io"
+	"runtime"
+	"strings"
+)
+
+// A HeapGraphView selects the frames of a heap graph profile.
+type HeapGraphView int
+
+const (
+	// HeapGraphTypes names frames by the type of objects, or by
+	// their size for objects whose type is not known. Types are
+	// known if the program runs with GODEBUG=heaptypes=1.
+	HeapGraphTypes HeapGraphView = iota
+
+	// HeapGraphSites names frames by the stack that allocated
+	// objects sampled by the heap profile; see
+	// runtime.MemProfileRate. Objects that were not sampled are
+	// counted in the frames of the nearest sampled object retaining
+	// them.
+	HeapGraphSites
+)
+
+// maxHeapGraphDepth bounds the depth of the stacks in a heap graph
+// profile, in objects. Objects deeper in the dominator tree are counted
+// in the frames of the deepest.
+const maxHeapGraphDepth = 64
+
+// heapGraphNodeFields is the number of uint64s per node filled in by
+// readHeapGraph. It must match the runtime.
+const heapGraphNodeFields = 4
+
+// WriteHeapGraphProfile writes the memory the heap retains to w as an
+// uncompressed profile in the format read by the pprof tool, so that
+// pprof can show what retains memory.
+//
+// Each reachable object is counted in a sample whose stack is the
+// object's frames, as selected by view, over the frames of the objects
+// that retain it, from the nearest to the furthest, over the root that
+// retains them all: a data or BSS segment, a function with the
+// pointer in a stack frame, or finalizers. An object retains another
+// if every path from the roots to the other passes through it: this is
+// the dominator tree of the heap graph. Objects retained by no single
+// root are under "<several roots>". Consecutive identical frames are
+// merged, so a linked list has the frames of one object. The sample
+// values are the objects and their bytes, so the cumulative bytes of a
+// frame are the memory that would be freed if the objects of the frame
+// were, and the flat bytes are the objects' own.
+//
+// The runtime computes the graph with the world stopped, for as long as
+// it takes to follow every pointer in the heap, in memory outside the
+// heap proportional to the number of reachable objects and pointers.
+func WriteHeapGraphProfile(w io.Writer, view HeapGraphView) error {
+	var nodes []uint64
+	var stacks [][]uintptr
+	var names []string
+	readHeapGraph(int(view), &nodes, &stacks, &names)
+	_, err := w.Write(heapGraphProfile(nodes, stacks, names))
+	return err
+}
+
+// A heapGraphStack is a stack of a heap graph profile, interned as its
+// leaf frame and the rest of the stack.
+type heapGraphStack struct {
+	heapGraphKey
+	depth          int
+	objects, bytes int64
+}
+
+type heapGraphKey struct {
+	frame  uint64 // as filled in by readHeapGraph
+	parent int    // index of the rest of the stack, or -1
+}
+
+// heapGraphProfile builds the profile of the nodes, stacks and names
+// filled in by readHeapGraph.
+func heapGraphProfile(nodes []uint64, allocStacks [][]uintptr, names []string) []byte {
+	// Allocation stacks are per heap profile bucket, and buckets
+	// with the same stack differ in the size of their objects.
+	// Use one frame for each distinct stack.
+	sameStack := make(map[string]uint64)
+	canon := make([]uint64, len(allocStacks))
+	for i, stk := range allocStacks {
+		k := make([]byte, 0, 8*len(stk))
+		for _, pc := range stk {
+			for shift := uint(0); shift < 64; shift += 8 {
+				k = append(k, byte(uint64(pc)>>shift))
+			}
+		}
+		f, ok := sameStack[string(k)]
+		if !ok {
+			f = 2*uint64(i) + 2
+			sameStack[string(k)] = f
+		}
+		canon[i] = f
+	}
+
+	var stacks []heapGraphStack
+	interned := make(map[heapGraphKey]int)
+	intern := func(frame uint64, parent int) int {
+		if frame != 0 && frame%2 == 0 {
+			frame = canon[frame/2-1]
+		}
+		depth := 0
+		if parent >= 0 {
+			p := &stacks[parent]
+			if frame == 0 || frame == p.frame || p.depth >= maxHeapGraphDepth {
+				return parent
+			}
+			depth = p.depth + 1
+		}
+		k := heapGraphKey{frame, parent}
+		if i, ok := interned[k]; ok {
+			return i
+		}
+		stacks = append(stacks, heapGraphStack{heapGraphKey: k, depth: depth})
+		interned[k] = len(stacks) - 1
+		return len(stacks) - 1
+	}
+
+	// Nodes come after their dominators.
+	n := len(nodes) / heapGraphNodeFields
+	nodeStacks := make([]int, n)
+	for i := 0; i < n; i++ {
+		f := nodes[i*heapGraphNodeFields : (i+1)*heapGraphNodeFields]
+		parent, objects, bytes, frame := int(f[0]), int64(f[1]), int64(f[2]), f[3]
+		if objects == 0 {
+			// The super root or a root.
+			nodeStacks[i] = intern(frame, -1)
+			continue
+		}
+		s := intern(frame, nodeStacks[parent])
+		nodeStacks[i] = s
+		stacks[s].objects += objects
+		stacks[s].bytes += bytes
+	}
+
+	b := newProfileBuilder([][2]string{
+		{"retained_space", "bytes"},
+		{"retained_objects", "count"},
+	})
+	frameLocs := make(map[uint64][]uint64)
+	var locs []uint64
+	for i := range stacks {
+		s := &stacks[i]
+		if s.objects == 0 {
+			continue
+		}
+		locs = locs[:0]
+		for j := i; j >= 0; j = stacks[j].parent {
+			f := stacks[j].frame
+			l, ok := frameLocs[f]
+			if !ok {
+				if f%2 == 1 {
+					l = []uint64{b.location(names[f/2])}
+				} else {
+					l = heapGraphLocations(b, allocStacks[f/2-1])
+				}
+				frameLocs[f] = l
+			}
+			locs = append(locs, l...)
+		}
+		b.sampleLocations(locs, []int64{s.bytes, s.objects}, nil)
+	}
+	return b.build()
+}
+
+// heapGraphLocations returns the locations of an allocation stack,
+// leaving out the allocator's frames.
+func heapGraphLocations(b *profileBuilder, stk []uintptr) []uint64 {
+	for i, pc := range stk {
+		f, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			stk = stk[i:]
+			break
+		}
+	}
+	locs := make([]uint64, len(stk))
+	for i, pc := range stk {
+		locs[i] = b.pcLocation(pc)
+	}
+	return locs
+}
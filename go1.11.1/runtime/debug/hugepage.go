@@ -0,0 +1,102 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HugePageStats describes the use of transparent huge pages by the
+// heap.
+type HugePageStats struct {
+	Policy        string // huge page policy set with GOTHP, or "default"
+	ArenaBytes    uint64 // heap address space mapped
+	HugePageBytes uint64 // heap memory backed by huge pages
+}
+
+// ReadHugePageStats reports how much of the heap is backed by
+// transparent huge pages. It reads /proc/self/smaps, so it only works
+// on Linux. If a mapping extends beyond the heap, its huge pages are
+// attributed to the heap in proportion to the overlap.
+func ReadHugePageStats() (HugePageStats, error) {
+	var bases []uintptr
+	policy, arenaBytes := readHugePageArenas(&bases)
+	stats := HugePageStats{
+		Policy:     policy,
+		ArenaBytes: uint64(len(bases)) * uint64(arenaBytes),
+	}
+	data, err := ioutil.ReadFile("/proc/self/smaps")
+	if err != nil {
+		return stats, err
+	}
+
+	// Merge adjacent arenas.
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	var heap [][2]uintptr
+	for _, b := range bases {
+		if n := len(heap); n > 0 && heap[n-1][1] == b {
+			heap[n-1][1] = b + arenaBytes
+		} else {
+			heap = append(heap, [2]uintptr{b, b + arenaBytes})
+		}
+	}
+
+	var overlap, size uint64 // of the current mapping
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(f[0], ":") {
+			// A mapping: start-end perms offset dev inode path.
+			i := strings.IndexByte(f[0], '-')
+			if i < 0 {
+				continue
+			}
+			lo, err1 := strconv.ParseUint(f[0][:i], 16, 64)
+			hi, err2 := strconv.ParseUint(f[0][i+1:], 16, 64)
+			if err1 != nil || err2 != nil || hi <= lo {
+				overlap = 0
+				continue
+			}
+			overlap, size = 0, hi-lo
+			for _, r := range heap {
+				if l, h := max64(lo, uint64(r[0])), min64(hi, uint64(r[1])); l < h {
+					overlap += h - l
+				}
+			}
+			continue
+		}
+		if f[0] != "AnonHugePages:" || overlap == 0 || len(f) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats.HugePageBytes += uint64(float64(kb<<10) * float64(overlap) / float64(size))
+	}
+	return stats, s.Err()
+}
+
+func min64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max64(x, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,319 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"math"
+	"unsafe"
+)
+
+// Runtime metrics are named by strings of the form "/path:unit", such
+// as "/gc/heap/allocs:bytes". The path groups related metrics and the
+// unit says what the value counts.
+//
+// The set of metrics may grow from release to release, and a metric may
+// be removed, but a name is never reused for a metric with a different
+// meaning, kind or unit: a metric that changes gets a new name. A
+// program should look up the metrics it uses with AllMetrics, and cope
+// with the ones that do not exist, whose samples have kind MetricKindBad.
+//
+// ReadMetrics never stops the world. Values
+// are read one by one while the program runs, so values of different
+// metrics in the same call may be slightly inconsistent.
+
+// MetricKind is the kind of a metric's value.
+type MetricKind int
+
+const (
+	// MetricKindBad is the kind of the value of an unknown metric.
+	MetricKindBad MetricKind = iota
+
+	MetricKindUint64
+	MetricKindFloat64
+	MetricKindFloat64Histogram
+)
+
+// MetricDescription describes a metric.
+type MetricDescription struct {
+	Name        string
+	Description string
+	Kind        MetricKind
+
+	// Cumulative is set if the metric only increases over the life
+	// of the program, so that the difference between two samples is
+	// meaningful.
+	Cumulative bool
+}
+
+// AllMetrics returns the descriptions of all the metrics this runtime
+// supports, sorted by name.
+func AllMetrics() []MetricDescription {
+	return append([]MetricDescription(nil), allMetrics...)
+}
+
+// MetricSample is a sample of the metric Name.
+type MetricSample struct {
+	Name  string
+	Value MetricValue
+}
+
+// MetricValue is the value of a metric. Its methods panic if called
+// for a value of another kind.
+type MetricValue struct {
+	kind    MetricKind
+	scalar  uint64         // uint64 value, or float64 bits
+	pointer unsafe.Pointer // *Float64Histogram
+}
+
+// Kind returns the kind of v.
+func (v MetricValue) Kind() MetricKind {
+	return v.kind
+}
+
+// Uint64 returns the value of a metric of kind MetricKindUint64.
+func (v MetricValue) Uint64() uint64 {
+	if v.kind != MetricKindUint64 {
+		panic("debug: MetricValue.Uint64 of a non-uint64 metric")
+	}
+	return v.scalar
+}
+
+// Float64 returns the value of a metric of kind MetricKindFloat64.
+func (v MetricValue) Float64() float64 {
+	if v.kind != MetricKindFloat64 {
+		panic("debug: MetricValue.Float64 of a non-float64 metric")
+	}
+	return math.Float64frombits(v.scalar)
+}
+
+// Float64Histogram returns the value of a metric of kind
+// MetricKindFloat64Histogram. ReadMetrics reuses the histogram when it
+// reads the same sample again.
+func (v MetricValue) Float64Histogram() *Float64Histogram {
+	if v.kind != MetricKindFloat64Histogram {
+		panic("debug: MetricValue.Float64Histogram of a non-histogram metric")
+	}
+	return (*Float64Histogram)(v.pointer)
+}
+
+// Float64Histogram is a histogram of float64 values.
+type Float64Histogram struct {
+	// Counts[i] is the number of values in [Buckets[i], Buckets[i+1]).
+	Counts []uint64
+
+	// Buckets holds len(Counts)+1 increasing bucket boundaries. The
+	// first may be -Inf and the last +Inf. The slice is shared by
+	// all histograms of the metric and must not be modified.
+	Buckets []float64
+}
+
+// ReadMetrics fills in the values of samples, named by their Name
+// fields. Samples of metrics the runtime does not support get kind
+// MetricKindBad. Reading many metrics in one call is cheaper than in
+// many calls.
+func ReadMetrics(samples []MetricSample) {
+	if len(samples) == 0 {
+		return
+	}
+	readMetrics(samples)
+}
+
+// allMetrics describes the metrics. It is kept sorted by name, and in
+// step with the runtime's metricsTable.
+var allMetrics = []MetricDescription{
+	{
+		Name:        "/cgo/go-to-c-calls:calls",
+		Description: "Calls from Go to C made by the program, as counted by runtime.NumCgoCall.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/cpu-fraction:ratio",
+		Description: "Fraction of the available CPU time used by the GC since the program started, as in MemStats.GCCPUFraction.",
+		Kind:        MetricKindFloat64,
+	},
+	{
+		Name:        "/gc/cycles/automatic:gc-cycles",
+		Description: "Completed GC cycles started by the runtime.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/cycles/forced:gc-cycles",
+		Description: "Completed GC cycles forced by the program, such as by runtime.GC.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/cycles/total:gc-cycles",
+		Description: "All completed GC cycles.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/gomemlimit:bytes",
+		Description: "Soft memory limit set by GOMEMLIMIT or SetMemoryLimit.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/gc/heap/allocs-by-size:bytes",
+		Description: "Distribution of the sizes of heap objects allocated, by size class. Tiny allocations are not included.",
+		Kind:        MetricKindFloat64Histogram,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/allocs:bytes",
+		Description: "Bytes of heap objects allocated, rounded up to their size class.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/allocs:objects",
+		Description: "Heap objects allocated, including tiny allocations.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/frees-by-size:bytes",
+		Description: "Distribution of the sizes of heap objects freed, by size class. Tiny allocations are not included.",
+		Kind:        MetricKindFloat64Histogram,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/frees:bytes",
+		Description: "Bytes of heap objects freed by the GC.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/frees:objects",
+		Description: "Heap objects freed by the GC, including tiny allocations, which are counted as freed when allocated.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/heap/goal:bytes",
+		Description: "Heap size the GC aims to finish the current or next cycle at.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/gc/heap/marked:bytes",
+		Description: "Bytes of heap marked live by the last GC cycle.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/gc/heap/objects:objects",
+		Description: "Heap objects allocated and not yet freed, including unreachable ones not yet swept.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/gc/heap/tiny/allocs:objects",
+		Description: "Small allocations without pointers packed together by the tiny allocator.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/pauses/total:seconds",
+		Description: "Stop-the-world time of all GC cycles.",
+		Kind:        MetricKindFloat64,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/pauses:seconds",
+		Description: "Distribution of the total stop-the-world time of each GC cycle.",
+		Kind:        MetricKindFloat64Histogram,
+		Cumulative:  true,
+	},
+	{
+		Name:        "/gc/stack/starting-size:bytes",
+		Description: "Stack size of new goroutines, set by GOSTACKSTART or learned from the stacks of existing goroutines.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/free:bytes",
+		Description: "Free heap memory that could be returned to the OS but has not been.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/objects:bytes",
+		Description: "Memory of heap objects allocated and not yet freed.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/released:bytes",
+		Description: "Free heap memory returned to the OS.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/stacks:bytes",
+		Description: "Heap memory used for goroutine stacks.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/heap/unused:bytes",
+		Description: "Heap memory in spans of objects that is not allocated to objects, a measure of fragmentation.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/metadata/mcache/free:bytes",
+		Description: "Memory reserved for per-P allocation caches but not in use.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/metadata/mcache/inuse:bytes",
+		Description: "Memory used by per-P allocation caches.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/metadata/mspan/free:bytes",
+		Description: "Memory reserved for span descriptors but not in use.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/metadata/mspan/inuse:bytes",
+		Description: "Memory used by span descriptors.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/metadata/other:bytes",
+		Description: "Memory used by GC metadata, such as heap bitmaps.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/os-stacks:bytes",
+		Description: "Stack memory of threads allocated directly from the OS.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/other:bytes",
+		Description: "Memory used by other runtime data structures.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/profiling/buckets:bytes",
+		Description: "Memory used by profiling hash tables.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/memory/classes/total:bytes",
+		Description: "All memory mapped by the runtime, the sum of the other /memory/classes metrics, as in MemStats.Sys.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/sched/gomaxprocs:threads",
+		Description: "Current GOMAXPROCS setting.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/sched/goroutines:goroutines",
+		Description: "Goroutines that exist, as counted by runtime.NumGoroutine.",
+		Kind:        MetricKindUint64,
+	},
+	{
+		Name:        "/sched/threads:threads",
+		Description: "OS threads created by the runtime and not exited.",
+		Kind:        MetricKindUint64,
+	},
+}
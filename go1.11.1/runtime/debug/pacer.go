@@ -0,0 +1,112 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"math"
+	"time"
+)
+
+// A PacerEventKind identifies a decision of the garbage collector's
+// pacer.
+type PacerEventKind uint8
+
+const (
+	// PacerStart is recorded when a cycle starts and the pacer sets
+	// the heap goal, the number of mark workers and the initial
+	// assist ratio.
+	PacerStart PacerEventKind = 1 + iota
+
+	// PacerRevise is recorded when the pacer revises the assist
+	// ratio during a cycle, as the heap grows and marking
+	// progresses. The pacer revises the ratio very often, so only
+	// the first revision of a cycle and at most one per millisecond
+	// after that are recorded.
+	PacerRevise
+
+	// PacerEndCycle is recorded when a cycle ends and the pacer
+	// chooses the trigger ratio for the next cycle. It is not
+	// recorded for cycles forced by the application.
+	PacerEndCycle
+)
+
+// A PacerEvent is a decision of the garbage collector's pacer, with the
+// inputs it was based on. Fields that do not apply to the event's Kind
+// are zero. The names in parentheses are those of the pacer design
+// document, https://golang.org/s/go15gcpacing.
+type PacerEvent struct {
+	Kind  PacerEventKind
+	Cycle uint32        // number of the GC cycle the event belongs to
+	When  time.Duration // time since the program started
+
+	HeapLive uint64 // heap bytes allocated (H_a at the end of a cycle)
+	HeapGoal uint64 // heap goal; for PacerRevise, the goal the assists are paced for
+
+	// PacerStart and PacerRevise.
+	HeapScan          uint64  // scannable heap bytes
+	ScanWork          int64   // scan work done in the cycle so far (W_a at the end of a cycle)
+	ScanWorkExpected  int64   // PacerRevise: total scan work expected for the cycle
+	AssistWorkPerByte float64 // scan work assists must do per byte allocated
+
+	// PacerStart.
+	DedicatedWorkers int64   // dedicated mark workers
+	FractionalGoal   float64 // utilization goal of the fractional mark worker
+
+	// PacerEndCycle.
+	HeapMarked       uint64  // heap marked by the previous cycle (H_m_prev)
+	Trigger          uint64  // heap size at which the cycle was triggered (H_T)
+	TriggerRatio     float64 // trigger ratio of the cycle (h_t)
+	GoalGrowth       float64 // goal heap growth ratio (h_g)
+	ActualGrowth     float64 // actual heap growth ratio (h_a)
+	Utilization      float64 // GC CPU utilization during the cycle (u_a)
+	NextTriggerRatio float64 // trigger ratio chosen for the next cycle
+}
+
+// pacerEventFields is the number of uint64s per event in a buffer
+// filled by readPacerEvents. It must match the runtime.
+const pacerEventFields = 18
+
+// SetPacerTrace enables or disables recording of pacer events for
+// ReadPacerEvents and returns the previous setting. Recording is off
+// initially. With GODEBUG=tracepacer=1, the pacer events are also
+// written to execution traces (see runtime/trace) whenever tracing is
+// on, regardless of this setting.
+func SetPacerTrace(enable bool) bool {
+	return setPacerTrace(enable)
+}
+
+// ReadPacerEvents appends to events the pacer events recorded after
+// the first n, oldest first, and returns the extended slice together
+// with the number of events recorded so far, which can be passed as n
+// to the next call. The runtime keeps the last 4096 events; older ones
+// are skipped.
+func ReadPacerEvents(events []PacerEvent, n uint64) ([]PacerEvent, uint64) {
+	var buf []uint64
+	n = readPacerEvents(&buf, n)
+	for ; len(buf) >= pacerEventFields; buf = buf[pacerEventFields:] {
+		f := buf[:pacerEventFields]
+		events = append(events, PacerEvent{
+			Kind:              PacerEventKind(f[0]),
+			Cycle:             uint32(f[1]),
+			When:              time.Duration(f[2]),
+			HeapLive:          f[3],
+			HeapGoal:          f[4],
+			HeapScan:          f[5],
+			ScanWork:          int64(f[6]),
+			ScanWorkExpected:  int64(f[7]),
+			AssistWorkPerByte: math.Float64frombits(f[8]),
+			DedicatedWorkers:  int64(f[9]),
+			FractionalGoal:    math.Float64frombits(f[10]),
+			HeapMarked:        f[11],
+			Trigger:           f[12],
+			TriggerRatio:      math.Float64frombits(f[13]),
+			GoalGrowth:        math.Float64frombits(f[14]),
+			ActualGrowth:      math.Float64frombits(f[15]),
+			Utilization:       math.Float64frombits(f[16]),
+			NextTriggerRatio:  math.Float64frombits(f[17]),
+		})
+	}
+	return events, n
+}
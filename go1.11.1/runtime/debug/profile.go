@@ -0,0 +1,257 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "runtime"
+
+// This file writes profiles in the protocol buffer format read by the
+// pprof tool (github.com/google/pprof/proto/profile.proto), without
+// compression. A frame is either a code location, given by its PC, or
+// a function with a descriptive name and no code.
+
+// Field numbers in profile.proto.
+const (
+	tagProfileSampleType        = 1 // repeated ValueType
+	tagProfileSample            = 2 // repeated Sample
+	tagProfileLocation          = 4 // repeated Location
+	tagProfileFunction          = 5 // repeated Function
+	tagProfileStringTable       = 6 // repeated string
+	tagProfileDefaultSampleType = 14
+
+	tagValueTypeType = 1
+	tagValueTypeUnit = 2
+
+	tagSampleLocation = 1 // repeated uint64, packed
+	tagSampleValue    = 2 // repeated int64, packed
+	tagSampleLabel    = 3 // repeated Label
+
+	tagLabelKey     = 1
+	tagLabelStr     = 2
+	tagLabelNum     = 3
+	tagLabelNumUnit = 4
+
+	tagLocationID      = 1
+	tagLocationAddress = 3
+	tagLocationLine    = 4 // repeated Line
+
+	tagLineFunctionID = 1
+	tagLineLine       = 2
+
+	tagFunctionID         = 1
+	tagFunctionName       = 2
+	tagFunctionSystemName = 3
+	tagFunctionFilename   = 4
+)
+
+// protobuf encodes a protocol buffer message.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) key(tag, wire int) {
+	b.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	b.key(tag, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protobuf) bytes(tag int, d []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(d)))
+	b.data = append(b.data, d...)
+}
+
+func (b *protobuf) string(tag int, s string) {
+	b.key(tag, 2)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protobuf) packed(tag int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(tag, p.data)
+}
+
+// A profileLabel is a label of a sample: a string if num is not set,
+// else a number with the given unit.
+type profileLabel struct {
+	key, str string
+	num      int64
+	unit     string
+}
+
+// A profileBuilder builds a profile.
+type profileBuilder struct {
+	pb      protobuf
+	strings map[string]int64
+	table   []string
+	funcs   map[[2]string]uint64 // function name and file to function ID
+	named   map[string]uint64    // function name to ID of a location with no PC
+	pcs     map[uintptr]uint64   // PC to location ID
+	nlocs   uint64
+}
+
+// newProfileBuilder starts a profile with the given sample types,
+// pairs of type and unit, the first of which is the default.
+func newProfileBuilder(sampleTypes [][2]string) *profileBuilder {
+	b := &profileBuilder{
+		strings: map[string]int64{},
+		funcs:   map[[2]string]uint64{},
+		named:   map[string]uint64{},
+		pcs:     map[uintptr]uint64{},
+	}
+	b.stringIndex("")
+	for _, t := range sampleTypes {
+		var vt protobuf
+		vt.int64(tagValueTypeType, b.stringIndex(t[0]))
+		vt.int64(tagValueTypeUnit, b.stringIndex(t[1]))
+		b.pb.bytes(tagProfileSampleType, vt.data)
+	}
+	if len(sampleTypes) > 0 {
+		b.pb.int64(tagProfileDefaultSampleType, b.stringIndex(sampleTypes[0][0]))
+	}
+	return b
+}
+
+func (b *profileBuilder) stringIndex(s string) int64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = int64(len(b.table))
+		b.strings[s] = i
+		b.table = append(b.table, s)
+	}
+	return i
+}
+
+// function returns the ID of the function named name in file, adding
+// it to the profile the first time.
+func (b *profileBuilder) function(name, file string) uint64 {
+	k := [2]string{name, file}
+	if id, ok := b.funcs[k]; ok {
+		return id
+	}
+	id := uint64(len(b.funcs) + 1)
+	b.funcs[k] = id
+
+	var f protobuf
+	f.uint64(tagFunctionID, id)
+	f.int64(tagFunctionName, b.stringIndex(name))
+	f.int64(tagFunctionSystemName, b.stringIndex(name))
+	if file != "" {
+		f.int64(tagFunctionFilename, b.stringIndex(file))
+	}
+	b.pb.bytes(tagProfileFunction, f.data)
+	return id
+}
+
+// location returns the ID of a location with no PC in the function
+// named fn, adding both to the profile the first time.
+func (b *profileBuilder) location(fn string) uint64 {
+	if id, ok := b.named[fn]; ok {
+		return id
+	}
+	b.nlocs++
+	id := b.nlocs
+	b.named[fn] = id
+
+	var line protobuf
+	line.uint64(tagLineFunctionID, b.function(fn, ""))
+	var loc protobuf
+	loc.uint64(tagLocationID, id)
+	loc.bytes(tagLocationLine, line.data)
+	b.pb.bytes(tagProfileLocation, loc.data)
+	return id
+}
+
+// pcLocation returns the ID of the location of the return PC pc, as
+// recorded by runtime.Callers, adding it to the profile the first time.
+// The location has a line for each function inlined at pc, innermost
+// first.
+func (b *profileBuilder) pcLocation(pc uintptr) uint64 {
+	if id, ok := b.pcs[pc]; ok {
+		return id
+	}
+	b.nlocs++
+	id := b.nlocs
+	b.pcs[pc] = id
+
+	var loc protobuf
+	loc.uint64(tagLocationID, id)
+	loc.uint64(tagLocationAddress, uint64(pc))
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		f, more := frames.Next()
+		var line protobuf
+		line.uint64(tagLineFunctionID, b.function(f.Function, f.File))
+		line.int64(tagLineLine, int64(f.Line))
+		loc.bytes(tagLocationLine, line.data)
+		if !more {
+			break
+		}
+	}
+	b.pb.bytes(tagProfileLocation, loc.data)
+	return id
+}
+
+// sample adds a sample with a stack of named functions, leaf first,
+// one value per sample type, and labels.
+func (b *profileBuilder) sample(stack []string, values []int64, labels []profileLabel) {
+	locs := make([]uint64, len(stack))
+	for i, fn := range stack {
+		locs[i] = b.location(fn)
+	}
+	b.sampleLocations(locs, values, labels)
+}
+
+// sampleLocations adds a sample with a stack of location IDs, leaf
+// first, one value per sample type, and labels.
+func (b *profileBuilder) sampleLocations(locs []uint64, values []int64, labels []profileLabel) {
+	var s protobuf
+	s.packed(tagSampleLocation, locs)
+	vals := make([]uint64, len(values))
+	for i, v := range values {
+		vals[i] = uint64(v)
+	}
+	s.packed(tagSampleValue, vals)
+	for _, l := range labels {
+		var lp protobuf
+		lp.int64(tagLabelKey, b.stringIndex(l.key))
+		if l.str != "" {
+			lp.int64(tagLabelStr, b.stringIndex(l.str))
+		} else {
+			lp.int64(tagLabelNum, l.num)
+			if l.unit != "" {
+				lp.int64(tagLabelNumUnit, b.stringIndex(l.unit))
+			}
+		}
+		s.bytes(tagSampleLabel, lp.data)
+	}
+	b.pb.bytes(tagProfileSample, s.data)
+}
+
+// build returns the encoded profile.
+func (b *profileBuilder) build() []byte {
+	for _, s := range b.table {
+		b.pb.string(tagProfileStringTable, s)
+	}
+	return b.pb.data
+}
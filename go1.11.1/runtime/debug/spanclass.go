@@ -0,0 +1,116 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// SpanClassStats describes the heap spans of one span class: a size
+// class and whether its objects contain pointers. Large objects, which
+// have a span each, are described under size class 0.
+type SpanClassStats struct {
+	SizeClass  int    // size class, 0 for large objects
+	NoScan     bool   // objects contain no pointers
+	ObjectSize uint64 // size of the objects, 0 for large objects
+
+	Spans       uint64 // spans in use
+	CachedSpans uint64 // spans cached by a P for allocation
+	SpanBytes   uint64 // total size of the spans
+	Objects     uint64 // object slots in the spans
+	Allocated   uint64 // allocated objects, including unswept garbage
+
+	// Memory in the spans that does not hold allocated objects:
+	// free object slots, and the space at the end of each span too
+	// small for an object.
+	FreeBytes uint64
+	TailWaste uint64
+
+	// AllocatedBytes is the size of the allocated objects.
+	// RoundingWaste is an upper bound of the bytes in them beyond
+	// the sizes requested: the sizes are not recorded, but an
+	// object of a size class was requested at least one byte more
+	// than the size of the class below.
+	AllocatedBytes uint64
+	RoundingWaste  uint64
+}
+
+// ReadSpanClassStats appends to stats the statistics of each span class
+// in use, and returns the extended slice.
+//
+// It walks all heap spans, stopping the world for each batch of that
+// many spans, or once for all spans if batch <= 0. With a small batch
+// the pauses are short, but the result is not a consistent snapshot of
+// the heap.
+func ReadSpanClassStats(stats []SpanClassStats, batch int) []SpanClassStats {
+	var buf []uint64
+	// The runtime reports how many values it filled in per span
+	// class. The first 11 are those decoded below.
+	fields := readSpanClassStats(&buf, batch)
+	if fields < 11 {
+		panic("runtime/debug: span class stats have " + strconv.Itoa(fields) + " fields, want at least 11")
+	}
+	for ; len(buf) >= fields; buf = buf[fields:] {
+		f := buf[:fields]
+		s := SpanClassStats{
+			SizeClass:      int(f[0]),
+			NoScan:         f[1] != 0,
+			ObjectSize:     f[2],
+			Spans:          f[3],
+			CachedSpans:    f[4],
+			SpanBytes:      f[5],
+			Objects:        f[6],
+			Allocated:      f[7],
+			TailWaste:      f[8],
+			AllocatedBytes: f[9],
+			RoundingWaste:  f[10],
+		}
+		// A large object fills its span.
+		if s.SizeClass != 0 {
+			s.FreeBytes = (s.Objects - s.Allocated) * s.ObjectSize
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// WriteSpanClassProfile writes stats, as returned by ReadSpanClassStats,
+// to w as an uncompressed profile in the format read by the pprof tool.
+// Each span class is a sample whose stack is its size class over
+// "scan" or "noscan", with the size class and object size as labels.
+func WriteSpanClassProfile(w io.Writer, stats []SpanClassStats) error {
+	b := newProfileBuilder([][2]string{
+		{"span_space", "bytes"},
+		{"spans", "count"},
+		{"objects", "count"},
+		{"inuse_objects", "count"},
+		{"free_space", "bytes"},
+		{"tail_waste", "bytes"},
+		{"rounding_waste", "bytes"},
+	})
+	for _, s := range stats {
+		class := "large objects"
+		if s.SizeClass != 0 {
+			class = fmt.Sprintf("size class %d (%d B)", s.SizeClass, s.ObjectSize)
+		}
+		kind := "scan"
+		if s.NoScan {
+			kind = "noscan"
+		}
+		b.sample([]string{class, kind},
+			[]int64{
+				int64(s.SpanBytes), int64(s.Spans), int64(s.Objects), int64(s.Allocated),
+				int64(s.FreeBytes), int64(s.TailWaste), int64(s.RoundingWaste),
+			},
+			[]profileLabel{
+				{key: "size_class", num: int64(s.SizeClass)},
+				{key: "object_size", num: int64(s.ObjectSize), unit: "bytes"},
+			})
+	}
+	_, err := w.Write(b.build())
+	return err
+}
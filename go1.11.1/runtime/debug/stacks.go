@@ -0,0 +1,154 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// StackProfileRecord describes the stacks of the goroutines created by
+// the same go statement and currently running the same function.
+type StackProfileRecord struct {
+	// CreatedBy is the function and line of the go statement that
+	// created the goroutines, such as "main.serve:42".
+	CreatedBy string
+
+	// Function is the function the goroutines are running: the
+	// innermost function on their stacks outside the runtime.
+	Function string
+
+	Goroutines int
+
+	// StackBytes is the total size of the goroutines' stacks, and
+	// UsedBytes the part of them in use.
+	StackBytes uint64
+	UsedBytes  uint64
+
+	// MaxStackBytes is the total of the largest stack each
+	// goroutine has had since it started, and LargestStack the
+	// largest of those. Stacks are grown by doubling, so the sizes
+	// are powers of two.
+	MaxStackBytes uint64
+	LargestStack  uint64
+}
+
+// stackProfileFields is the number of words per goroutine filled in by
+// readStackProfile. It must match the runtime.
+const stackProfileFields = 4 + 16
+
+// ReadStackProfile returns the stack sizes of all goroutines, grouped
+// by creation site and current function, from the largest total stack
+// size to the smallest. It stops the world while it reads them.
+func ReadStackProfile() []StackProfileRecord {
+	type key struct{ createdBy, function string }
+	var buf []uintptr
+	readStackProfile(&buf)
+	m := make(map[key]*StackProfileRecord)
+	sites := make(map[uintptr]string)
+	var stack []uintptr
+	for ; len(buf) >= stackProfileFields; buf = buf[stackProfileFields:] {
+		f := buf[:stackProfileFields]
+		site, ok := sites[f[0]]
+		if !ok {
+			site = goStatementSite(f[0])
+			sites[f[0]] = site
+		}
+		stack = stack[:0]
+		for _, pc := range f[4:] {
+			if pc == 0 {
+				break
+			}
+			stack = append(stack, pc)
+		}
+		k := key{site, currentFunction(stack)}
+		r := m[k]
+		if r == nil {
+			r = &StackProfileRecord{CreatedBy: k.createdBy, Function: k.function}
+			m[k] = r
+		}
+		r.Goroutines++
+		r.StackBytes += uint64(f[1])
+		r.MaxStackBytes += uint64(f[2])
+		r.UsedBytes += uint64(f[3])
+		if uint64(f[2]) > r.LargestStack {
+			r.LargestStack = uint64(f[2])
+		}
+	}
+	records := make([]StackProfileRecord, 0, len(m))
+	for _, r := range m {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		ri, rj := &records[i], &records[j]
+		if ri.StackBytes != rj.StackBytes {
+			return ri.StackBytes > rj.StackBytes
+		}
+		if ri.CreatedBy != rj.CreatedBy {
+			return ri.CreatedBy < rj.CreatedBy
+		}
+		return ri.Function < rj.Function
+	})
+	return records
+}
+
+// goStatementSite returns the function and line of the go statement
+// that returns to pc, or "main" for the main goroutine.
+func goStatementSite(pc uintptr) string {
+	if pc == 0 {
+		return "main"
+	}
+	fr, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if fr.Function == "" {
+		return fmt.Sprintf("%#x", pc)
+	}
+	return fmt.Sprintf("%s:%d", fr.Function, fr.Line)
+}
+
+// currentFunction returns the innermost function of stack outside the
+// runtime, or the innermost function if they are all in the runtime.
+func currentFunction(stack []uintptr) string {
+	frames := runtime.CallersFrames(stack)
+	first := ""
+	for {
+		fr, more := frames.Next()
+		if first == "" {
+			first = fr.Function
+		}
+		if fr.Function != "" && !strings.HasPrefix(fr.Function, "runtime.") {
+			return fr.Function
+		}
+		if !more {
+			break
+		}
+	}
+	if first == "" {
+		return "unknown"
+	}
+	return first
+}
+
+// WriteStackProfile writes the "stacks" profile, records as returned
+// by ReadStackProfile, to w as an uncompressed profile in the format
+// read by the pprof tool. Each record is a sample whose stack is its
+// function over its creation site.
+func WriteStackProfile(w io.Writer, records []StackProfileRecord) error {
+	b := newProfileBuilder([][2]string{
+		{"stack_space", "bytes"},
+		{"used_stack_space", "bytes"},
+		{"max_stack_space", "bytes"},
+		{"goroutines", "count"},
+	})
+	for _, r := range records {
+		b.sample([]string{r.Function, "go " + r.CreatedBy},
+			[]int64{int64(r.StackBytes), int64(r.UsedBytes), int64(r.MaxStackBytes), int64(r.Goroutines)},
+			[]profileLabel{{key: "largest_stack", num: int64(r.LargestStack), unit: "bytes"}})
+	}
+	_, err := w.Write(b.build())
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"time"
+	"unsafe"
+)
+
+// Implemented in package runtime.
+func readGCStats(*[]time.Duration)
+func freeOSMemory()
+func setMaxStack(int) int
+func setGCPercent(int32) int32
+func setPanicOnFault(bool) bool
+func setMaxThreads(int) int
+func setMaxGoroutines(int, int) int
+func setGoroutineLimitReporter(func(n, max int))
+func setGoroutineLabelLimit(key, value string, n int) int
+func goroutineLabelCount(key, value string) int
+func setSyscallStats(bool) bool
+func setSyscallWarning(int64) int64
+func readSyscallStats(*[]uint64)
+func setMemoryLimit(int64) int64
+func readGCCycles(*[]uint64, uint32)
+func waitGCCycle(uint32) uint32
+func setPacerTrace(bool) bool
+func readPacerEvents(*[]uint64, uint64) uint64
+func newUserArena() unsafe.Pointer
+func userArenaNew(unsafe.Pointer, interface{}) interface{}
+func userArenaMakeSlice(unsafe.Pointer, interface{}, int, int) interface{}
+func freeUserArena(unsafe.Pointer)
+func readSpanClassStats(*[]uint64, int) int
+func readHugePageArenas(*[]uintptr) (string, uintptr)
+func readGoroutineAllocs() (uint64, uint64)
+func setLabelAllocAccounting(bool) bool
+func readLabelAllocs(*[]string, *[]uint64)
+func setAllocHookRate(int) int
+func readAllocHook(*[]uint64, *[]string)
+func weakPointerMake(interface{}, bool) (unsafe.Pointer, interface{})
+func weakPointerValue(unsafe.Pointer, interface{}) interface{}
+func weakPointersCleared() uint64
+func setFinalizerWarning(int64) int64
+func readFinalizerStats(*[8]uint64)
+func readStackProfile(*[]uintptr)
+func readMetrics([]MetricSample)
+func readHeapGraph(int, *[]uint64, *[][]uintptr, *[]string)
+
+// Only implemented on Linux on 386 and amd64, see heapdump_fork.go.
+func forkHeapDump(uintptr) int32
@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "time"
+
+// SyscallBuckets is the number of latency buckets in SyscallStats.
+// Bucket 0 counts calls that took less than 1µs; bucket i counts
+// calls that took at least 2^(i-1)µs and less than 2^iµs; the last
+// bucket counts all longer calls.
+const SyscallBuckets = 24
+
+// SyscallStats describes the system calls made with one system call
+// number.
+type SyscallStats struct {
+	Num     uintptr                // system call number, or ^uintptr(0) if unknown
+	Count   uint64                 // number of calls
+	Total   time.Duration          // time spent in the calls
+	Max     time.Duration          // longest call
+	Buckets [SyscallBuckets]uint64 // latency histogram, see SyscallBuckets
+}
+
+// SetSyscallStats turns accounting of system call latencies on or off
+// and returns the previous setting. The initial setting is off, unless
+// the GODEBUG environment variable contains syscallstats=1.
+//
+// Only system calls made through syscall.Syscall and syscall.Syscall6
+// on 386 and amd64 are attributed to their system call number; cgo
+// calls, other blocking calls and all calls on other architectures
+// are counted under the unknown number.
+func SetSyscallStats(enable bool) bool {
+	return setSyscallStats(enable)
+}
+
+// SetSyscallWarning makes the runtime print a warning, with the
+// goroutine's stack, for every system call that takes longer than d.
+// A zero d, the initial setting unless GODEBUG contains
+// syscallwarn=<milliseconds>, turns warnings off. It returns the
+// previous setting. Warnings are checked periodically, so they may be
+// printed somewhat after the threshold has passed.
+func SetSyscallWarning(d time.Duration) time.Duration {
+	return time.Duration(setSyscallWarning(int64(d)))
+}
+
+// ReadSyscallStats returns the statistics collected while accounting
+// was enabled, one entry per system call number that was used, in
+// increasing order of number, with the unknown number last.
+func ReadSyscallStats() []SyscallStats {
+	const width = 4 + SyscallBuckets
+	var buf []uint64
+	readSyscallStats(&buf)
+	stats := make([]SyscallStats, len(buf)/width)
+	for i := range stats {
+		r := buf[i*width : (i+1)*width]
+		s := &stats[i]
+		s.Num = uintptr(r[0])
+		s.Count = r[1]
+		s.Total = time.Duration(r[2])
+		s.Max = time.Duration(r[3])
+		copy(s.Buckets[:], r[4:])
+	}
+	return stats
+}
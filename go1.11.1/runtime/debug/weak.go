@@ -0,0 +1,161 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// A WeakPointer refers to an object without keeping it alive. Once the
+// object becomes unreachable, the garbage collector clears the weak
+// pointer, and Value returns nil. Weak pointers are cleared before the
+// object's finalizer, if any, is run, and stay cleared if the finalizer
+// makes the object reachable again.
+//
+// Weak pointers made from pointers to the same object compare equal,
+// even after they are cleared, so a WeakPointer may be used as a map
+// key. The zero WeakPointer is a cleared one of no type.
+//
+// Objects smaller than 16 bytes that contain no pointers may share a
+// memory block with other such objects, and a weak pointer to one of
+// them is only cleared when they are all unreachable.
+type WeakPointer struct {
+	h   unsafe.Pointer // weak handle, shared by all weak pointers to the object
+	typ interface{}    // nil pointer of the type of the pointer made weak
+}
+
+// MakeWeak returns a weak pointer to the object p points to. p must be
+// a pointer to an object in the garbage-collected heap, or a nil
+// pointer.
+func MakeWeak(p interface{}) WeakPointer {
+	h, typ := weakPointerMake(p, true)
+	return WeakPointer{h, typ}
+}
+
+// Value returns the pointer w was made from, or a nil pointer of the
+// same type if the object it points to has become unreachable. Value
+// returns nil for the zero WeakPointer.
+func (w WeakPointer) Value() interface{} {
+	if w.typ == nil {
+		return nil
+	}
+	return weakPointerValue(w.h, w.typ)
+}
+
+// A WeakMap is a map whose keys are held by weak pointers. Once a key
+// becomes unreachable, its entry is dropped from the map. Keys must be
+// pointers to objects in the garbage-collected heap. Values are held
+// strongly, so a value that refers to its key keeps the entry alive.
+//
+// The zero WeakMap is empty and ready for use. A WeakMap is safe for
+// concurrent use by multiple goroutines.
+type WeakMap struct {
+	mu      sync.Mutex
+	m       map[WeakPointer]interface{}
+	cleared uint64 // weakPointersCleared when dead entries were last dropped
+}
+
+// Load returns the value stored in the map for key, and whether one was
+// found.
+func (m *WeakMap) Load(key interface{}) (value interface{}, ok bool) {
+	w, found := lookupWeak(key)
+	if !found {
+		return nil, false
+	}
+	m.mu.Lock()
+	value, ok = m.m[w]
+	m.mu.Unlock()
+	return value, ok
+}
+
+// Store sets the value for key.
+func (m *WeakMap) Store(key, value interface{}) {
+	w := MakeWeak(key)
+	if w.h == nil {
+		panic("debug: WeakMap key is a nil pointer")
+	}
+	m.mu.Lock()
+	m.expunge()
+	if m.m == nil {
+		m.m = make(map[WeakPointer]interface{})
+	}
+	m.m[w] = value
+	m.mu.Unlock()
+}
+
+// Delete deletes the value for key.
+func (m *WeakMap) Delete(key interface{}) {
+	w, found := lookupWeak(key)
+	if !found {
+		return
+	}
+	m.mu.Lock()
+	delete(m.m, w)
+	m.mu.Unlock()
+}
+
+// Len returns the number of entries whose keys are still reachable.
+func (m *WeakMap) Len() int {
+	m.mu.Lock()
+	m.expunge()
+	n := len(m.m)
+	m.mu.Unlock()
+	return n
+}
+
+// Range calls f for each key and value in the map, in no particular
+// order, until f returns false. Keys that become unreachable during
+// Range are skipped. f may modify the map.
+func (m *WeakMap) Range(f func(key, value interface{}) bool) {
+	type entry struct {
+		w     WeakPointer
+		value interface{}
+	}
+	m.mu.Lock()
+	m.expunge()
+	entries := make([]entry, 0, len(m.m))
+	for w, v := range m.m {
+		entries = append(entries, entry{w, v})
+	}
+	m.mu.Unlock()
+	for _, e := range entries {
+		key := e.w.Value()
+		if isNilPointer(key) {
+			continue
+		}
+		if !f(key, e.value) {
+			break
+		}
+	}
+}
+
+// expunge drops the entries whose keys have been cleared, if any weak
+// pointer has been cleared since it last ran. m.mu must be held.
+func (m *WeakMap) expunge() {
+	cleared := weakPointersCleared()
+	if cleared == m.cleared {
+		return
+	}
+	m.cleared = cleared
+	for w := range m.m {
+		if isNilPointer(w.Value()) {
+			delete(m.m, w)
+		}
+	}
+}
+
+// lookupWeak returns the weak pointer to key without adding a weak
+// handle to it. found is false if key has no weak pointers, in which
+// case no map holds it.
+func lookupWeak(key interface{}) (w WeakPointer, found bool) {
+	h, typ := weakPointerMake(key, false)
+	return WeakPointer{h, typ}, h != nil
+}
+
+// isNilPointer reports whether p, a pointer, is nil.
+func isNilPointer(p interface{}) bool {
+	return (*[2]unsafe.Pointer)(unsafe.Pointer(&p))[1] == nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Export guts for testing.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// NUMADiscover reads a sysfs-style NUMA node directory and returns,
+// for each node found, its number and the CPUs it contains.
+func NUMADiscover(dir string, allowed []byte) (ids []int, cpus [][]int) {
+	for _, node := range numaDiscoverDir(dir, allowed) {
+		var list []int
+		for i, w := range node.cpus {
+			for b := 0; w != 0; b, w = b+1, w>>1 {
+				if w&1 != 0 {
+					list = append(list, i*64+b)
+				}
+			}
+		}
+		ids = append(ids, int(node.id))
+		cpus = append(cpus, list)
+	}
+	return
+}
+
+type MadviseCall struct {
+	Addr, N uintptr
+	Advice  int32
+}
+
+const (
+	HugePageSize    = sys.HugePageSize
+	MADV_DONTNEED   = _MADV_DONTNEED
+	MADV_HUGEPAGE   = _MADV_HUGEPAGE
+	MADV_NOHUGEPAGE = _MADV_NOHUGEPAGE
+)
+
+// THPAdvice runs f with the huge page policy set to policy and madvise
+// replaced by a fake that records, instead of making, the calls for
+// addresses in [lo, hi). The runtime's own calls go through as usual.
+func THPAdvice(policy string, lo, hi uintptr, f func()) (calls []MadviseCall) {
+	p, ok := parseTHPPolicy(policy)
+	if !ok {
+		panic("bad policy " + policy)
+	}
+	oldPolicy, oldMadvise := thpPolicy, sysMadvise
+	defer func() {
+		thpPolicy, sysMadvise = oldPolicy, oldMadvise
+	}()
+	thpPolicy = p
+	sysMadvise = func(addr unsafe.Pointer, n uintptr, flags int32) {
+		if uintptr(addr) < lo || uintptr(addr) >= hi {
+			madvise(addr, n, flags)
+			return
+		}
+		calls = append(calls, MadviseCall{uintptr(addr), n, flags})
+	}
+	f()
+	return
+}
+
+func SysArenaMapped(v, n uintptr) {
+	sysArenaMapped(unsafe.Pointer(v), n)
+}
+
+func SysUnused(v, n uintptr) {
+	sysUnused(unsafe.Pointer(v), n)
+}
+
+func SysUsed(v, n uintptr) {
+	sysUsed(unsafe.Pointer(v), n)
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Export guts for testing.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+var ParseByteCount = parseByteCount
+
+// MemoryLimitHeapGoal returns the heap goal the memory limit allows,
+// given the non-heap memory, the marked heap, the growth allowed over
+// the limit and the goal computed from GOGC.
+func MemoryLimitHeapGoal(limit, overhead, marked uint64, growth float64, goal uint64) (uint64, bool) {
+	return memoryLimitHeapGoal(limit, overhead, marked, growth, goal)
+}
+
+var MemoryLimitNextGrowth = memoryLimitNextGrowth
+
+const (
+	MemoryLimitMaxCPU    = memoryLimitMaxCPU
+	MemoryLimitMinGrowth = memoryLimitMinGrowth
+	MemoryLimitMaxGrowth = memoryLimitMaxGrowth
+)
+
+const (
+	FixedStack           = _FixedStack
+	MaxStartingStackSize = maxStartingStackSize
+)
+
+var (
+	ParseStackStart      = parseStackStart
+	StartingStackSizeFor = startingStackSizeFor
+)
+
+func StartingStackSize() int {
+	return int(atomic.Load(&startingStackSize))
+}
+
+// SetTraceSTW sets GODEBUG=tracestw and returns the previous setting.
+func SetTraceSTW(on bool) (old bool) {
+	old = debug.tracestw != 0
+	debug.tracestw = 0
+	if on {
+		debug.tracestw = 1
+	}
+	return old
+}
+
+// SetTraceSyscall sets GODEBUG=tracesyscall and returns the previous
+// setting.
+func SetTraceSyscall(on bool) (old bool) {
+	old = debug.tracesyscall != 0
+	debug.tracesyscall = 0
+	if on {
+		debug.tracesyscall = 1
+	}
+	return old
+}
+
+// SetTracePacer sets GODEBUG=tracepacer and returns the previous
+// setting.
+func SetTracePacer(on bool) (old bool) {
+	old = debug.tracepacer != 0
+	debug.tracepacer = 0
+	if on {
+		debug.tracepacer = 1
+	}
+	return old
+}
+
+// NewGoroutineStackSize returns the size of the stack of a new goroutine.
+func NewGoroutineStackSize() int {
+	c := make(chan uintptr)
+	go func() {
+		gp := getg()
+		c <- gp.stack.hi - gp.stack.lo
+	}()
+	return int(<-c)
+}
+
+// MetricNames returns the names of the metrics the runtime supports.
+func MetricNames() []string {
+	semacquire(&metricsSema)
+	initMetrics()
+	semrelease(&metricsSema)
+	names := make([]string, 0, len(metricsTable))
+	for name := range metricsTable {
+		names = append(names, name)
+	}
+	return names
+}
+
+// CurrentM returns an identifier of the M, and so of the thread, the
+// calling goroutine runs on.
+func CurrentM() uintptr {
+	return uintptr(unsafe.Pointer(getg().m))
+}
+
+// LockedThreadMs returns the number of Ms that belong to t.
+func LockedThreadMs(t *LockedThread) int {
+	n := 0
+	lock(&sched.lock)
+	for mp := allm; mp != nil; mp = mp.alllink {
+		if mp.lockedThread == t {
+			n++
+		}
+	}
+	unlock(&sched.lock)
+	return n
+}
+
+const RetainExtraPercent = retainExtraPercent
+
+const PacerReviseInterval = pacerReviseInterval
+
+const UserArenaQuarantineBytes = userArenaQuarantineBytes
+
+// ReadSpanClassStatsRaw returns the buffer filled in by
+// readSpanClassStats and the number of values per span class.
+func ReadSpanClassStatsRaw(batch int) ([]uint64, int) {
+	var buf []uint64
+	fields := readSpanClassStats(&buf, batch)
+	return buf, fields
+}
+
+// SetProfLabel sets the profiling labels of the calling goroutine, as
+// runtime/pprof.SetGoroutineLabels does.
+func SetProfLabel(labels map[string]string) {
+	if labels == nil {
+		runtime_setProfLabel(nil)
+		return
+	}
+	runtime_setProfLabel(unsafe.Pointer(&labels))
+}
+
+// GoroutineProfileWithLabels is like GoroutineProfile, but also returns
+// the profiling labels of each goroutine recorded, as set by
+// SetProfLabel.
+func GoroutineProfileWithLabels(p []StackRecord) (n int, labels []map[string]string, ok bool) {
+	lp := make([]unsafe.Pointer, len(p))
+	n, ok = runtime_goroutineProfileWithLabels(p, lp)
+	if !ok {
+		return n, nil, false
+	}
+	labels = make([]map[string]string, n)
+	for i := range labels {
+		if lp[i] != nil {
+			labels[i] = *(*map[string]string)(lp[i])
+		}
+	}
+	return n, labels, true
+}
+
+const LabelAllocMaxSets = labelAllocMaxSets
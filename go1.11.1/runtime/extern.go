@@ -21,11 +21,36 @@ is GOGC=100. Setting GOGC=off disables the garbage collector entirely.
 The runtime/debug package's SetGCPercent function allows changing this
 percentage at run time. See https://golang.org/pkg/runtime/debug/#SetGCPercent.
 
+The GOMEMLIMIT variable sets a soft limit on the memory used by the runtime,
+as a number of bytes with an optional unit suffix: B, KiB, MiB, GiB or TiB.
+As the memory in use approaches the limit, the garbage collector runs more
+often and free memory is returned to the operating system sooner. This also
+applies with GOGC=off. GOMEMLIMIT=off, the default, sets no limit. The
+runtime/debug package's SetMemoryLimit function allows changing the limit
+at run time.
+
+The GOSTACKSTART variable sets the stack size of new goroutines, as a number
+of bytes with an optional unit suffix, rounded up to a power of two between
+2KiB and 16KiB. By default, GOSTACKSTART=adaptive, the runtime sets it after
+every garbage collection from the average stack space used by the program's
+goroutines, so that goroutines rarely need to grow their stacks.
+
+On Linux, the GOTHP variable selects how the heap uses transparent huge pages.
+GOTHP=always asks the kernel to back the heap with huge pages and never turns
+them off. GOTHP=never asks it not to. GOTHP=heap asks for huge pages except on
+heap memory returned to the operating system, until it is reused, which bounds
+the memory huge pages can add to the resident set size. By default, the kernel's
+setting applies, with huge pages turned off around memory returned to the
+operating system. The runtime/debug package's ReadHugePageStats function reports
+how much of the heap is backed by huge pages.
+
 The GODEBUG variable controls debugging variables within the runtime.
 It is a comma-separated list of name=val pairs setting these named variables:
 
 	allocfreetrace: setting allocfreetrace=1 causes every allocation to be
 	profiled and a stack trace printed on each object's allocation and free.
+	The runtime/debug package's SetAllocHook function reports sampled
+	allocations and frees at a cost suitable for production use.
 
 	cgocheck: setting cgocheck=0 disables all checks for packages
 	using cgo to incorrectly pass Go pointers to non-Go code.
@@ -38,14 +63,26 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	where each object is allocated on a unique page and addresses are
 	never recycled.
 
+	finwarn: setting finwarn=N causes the runtime to print the function and the
+	stack of a finalizer that has been running for more than N milliseconds, which
+	holds up all other finalizers. See also runtime/debug.SetFinalizerWarning.
+
 	gccheckmark: setting gccheckmark=1 enables verification of the
 	garbage collector's concurrent mark phase by performing a
 	second mark pass while the world is stopped.  If the second
 	pass finds a reachable object that was not found by concurrent
 	mark, the garbage collector will panic.
 
+	gcgen: setting gcgen=1 enables an experimental generational mode. Most
+	collections then only mark and free the objects allocated since the previous
+	collection, and run more often than full collections. Write barriers stay
+	enabled between collections to track pointers from older objects. This mode
+	is ignored with gccheckmark=1 or gctrace=2.
+
 	gcpacertrace: setting gcpacertrace=1 causes the garbage collector to
 	print information about the internal state of the concurrent pacer.
+	The same information is available as structured data from
+	runtime/debug.ReadPacerEvents and in execution traces.
 
 	gcshrinkstackoff: setting gcshrinkstackoff=1 disables moving goroutines
 	onto smaller stacks. In this mode, a goroutine's stack can only grow.
@@ -78,7 +115,8 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	for mark/scan are broken down in to assist time (GC performed in
 	line with allocation), background GC time, and idle GC time.
 	If the line ends with "(forced)", this GC was forced by a
-	runtime.GC() call.
+	runtime.GC() call. If it ends with "(minor)", this GC only
+	collected the objects allocated since the previous GC (see gcgen).
 
 	Setting gctrace to any value > 0 also causes the garbage collector
 	to emit a summary when memory is released back to the system.
@@ -94,6 +132,18 @@ It is a comma-separated list of name=val pairs setting these named variables:
 		sys: #       MB mapped from the system
 		released: #  MB released to the system
 		consumed: #  MB allocated from the system
+	The background scavenger, which returns memory to the system a little at
+	a time after each collection, emits a summary when it reaches its goal:
+		scvg: background: # KB released in # ms, retained: #, goal: # (MB)
+	where the fields are as follows:
+		released in # ms  KB released and the time it took since the last summary
+		retained: #       MB of heap memory still obtained from the system
+		goal: #           MB the scavenger aims to retain, a little above the heap goal
+
+	heaptypes: setting heaptypes=1 causes the allocator to record the type of
+	every heap object, so that heap dumps written by runtime/debug.WriteHeapDump
+	say what each object is. It costs a word of memory for each object, and
+	only objects allocated after the program starts are recorded.
 
 	memprofilerate: setting memprofilerate=X will update the value of runtime.MemProfileRate.
 	When set to 0 memory profiling is disabled.  Refer to the description of
@@ -105,6 +155,11 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	This should only be used as a temporary workaround to diagnose buggy code.
 	The real fix is to not store integers in pointer-typed locations.
 
+	numa: setting numa=1 makes the scheduler group Ps by NUMA node and steal work
+	from Ps on the same node first, on Linux machines with more than one node.
+	Setting numapin=1 as well binds each thread to the CPUs of the node of its P;
+	this is only implemented on linux/386 and linux/amd64.
+
 	sbrk: setting sbrk=1 replaces the memory allocator and garbage collector
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
@@ -124,6 +179,22 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	IDs will refer to the ID of the goroutine at the time of creation; it's possible for this
 	ID to be reused for another goroutine. Setting N to 0 will report no ancestry information.
 
+	tracepacer: setting tracepacer=1 causes execution traces to record the decisions of
+	the GC pacer, as described for runtime/debug.PacerEvent, as events of types 50
+	(start of cycle), 51 (revise) and 52 (end of cycle). Revise events are recorded at
+	most once a millisecond. As with tracestw, the Go 1.11 trace parser rejects these
+	events.
+
+	tracestw: setting tracestw=1 causes execution traces to record the times the world
+	is stopped outside the garbage collector, such as by GOMAXPROCS or runtime.Stack,
+	as GC STW events of kind 2. The trace format version is unchanged and the Go 1.11
+	trace parser rejects these events, so only set this for tools that expect them.
+
+	tracesyscall: setting tracesyscall=1 causes execution traces to record the number
+	and duration of every system call accounted by syscallstats=1 or
+	runtime/debug.SetSyscallStats, as events of type 49. As with tracestw, the Go 1.11
+	trace parser rejects these events.
+
 The net and net/http packages also refer to debugging variables in GODEBUG.
 See the documentation for those packages for details.
 
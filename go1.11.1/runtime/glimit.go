@@ -0,0 +1,409 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Goroutine limits.
+//
+// The runtime can optionally enforce a limit on the number of live
+// user goroutines, much like sched.maxmcount limits the number of Ms.
+// The count is derived from the same bookkeeping as gcount: allglen
+// minus the goroutines sitting on the free lists.
+//
+// What happens when a go statement would exceed the limit depends on
+// the policy:
+//
+// glimitBlock: the new goroutine is created (so its arguments are
+// copied off the spawner's stack and are visible to the GC), but it is
+// parked in _Gwaiting on glimit.pending instead of being put on a run
+// queue. The spawner then parks until some goroutine exits and the new
+// goroutine is admitted. At most one goroutine per blocked spawner is
+// pending, so the overshoot is bounded by the number of spawners.
+//
+// glimitPanic: the new goroutine is discarded and the go statement
+// panics.
+//
+// glimitReport: the goroutine is started anyway and the registered
+// callback is invoked once per excursion above the limit.
+//
+// In addition, up to glimitMaxQuotas per-label quotas can be set. A
+// goroutine is charged to every quota whose key=value pair appears in
+// the profiling labels it was created with (inherited from its
+// creator, see newproc1). Later changes to the labels of a running
+// goroutine do not move its charge.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+// Goroutine limit policies. These must match the values in
+// runtime/debug.
+const (
+	glimitBlock = iota
+	glimitPanic
+	glimitReport
+)
+
+// Actions returned by glimitCheck to newproc1.
+const (
+	glimitRun  = iota // queue the new goroutine as usual
+	glimitPend        // finish creating it, then call glimitAddPending
+	glimitDrop        // discard it
+)
+
+// Spawner states, kept in g.glimitState of the goroutine executing
+// the go statement. They are set by newproc1 on the system stack and
+// acted on by glimitSpawned once newproc is back on the user stack.
+const (
+	glimitSpawnerNone     = iota
+	glimitSpawnerWaiting  // child is pending; protected by glimit.lock
+	glimitSpawnerParked   // as waiting, and the spawner is parked
+	glimitSpawnerRejected // child was discarded; panic
+	glimitSpawnerReport   // child exceeded the limit; call glimit.report
+)
+
+const glimitMaxQuotas = 64
+
+type glimitQuota struct {
+	key, value string
+	max        int32 // 0 means unlimited
+	n          int32 // goroutines charged, including pending ones
+	npending   int32 // pending goroutines charged
+}
+
+var glimit struct {
+	// enabled is set if a global limit or any quota is in effect.
+	// It is read without the lock on the newproc fast path.
+	enabled uint32
+
+	lock     mutex
+	max      int32 // maximum live user goroutines; 0 means unlimited
+	policy   int32
+	reported bool // glimit.report was called for the current excursion
+	report   func(n, max int)
+
+	// pending is a list of goroutines created in excess of the
+	// limit, linked through g.schedlink.
+	pendinghead guintptr
+	pendingtail guintptr
+	npending    int32
+
+	quotas []glimitQuota
+}
+
+// glimitLive returns the number of live user goroutines that are not
+// pending admission. glimit.lock must be held.
+func glimitLive() int32 {
+	return gcount() - glimit.npending
+}
+
+// glimitCheck is called by newproc1 on the system stack once newg has
+// its labels but before it is made runnable. It charges newg to the
+// limits and returns one of glimitRun, glimitPend or glimitDrop.
+func glimitCheck(newg, callergp *g) int {
+	lock(&glimit.lock)
+	var charged uint64
+	over := glimit.max > 0 && glimitLive() > glimit.max
+	if newg.labels != nil && len(glimit.quotas) > 0 {
+		labels := *(*map[string]string)(newg.labels)
+		for i := range glimit.quotas {
+			q := &glimit.quotas[i]
+			if v, ok := labels[q.key]; ok && v == q.value {
+				charged |= 1 << uint(i)
+				if q.max > 0 && q.n-q.npending >= q.max {
+					over = true
+				}
+			}
+		}
+	}
+	action := glimitRun
+	if over {
+		switch glimit.policy {
+		case glimitPanic:
+			callergp.glimitState = glimitSpawnerRejected
+			action = glimitDrop
+		case glimitReport:
+			if !glimit.reported && glimit.report != nil {
+				glimit.reported = true
+				callergp.glimitState = glimitSpawnerReport
+			}
+		default:
+			// newg is counted as pending from now on, even though
+			// it is only put on the list by glimitAddPending.
+			glimit.npending++
+			callergp.glimitState = glimitSpawnerWaiting
+			action = glimitPend
+		}
+	}
+	switch action {
+	case glimitRun:
+		glimitCharge(newg, charged, 1, 0)
+	case glimitPend:
+		glimitCharge(newg, charged, 1, 1)
+	}
+	unlock(&glimit.lock)
+	return action
+}
+
+// glimitAddPending puts newg, which newproc1 has fully initialized in
+// _Gwaiting, on the pending list.
+func glimitAddPending(newg, callergp *g) {
+	lock(&glimit.lock)
+	newg.glimitSpawner.set(callergp)
+	newg.schedlink = 0
+	if glimit.pendingtail != 0 {
+		glimit.pendingtail.ptr().schedlink.set(newg)
+	} else {
+		glimit.pendinghead.set(newg)
+	}
+	glimit.pendingtail.set(newg)
+	// Goroutines may have exited since glimitCheck, in which case
+	// nobody else will admit newg.
+	glimitAdmit(glimitLive())
+	unlock(&glimit.lock)
+}
+
+// glimitCharge adds delta to the quotas in mask and records the mask
+// in gp. glimit.lock must be held.
+func glimitCharge(gp *g, mask uint64, delta, pending int32) {
+	gp.glimitQuotas = mask
+	for i := 0; mask != 0; i, mask = i+1, mask>>1 {
+		if mask&1 != 0 {
+			glimit.quotas[i].n += delta
+			glimit.quotas[i].npending += pending
+		}
+	}
+}
+
+// glimitSpawned finishes a go statement that ran into a goroutine
+// limit. It is called by newproc on the spawner's stack.
+//
+// This must not be inlined into newproc: newproc's frame holds the
+// untyped arguments of the go statement and must not grow.
+//
+//go:noinline
+func glimitSpawned(gp *g) {
+	switch gp.glimitState {
+	case glimitSpawnerRejected:
+		gp.glimitState = glimitSpawnerNone
+		panic(plainError("goroutine limit exceeded"))
+	case glimitSpawnerReport:
+		gp.glimitState = glimitSpawnerNone
+		lock(&glimit.lock)
+		f, n, max := glimit.report, int(glimitLive()), int(glimit.max)
+		unlock(&glimit.lock)
+		if f != nil {
+			f(n, max)
+		}
+	case glimitSpawnerWaiting:
+		lock(&glimit.lock)
+		if gp.glimitState != glimitSpawnerWaiting {
+			// Admitted already.
+			unlock(&glimit.lock)
+			return
+		}
+		gp.glimitState = glimitSpawnerParked
+		goparkunlock(&glimit.lock, waitReasonGoroutineLimit, traceEvGoBlock, 1)
+	}
+}
+
+// glimitExit is called by goexit0 for a goroutine that is about to be
+// put on a free list. It releases the goroutine's charges and admits
+// pending goroutines that now fit.
+func glimitExit(gp *g) {
+	if gp.glimitQuotas == 0 && atomic.Load(&glimit.enabled) == 0 {
+		return
+	}
+	lock(&glimit.lock)
+	glimitCharge(gp, gp.glimitQuotas, -1, 0)
+	gp.glimitQuotas = 0
+	// gp is still counted by gcount until gfput.
+	live := glimitLive() - 1
+	if glimit.max == 0 || live <= glimit.max {
+		// Back within the limit: the next excursion is reported.
+		glimit.reported = false
+	}
+	glimitAdmit(live)
+	unlock(&glimit.lock)
+}
+
+// glimitAdmit makes pending goroutines runnable as long as they fit
+// in the global limit and their quotas, given live goroutines already
+// running. Pending goroutines are scanned in FIFO order; one that is
+// over its quota does not hold up the others.
+// glimit.lock must be held and the caller must own a P.
+func glimitAdmit(live int32) {
+	var prev *g
+	for gp := glimit.pendinghead.ptr(); gp != nil; {
+		next := gp.schedlink.ptr()
+		if glimit.max > 0 && live >= glimit.max {
+			break
+		}
+		if !glimitFits(gp.glimitQuotas) {
+			prev, gp = gp, next
+			continue
+		}
+
+		// Unlink gp.
+		if prev != nil {
+			prev.schedlink.set(next)
+		} else {
+			glimit.pendinghead.set(next)
+		}
+		if next == nil {
+			glimit.pendingtail.set(prev)
+		}
+		gp.schedlink = 0
+		glimit.npending--
+		for i, mask := 0, gp.glimitQuotas; mask != 0; i, mask = i+1, mask>>1 {
+			if mask&1 != 0 {
+				glimit.quotas[i].npending--
+			}
+		}
+		live++
+
+		// gp has never run. If it was created while tracing, the
+		// tracer thinks it is runnable already. If tracing started
+		// afterwards, StartTrace reported it as waiting, which bumped
+		// traceseq, and it needs an unblock event.
+		if trace.enabled && gp.traceseq != 0 {
+			traceGoUnpark(gp, 0)
+		}
+		gp.waitreason = waitReasonZero
+		casgstatus(gp, _Gwaiting, _Grunnable)
+		runqput(getg().m.p.ptr(), gp, false)
+
+		spawner := gp.glimitSpawner.ptr()
+		gp.glimitSpawner = 0
+		parked := spawner.glimitState == glimitSpawnerParked
+		spawner.glimitState = glimitSpawnerNone
+		if parked {
+			ready(spawner, 0, false)
+		}
+
+		gp = next
+	}
+	if atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 {
+		wakep()
+	}
+}
+
+// glimitFits reports whether a goroutine charged to the quotas in
+// mask can start running. glimit.lock must be held.
+func glimitFits(mask uint64) bool {
+	for i := 0; mask != 0; i, mask = i+1, mask>>1 {
+		q := &glimit.quotas[i]
+		if mask&1 != 0 && q.max > 0 && q.n-q.npending >= q.max {
+			return false
+		}
+	}
+	return true
+}
+
+// glimitUpdate recomputes glimit.enabled and admits pending goroutines
+// after the limits changed. glimit.lock must be held.
+func glimitUpdate() {
+	enabled := glimit.max > 0
+	for i := range glimit.quotas {
+		if glimit.quotas[i].max > 0 {
+			enabled = true
+		}
+	}
+	if enabled {
+		atomic.Store(&glimit.enabled, 1)
+	} else {
+		atomic.Store(&glimit.enabled, 0)
+	}
+	if glimit.npending > 0 {
+		mp := acquirem()
+		glimitAdmit(glimitLive())
+		releasem(mp)
+	}
+}
+
+//go:linkname setMaxGoroutines runtime/debug.setMaxGoroutines
+func setMaxGoroutines(in, policy int) (out int) {
+	lock(&glimit.lock)
+	out = int(glimit.max)
+	if in > 0x7fffffff { // MaxInt32
+		glimit.max = 0x7fffffff
+	} else if in < 0 {
+		glimit.max = 0
+	} else {
+		glimit.max = int32(in)
+	}
+	glimit.policy = int32(policy)
+	glimit.reported = false
+	glimitUpdate()
+	unlock(&glimit.lock)
+	return
+}
+
+//go:linkname setGoroutineLimitReporter runtime/debug.setGoroutineLimitReporter
+func setGoroutineLimitReporter(f func(n, max int)) {
+	lock(&glimit.lock)
+	glimit.report = f
+	glimit.reported = false
+	unlock(&glimit.lock)
+}
+
+//go:linkname setGoroutineLabelLimit runtime/debug.setGoroutineLabelLimit
+func setGoroutineLabelLimit(key, value string, in int) (out int) {
+	if in > 0x7fffffff {
+		in = 0x7fffffff
+	} else if in < 0 {
+		in = 0
+	}
+	var grown []glimitQuota
+	lock(&glimit.lock)
+	q := glimitFindQuota(key, value)
+	for q == nil && in != 0 && len(grown) != len(glimit.quotas)+1 {
+		// Quotas are never removed, so that the indexes recorded
+		// in g.glimitQuotas stay valid. Grow with a copy, since
+		// glimit.quotas is read on the system stack, allocated
+		// without the lock. Retry if the quotas changed meanwhile.
+		n := len(glimit.quotas)
+		unlock(&glimit.lock)
+		if n == glimitMaxQuotas {
+			panic(plainError("too many goroutine label limits"))
+		}
+		grown = make([]glimitQuota, n+1)
+		lock(&glimit.lock)
+		q = glimitFindQuota(key, value)
+	}
+	if q == nil {
+		if in == 0 {
+			unlock(&glimit.lock)
+			return 0
+		}
+		copy(grown, glimit.quotas)
+		grown[len(grown)-1] = glimitQuota{key: key, value: value}
+		glimit.quotas = grown
+		q = &grown[len(grown)-1]
+	}
+	out = int(q.max)
+	q.max = int32(in)
+	glimitUpdate()
+	unlock(&glimit.lock)
+	return
+}
+
+//go:linkname goroutineLabelCount runtime/debug.goroutineLabelCount
+func goroutineLabelCount(key, value string) int {
+	lock(&glimit.lock)
+	defer unlock(&glimit.lock)
+	if q := glimitFindQuota(key, value); q != nil {
+		return int(q.n - q.npending)
+	}
+	return -1
+}
+
+// glimitFindQuota returns the quota for key=value, or nil. glimit.lock
+// must be held.
+func glimitFindQuota(key, value string) *glimitQuota {
+	for i := range glimit.quotas {
+		if q := &glimit.quotas[i]; q.key == key && q.value == value {
+			return q
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitClosed fails t unless ch is closed within 5 seconds.
+func waitClosed(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: timed out", what)
+	}
+}
+
+// checkOpen fails t if ch is closed within 100ms.
+func checkOpen(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("%s: happened despite the limit", what)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// waitGoroutines waits for the number of goroutines to drop to n.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; runtime.NumGoroutine() > n; i++ {
+		if i == 500 {
+			t.Fatalf("%d goroutines, want %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// spawner starts a goroutine that runs f once start is closed, and
+// closes the returned channel when f returns. It exists before a limit
+// is set, so that the go statements in f are the only ones counted.
+func spawner(start chan struct{}, labels map[string]string, f func()) chan struct{} {
+	done := make(chan struct{})
+	ready := make(chan struct{})
+	go func() {
+		if labels != nil {
+			runtime.SetProfLabel(labels)
+		}
+		close(ready)
+		<-start
+		f()
+		close(done)
+	}()
+	<-ready
+	return done
+}
+
+// The helper runs in a process of its own, so that the number of
+// goroutines is not disturbed by other tests.
+func TestGoroutineLimitHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	defer debug.SetMaxGoroutines(0, debug.BlockOnLimit)
+
+	t.Run("Block", func(t *testing.T) {
+		release := make(chan struct{})
+		go func() { <-release }()
+		start := make(chan struct{})
+		ran := make(chan struct{})
+		done := spawner(start, nil, func() {
+			go close(ran)
+		})
+		base := runtime.NumGoroutine()
+		debug.SetMaxGoroutines(base, debug.BlockOnLimit)
+		defer debug.SetMaxGoroutines(0, debug.BlockOnLimit)
+		close(start)
+		checkOpen(t, ran, "new goroutine ran")
+		checkOpen(t, done, "go statement returned")
+		// An exiting goroutine makes room.
+		close(release)
+		waitClosed(t, ran, "new goroutine ran")
+		waitClosed(t, done, "go statement returned")
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		go func() { <-release }()
+		base := runtime.NumGoroutine()
+		debug.SetMaxGoroutines(base, debug.PanicOnLimit)
+		defer debug.SetMaxGoroutines(0, debug.BlockOnLimit)
+		ran := make(chan struct{})
+		var r interface{}
+		func() {
+			defer func() { r = recover() }()
+			go close(ran)
+		}()
+		if r == nil || !strings.Contains(r.(error).Error(), "goroutine limit exceeded") {
+			t.Fatalf("go statement over the limit: recovered %v, want a goroutine limit panic", r)
+		}
+		checkOpen(t, ran, "rejected goroutine ran")
+		debug.SetMaxGoroutines(0, debug.PanicOnLimit)
+		go close(ran)
+		waitClosed(t, ran, "goroutine ran without a limit")
+	})
+
+	t.Run("Report", func(t *testing.T) {
+		type report struct{ n, max int }
+		var reports []report
+		debug.SetGoroutineLimitReporter(func(n, max int) {
+			reports = append(reports, report{n, max})
+		})
+		defer debug.SetGoroutineLimitReporter(nil)
+		base := runtime.NumGoroutine()
+		debug.SetMaxGoroutines(base, debug.ReportOnLimit)
+		defer debug.SetMaxGoroutines(0, debug.BlockOnLimit)
+
+		// Two goroutines over the limit are one excursion.
+		release := make(chan struct{})
+		ran := make(chan struct{}, 3)
+		for i := 0; i < 2; i++ {
+			go func() {
+				ran <- struct{}{}
+				<-release
+			}()
+		}
+		<-ran
+		<-ran
+		if len(reports) != 1 || reports[0] != (report{base + 1, base}) {
+			t.Fatalf("reports %v, want one of %d goroutines, limit %d", reports, base+1, base)
+		}
+		// Dropping back under the limit ends it.
+		close(release)
+		waitGoroutines(t, base)
+		go func() { ran <- struct{}{} }()
+		<-ran
+		if len(reports) != 2 {
+			t.Fatalf("reports %v, want a second one after dropping under the limit", reports)
+		}
+	})
+
+	t.Run("Label", func(t *testing.T) {
+		const key, value = "glimit", "label"
+		if n := debug.GoroutineLabelCount(key, value); n != -1 {
+			t.Fatalf("GoroutineLabelCount before any limit = %d, want -1", n)
+		}
+		labels := map[string]string{key: value, "other": "x"}
+		release1 := make(chan struct{})
+		release2 := make(chan struct{})
+		ran1 := make(chan struct{})
+		ran2 := make(chan struct{})
+		start := make(chan struct{})
+		done := spawner(start, labels, func() {
+			go func() {
+				close(ran1)
+				<-release1
+			}()
+			go func() {
+				close(ran2)
+				<-release2
+			}()
+		})
+		debug.SetGoroutineLabelLimit(key, value, 1)
+		defer debug.SetGoroutineLabelLimit(key, value, 0)
+
+		close(start)
+		waitClosed(t, ran1, "first labelled goroutine ran")
+		checkOpen(t, ran2, "second labelled goroutine ran")
+		if n := debug.GoroutineLabelCount(key, value); n != 1 {
+			t.Errorf("GoroutineLabelCount = %d with one goroutine running and one pending, want 1", n)
+		}
+
+		// Goroutines without the label are not held up.
+		other := make(chan struct{})
+		go close(other)
+		waitClosed(t, other, "unlabelled goroutine ran")
+
+		// The first one exiting admits the second.
+		close(release1)
+		waitClosed(t, ran2, "second labelled goroutine ran")
+		waitClosed(t, done, "go statement returned")
+		if n := debug.GoroutineLabelCount(key, value); n != 1 {
+			t.Errorf("GoroutineLabelCount = %d after the second goroutine was admitted, want 1", n)
+		}
+		close(release2)
+		for i := 0; debug.GoroutineLabelCount(key, value) != 0; i++ {
+			if i == 500 {
+				t.Fatalf("GoroutineLabelCount = %d after all labelled goroutines exited, want 0", debug.GoroutineLabelCount(key, value))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if old := debug.SetGoroutineLabelLimit(key, value, 0); old != 1 {
+			t.Errorf("SetGoroutineLabelLimit returned %d, want the previous limit 1", old)
+		}
+	})
+}
+
+func TestGoroutineLimit(t *testing.T) {
+	out, err := runHelper(t, "TestGoroutineLimitHelper")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
@@ -7,7 +7,9 @@
 // finalizers, etc.) to a file.
 
 // The format of the dumped file is described at
-// https://golang.org/s/go15heapdump.
+// https://golang.org/s/go15heapdump. With GODEBUG=heaptypes=1,
+// objects whose type is known are written as tagTypedObject records,
+// which add the address of a type record after the object's address.
 
 package runtime
 
@@ -50,6 +52,7 @@ const (
 	tagPanic           = 15
 	tagMemProf         = 16
 	tagAllocSample     = 17
+	tagTypedObject     = 18
 )
 
 var dumpfd uintptr // fd to write the dump to.
@@ -198,10 +201,17 @@ func dumptype(t *_type) {
 	dumpbool(t.kind&kindDirectIface == 0 || t.kind&kindNoPointers == 0)
 }
 
-// dump an object
-func dumpobj(obj unsafe.Pointer, size uintptr, bv bitvector) {
-	dumpint(tagObject)
-	dumpint(uint64(uintptr(obj)))
+// dump an object, and its type if known
+func dumpobj(obj unsafe.Pointer, size uintptr, bv bitvector, t *_type) {
+	if t != nil {
+		dumptype(t)
+		dumpint(tagTypedObject)
+		dumpint(uint64(uintptr(obj)))
+		dumpint(uint64(uintptr(unsafe.Pointer(t))))
+	} else {
+		dumpint(tagObject)
+		dumpint(uint64(uintptr(obj)))
+	}
 	dumpmemrange(obj, size)
 	dumpfields(bv)
 }
@@ -474,7 +484,7 @@ func dumpobjs() {
 				freemark[j] = false
 				continue
 			}
-			dumpobj(unsafe.Pointer(p), size, makeheapobjbv(p, size))
+			dumpobj(unsafe.Pointer(p), size, makeheapobjbv(p, size), s.typeOf(p))
 		}
 	}
 }
@@ -0,0 +1,74 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build 386 amd64
+
+// Heap dump snapshots.
+//
+// WriteHeapDump stops the world for as long as it takes to write the
+// dump, which is seconds for a large heap. What was asked for is a
+// dump written incrementally alongside the concurrent mark phase,
+// snapshotting objects copy-on-write. That is not what this is: the
+// write barrier only sees pointer writes, so it cannot snapshot
+// objects. The kernel can: a forked child process shares the parent's
+// memory copy-on-write. So runtime/debug.WriteHeapDumpSnapshot stops
+// the world to fork, and the child, whose memory is frozen at the
+// fork, writes the dump with writeheapdump_m and exits while the
+// parent carries on. The GC plays no part.
+//
+// The pause is not constant: fork copies the page tables, which takes
+// time proportional to the memory mapped. Only linux/386 and
+// linux/amd64 have rawfork.
+//
+// The child has only the forking thread, and any runtime lock that
+// another thread held at the fork stays held forever. Ps are stopped,
+// so only threads without a P can hold one. Of the locks the dump
+// takes, sysmon may hold the heap lock to scavenge, so the heap lock
+// is held across the fork, which delays anyone who needs it for the
+// duration of the fork. The others, see forkResetLocks, are
+// reinitialized in the child.
+
+package runtime
+
+// rawfork forks the process. The child continues on a copy of the
+// calling thread and its stack. It returns the child's process ID in
+// the parent, 0 in the child, or a negative errno.
+func rawfork() int32
+
+// forkHeapDump forks a child process that writes a heap dump to fd
+// and exits with status 0. It returns the child's process ID, or a
+// negative errno.
+//
+//go:linkname forkHeapDump runtime/debug.forkHeapDump
+func forkHeapDump(fd uintptr) int32 {
+	stopTheWorld("fork heap dump")
+	var pid int32
+	systemstack(func() {
+		lock(&mheap_.lock)
+		pid = rawfork()
+		unlock(&mheap_.lock)
+		if pid == 0 {
+			forkResetLocks()
+			writeheapdump_m(fd)
+			exit(0)
+		}
+	})
+	startTheWorld()
+	return pid
+}
+
+// forkResetLocks reinitializes, in the child of rawfork, the locks
+// writeheapdump_m may take other than the heap lock: the central free
+// lists flushed by updatememstats and taken by sweeping, and the
+// special record, finalizer and profiling locks taken when sweeping
+// frees objects with specials and when dumping the memory profile. No
+// other thread is left to release them.
+func forkResetLocks() {
+	for i := range mheap_.central {
+		mheap_.central[i].mcentral.lock.key = 0
+	}
+	mheap_.speciallock.key = 0
+	finlock.key = 0
+	proflock.key = 0
+}
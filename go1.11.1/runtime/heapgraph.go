@@ -0,0 +1,690 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Heap graph.
+//
+// readHeapGraph, behind runtime/debug.WriteHeapGraphProfile, finds what
+// retains the heap: the dominator tree of the graph of reachable heap
+// objects. An object dominates another if every path from the roots to
+// the other passes through it, so the objects an object dominates are
+// the memory that would be freed with it.
+//
+// It runs a mark pass of its own with the world stopped. Starting from
+// the roots the garbage collector scans (data and BSS segments,
+// goroutine stacks and finalizers) it follows pointers using the same
+// pointer maps and heap bitmap as the collector, but it numbers the
+// objects it reaches and records the edges it follows. It then
+// computes the dominators with the iterative algorithm of Cooper,
+// Harvey and Kennedy, "A Simple, Fast Dominance Algorithm". All of this
+// happens in memory from sysAlloc, which is freed once the result has
+// been copied out.
+//
+// The nodes of the graph are a super root, node 0, which points to
+// every root, then the roots, then the objects in the order they were
+// reached. Stack frames are grouped into one root per function, so
+// that the result does not grow with the number of goroutines.
+//
+// Each object is labeled with its allocation site, from the heap
+// profile (see mprof.go), or with its type, as recorded with
+// GODEBUG=heaptypes=1 (see heaptypes.go). Objects the heap profile did
+// not sample, or whose type is not known, have no site or type.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// Nodes of the heap graph before the roots for functions.
+const (
+	hgSuperRoot = iota
+	hgRootData
+	hgRootBSS
+	hgRootFinalizers
+	hgRootContexts
+	hgFixedRoots
+)
+
+var hgRootNames = [hgFixedRoots]string{
+	hgSuperRoot:      "<several roots>",
+	hgRootData:       "data segment",
+	hgRootBSS:        "bss segment",
+	hgRootFinalizers: "finalizers",
+	hgRootContexts:   "goroutine contexts",
+}
+
+// hgViewSites is runtime/debug.HeapGraphSites. Any other view labels
+// objects with their types.
+const hgViewSites = 1
+
+// heapGraphNodeFields is the number of uint64s per node in the buffer
+// filled in by readHeapGraph.
+const heapGraphNodeFields = 4
+
+// An hgArray is a growable array allocated with sysAlloc, so that it
+// can grow while the world is stopped. Its elements are size bytes,
+// passed to every method.
+type hgArray struct {
+	p        unsafe.Pointer
+	len, cap uintptr
+}
+
+func (a *hgArray) grow(n, size uintptr) {
+	if n <= a.cap {
+		return
+	}
+	c := 2 * a.cap
+	if c < n {
+		c = n
+	}
+	if c < 1024 {
+		c = 1024
+	}
+	p := sysAlloc(c*size, &memstats.other_sys)
+	if p == nil {
+		throw("heap graph: out of memory")
+	}
+	if a.p != nil {
+		memmove(p, a.p, a.len*size)
+		sysFree(a.p, a.cap*size, &memstats.other_sys)
+	}
+	a.p, a.cap = p, c
+}
+
+// resize sets the length of a to n, zeroing new elements.
+func (a *hgArray) resize(n, size uintptr) {
+	a.grow(n, size)
+	if n > a.len {
+		memclrNoHeapPointers(add(a.p, a.len*size), (n-a.len)*size)
+	}
+	a.len = n
+}
+
+func (a *hgArray) free(size uintptr) {
+	if a.p != nil {
+		sysFree(a.p, a.cap*size, &memstats.other_sys)
+	}
+	*a = hgArray{}
+}
+
+type hgUint32 struct{ a hgArray }
+
+func (x *hgUint32) len() uintptr         { return x.a.len }
+func (x *hgUint32) at(i uintptr) *uint32 { return (*uint32)(add(x.a.p, i*4)) }
+func (x *hgUint32) resize(n uintptr)     { x.a.resize(n, 4) }
+func (x *hgUint32) pop()                 { x.a.len-- }
+func (x *hgUint32) free()                { x.a.free(4) }
+
+func (x *hgUint32) push(v uint32) {
+	x.a.grow(x.a.len+1, 4)
+	*x.at(x.a.len) = v
+	x.a.len++
+}
+
+type hgUintptr struct{ a hgArray }
+
+func (x *hgUintptr) len() uintptr          { return x.a.len }
+func (x *hgUintptr) at(i uintptr) *uintptr { return (*uintptr)(add(x.a.p, i*sys.PtrSize)) }
+func (x *hgUintptr) resize(n uintptr)      { x.a.resize(n, sys.PtrSize) }
+func (x *hgUintptr) free()                 { x.a.free(sys.PtrSize) }
+
+func (x *hgUintptr) push(v uintptr) {
+	x.a.grow(x.a.len+1, sys.PtrSize)
+	*x.at(x.a.len) = v
+	x.a.len++
+}
+
+// An hgIndex is a hash table from non-zero uintptrs to uint32s.
+type hgIndex struct {
+	keys hgUintptr
+	vals hgUint32
+	n    uintptr
+}
+
+func (x *hgIndex) slot(k uintptr) uintptr {
+	mask := x.keys.len() - 1
+	h := k * 0x9e3779b1
+	h ^= h >> 16
+	for i := h & mask; ; i = (i + 1) & mask {
+		if kk := *x.keys.at(i); kk == k || kk == 0 {
+			return i
+		}
+	}
+}
+
+func (x *hgIndex) lookup(k uintptr) (uint32, bool) {
+	if x.n == 0 {
+		return 0, false
+	}
+	i := x.slot(k)
+	if *x.keys.at(i) == 0 {
+		return 0, false
+	}
+	return *x.vals.at(i), true
+}
+
+// insert adds k, which must not be in x.
+func (x *hgIndex) insert(k uintptr, v uint32) {
+	if 2*(x.n+1) > x.keys.len() {
+		old := *x
+		*x = hgIndex{}
+		n := 2 * old.keys.len()
+		if n < 1024 {
+			n = 1024
+		}
+		x.keys.resize(n)
+		x.vals.resize(n)
+		for i := uintptr(0); i < old.keys.len(); i++ {
+			if k := *old.keys.at(i); k != 0 {
+				x.insert(k, *old.vals.at(i))
+			}
+		}
+		old.keys.free()
+		old.vals.free()
+	}
+	i := x.slot(k)
+	*x.keys.at(i) = k
+	*x.vals.at(i) = v
+	x.n++
+}
+
+func (x *hgIndex) free() {
+	x.keys.free()
+	x.vals.free()
+	x.n = 0
+}
+
+type heapGraph struct {
+	view int
+
+	index     hgIndex   // object base or function entry PC to node
+	addr      hgUintptr // per node: object base, or entry PC for functions
+	succStart hgUint32  // per node: index of its first successor in succ
+	succ      hgUint32
+
+	// During the root scan, the edges from roots to objects, and
+	// the root being scanned, or 0 if it is the function of frame
+	// and has no node yet.
+	scanningRoots bool
+	rootFrom      hgUint32
+	rootTo        hgUintptr
+	nroots        uint32
+	root          uint32
+	frame         funcInfo
+
+	// Computed by dominators.
+	po   hgUint32 // per node: postorder number, from 1
+	rpo  hgUint32 // nodes in reverse postorder
+	idom hgUint32 // per node: immediate dominator
+
+	// Per node, set with the world stopped for the result.
+	size hgUintptr // object size
+	key  hgUintptr // bucket or type, with hgArrayKey if an array
+}
+
+// hgArrayKey is set in a type key if the object is an array of the
+// type. Types are at least 4-byte aligned.
+const hgArrayKey = 1
+
+// readHeapGraph computes the dominator tree of the heap. It fills nodes
+// with heapGraphNodeFields values per node, in reverse postorder, so
+// that a node's dominator comes before it:
+//
+//	- the index of the node's immediate dominator, 0 for the super
+//	  root and the roots;
+//	- 1 for objects, 0 for roots;
+//	- the size of the object;
+//	- the node's frame: 0 if it has none, 2i+1 for (*names)[i], 2i+2
+//	  for the allocation stack (*stacks)[i].
+//
+// The first node is the super root, which objects retained by several
+// roots are dominated by.
+//
+//go:linkname readHeapGraph runtime/debug.readHeapGraph
+func readHeapGraph(view int, nodes *[]uint64, stacks *[][]uintptr, names *[]string) {
+	g := &heapGraph{view: view}
+	stopTheWorld("heap graph")
+	systemstack(func() {
+		gp := getg().m.curg
+		casgstatus(gp, _Grunning, _Gwaiting)
+		gp.waitreason = waitReasonDumpingHeap
+		g.mark()
+		g.dominators()
+		g.label()
+		casgstatus(gp, _Gwaiting, _Grunning)
+	})
+	startTheWorld()
+	g.write(nodes, stacks, names)
+	systemstack(g.free)
+}
+
+// mark numbers the reachable objects and records the edges between
+// nodes.
+func (g *heapGraph) mark() {
+	for i := 0; i < hgFixedRoots; i++ {
+		g.addr.push(0)
+	}
+	g.nroots = hgFixedRoots - 1
+
+	g.scanningRoots = true
+	for _, datap := range activeModules() {
+		g.root = hgRootData
+		g.block(datap.data, datap.edata-datap.data, datap.gcdatamask.bytedata)
+		g.root = hgRootBSS
+		g.block(datap.bss, datap.ebss-datap.bss, datap.gcbssmask.bytedata)
+	}
+
+	// As for the garbage collector, a finalizer keeps alive its
+	// function and what its object points to, but not the object
+	// itself, until it is queued to run.
+	g.root = hgRootFinalizers
+	for _, s := range mheap_.allspans {
+		if s.state != mSpanInUse {
+			continue
+		}
+		for sp := s.specials; sp != nil; sp = sp.next {
+			if sp.kind != _KindSpecialFinalizer {
+				continue
+			}
+			spf := (*specialfinalizer)(unsafe.Pointer(sp))
+			p := s.base() + uintptr(spf.special.offset)/s.elemsize*s.elemsize
+			g.object(p)
+			g.ptr(uintptr(unsafe.Pointer(spf.fn)))
+		}
+	}
+	for fb := allfin; fb != nil; fb = fb.alllink {
+		for i := uint32(0); i < fb.cnt; i++ {
+			f := &fb.fin[i]
+			g.ptr(uintptr(unsafe.Pointer(f.fn)))
+			g.ptr(uintptr(f.arg))
+		}
+	}
+
+	var cache pcvalueCache
+	scanframe := func(frame *stkframe, unused unsafe.Pointer) bool {
+		g.root = 0
+		g.frame = frame.fn
+		locals, args := getStackMap(frame, &cache, false)
+		if locals.n > 0 {
+			size := uintptr(locals.n) * sys.PtrSize
+			g.block(frame.varp-size, size, locals.bytedata)
+		}
+		if args.n > 0 {
+			g.block(frame.argp, uintptr(args.n)*sys.PtrSize, args.bytedata)
+		}
+		return true
+	}
+	for i := uintptr(0); i < allglen; i++ {
+		gp := allgs[i]
+		if readgstatus(gp) == _Gdead {
+			continue
+		}
+		g.root = hgRootContexts
+		g.ptr(uintptr(gp.sched.ctxt))
+		gentraceback(^uintptr(0), ^uintptr(0), 0, gp, 0, nil, 0x7fffffff, scanframe, nil, 0)
+		tracebackdefers(gp, scanframe, nil)
+	}
+	g.scanningRoots = false
+
+	// The super root points to the roots, and the roots to the
+	// objects they point to. Sort the root edges by root to make
+	// the successors of each root contiguous.
+	nroots := uintptr(g.nroots)
+	g.succStart.push(0)
+	for r := uint32(1); r <= g.nroots; r++ {
+		g.succ.push(r)
+	}
+	var start, order hgUint32
+	start.resize(nroots + 2)
+	order.resize(g.rootFrom.len())
+	for i := uintptr(0); i < g.rootFrom.len(); i++ {
+		*start.at(uintptr(*g.rootFrom.at(i)) + 1)++
+	}
+	for r := uintptr(1); r < start.len(); r++ {
+		*start.at(r) += *start.at(r - 1)
+	}
+	for i := uintptr(0); i < g.rootFrom.len(); i++ {
+		p := start.at(uintptr(*g.rootFrom.at(i)))
+		*order.at(uintptr(*p)) = uint32(i)
+		*p++
+	}
+	// Now start[r] is the end of the edges of root r.
+	for r := uintptr(1); r <= nroots; r++ {
+		g.succStart.push(uint32(g.succ.len()))
+		for k := *start.at(r - 1); k < *start.at(r); k++ {
+			g.succ.push(g.node(*g.rootTo.at(uintptr(*order.at(uintptr(k))))))
+		}
+	}
+	start.free()
+	order.free()
+	g.rootFrom.free()
+	g.rootTo.free()
+
+	// Scan the objects in the order they are reached, which
+	// numbers the objects they point to.
+	for u := nroots + 1; u < g.addr.len(); u++ {
+		g.succStart.push(uint32(g.succ.len()))
+		g.object(*g.addr.at(u))
+	}
+	g.succStart.push(uint32(g.succ.len()))
+}
+
+// node returns the node of the object at base, adding it if it has not
+// been reached before.
+func (g *heapGraph) node(base uintptr) uint32 {
+	if u, ok := g.index.lookup(base); ok {
+		return u
+	}
+	u := uint32(g.addr.len())
+	g.addr.push(base)
+	g.index.insert(base, u)
+	return u
+}
+
+// ptr records an edge from the root or object being scanned to the
+// object p points into, if any.
+func (g *heapGraph) ptr(p uintptr) {
+	if p == 0 {
+		return
+	}
+	base, _, _ := findObject(p, 0, 0)
+	if base == 0 {
+		return
+	}
+	if !g.scanningRoots {
+		g.succ.push(g.node(base))
+		return
+	}
+	if g.root == 0 {
+		// First pointer from a frame of this function.
+		entry := g.frame.entry
+		r, ok := g.index.lookup(entry)
+		if !ok {
+			g.nroots++
+			r = g.nroots
+			g.addr.push(entry)
+			g.index.insert(entry, r)
+		}
+		g.root = r
+	}
+	g.rootFrom.push(g.root)
+	g.rootTo.push(base)
+}
+
+// block scans the n bytes at b, whose pointers are given by ptrmask,
+// as scanblock does.
+func (g *heapGraph) block(b, n uintptr, ptrmask *uint8) {
+	for i := uintptr(0); i < n; {
+		bits := uint32(*addb(ptrmask, i/(sys.PtrSize*8)))
+		if bits == 0 {
+			i += sys.PtrSize * 8
+			continue
+		}
+		for j := 0; j < 8 && i < n; j++ {
+			if bits&1 != 0 {
+				g.ptr(*(*uintptr)(unsafe.Pointer(b + i)))
+			}
+			bits >>= 1
+			i += sys.PtrSize
+		}
+	}
+}
+
+// object scans the heap object at b, as scanobject does.
+func (g *heapGraph) object(b uintptr) {
+	s := spanOfUnchecked(b)
+	if s.spanclass.noscan() {
+		return
+	}
+	hbits := heapBitsForAddr(b)
+	for i := uintptr(0); i < s.elemsize; i += sys.PtrSize {
+		if i != 0 {
+			hbits = hbits.next()
+		}
+		bits := hbits.bits()
+		if i != 1*sys.PtrSize && bits&bitScan == 0 {
+			break
+		}
+		if bits&bitPointer != 0 {
+			g.ptr(*(*uintptr)(unsafe.Pointer(b + i)))
+		}
+	}
+}
+
+// dominators computes the immediate dominator of every node.
+func (g *heapGraph) dominators() {
+	const (
+		inProgress = ^uint32(0)
+		undefined  = ^uint32(0)
+	)
+	n := g.addr.len()
+	succs := func(u uint32) (uint32, uint32) {
+		return *g.succStart.at(uintptr(u)), *g.succStart.at(uintptr(u) + 1)
+	}
+
+	// Number the nodes in postorder, depth first.
+	g.po.resize(n)
+	g.rpo.resize(n)
+	var stack, next hgUint32
+	stack.push(0)
+	next.push(0)
+	*g.po.at(0) = inProgress
+	for k := uintptr(0); stack.len() > 0; {
+		top := stack.len() - 1
+		u := *stack.at(top)
+		if i, end := *next.at(top), *g.succStart.at(uintptr(u) + 1); i < end {
+			*next.at(top) = i + 1
+			v := *g.succ.at(uintptr(i))
+			if *g.po.at(uintptr(v)) == 0 {
+				*g.po.at(uintptr(v)) = inProgress
+				stack.push(v)
+				next.push(*g.succStart.at(uintptr(v)))
+			}
+			continue
+		}
+		k++
+		*g.po.at(uintptr(u)) = uint32(k)
+		*g.rpo.at(n - k) = u
+		stack.pop()
+		next.pop()
+	}
+	stack.free()
+	next.free()
+
+	// Index the predecessors of each node. After filling preds,
+	// predEnd[v] is the end of the predecessors of v, which start
+	// at predEnd[v-1].
+	var predEnd, preds hgUint32
+	predEnd.resize(n + 1)
+	for u := uint32(0); uintptr(u) < n; u++ {
+		lo, hi := succs(u)
+		for i := lo; i < hi; i++ {
+			*predEnd.at(uintptr(*g.succ.at(uintptr(i))) + 1)++
+		}
+	}
+	for v := uintptr(1); v <= n; v++ {
+		*predEnd.at(v) += *predEnd.at(v - 1)
+	}
+	preds.resize(g.succ.len())
+	for u := uint32(0); uintptr(u) < n; u++ {
+		lo, hi := succs(u)
+		for i := lo; i < hi; i++ {
+			p := predEnd.at(uintptr(*g.succ.at(uintptr(i))))
+			*preds.at(uintptr(*p)) = u
+			*p++
+		}
+	}
+
+	po := func(u uint32) uint32 { return *g.po.at(uintptr(u)) }
+	g.idom.resize(n)
+	for v := uintptr(1); v < n; v++ {
+		*g.idom.at(v) = undefined
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := uintptr(1); k < n; k++ {
+			v := *g.rpo.at(k)
+			lo := uint32(0)
+			if v > 0 {
+				lo = *predEnd.at(uintptr(v) - 1)
+			}
+			d := undefined
+			for i := lo; i < *predEnd.at(uintptr(v)); i++ {
+				p := *preds.at(uintptr(i))
+				if *g.idom.at(uintptr(p)) == undefined {
+					continue
+				}
+				if d == undefined {
+					d = p
+					continue
+				}
+				// Intersect: walk up from both until they meet.
+				for a := p; a != d; {
+					for po(a) < po(d) {
+						a = *g.idom.at(uintptr(a))
+					}
+					for po(d) < po(a) {
+						d = *g.idom.at(uintptr(d))
+					}
+				}
+			}
+			if *g.idom.at(uintptr(v)) != d {
+				*g.idom.at(uintptr(v)) = d
+				changed = true
+			}
+		}
+	}
+	predEnd.free()
+	preds.free()
+	g.succStart.free()
+	g.succ.free()
+	g.index.free()
+}
+
+// label records the size and the allocation site or type of every
+// object, which are only stable with the world stopped.
+func (g *heapGraph) label() {
+	n := g.addr.len()
+	g.size.resize(n)
+	g.key.resize(n)
+	for u := uintptr(g.nroots) + 1; u < n; u++ {
+		b := *g.addr.at(u)
+		s := spanOfUnchecked(b)
+		*g.size.at(u) = s.elemsize
+		var key uintptr
+		if g.view == hgViewSites {
+			key = uintptr(unsafe.Pointer(profileBucketOf(s, b)))
+		} else if t := s.typeOf(b); t != nil {
+			key = uintptr(unsafe.Pointer(t))
+			if t.size != 0 && 2*t.size <= s.elemsize {
+				// Size classes round up by less than 2x,
+				// so the object has more than one element.
+				key |= hgArrayKey
+			}
+		}
+		*g.key.at(u) = key
+	}
+}
+
+// profileBucketOf returns the heap profile bucket of the object at p in
+// s, or nil if the heap profile did not sample it.
+func profileBucketOf(s *mspan, p uintptr) *bucket {
+	offset := p - s.base()
+	for sp := s.specials; sp != nil; sp = sp.next {
+		if uintptr(sp.offset) > offset {
+			break
+		}
+		if uintptr(sp.offset) == offset && sp.kind == _KindSpecialProfile {
+			return (*specialprofile)(unsafe.Pointer(sp)).b
+		}
+	}
+	return nil
+}
+
+// write fills in the result of readHeapGraph.
+func (g *heapGraph) write(nodes *[]uint64, stacks *[][]uintptr, names *[]string) {
+	n := g.addr.len()
+	nd := make([]uint64, 0, n*heapGraphNodeFields)
+	st := (*stacks)[:0]
+	nm := append((*names)[:0], hgRootNames[:]...)
+
+	// Frames by key, and for objects of unknown type, by size.
+	var frames, sizes hgIndex
+	for k := uintptr(0); k < n; k++ {
+		u := *g.rpo.at(k)
+		var parent, objects, size, frame uint64
+		switch {
+		case u < hgFixedRoots:
+			frame = 2*uint64(u) + 1
+		case u <= g.nroots:
+			nm = append(nm, funcname(findfunc(*g.addr.at(uintptr(u)))))
+			frame = 2*uint64(len(nm)-1) + 1
+		default:
+			d := *g.idom.at(uintptr(u))
+			parent = uint64(n - uintptr(*g.po.at(uintptr(d))))
+			objects = 1
+			size = uint64(*g.size.at(uintptr(u)))
+			key := *g.key.at(uintptr(u))
+			if key != 0 {
+				if f, ok := frames.lookup(key); ok {
+					frame = uint64(f)
+					break
+				}
+			}
+			switch {
+			case key != 0 && g.view == hgViewSites:
+				b := (*bucket)(unsafe.Pointer(key))
+				st = append(st, append([]uintptr(nil), b.stk()...))
+				frame = 2 * uint64(len(st))
+			case key != 0:
+				t := (*_type)(unsafe.Pointer(key &^ hgArrayKey))
+				name := t.string()
+				if key&hgArrayKey != 0 {
+					name = "[]" + name
+				}
+				nm = append(nm, name)
+				frame = 2*uint64(len(nm)-1) + 1
+			default:
+				if g.view == hgViewSites {
+					break
+				}
+				if f, ok := sizes.lookup(uintptr(size)); ok {
+					frame = uint64(f)
+					break
+				}
+				var buf [20]byte
+				i := len(buf)
+				for v := size; ; v /= 10 {
+					i--
+					buf[i] = byte('0' + v%10)
+					if v < 10 {
+						break
+					}
+				}
+				nm = append(nm, string(buf[i:])+"-byte object")
+				frame = 2*uint64(len(nm)-1) + 1
+				systemstack(func() { sizes.insert(uintptr(size), uint32(frame)) })
+			}
+			if key != 0 {
+				systemstack(func() { frames.insert(key, uint32(frame)) })
+			}
+		}
+		nd = append(nd, parent, objects, size, frame)
+	}
+	systemstack(func() {
+		frames.free()
+		sizes.free()
+	})
+	*nodes, *stacks, *names = nd, st, nm
+}
+
+func (g *heapGraph) free() {
+	g.addr.free()
+	g.po.free()
+	g.rpo.free()
+	g.idom.free()
+	g.size.free()
+	g.key.free()
+}
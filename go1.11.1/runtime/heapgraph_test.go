@@ -0,0 +1,181 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+type graphNode struct {
+	next *graphNode
+	pad  [7]uintptr
+}
+
+type graphOwner struct {
+	shared *graphNode
+	pad    [3]uintptr
+}
+
+var (
+	graphList      *graphNode
+	graphA, graphB *graphOwner
+)
+
+const graphListLen = 1000
+
+//go:noinline
+func makeGraphList() {
+	for i := 0; i < graphListLen; i++ {
+		graphList = &graphNode{next: graphList}
+	}
+}
+
+//go:noinline
+func makeGraphOwner() *graphOwner {
+	return new(graphOwner)
+}
+
+//go:noinline
+func makeGraphShared() *graphNode {
+	return new(graphNode)
+}
+
+// makeGraph builds a list only graphList retains, and an object
+// retained by graphA and graphB together, but by neither alone.
+//
+//go:noinline
+func makeGraph() {
+	makeGraphList()
+	graphA, graphB = makeGraphOwner(), makeGraphOwner()
+	shared := makeGraphShared()
+	graphA.shared, graphB.shared = shared, shared
+}
+
+func heapGraph(t *testing.T, view debug.HeapGraphView) *decodedProfile {
+	var buf bytes.Buffer
+	if err := debug.WriteHeapGraphProfile(&buf, view); err != nil {
+		t.Fatal(err)
+	}
+	p, err := decodeProfile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[retained_space/bytes retained_objects/count]"
+	if got := fmt.Sprint(p.sampleTypes); got != want {
+		t.Fatalf("sample types %v, want %v", got, want)
+	}
+	return p
+}
+
+// graphSamples returns the samples with a frame named fn, and the
+// objects they count.
+func graphSamples(p *decodedProfile, fn string) (samples []decodedSample, objects int64) {
+	for _, s := range p.samples {
+		for _, f := range s.stack {
+			if f == fn {
+				samples = append(samples, s)
+				objects += s.values[1]
+				break
+			}
+		}
+	}
+	return
+}
+
+func TestHeapGraphSites(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	makeGraph()
+	defer func() { graphList, graphA, graphB = nil, nil, nil }()
+
+	p := heapGraph(t, debug.HeapGraphSites)
+
+	list, n := graphSamples(p, "runtime_test.makeGraphList")
+	if n < graphListLen {
+		t.Errorf("%d objects retained by makeGraphList objects, want at least %d", n, graphListLen)
+	}
+	for _, s := range list {
+		// The list is one frame, over the root.
+		count := 0
+		for _, f := range s.stack {
+			if f == "runtime_test.makeGraphList" {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("makeGraphList appears %d times in %q", count, s.stack)
+		}
+		if root := s.stack[len(s.stack)-1]; root != "bss segment" {
+			t.Errorf("list retained by %q, want bss segment", root)
+		}
+	}
+
+	// Neither owner retains the shared object.
+	shared, n := graphSamples(p, "runtime_test.makeGraphShared")
+	if n == 0 {
+		t.Fatal("no sample for the shared object")
+	}
+	for _, s := range shared {
+		for _, f := range s.stack {
+			if f == "runtime_test.makeGraphOwner" {
+				t.Errorf("shared object retained by an owner: %q", s.stack)
+			}
+		}
+	}
+	if _, n := graphSamples(p, "runtime_test.makeGraphOwner"); n < 2 {
+		t.Errorf("%d objects retained by the owners, want at least 2", n)
+	}
+	runtime.KeepAlive(graphList)
+}
+
+func TestHeapGraphSizes(t *testing.T) {
+	// Without GODEBUG=heaptypes=1, objects are named by size.
+	if strings.Contains(os.Getenv("GODEBUG"), "heaptypes=1") {
+		t.Skip("types recorded")
+	}
+	makeGraph()
+	defer func() { graphList, graphA, graphB = nil, nil, nil }()
+	p := heapGraph(t, debug.HeapGraphTypes)
+	frame := fmt.Sprintf("%d-byte object", unsafe.Sizeof(graphNode{}))
+	if _, n := graphSamples(p, frame); n < graphListLen {
+		t.Errorf("%d objects under %s, want at least %d", n, frame, graphListLen)
+	}
+}
+
+func TestHeapGraphTypesHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	makeGraph()
+	p := heapGraph(t, debug.HeapGraphTypes)
+	list, n := graphSamples(p, "runtime_test.graphNode")
+	if n < graphListLen {
+		t.Errorf("%d objects under runtime_test.graphNode, want at least %d", n, graphListLen)
+	}
+	for _, s := range list {
+		if root := s.stack[len(s.stack)-1]; root != "bss segment" && root != "<several roots>" {
+			t.Errorf("graphNode objects retained by %q", root)
+		}
+	}
+	if _, n := graphSamples(p, "runtime_test.graphOwner"); n < 2 {
+		t.Errorf("%d objects under runtime_test.graphOwner, want at least 2", n)
+	}
+	runtime.KeepAlive(graphList)
+}
+
+func TestHeapGraphTypes(t *testing.T) {
+	// Types are only recorded from startup.
+	out, err := runHelper(t, "TestHeapGraphTypesHelper", "GODEBUG=heaptypes=1")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Heap object types.
+//
+// The heap bitmap only says which words of an object are pointers, so
+// a heap dump cannot say what an object is. With GODEBUG=heaptypes=1,
+// each span allocated for heap objects gets a table of the type of
+// each of its objects, written by mallocgc and read by dumpobjs.
+//
+// The tables are allocated off-heap when the span is allocated and
+// returned to a free list for their size class when it is freed, both
+// with the heap lock held. An entry is only meaningful while its
+// object is allocated. Spans allocated before the setting is read at
+// startup have no table.
+//
+// The types are stored as uintptrs, since the tables are not scanned.
+// The types of heap objects are static or, if made by reflect, cached
+// by it forever, so the dump can use them later.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// spanTypes is a type table. Spans have at most _PageSize/8 objects,
+// and only the first nelems entries of a table are allocated.
+type spanTypes [_PageSize / 8]uintptr
+
+// spanTypesFree is the free tables by size class, linked through
+// their first entry. It is protected by mheap_.lock.
+var spanTypesFree [_NumSizeClasses]*spanTypes
+
+// allocSpanTypes returns a type table for s, which must have its
+// size class and element size set. h must be locked.
+func (h *mheap) allocSpanTypes(s *mspan) *spanTypes {
+	sc := s.spanclass.sizeclass()
+	if t := spanTypesFree[sc]; t != nil {
+		spanTypesFree[sc] = (*spanTypes)(unsafe.Pointer(t[0]))
+		t[0] = 0
+		return t
+	}
+	n := uintptr(1) // large objects
+	if sc != 0 {
+		n = uintptr(class_to_allocnpages[sc]) << _PageShift / s.elemsize
+	}
+	return (*spanTypes)(persistentalloc(n*sys.PtrSize, sys.PtrSize, &memstats.other_sys))
+}
+
+// freeSpanTypes frees the type table of s. h must be locked.
+func (h *mheap) freeSpanTypes(s *mspan) {
+	sc := s.spanclass.sizeclass()
+	s.types[0] = uintptr(unsafe.Pointer(spanTypesFree[sc]))
+	spanTypesFree[sc] = s.types
+	s.types = nil
+}
+
+// setType records that the object at p, in s, was allocated with type
+// typ. typ may be nil for untyped memory. s.types must not be nil.
+func (s *mspan) setType(p uintptr, typ *_type) {
+	s.types[s.objIndex(p)] = uintptr(unsafe.Pointer(typ))
+}
+
+// typeOf returns the type the object at p, in s, was allocated with,
+// or nil if it is not known.
+func (s *mspan) typeOf(p uintptr) *_type {
+	if s.types == nil {
+		return nil
+	}
+	return (*_type)(unsafe.Pointer(s.types[s.objIndex(p)]))
+}
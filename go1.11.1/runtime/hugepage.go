@@ -0,0 +1,93 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transparent huge page policy.
+//
+// Linux may back anonymous memory with huge pages, either when it is
+// first touched or later, when khugepaged collapses small pages. Huge
+// pages make the heap cheaper to access, but collapsing stalls, and a
+// huge page backing a mostly released range bloats RSS. By default the
+// runtime leaves the choice to the kernel, except that sysUnused turns
+// huge pages off around the edges of released ranges and sysUsed turns
+// them back on (see mem_linux.go).
+//
+// GOTHP selects an explicit policy at startup:
+//
+//	always  heap arenas are advised MADV_HUGEPAGE when mapped, and
+//	        huge pages are never turned off.
+//	never   heap arenas are advised MADV_NOHUGEPAGE when mapped.
+//	heap    heap arenas are advised MADV_HUGEPAGE when mapped, and
+//	        whole ranges released to the OS MADV_NOHUGEPAGE until
+//	        they are used again.
+//
+// The advice is given by sysArenaMapped, sysUnused and sysUsed, and
+// only followed on Linux. Huge pages only apply to heap arenas, which
+// are mapped whole, so the advice for them never splits a mapping.
+
+package runtime
+
+import "unsafe"
+
+const (
+	thpDefault = iota
+	thpAlways
+	thpNever
+	thpHeap
+)
+
+var thpPolicyNames = [...]string{
+	thpDefault: "default",
+	thpAlways:  "always",
+	thpNever:   "never",
+	thpHeap:    "heap",
+}
+
+// thpPolicy is the huge page policy. It is set by thpInit and not
+// changed afterwards.
+var thpPolicy int32
+
+// thpInit sets the huge page policy from $GOTHP. It is called by
+// schedinit once the environment is available, after the first heap
+// arenas may have been mapped.
+func thpInit() {
+	p := gogetenv("GOTHP")
+	if p == "" {
+		return
+	}
+	policy, ok := parseTHPPolicy(p)
+	if !ok {
+		print("runtime: GOTHP=", p, "\n")
+		throw("malformed GOTHP; want default, always, never or heap")
+	}
+	thpPolicy = policy
+	for _, ai := range mheap_.allArenas {
+		sysArenaMapped(unsafe.Pointer(arenaBase(ai)), heapArenaBytes)
+	}
+}
+
+func parseTHPPolicy(s string) (int32, bool) {
+	for i, name := range thpPolicyNames {
+		if s == name {
+			return int32(i), true
+		}
+	}
+	return 0, false
+}
+
+// readHugePageArenas appends the base address of every heap arena to
+// bases, and returns the huge page policy and the size of an arena.
+//
+//go:linkname readHugePageArenas runtime/debug.readHugePageArenas
+func readHugePageArenas(bases *[]uintptr) (policy string, arenaBytes uintptr) {
+	var arenas []arenaIdx
+	systemstack(func() {
+		lock(&mheap_.lock)
+		arenas = mheap_.allArenas
+		unlock(&mheap_.lock)
+	})
+	for _, ai := range arenas {
+		*bases = append(*bases, arenaBase(ai))
+	}
+	return thpPolicyNames[thpPolicy], heapArenaBytes
+}
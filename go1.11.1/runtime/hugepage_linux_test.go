@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestTHPAdvice(t *testing.T) {
+	const hp = runtime.HugePageSize
+	if hp == 0 {
+		t.Skip("no huge pages on this architecture")
+	}
+	// The fake madvise records the calls instead of making them,
+	// so the addresses need not be mapped.
+	const (
+		base = 64 << 20
+		size = 8 * hp
+		page = 64 << 10
+	)
+	type call = runtime.MadviseCall
+	dontneed := call{base + hp + page, 2 * page, runtime.MADV_DONTNEED}
+
+	for _, tt := range []struct {
+		policy               string
+		mapped, unused, used []call
+	}{
+		{
+			policy: "default",
+			unused: []call{{base + hp, hp, runtime.MADV_NOHUGEPAGE}, dontneed},
+			used:   []call{{base + hp, 6 * hp, runtime.MADV_HUGEPAGE}},
+		},
+		{
+			policy: "always",
+			mapped: []call{{base, size, runtime.MADV_HUGEPAGE}},
+			unused: []call{dontneed},
+		},
+		{
+			policy: "never",
+			mapped: []call{{base, size, runtime.MADV_NOHUGEPAGE}},
+			unused: []call{dontneed},
+		},
+		{
+			policy: "heap",
+			mapped: []call{{base, size, runtime.MADV_HUGEPAGE}},
+			unused: []call{{base + hp, hp, runtime.MADV_NOHUGEPAGE}, dontneed},
+			used:   []call{{base + hp, 6 * hp, runtime.MADV_HUGEPAGE}},
+		},
+	} {
+		got := runtime.THPAdvice(tt.policy, base, base+size, func() {
+			runtime.SysArenaMapped(base, size)
+		})
+		if !reflect.DeepEqual(got, tt.mapped) {
+			t.Errorf("GOTHP=%s: mapping arena: got %v, want %v", tt.policy, got, tt.mapped)
+		}
+		got = runtime.THPAdvice(tt.policy, base, base+size, func() {
+			runtime.SysUnused(dontneed.Addr, dontneed.N)
+		})
+		if !reflect.DeepEqual(got, tt.unused) {
+			t.Errorf("GOTHP=%s: releasing memory: got %v, want %v", tt.policy, got, tt.unused)
+		}
+		// Only the whole huge pages of a reused range get huge
+		// pages back.
+		got = runtime.THPAdvice(tt.policy, base, base+size, func() {
+			runtime.SysUsed(base+hp-page, 6*hp+2*page)
+		})
+		if !reflect.DeepEqual(got, tt.used) {
+			t.Errorf("GOTHP=%s: reusing memory: got %v, want %v", tt.policy, got, tt.used)
+		}
+	}
+}
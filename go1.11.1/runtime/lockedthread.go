@@ -0,0 +1,214 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Locked threads.
+//
+// A LockedThread owns a worker goroutine that calls LockOSThread and
+// then runs submitted functions in a loop. While there is no work the
+// worker parks; its M sleeps in stoplockedm, keeping the thread and
+// any per-thread OS state alive. Submitting work readies the worker,
+// and the scheduler hands a P to its M through startlockedm, exactly
+// as for any other locked goroutine.
+//
+// Normally a goroutine that exits while locked takes its thread down
+// with it (see goexit0). If a submitted function calls Goexit, the
+// worker goroutine exits, but goexit0 notices m.lockedThread and
+// replaces it with a fresh worker locked to the same M instead, so
+// the thread survives.
+
+package runtime
+
+import "unsafe"
+
+// A LockedThread is an operating system thread dedicated to running
+// functions submitted to it. Functions run one at a time, in the order
+// they were submitted, and always on the same thread, so they may rely
+// on per-thread state such as a graphics context made current by an
+// earlier function. No other goroutine runs on the thread.
+//
+// If a function submitted with Go panics, the program crashes as it
+// would for a panic in any goroutine. If a function calls Goexit, the
+// remaining functions still run on the same thread. If a function
+// calls UnlockOSThread more times than LockOSThread, t gives up its
+// thread and the remaining functions run on a new one.
+type LockedThread struct {
+	// Local is free for use by the functions running on the thread,
+	// for example to keep the thread's state between submissions.
+	// It must only be accessed from functions running on the thread.
+	Local interface{}
+
+	lock   mutex
+	head   *lockedThreadWork
+	tail   *lockedThreadWork
+	worker guintptr // parked worker goroutine, or 0 if running
+	closed bool
+}
+
+type lockedThreadWork struct {
+	f    func()
+	next *lockedThreadWork
+
+	// For Do.
+	do       bool
+	wait     uint32 // semaphore, released once f is done
+	panicv   interface{}
+	panicked bool
+}
+
+// NewLockedThread starts a new operating system thread for running
+// functions submitted with Go or Do. The thread exists until Close is
+// called and the functions submitted before it have run.
+func NewLockedThread() *LockedThread {
+	t := new(LockedThread)
+	go lockedThreadMain(t)
+	return t
+}
+
+// Go submits f to run on t and returns without waiting for it.
+// Go panics if t has been closed.
+func (t *LockedThread) Go(f func()) {
+	t.submit(&lockedThreadWork{f: f})
+}
+
+// Do runs f on t and waits for it to return. If f panics, Do panics
+// with the same value on the calling goroutine. Do must not be called
+// from a function running on t itself, as that would deadlock.
+// Do panics if t has been closed.
+func (t *LockedThread) Do(f func()) {
+	w := &lockedThreadWork{f: f, do: true}
+	t.submit(w)
+	semacquire(&w.wait)
+	if w.panicked {
+		panic(w.panicv)
+	}
+}
+
+// Close stops t. Functions already submitted still run; after the
+// last one returns the thread is terminated, discarding any state it
+// had. Close does not wait for that to happen.
+func (t *LockedThread) Close() {
+	lock(&t.lock)
+	t.closed = true
+	gp := t.worker.ptr()
+	t.worker = 0
+	unlock(&t.lock)
+	if gp != nil {
+		goready(gp, 1)
+	}
+}
+
+func (t *LockedThread) submit(w *lockedThreadWork) {
+	lock(&t.lock)
+	if t.closed {
+		unlock(&t.lock)
+		panic(plainError("runtime: use of closed LockedThread"))
+	}
+	if t.tail != nil {
+		t.tail.next = w
+	} else {
+		t.head = w
+	}
+	t.tail = w
+	gp := t.worker.ptr()
+	t.worker = 0
+	unlock(&t.lock)
+	if gp != nil {
+		goready(gp, 1)
+	}
+}
+
+// lockedThreadMain is the worker goroutine of t. It is started by
+// NewLockedThread, and again by lockedThreadRespawn after a submitted
+// function called Goexit; in the latter case the new goroutine is
+// locked to the thread before it starts.
+//
+// The worker is a system goroutine, except while it runs a submitted
+// function (see isSystemGoroutine).
+func lockedThreadMain(t *LockedThread) {
+	gp := getg()
+	if gp.lockedm == 0 {
+		LockOSThread()
+	}
+	gp.m.lockedThread = t
+
+	for {
+		if gp.lockedm == 0 {
+			// The last function unlocked the thread, which
+			// dounlockOSThread took away from t. Take this one.
+			LockOSThread()
+			gp.m.lockedThread = t
+		}
+		lock(&t.lock)
+		w := t.head
+		if w == nil {
+			if t.closed {
+				unlock(&t.lock)
+				break
+			}
+			t.worker.set(gp)
+			goparkunlock(&t.lock, waitReasonLockedThreadIdle, traceEvGoBlock, 1)
+			continue
+		}
+		t.head = w.next
+		if t.head == nil {
+			t.tail = nil
+		}
+		unlock(&t.lock)
+		w.next = nil
+		t.run(w)
+	}
+
+	// Closed. Exit while still locked so the thread is terminated
+	// rather than reused.
+	gp.m.lockedThread = nil
+}
+
+// run runs w.f. For Do, the deferred function runs on panic and on
+// Goexit as well as on normal return, so Do never waits forever.
+func (t *LockedThread) run(w *lockedThreadWork) {
+	gp := getg()
+	gp.lockedRunning = true
+	if !w.do {
+		w.f()
+		gp.lockedRunning = false
+		return
+	}
+	normal := false
+	defer func() {
+		gp.lockedRunning = false
+		if !normal {
+			// Hand a panic over to the goroutine in Do. On
+			// Goexit, recover returns nil and Do just returns.
+			if v := recover(); v != nil {
+				w.panicv, w.panicked = v, true
+			}
+		}
+		semrelease(&w.wait)
+	}()
+	w.f()
+	normal = true
+}
+
+// lockedThreadRespawn is called by goexit0 on g0 after the worker
+// goroutine of t exited while locked to the current M. It starts a new
+// worker locked to the same M and runs it, so the thread is kept. It
+// returns only if t was closed, in which case the thread must go.
+func lockedThreadRespawn(t *LockedThread) {
+	_g_ := getg()
+	lock(&t.lock)
+	closed := t.closed && t.head == nil
+	unlock(&t.lock)
+	if closed {
+		_g_.m.lockedThread = nil
+		return
+	}
+	fn := func() { lockedThreadMain(t) }
+	_g_.m.spawnlocked = true
+	newg := newproc1(*(**funcval)(unsafe.Pointer(&fn)), nil, 0, _g_, getcallerpc())
+	_g_.m.spawnlocked = false
+	// Identify newg as a worker to isSystemGoroutine, like the
+	// first one, rather than as a runtime closure.
+	newg.startpc = funcPC(lockedThreadMain)
+	execute(newg, false) // Never returns.
+}
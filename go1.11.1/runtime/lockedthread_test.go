@@ -0,0 +1,157 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockedThread(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	defer lt.Close()
+
+	var first uintptr
+	lt.Do(func() { first = runtime.CurrentM() })
+	var order []int
+	for i := 0; i < 10; i++ {
+		i := i
+		lt.Go(func() {
+			if m := runtime.CurrentM(); m != first {
+				t.Errorf("function %d ran on M %#x, want %#x", i, m, first)
+			}
+			order = append(order, i)
+		})
+	}
+	lt.Do(func() {})
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("functions ran in order %v", order)
+		}
+	}
+	if len(order) != 10 {
+		t.Fatalf("%d functions ran, want 10", len(order))
+	}
+}
+
+func TestLockedThreadGoexit(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	defer lt.Close()
+
+	var first, second uintptr
+	lt.Do(func() {
+		first = runtime.CurrentM()
+		lt.Local = "state"
+	})
+	lt.Go(runtime.Goexit)
+	lt.Do(runtime.Goexit)
+	var local interface{}
+	lt.Do(func() {
+		second = runtime.CurrentM()
+		local = lt.Local
+	})
+	if first != second {
+		t.Errorf("thread changed after Goexit: M %#x, then %#x", first, second)
+	}
+	if local != "state" {
+		t.Errorf("Local = %v after Goexit, want state", local)
+	}
+	if n := runtime.LockedThreadMs(lt); n != 1 {
+		t.Errorf("%d threads belong to the LockedThread, want 1", n)
+	}
+}
+
+func TestLockedThreadDoPanic(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	defer lt.Close()
+
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("Do panicked with %v, want boom", v)
+		}
+	}()
+	lt.Do(func() { panic("boom") })
+	t.Fatal("Do did not panic")
+}
+
+func TestLockedThreadUnlock(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	defer lt.Close()
+
+	var first, second uintptr
+	lt.Do(func() {
+		first = runtime.CurrentM()
+		runtime.UnlockOSThread()
+	})
+	// The worker locks a new thread; Goexit must not then start a
+	// second worker on the thread it gave up.
+	lt.Go(runtime.Goexit)
+	lt.Do(func() {
+		runtime.LockOSThread()
+		second = runtime.CurrentM()
+		runtime.UnlockOSThread()
+	})
+	if first == 0 || second == 0 {
+		t.Fatal("functions did not run")
+	}
+	if n := runtime.LockedThreadMs(lt); n != 1 {
+		t.Errorf("%d threads belong to the LockedThread, want 1", n)
+	}
+}
+
+func TestLockedThreadClose(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	ran := false
+	lt.Do(func() {})
+	lt.Go(func() { ran = true })
+	lt.Close()
+	for i := 0; runtime.LockedThreadMs(lt) != 0; i++ {
+		if i == 100 {
+			t.Fatal("thread still exists after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ran {
+		t.Error("function submitted before Close did not run")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Go on a closed LockedThread did not panic")
+		}
+	}()
+	lt.Go(func() {})
+}
+
+func TestLockedThreadTraceback(t *testing.T) {
+	lt := runtime.NewLockedThread()
+	defer lt.Close()
+
+	// An idle worker is a system goroutine, hidden from tracebacks.
+	lt.Do(func() {})
+	if s := allStacks(); strings.Contains(s, "runtime.lockedThreadMain") {
+		t.Errorf("idle worker in traceback:\n%s", s)
+	}
+
+	// One running user code is not.
+	started, release := make(chan bool), make(chan bool)
+	lt.Go(func() {
+		started <- true
+		<-release
+	})
+	<-started
+	s := allStacks()
+	close(release)
+	if !strings.Contains(s, "runtime_test.TestLockedThreadTraceback.func") {
+		t.Errorf("running worker not in traceback:\n%s", s)
+	}
+	lt.Do(func() {})
+}
+
+func allStacks() string {
+	buf := make([]byte, 1<<20)
+	return string(buf[:runtime.Stack(buf, true)])
+}
@@ -604,6 +604,7 @@ func (h *mheap) sysAlloc(n uintptr) (v unsafe.Pointer, size uintptr) {
 
 	// Back the reservation.
 	sysMap(v, size, &memstats.heap_sys)
+	sysArenaMapped(v, size)
 
 mapped:
 	// Create arena metadata.
@@ -635,6 +636,27 @@ mapped:
 		// is released (which shouldn't happen, but there's
 		// little downside to this).
 		atomic.StorepNoWB(unsafe.Pointer(&l2[ri.l2()]), unsafe.Pointer(r))
+
+		// Add the arena to the arenas list.
+		if len(h.allArenas) == cap(h.allArenas) {
+			size := 2 * uintptr(cap(h.allArenas)) * sys.PtrSize
+			if size == 0 {
+				size = physPageSize
+			}
+			newArray := (*notInHeap)(persistentalloc(size, sys.PtrSize, &memstats.gc_sys))
+			if newArray == nil {
+				throw("out of memory allocating allArenas")
+			}
+			oldSlice := h.allArenas
+			*(*notInHeapSlice)(unsafe.Pointer(&h.allArenas)) = notInHeapSlice{newArray, len(h.allArenas), int(size / sys.PtrSize)}
+			copy(h.allArenas, oldSlice)
+			// Do not free the old backing array because
+			// there may be concurrent readers. Since we
+			// double the array each time, this can lead
+			// to at most 2x waste.
+		}
+		h.allArenas = h.allArenas[:len(h.allArenas)+1]
+		h.allArenas[len(h.allArenas)-1] = ri
 	}
 
 	// Tell the race detector about the new heap memory.
@@ -808,6 +830,8 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	shouldhelpgc := false
 	dataSize := size
 	c := gomcache()
+	c.local_gbytes += size
+	c.local_gobjects++
 	var x unsafe.Pointer
 	noscan := typ == nil || typ.kind&kindNoPointers != 0
 	if size <= maxSmallSize {
@@ -863,9 +887,13 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 			span := c.alloc[tinySpanClass]
 			v := nextFreeFast(span)
 			if v == 0 {
-				v, _, shouldhelpgc = c.nextFree(tinySpanClass)
+				v, span, shouldhelpgc = c.nextFree(tinySpanClass)
 			}
 			x = unsafe.Pointer(v)
+			if span.types != nil {
+				// A tiny block holds several objects.
+				span.setType(uintptr(v), nil)
+			}
 			(*[2]uint64)(x)[0] = 0
 			(*[2]uint64)(x)[1] = 0
 			// See if we need to replace the existing tiny block with the new one
@@ -890,6 +918,9 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 				v, span, shouldhelpgc = c.nextFree(spc)
 			}
 			x = unsafe.Pointer(v)
+			if span.types != nil {
+				span.setType(uintptr(v), typ)
+			}
 			if needzero && span.needzero != 0 {
 				memclrNoHeapPointers(unsafe.Pointer(v), size)
 			}
@@ -904,6 +935,9 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		s.allocCount = 1
 		x = unsafe.Pointer(s.base())
 		size = s.elemsize
+		if s.types != nil {
+			s.setType(s.base(), typ)
+		}
 	}
 
 	var scanSize uintptr
@@ -972,6 +1006,14 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		}
 	}
 
+	if rate := atomic.Load(&allocHook.rate); rate != 0 {
+		if int32(size) < c.next_hook_sample && size < uintptr(rate) {
+			c.next_hook_sample -= int32(size)
+		} else {
+			allocHookSample(rate, x, size, typ)
+		}
+	}
+
 	if assistG != nil {
 		// Account for internal fragmentation in the assist
 		// debt now that we know it.
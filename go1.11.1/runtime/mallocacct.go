@@ -0,0 +1,162 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Allocation accounting.
+//
+// Every goroutine counts the bytes and objects it allocates, so that
+// a program can bill allocation to the request or tenant that made
+// it. To keep mallocgc cheap, it only bumps two counters in the
+// mcache. The counts are charged to the goroutine that made them by
+// gAllocFlush whenever the goroutine stops running on its P: when it
+// parks, yields, is preempted, exits or enters a system call. A
+// goroutine reading its own counts flushes its P first.
+//
+// Counts are by bytes requested, not by size class, and allocations
+// made by the runtime on a goroutine's behalf, for example to grow a
+// map, are charged to it.
+//
+// When label accounting is on, the counts of goroutines with profiling
+// labels are also charged to a record for their label set, found by
+// runtime_setProfLabel and inherited by new goroutines along with the
+// labels. The records are kept in a hash table allocated off-heap and
+// never freed. So that label sets with many values, such as request
+// IDs, cannot grow it without bound, label sets beyond
+// labelAllocMaxSets are charged to a single record with no labels.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+const (
+	labelAllocBuckets = 1024
+	labelAllocMaxSets = 4096
+)
+
+// labelAllocRecord is the allocation count of a label set.
+//
+//go:notinheap
+type labelAllocRecord struct {
+	bytes   uint64 // updated atomically
+	objects uint64 // updated atomically
+	next    *labelAllocRecord
+	labels  string // as formatted by labelsString; off-heap
+}
+
+var labelAllocs struct {
+	other labelAllocRecord // label sets beyond labelAllocMaxSets; first for 64-bit alignment
+
+	enabled uint32 // set by setLabelAllocAccounting
+
+	lock    mutex
+	nsets   int
+	buckets [labelAllocBuckets]*labelAllocRecord // written with lock, read atomically
+}
+
+// gAllocFlush charges the allocations counted in c since the last
+// flush to gp, which has been running on c's P. It runs as gp stops
+// running, so it cannot race with gp reading its counts.
+//
+//go:nosplit
+func gAllocFlush(c *mcache, gp *g) {
+	if c == nil || c.local_gobjects == 0 {
+		return
+	}
+	gp.allocBytes += uint64(c.local_gbytes)
+	gp.allocObjects += uint64(c.local_gobjects)
+	if r := gp.allocLabels; r != nil {
+		atomic.Xadd64(&r.bytes, int64(c.local_gbytes))
+		atomic.Xadd64(&r.objects, int64(c.local_gobjects))
+	}
+	c.local_gbytes = 0
+	c.local_gobjects = 0
+}
+
+// labelAllocRecordFor returns the record for the label set labels, or
+// nil if label accounting is off or the set is empty. It allocates, so
+// it must run on the user stack.
+func labelAllocRecordFor(labels unsafe.Pointer) *labelAllocRecord {
+	if atomic.Load(&labelAllocs.enabled) == 0 {
+		return nil
+	}
+	s := labelsString(labels)
+	if s == "" {
+		return nil
+	}
+	var h uint32 = 2166136261
+	for i := 0; i < len(s); i++ {
+		h = (h ^ uint32(s[i])) * 16777619
+	}
+	bucket := &labelAllocs.buckets[h%labelAllocBuckets]
+	var r *labelAllocRecord
+	systemstack(func() {
+		lock(&labelAllocs.lock)
+		for r = *bucket; r != nil; r = r.next {
+			if r.labels == s {
+				break
+			}
+		}
+		if r == nil {
+			if labelAllocs.nsets >= labelAllocMaxSets {
+				r = &labelAllocs.other
+			} else {
+				r = (*labelAllocRecord)(persistentalloc(unsafe.Sizeof(*r), 8, &memstats.other_sys))
+				p := persistentalloc(uintptr(len(s)), 1, &memstats.other_sys)
+				memmove(p, stringStructOf(&s).str, uintptr(len(s)))
+				ss := stringStructOf(&r.labels)
+				ss.str, ss.len = p, len(s)
+				r.next = *bucket
+				atomic.StorepNoWB(unsafe.Pointer(bucket), unsafe.Pointer(r))
+				labelAllocs.nsets++
+			}
+		}
+		unlock(&labelAllocs.lock)
+	})
+	return r
+}
+
+//go:linkname setLabelAllocAccounting runtime/debug.setLabelAllocAccounting
+func setLabelAllocAccounting(enable bool) bool {
+	v := uint32(0)
+	if enable {
+		v = 1
+	}
+	return atomic.Xchg(&labelAllocs.enabled, v) != 0
+}
+
+// readGoroutineAllocs returns the bytes and objects allocated so far
+// by the calling goroutine.
+//
+//go:linkname readGoroutineAllocs runtime/debug.readGoroutineAllocs
+func readGoroutineAllocs() (bytes, objects uint64) {
+	mp := acquirem()
+	gAllocFlush(mp.mcache, mp.curg)
+	bytes, objects = mp.curg.allocBytes, mp.curg.allocObjects
+	releasem(mp)
+	return
+}
+
+// readLabelAllocs appends the label set and counts of every record to
+// labels and counts, two counts per record. The record for label sets
+// beyond the limit comes last, with empty labels, if used.
+//
+//go:linkname readLabelAllocs runtime/debug.readLabelAllocs
+func readLabelAllocs(labels *[]string, counts *[]uint64) {
+	// Records are never freed or unlinked, and are complete before
+	// they are published, so the table can be read without the
+	// lock.
+	for i := range labelAllocs.buckets {
+		r := (*labelAllocRecord)(atomic.Loadp(unsafe.Pointer(&labelAllocs.buckets[i])))
+		for ; r != nil; r = r.next {
+			*labels = append(*labels, r.labels)
+			*counts = append(*counts, atomic.Load64(&r.bytes), atomic.Load64(&r.objects))
+		}
+	}
+	if r := &labelAllocs.other; atomic.Load64(&r.objects) != 0 {
+		*labels = append(*labels, "")
+		*counts = append(*counts, atomic.Load64(&r.bytes), atomic.Load64(&r.objects))
+	}
+}
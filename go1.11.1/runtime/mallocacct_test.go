@@ -0,0 +1,142 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"testing"
+)
+
+var allocSink, otherAllocSink [][]byte
+
+func TestGoroutineAllocs(t *testing.T) {
+	const n, size = 1000, 100
+
+	b0, o0 := debug.GoroutineAllocs()
+	// Allocations of another goroutine are not charged to this
+	// one, even if it runs on the same P.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10*n; i++ {
+			otherAllocSink = append(otherAllocSink[:0], make([]byte, size))
+		}
+		done <- true
+	}()
+	for i := 0; i < n; i++ {
+		allocSink = append(allocSink, make([]byte, size))
+		if i%100 == 0 {
+			runtime.Gosched()
+		}
+	}
+	<-done
+	b1, o1 := debug.GoroutineAllocs()
+	allocSink, otherAllocSink = nil, nil
+
+	// append allocates too, and the test framework and channel
+	// operations may allocate a little.
+	if b, o := b1-b0, o1-o0; b < n*size || o < n || o > 2*n {
+		t.Errorf("allocating %d objects of %d bytes counted %d objects, %d bytes", n, size, o, b)
+	}
+}
+
+func findLabelAllocs(labels string) (debug.LabelAllocs, bool) {
+	for _, a := range debug.ReadLabelAllocs(nil) {
+		if a.Labels == labels {
+			return a, true
+		}
+	}
+	return debug.LabelAllocs{}, false
+}
+
+func TestLabelAllocs(t *testing.T) {
+	const n, size = 1000, 100
+	defer debug.SetLabelAllocAccounting(debug.SetLabelAllocAccounting(true))
+	defer runtime.SetProfLabel(nil)
+
+	// Labels set while accounting is off are not accounted.
+	debug.SetLabelAllocAccounting(false)
+	runtime.SetProfLabel(map[string]string{"test": "off"})
+	allocSink = append(allocSink, make([]byte, size))
+	debug.SetLabelAllocAccounting(true)
+
+	// Allocations are charged to the labels they were made under,
+	// and a new goroutine is charged to the labels of the one that
+	// started it.
+	runtime.SetProfLabel(map[string]string{"test": "label-allocs", "a": "1"})
+	for i := 0; i < n; i++ {
+		allocSink = append(allocSink, make([]byte, size))
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			otherAllocSink = append(otherAllocSink, make([]byte, size))
+		}
+		done <- true
+	}()
+	<-done
+	runtime.SetProfLabel(nil)
+	for i := 0; i < n; i++ {
+		allocSink = append(allocSink, make([]byte, size))
+	}
+	debug.GoroutineAllocs()
+	allocSink, otherAllocSink = nil, nil
+
+	if a, ok := findLabelAllocs("test=off"); ok {
+		t.Errorf("labels set while accounting was off accounted: %+v", a)
+	}
+	a, ok := findLabelAllocs("a=1,test=label-allocs")
+	if !ok {
+		t.Fatalf("no record for the labels in %+v", debug.ReadLabelAllocs(nil))
+	}
+	// The goroutine's own allocations after the labels were
+	// cleared are not charged to them.
+	if a.Objects < 2*n || a.Objects > 3*n || a.Bytes < 2*n*size {
+		t.Errorf("allocating %d objects of %d bytes under the labels counted %d objects, %d bytes",
+			2*n, size, a.Objects, a.Bytes)
+	}
+}
+
+func TestLabelAllocsLimitHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	debug.SetLabelAllocAccounting(true)
+	const extra = 10
+	for i := 0; i < runtime.LabelAllocMaxSets+extra; i++ {
+		runtime.SetProfLabel(map[string]string{"i": strconv.Itoa(i)})
+		allocSink = append(allocSink[:0], make([]byte, 100))
+	}
+	runtime.SetProfLabel(nil)
+
+	var sets int
+	var other debug.LabelAllocs
+	for _, a := range debug.ReadLabelAllocs(nil) {
+		if a.Labels == "" {
+			other = a
+			continue
+		}
+		sets++
+	}
+	if sets != runtime.LabelAllocMaxSets {
+		t.Errorf("%d label sets counted separately, want %d", sets, runtime.LabelAllocMaxSets)
+	}
+	if other.Objects < extra {
+		t.Errorf("%d objects counted for label sets beyond the limit, want at least %d", other.Objects, extra)
+	}
+	if a, ok := findLabelAllocs("i=" + strconv.Itoa(runtime.LabelAllocMaxSets)); ok {
+		t.Errorf("label set beyond the limit counted separately: %+v", a)
+	}
+}
+
+func TestLabelAllocsLimit(t *testing.T) {
+	// The label sets are never freed, so fill the table in a new
+	// process.
+	out, err := runHelper(t, "TestLabelAllocsLimitHelper")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
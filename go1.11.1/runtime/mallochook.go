@@ -0,0 +1,252 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Allocation hooks.
+//
+// runtime/debug.SetAllocHook registers a function to be called for
+// sampled allocations, and for the frees of the sampled objects. It is
+// a production-friendly replacement for GODEBUG=allocfreetrace=1.
+//
+// Allocations are sampled like the heap profile, on average once every
+// allocHook.rate bytes, using a countdown in the mcache separate from
+// the heap profile's. mallocgc records a sampled allocation in a
+// profBuf, along with its stack, and attaches a special to the object
+// so that the sweeper records its free. Neither allocates. A goroutine
+// in runtime/debug reads the buffers and calls the hook, so the hook
+// never runs on the allocation path and may itself allocate. If the
+// hook falls behind, events are dropped and the reader is told how
+// many.
+//
+// profBuf supports a single writer, so each P has its own,
+// p.allocHookLog, written only by the M holding the P, which does not
+// give it up during the write. Writers share nothing but the wakeup of
+// the reader.
+//
+// The reader merges the buffers of all Ps in time order, so that the
+// hook sees each free after the allocation of its object, and the free
+// of an object before the allocation of another at the same address.
+// A buffer may be read before an earlier event in it is written, so
+// the reader takes the time before reading the buffers and holds back
+// the events after that time until its next read. An event that
+// happened after another, on any P, was timed after the other was
+// written: the stop of the world that ends the mark phase waits for
+// the write of an allocation to finish, and the sweeper records a free
+// before the object's memory can be allocated again. So each event
+// returned comes after every event that happened before it.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+const (
+	allocHookAlloc = 1 + iota
+	allocHookFree
+)
+
+// allocHookHdr is the number of words in the header of an event in
+// allocHook.log: kind, address, size and type. The type is stored as
+// a uintptr rather than a tag because the write runs without write
+// barriers. The types of heap objects are static or, if made by
+// reflect, cached by it forever, so the reader may use it later.
+const allocHookHdr = 4
+
+var allocHook struct {
+	rate uint32 // sampling rate in bytes; 0 if off
+
+	// logs is set, with the world stopped, once all Ps have an
+	// allocHookLog, before rate is first set. Ps made later get
+	// one in procresize.
+	logs bool
+
+	// The reader sets waiting before sleeping on wait, and the
+	// writer that clears it wakes the reader.
+	waiting uint32
+	wait    note
+
+	// pending holds the events read from the logs but held back,
+	// in runs as returned by allocHookDrain. Only the reader uses
+	// it.
+	pending     []uint64
+	pendingRuns []int
+}
+
+// newAllocHookLog returns a buffer for the events of one P.
+func newAllocHookLog() *profBuf {
+	return newProfBuf(allocHookHdr, 1<<15, 1<<8)
+}
+
+// setAllocHookRate sets the sampling rate in bytes, 0 to turn sampling
+// off, and returns the previous rate.
+//
+//go:linkname setAllocHookRate runtime/debug.setAllocHookRate
+func setAllocHookRate(rate int) int {
+	if rate < 0 {
+		rate = 0
+	}
+	if rate > 0x7000000 {
+		rate = 0x7000000 // see fastexprand
+	}
+	if rate != 0 && !allocHook.logs {
+		// runtime/debug serializes calls, so there is no race
+		// to set logs.
+		stopTheWorld("alloc hook")
+		for _, pp := range allp {
+			if pp.allocHookLog == nil {
+				pp.allocHookLog = newAllocHookLog()
+			}
+		}
+		allocHook.logs = true
+		startTheWorld()
+	}
+	return int(atomic.Xchg(&allocHook.rate, uint32(rate)))
+}
+
+// allocHookSample reports the allocation of x, of the given size and
+// type, to the allocation hook. It must not allocate.
+func allocHookSample(rate uint32, x unsafe.Pointer, size uintptr, typ *_type) {
+	mp := acquirem()
+	mp.mcache.next_hook_sample = fastexprand(int(rate))
+	var stk [maxStack]uintptr
+	// Skip mallocgc and its caller, as mProf_Malloc does.
+	nstk := callers(3, stk[:])
+	allocHookWrite(allocHookAlloc, uintptr(x), size, typ, stk[:nstk])
+	systemstack(func() {
+		setallochook(x, typ)
+	})
+	releasem(mp)
+}
+
+// allocHookWrite records an event in the log of the current P. It is
+// called from mallocgc and the sweeper, which hold a P, and must not
+// allocate.
+func allocHookWrite(kind int, p, size uintptr, typ *_type, stk []uintptr) {
+	mp := acquirem()
+	pp := mp.p.ptr()
+	if pp == nil || pp.allocHookLog == nil {
+		throw("allocation hook event without a log")
+	}
+	hdr := [allocHookHdr]uint64{uint64(kind), uint64(p), uint64(size), uint64(uintptr(unsafe.Pointer(typ)))}
+	pp.allocHookLog.write(nil, nanotime(), hdr[:], stk)
+	releasem(mp)
+	if atomic.Load(&allocHook.waiting) != 0 && atomic.Cas(&allocHook.waiting, 1, 0) {
+		notewakeup(&allocHook.wait)
+	}
+}
+
+// allocHookDrain appends the records in the logs of all Ps to raw, and
+// the offset in raw of the records of each log to runs. The records of
+// a run are in time order, and the run ends where the next one starts.
+func allocHookDrain(raw []uint64, runs []int) ([]uint64, []int) {
+	// Ps beyond gomaxprocs may still hold events.
+	for _, pp := range allp[:cap(allp)] {
+		if pp == nil || pp.allocHookLog == nil {
+			continue
+		}
+		data, _, _ := pp.allocHookLog.read(profBufNonBlocking)
+		if len(data) > 0 {
+			runs = append(runs, len(raw))
+			raw = append(raw, data...)
+		}
+	}
+	return raw, runs
+}
+
+// allocHookReady reports whether a run in raw starts with a record
+// timed no later than cutoff.
+func allocHookReady(raw []uint64, runs []int, cutoff uint64) bool {
+	for _, off := range runs {
+		if raw[off+1] <= cutoff {
+			return true
+		}
+	}
+	return false
+}
+
+// readAllocHook waits for events and appends them to events, in the
+// order described above, each as kind, Unix time, address, size, stack
+// length and stack, with the name of the type of each event appended
+// to types. A dropped-events record
+// has kind 0 and the number of events dropped as its only stack word.
+//
+//go:linkname readAllocHook runtime/debug.readAllocHook
+func readAllocHook(events *[]uint64, types *[]string) {
+	raw, runs := allocHook.pending, allocHook.pendingRuns
+	var cutoff uint64
+	for {
+		// See the comment at the top of the file.
+		cutoff = uint64(nanotime())
+		raw, runs = allocHookDrain(raw, runs)
+		if allocHookReady(raw, runs, cutoff) {
+			break
+		}
+		if len(raw) > 0 {
+			// All events came after cutoff. Take a new one.
+			continue
+		}
+		noteclear(&allocHook.wait)
+		atomic.Store(&allocHook.waiting, 1)
+		if raw, runs = allocHookDrain(raw, runs); len(raw) > 0 {
+			if !atomic.Cas(&allocHook.waiting, 1, 0) {
+				// A writer is waking us. Let it finish
+				// before the note is cleared again.
+				notetsleepg(&allocHook.wait, -1)
+			}
+			continue
+		}
+		notetsleepg(&allocHook.wait, -1)
+	}
+
+	// Events are timed with nanotime.
+	sec, nsec := walltime()
+	offset := sec*1e9 + int64(nsec) - nanotime()
+	ends := make([]int, len(runs))
+	for i := range runs {
+		ends[i] = len(raw)
+		if i+1 < len(runs) {
+			ends[i] = runs[i+1]
+		}
+	}
+	for {
+		// Take the earliest record at the head of a run.
+		best := -1
+		for i, off := range runs {
+			if off < ends[i] && (best < 0 || raw[off+1] < raw[runs[best]+1]) {
+				best = i
+			}
+		}
+		if best < 0 || raw[runs[best]+1] > cutoff {
+			break
+		}
+		data := raw[runs[best]:ends[best]]
+		n := int(data[0])
+		if n < 2+allocHookHdr || n > len(data) {
+			throw("bad allocation hook record")
+		}
+		time, hdr, stk := data[1], data[2:2+allocHookHdr], data[2+allocHookHdr:n]
+		runs[best] += n
+
+		typ := (*_type)(unsafe.Pointer(uintptr(hdr[3])))
+		name := ""
+		if typ != nil {
+			name = typ.string()
+		}
+		*events = append(*events, hdr[0], uint64(int64(time)+offset), hdr[1], hdr[2], uint64(len(stk)))
+		*events = append(*events, stk...)
+		*types = append(*types, name)
+	}
+
+	// Keep the rest of each run for the next read.
+	var rest []uint64
+	var restRuns []int
+	for i, off := range runs {
+		if off < ends[i] {
+			restRuns = append(restRuns, len(rest))
+			rest = append(rest, raw[off:ends[i]]...)
+		}
+	}
+	allocHook.pending, allocHook.pendingRuns = rest, restRuns
+}
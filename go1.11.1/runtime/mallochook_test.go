@@ -0,0 +1,166 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type allocHookObj struct {
+	p [16]*int
+}
+
+var allocHookSink *allocHookObj
+
+func TestAllocHook(t *testing.T) {
+	const typ = "runtime_test.allocHookObj"
+	events := make(chan debug.AllocEvent, 100)
+	// Sample every allocation.
+	debug.SetAllocHook(1, func(e debug.AllocEvent) {
+		if e.Type == typ {
+			select {
+			case events <- e:
+			default:
+			}
+		}
+	})
+	defer debug.SetAllocHook(0, nil)
+
+	// The hook path must not allocate, so each new object costs
+	// exactly one allocation.
+	const n = 100
+	_, o0 := debug.GoroutineAllocs()
+	for i := 0; i < n; i++ {
+		allocHookSink = new(allocHookObj)
+	}
+	_, o1 := debug.GoroutineAllocs()
+	if o1-o0 != n {
+		t.Errorf("allocating %d objects with the hook on made %d allocations", n, o1-o0)
+	}
+	allocHookSink = nil
+
+	var sawAlloc, sawFree bool
+	timeout := time.After(10 * time.Second)
+	for !sawAlloc || !sawFree {
+		runtime.GC()
+		select {
+		case e := <-events:
+			switch e.Kind {
+			case debug.AllocEventAlloc:
+				if !sawAlloc {
+					sawAlloc = true
+					checkAllocHookStack(t, e.Stack)
+				}
+			case debug.AllocEventFree:
+				sawFree = true
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got alloc %v, free %v", sawAlloc, sawFree)
+		}
+	}
+}
+
+func checkAllocHookStack(t *testing.T, stk []uintptr) {
+	frames := runtime.CallersFrames(stk)
+	for {
+		f, more := frames.Next()
+		if strings.HasSuffix(f.Function, ".TestAllocHook") {
+			return
+		}
+		if !more {
+			break
+		}
+	}
+	t.Errorf("allocation stack does not include TestAllocHook")
+}
+
+type allocHookOrderObj struct {
+	p [4]*int
+}
+
+var allocHookOrderSink [4][]*allocHookOrderObj
+
+func TestAllocHookOrder(t *testing.T) {
+	const typ = "runtime_test.allocHookOrderObj"
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var (
+		mu     sync.Mutex
+		events []debug.AllocEvent
+		frees  int
+	)
+	debug.SetAllocHook(1, func(e debug.AllocEvent) {
+		if e.Type == typ || e.Kind == debug.AllocEventDropped {
+			mu.Lock()
+			events = append(events, e)
+			if e.Kind == debug.AllocEventFree {
+				frees++
+			}
+			mu.Unlock()
+		}
+	})
+	defer debug.SetAllocHook(0, nil)
+
+	// Allocate on several Ps, and let the objects die and their
+	// memory be reused, so that frees and allocations at the same
+	// address are recorded on different Ps.
+	const rounds, n = 5, 200
+	for r := 0; r < rounds; r++ {
+		var wg sync.WaitGroup
+		for g := range allocHookOrderSink {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				allocHookOrderSink[g] = allocHookOrderSink[g][:0]
+				for i := 0; i < n; i++ {
+					allocHookOrderSink[g] = append(allocHookOrderSink[g], new(allocHookOrderObj))
+				}
+			}(g)
+		}
+		wg.Wait()
+		runtime.GC()
+	}
+	for g := range allocHookOrderSink {
+		allocHookOrderSink[g] = nil
+	}
+	total := rounds * n * len(allocHookOrderSink)
+	for i := 0; ; i++ {
+		runtime.GC()
+		mu.Lock()
+		done := frees >= total
+		mu.Unlock()
+		if done {
+			break
+		}
+		if i == 500 {
+			t.Fatalf("%d of %d frees reported", frees, total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	live := make(map[uintptr]bool)
+	for _, e := range events {
+		switch e.Kind {
+		case debug.AllocEventDropped:
+			t.Skipf("%d events dropped", e.Dropped)
+		case debug.AllocEventAlloc:
+			if live[e.Addr] {
+				t.Fatalf("allocation at %#x reported before the free of the previous object there", e.Addr)
+			}
+			live[e.Addr] = true
+		case debug.AllocEventFree:
+			if !live[e.Addr] {
+				t.Fatalf("free at %#x reported before its allocation", e.Addr)
+			}
+			delete(live, e.Addr)
+		}
+	}
+}
@@ -616,7 +616,12 @@ func bulkBarrierPreWrite(dst, src, size uintptr) {
 		// another stack. Either way, no need for barriers.
 		// This will also catch if dst is in a freed span,
 		// though that should never have.
-		return
+		//
+		// User arena chunks are the exception: they have a
+		// heap bitmap and are only scanned once per cycle.
+		if !(s.state == _MSpanManual && s.userArena) {
+			return
+		}
 	}
 
 	buf := &getg().m.p.ptr().wbBuf
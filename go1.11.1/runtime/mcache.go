@@ -16,8 +16,12 @@ import "unsafe"
 type mcache struct {
 	// The following members are accessed on every malloc,
 	// so they are grouped here for better caching.
-	next_sample int32   // trigger heap sample after allocating this many bytes
-	local_scan  uintptr // bytes of scannable heap allocated
+	next_sample    int32   // trigger heap sample after allocating this many bytes
+	local_scan     uintptr // bytes of scannable heap allocated
+	local_gbytes   uintptr // bytes allocated by the running g, see gAllocFlush
+	local_gobjects uintptr // objects allocated by the running g
+
+	next_hook_sample int32 // trigger allocation hook sample after allocating this many bytes
 
 	// Allocator cache for tiny objects w/o pointers.
 	// See "Tiny allocator" comment in malloc.go.
@@ -39,6 +43,15 @@ type mcache struct {
 	stackcache [_NumStackOrders]stackfreelist
 
 	// Local allocator stats, flushed during GC.
+	//
+	// These, and local_tinyallocs, are read by other Ps without
+	// flushing them, with the heap lock held, so that ReadMemStats
+	// need not stop the world (see heapStatsAggregate.read). Only the
+	// owner of the mcache updates them, with atomic stores, except
+	// local_tinyallocs, which is updated on every tiny allocation. A
+	// word-sized counter cannot be read torn, so its readers only
+	// miss the latest increments. They are flushed with the heap
+	// lock held or the world stopped.
 	local_largefree  uintptr                  // bytes freed for large objects (>maxsmallsize)
 	local_nlargefree uintptr                  // number of frees for large objects (>maxsmallsize)
 	local_nsmallfree [_NumSizeClasses]uintptr // number of frees for small objects (<=maxsmallsize)
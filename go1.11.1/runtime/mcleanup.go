@@ -0,0 +1,248 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Cleanups.
+//
+// AddCleanup attaches a function to an object, to be run after the
+// object is freed. Unlike a finalizer, a cleanup does not receive the
+// object, so it does not resurrect it and the object is freed by the
+// cycle that finds it unreachable, even if it is part of a reference
+// cycle. An object may have any number of cleanups.
+//
+// A cleanup is a _KindSpecialCleanup special holding a closure of the
+// function and its argument. When the sweeper frees the object, it
+// moves the closures to cleanupq, a queue of finblocks allocated and
+// scanned like the finalizer queue's. The cleanups are run by a pool of
+// goroutines, runcleanupq, of at most cleanupMaxWorkers, which is
+// started by the first AddCleanup and grows when cleanups are queued
+// or left in the queue and no worker is idle. The sweeper cannot start
+// goroutines, so new workers are only reserved there and started by
+// the scheduler, which also wakes idle workers, as it does fing.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// cleanupMaxWorkers bounds the number of goroutines running cleanups,
+// further bounded by GOMAXPROCS.
+const cleanupMaxWorkers = 16
+
+var cleanupq struct {
+	// All fields are protected by finlock.
+	q        *finblock // cleanups to run, in the fn field of each entry
+	idle     guintptr  // idle workers, linked through schedlink
+	nidle    int32
+	nworkers int32 // including those in nspawn
+	nspawn   int32 // workers reserved by cleanupgrow, not yet started
+}
+
+var cleanupCreate uint32
+
+// queuecleanup queues fn to be run by a cleanup worker.
+func queuecleanup(fn *funcval) {
+	if gcphase != _GCoff {
+		// Like queuefinalizer. cleanupq blocks are on the
+		// allfin list, which is scanned once per cycle.
+		throw("queuecleanup during GC")
+	}
+
+	lock(&finlock)
+	if cleanupq.q == nil || cleanupq.q.cnt == uint32(len(cleanupq.q.fin)) {
+		block := getfinblock()
+		block.next = cleanupq.q
+		cleanupq.q = block
+	}
+	f := &cleanupq.q.fin[cleanupq.q.cnt]
+	atomic.Xadd(&cleanupq.q.cnt, +1) // Sync with markroots
+	f.fn = fn
+	atomic.Xadd64(&finstats.cleanupsQueued, 1)
+	// Every worker may be stuck in a slow cleanup, and none of
+	// them would look at the queue again until it returns.
+	cleanupgrow()
+	unlock(&finlock)
+}
+
+// cleanupgrow reserves a new worker if there are cleanups to run and
+// no worker is idle or about to start. The scheduler starts it.
+// finlock must be held.
+func cleanupgrow() {
+	if cleanupq.q != nil && cleanupq.nidle == 0 && cleanupq.nspawn == 0 &&
+		cleanupq.nworkers < cleanupMaxWorkers && cleanupq.nworkers < gomaxprocs {
+		cleanupq.nworkers++
+		cleanupq.nspawn++
+	}
+}
+
+// startcleanupworkers starts the workers reserved by cleanupgrow. It
+// must run on g0, in the scheduler or on a worker's system stack.
+func startcleanupworkers() {
+	_g_ := getg()
+	lock(&finlock)
+	n := cleanupq.nspawn
+	cleanupq.nspawn = 0
+	unlock(&finlock)
+	for ; n > 0; n-- {
+		fn := runcleanupq
+		newproc1(*(**funcval)(unsafe.Pointer(&fn)), nil, 0, _g_, getcallerpc())
+	}
+}
+
+// wakecleanup returns an idle cleanup worker to be readied if there are
+// cleanups to run, or nil.
+func wakecleanup() *g {
+	var gp *g
+	lock(&finlock)
+	if cleanupq.q != nil && cleanupq.nidle > 0 {
+		gp = cleanupq.idle.ptr()
+		cleanupq.idle = gp.schedlink
+		gp.schedlink = 0
+		cleanupq.nidle--
+	}
+	unlock(&finlock)
+	return gp
+}
+
+func createcleanupworker() {
+	// start the first worker exactly once
+	if cleanupCreate == 0 && atomic.Cas(&cleanupCreate, 0, 1) {
+		lock(&finlock)
+		cleanupq.nworkers++
+		unlock(&finlock)
+		go runcleanupq()
+	}
+}
+
+// runcleanupq is a cleanup worker. It runs one cleanup at a time.
+func runcleanupq() {
+	gp := getg()
+	for {
+		lock(&finlock)
+		fb := cleanupq.q
+		if fb == nil {
+			gp.schedlink = cleanupq.idle
+			cleanupq.idle.set(gp)
+			cleanupq.nidle++
+			goparkunlock(&finlock, waitReasonCleanupWait, traceEvGoBlock, 1)
+			continue
+		}
+		f := &fb.fin[fb.cnt-1]
+		fn := f.fn
+		// Drop the queue's reference before hiding it from
+		// markroot. fn is on our stack now.
+		f.fn = nil
+		atomic.Store(&fb.cnt, fb.cnt-1)
+		if fb.cnt == 0 {
+			cleanupq.q = fb.next
+			fb.next = finc
+			finc = fb
+		}
+		cleanupgrow()
+		spawn := cleanupq.nspawn > 0
+		unlock(&finlock)
+		if spawn {
+			// Don't wait for the scheduler.
+			systemstack(startcleanupworkers)
+		}
+
+		var call func()
+		*(**funcval)(unsafe.Pointer(&call)) = fn
+		gp.cleanupRunning = true
+		call()
+		gp.cleanupRunning = false
+		atomic.Xadd64(&finstats.cleanupsRun, 1)
+	}
+}
+
+// A Cleanup is a handle to a cleanup added by AddCleanup. It does not
+// keep the object alive.
+type Cleanup struct {
+	ptr uintptr // address of the object
+	id  uint64  // 0 if the cleanup will never run
+}
+
+// AddCleanup attaches a cleanup function to the object ptr points to.
+// Some time after the object becomes unreachable and is freed, the
+// garbage collector calls cleanup(arg) on a separate goroutine.
+//
+// Unlike a finalizer, a cleanup does not resurrect the object: cleanup
+// receives arg, not the object, and the object is freed by the cycle
+// that finds it unreachable, even if it is part of a reference cycle.
+// arg must not be ptr, and neither arg nor cleanup may reference the
+// object, or it stays reachable and the cleanup never runs. An object
+// may have any number of cleanups, including alongside a finalizer, in
+// which case the cleanups run once the object is unreachable again
+// after its finalizer has run.
+//
+// Cleanups run concurrently with each other, on a pool of goroutines
+// that grows up to GOMAXPROCS, or 16, so a slow cleanup does not delay
+// the others. Cleanups attached to the same object run in no particular
+// order. As with finalizers, there is no guarantee that cleanups run
+// before a program exits, and use KeepAlive to keep an object
+// reachable until it is no longer required.
+//
+// ptr must be a pointer to an object allocated by calling new, by
+// taking the address of a composite literal, or by taking the address
+// of a local variable. Cleanups attached to objects that are not
+// allocated in the heap, such as package-level variables and
+// zero-sized objects, never run.
+func AddCleanup(ptr interface{}, cleanup func(arg interface{}), arg interface{}) Cleanup {
+	e := efaceOf(&ptr)
+	etyp := e._type
+	if etyp == nil {
+		panic(plainError("runtime.AddCleanup: ptr is nil"))
+	}
+	if etyp.kind&kindMask != kindPtr {
+		panic(plainError("runtime.AddCleanup: ptr is " + etyp.string() + ", not pointer"))
+	}
+	if cleanup == nil {
+		panic(plainError("runtime.AddCleanup: cleanup is nil"))
+	}
+	if debug.sbrk != 0 {
+		// debug.sbrk never frees memory, so no cleanups run.
+		return Cleanup{}
+	}
+
+	base, _, _ := findObject(uintptr(e.data), 0, 0)
+	if base == 0 {
+		// Zero-sized, linker-allocated or otherwise not in
+		// the heap: the object is never freed.
+		return Cleanup{}
+	}
+	ot := (*ptrtype)(unsafe.Pointer(etyp))
+	if uintptr(e.data) != base {
+		// As with SetFinalizer, allow an inner byte of an
+		// object that could come from tiny alloc.
+		if ot.elem == nil || ot.elem.kind&kindNoPointers == 0 || ot.elem.size >= maxTinySize {
+			panic(plainError("runtime.AddCleanup: pointer not at beginning of allocated block"))
+		}
+	}
+	if a := efaceOf(&arg); a._type != nil && a._type.kind&kindMask == kindPtr {
+		if abase, _, _ := findObject(uintptr(a.data), 0, 0); abase == base {
+			panic(plainError("runtime.AddCleanup: ptr is equal to arg, cleanup will never run"))
+		}
+	}
+
+	fn := func() { cleanup(arg) }
+	createcleanupworker()
+	var id uint64
+	systemstack(func() {
+		id = addcleanup(e.data, *(**funcval)(unsafe.Pointer(&fn)))
+	})
+	return Cleanup{uintptr(e.data), id}
+}
+
+// Stop cancels the cleanup, if it has not been queued to run yet. It is
+// safe to call Stop on a cleanup that has run or been stopped, and on
+// the zero Cleanup.
+func (c Cleanup) Stop() {
+	if c.id == 0 {
+		return
+	}
+	systemstack(func() {
+		removecleanup(c.ptr, c.id)
+	})
+}
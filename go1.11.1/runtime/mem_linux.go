@@ -34,6 +34,9 @@ func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	return p
 }
 
+// sysMadvise is madvise. Tests of the huge page policies replace it.
+var sysMadvise = madvise
+
 func sysUnused(v unsafe.Pointer, n uintptr) {
 	// By default, Linux's "transparent huge page" support will
 	// merge pages into a huge page if there's even a single
@@ -60,7 +63,17 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 	// gets most of the benefit of huge pages while keeping the
 	// number of VMAs under control. With hugePageSize = 2MB, even
 	// a pessimal heap can reach 128GB before running out of VMAs.
-	if sys.HugePageSize != 0 {
+	//
+	// The explicit huge page policies (see hugepage.go) replace
+	// this: with GOTHP=always and never the flag is left as set
+	// when the arena was mapped, and with GOTHP=heap it is cleared
+	// on every huge page the range touches.
+	if sys.HugePageSize != 0 && thpPolicy == thpHeap {
+		var s uintptr = sys.HugePageSize
+		beg := uintptr(v) &^ (s - 1)
+		end := (uintptr(v) + n + s - 1) &^ (s - 1)
+		sysMadvise(unsafe.Pointer(beg), end-beg, _MADV_NOHUGEPAGE)
+	} else if sys.HugePageSize != 0 && thpPolicy == thpDefault {
 		var s uintptr = sys.HugePageSize // division by constant 0 is a compile-time error :(
 
 		// If it's a large allocation, we want to leave huge
@@ -83,14 +96,14 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 		if head != 0 && head+sys.HugePageSize == tail {
 			// head and tail are different but adjacent,
 			// so do this in one call.
-			madvise(unsafe.Pointer(head), 2*sys.HugePageSize, _MADV_NOHUGEPAGE)
+			sysMadvise(unsafe.Pointer(head), 2*sys.HugePageSize, _MADV_NOHUGEPAGE)
 		} else {
 			// Advise the huge pages containing v and v+n-1.
 			if head != 0 {
-				madvise(unsafe.Pointer(head), sys.HugePageSize, _MADV_NOHUGEPAGE)
+				sysMadvise(unsafe.Pointer(head), sys.HugePageSize, _MADV_NOHUGEPAGE)
 			}
 			if tail != 0 && tail != head {
-				madvise(unsafe.Pointer(tail), sys.HugePageSize, _MADV_NOHUGEPAGE)
+				sysMadvise(unsafe.Pointer(tail), sys.HugePageSize, _MADV_NOHUGEPAGE)
 			}
 		}
 	}
@@ -102,11 +115,11 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 		throw("unaligned sysUnused")
 	}
 
-	madvise(v, n, _MADV_DONTNEED)
+	sysMadvise(v, n, _MADV_DONTNEED)
 }
 
 func sysUsed(v unsafe.Pointer, n uintptr) {
-	if sys.HugePageSize != 0 {
+	if sys.HugePageSize != 0 && (thpPolicy == thpDefault || thpPolicy == thpHeap) {
 		// Partially undo the NOHUGEPAGE marks from sysUnused
 		// for whole huge pages between v and v+n. This may
 		// leave huge pages off at the end points v and v+n
@@ -123,11 +136,22 @@ func sysUsed(v unsafe.Pointer, n uintptr) {
 		end := (uintptr(v) + n) &^ (s - 1)
 
 		if beg < end {
-			madvise(unsafe.Pointer(beg), end-beg, _MADV_HUGEPAGE)
+			sysMadvise(unsafe.Pointer(beg), end-beg, _MADV_HUGEPAGE)
 		}
 	}
 }
 
+// sysArenaMapped applies the huge page policy to the heap arenas
+// sysMap has just mapped at v.
+func sysArenaMapped(v unsafe.Pointer, n uintptr) {
+	switch thpPolicy {
+	case thpAlways, thpHeap:
+		sysMadvise(v, n, _MADV_HUGEPAGE)
+	case thpNever:
+		sysMadvise(v, n, _MADV_NOHUGEPAGE)
+	}
+}
+
 // Don't split the stack as this function may be invoked without a valid G,
 // which prevents us from allocating more stack.
 //go:nosplit
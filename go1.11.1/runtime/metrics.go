@@ -0,0 +1,447 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Runtime metrics.
+//
+// readMetrics, behind runtime/debug.ReadMetrics, fills in samples of
+// metrics named by strings such as "/gc/heap/allocs:bytes". It never
+// stops the world. Most metrics are a single counter read atomically.
+// The heap statistics, which ReadMemStats also uses, are summed, with
+// the heap lock held so that mheap.alloc_m cannot flush an mcache
+// meanwhile, from the global counters and the local counters of the
+// mcache of every P, much as cachestats does but without flushing them.
+// The owners keep updating their local counters, so the sums may be
+// slightly skewed.
+//
+// mcentral counts every free object in a span as allocated when it hands
+// the span to an mcache, so the objects still free in the spans cached
+// by each mcache are subtracted from the allocations.
+//
+// The metrics a runtime knows about are the keys of metricsTable. Their
+// descriptions are in runtime/debug, which also documents the names:
+// a name is never reused for a metric with a different meaning, so a
+// program can rely on a metric staying the same as long as it exists.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// metricKind, metricValue and metricSample must match MetricKind,
+// MetricValue and MetricSample in runtime/debug.
+type metricKind int
+
+const (
+	metricKindBad metricKind = iota
+	metricKindUint64
+	metricKindFloat64
+	metricKindFloat64Histogram
+)
+
+type metricValue struct {
+	kind    metricKind
+	scalar  uint64         // uint64 value, or float64 bits
+	pointer unsafe.Pointer // *float64Histogram
+}
+
+type metricSample struct {
+	name  string
+	value metricValue
+}
+
+// float64Histogram must match runtime/debug.Float64Histogram.
+type float64Histogram struct {
+	counts  []uint64
+	buckets []float64
+}
+
+type metricData struct {
+	kind metricKind
+
+	// heap is set if compute needs the heap statistics.
+	heap bool
+
+	// compute fills in v, whose kind is already set.
+	compute func(a *heapStatsAggregate, v *metricValue)
+}
+
+var (
+	metricsSema  uint32 = 1 // protects the variables below while they are built
+	metricsInit  bool
+	metricsTable map[string]metricData
+
+	// Bucket boundaries of the histograms, shared by all samples.
+	sizeClassBuckets []float64
+	timeHistBuckets  []float64
+)
+
+// initMetrics builds metricsTable. metricsSema must be held.
+func initMetrics() {
+	if metricsInit {
+		return
+	}
+	sizeClassBuckets = make([]float64, 0, _NumSizeClasses+1)
+	sizeClassBuckets = append(sizeClassBuckets, 1)
+	for i := 1; i < _NumSizeClasses; i++ {
+		sizeClassBuckets = append(sizeClassBuckets, float64(class_to_size[i])+1)
+	}
+	sizeClassBuckets = append(sizeClassBuckets, inf)
+	timeHistBuckets = make([]float64, timeHistogramLen+1)
+	for i := 1; i < timeHistogramLen; i++ {
+		timeHistBuckets[i] = float64(int64(1)<<uint(i)) / 1e9
+	}
+	timeHistBuckets[timeHistogramLen] = inf
+
+	u64 := func(f func() uint64) metricData {
+		return metricData{
+			kind: metricKindUint64,
+			compute: func(_ *heapStatsAggregate, v *metricValue) {
+				v.scalar = f()
+			},
+		}
+	}
+	heapU64 := func(f func(a *heapStatsAggregate) uint64) metricData {
+		return metricData{
+			kind: metricKindUint64,
+			heap: true,
+			compute: func(a *heapStatsAggregate, v *metricValue) {
+				v.scalar = f(a)
+			},
+		}
+	}
+	metricsTable = map[string]metricData{
+		"/cgo/go-to-c-calls:calls": u64(func() uint64 {
+			return uint64(NumCgoCall())
+		}),
+		"/gc/cycles/automatic:gc-cycles": u64(func() uint64 {
+			return uint64(atomic.Load(&memstats.numgc) - atomic.Load(&memstats.numforcedgc))
+		}),
+		"/gc/cycles/forced:gc-cycles": u64(func() uint64 {
+			return uint64(atomic.Load(&memstats.numforcedgc))
+		}),
+		"/gc/cycles/total:gc-cycles": u64(func() uint64 {
+			return uint64(atomic.Load(&memstats.numgc))
+		}),
+		"/gc/cpu-fraction:ratio": {
+			kind: metricKindFloat64,
+			compute: func(_ *heapStatsAggregate, v *metricValue) {
+				v.scalar = atomic.Load64((*uint64)(unsafe.Pointer(&memstats.gc_cpu_fraction)))
+			},
+		},
+		"/gc/gomemlimit:bytes": u64(func() uint64 {
+			return atomic.Load64((*uint64)(unsafe.Pointer(&memlimit.limit)))
+		}),
+		"/gc/heap/allocs-by-size:bytes": {
+			kind: metricKindFloat64Histogram,
+			heap: true,
+			compute: func(a *heapStatsAggregate, v *metricValue) {
+				hist := v.float64HistOrInit(sizeClassBuckets)
+				copy(hist.counts, a.smallAllocs[1:])
+				hist.counts[len(hist.counts)-1] = a.largeAllocs
+			},
+		},
+		"/gc/heap/allocs:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.totalAllocBytes
+		}),
+		"/gc/heap/allocs:objects": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.totalAllocs
+		}),
+		"/gc/heap/frees-by-size:bytes": {
+			kind: metricKindFloat64Histogram,
+			heap: true,
+			compute: func(a *heapStatsAggregate, v *metricValue) {
+				hist := v.float64HistOrInit(sizeClassBuckets)
+				copy(hist.counts, a.smallFrees[1:])
+				hist.counts[len(hist.counts)-1] = a.largeFrees
+			},
+		},
+		"/gc/heap/frees:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.totalFreeBytes
+		}),
+		"/gc/heap/frees:objects": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.totalFrees
+		}),
+		"/gc/heap/goal:bytes": u64(func() uint64 {
+			return atomic.Load64(&memstats.next_gc)
+		}),
+		"/gc/heap/marked:bytes": u64(func() uint64 {
+			return atomic.Load64(&memstats.heap_marked)
+		}),
+		"/gc/heap/objects:objects": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.totalAllocs - a.totalFrees
+		}),
+		"/gc/heap/tiny/allocs:objects": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.tinyAllocs
+		}),
+		"/gc/pauses:seconds": {
+			kind: metricKindFloat64Histogram,
+			compute: func(_ *heapStatsAggregate, v *metricValue) {
+				hist := v.float64HistOrInit(timeHistBuckets)
+				gcPauseHist.read(hist.counts)
+			},
+		},
+		"/gc/pauses/total:seconds": {
+			kind: metricKindFloat64,
+			compute: func(_ *heapStatsAggregate, v *metricValue) {
+				v.scalar = float64bits(float64(atomic.Load64(&memstats.pause_total_ns)) / 1e9)
+			},
+		},
+		"/gc/stack/starting-size:bytes": u64(func() uint64 {
+			return uint64(atomic.Load(&startingStackSize))
+		}),
+		"/memory/classes/heap/free:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.heapIdle - a.heapReleased
+		}),
+		"/memory/classes/heap/objects:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.objectBytes()
+		}),
+		"/memory/classes/heap/released:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.heapReleased
+		}),
+		"/memory/classes/heap/stacks:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.stacksInuse
+		}),
+		"/memory/classes/heap/unused:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.heapInuse - a.objectBytes()
+		}),
+		"/memory/classes/metadata/mcache/free:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.mcacheSys - a.mcacheInuse
+		}),
+		"/memory/classes/metadata/mcache/inuse:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.mcacheInuse
+		}),
+		"/memory/classes/metadata/mspan/free:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.mspanSys - a.mspanInuse
+		}),
+		"/memory/classes/metadata/mspan/inuse:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.mspanInuse
+		}),
+		"/memory/classes/metadata/other:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.gcSys
+		}),
+		"/memory/classes/os-stacks:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.stacksSys
+		}),
+		"/memory/classes/other:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.otherSys
+		}),
+		"/memory/classes/profiling/buckets:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.buckhashSys
+		}),
+		"/memory/classes/total:bytes": heapU64(func(a *heapStatsAggregate) uint64 {
+			return a.heapIdle + a.heapInuse + a.stacksInuse + a.stacksSys +
+				a.mspanSys + a.mcacheSys + a.buckhashSys + a.gcSys + a.otherSys
+		}),
+		"/sched/gomaxprocs:threads": u64(func() uint64 {
+			return uint64(gomaxprocs)
+		}),
+		"/sched/goroutines:goroutines": u64(func() uint64 {
+			return uint64(gcount())
+		}),
+		"/sched/threads:threads": u64(func() uint64 {
+			lock(&sched.lock)
+			n := mcount()
+			unlock(&sched.lock)
+			return uint64(n)
+		}),
+	}
+	metricsInit = true
+}
+
+// heapStatsAggregate is a sum of the heap statistics. Allocations and
+// frees are counted in objects, by size class for small objects.
+type heapStatsAggregate struct {
+	smallAllocs [_NumSizeClasses]uint64
+	smallFrees  [_NumSizeClasses]uint64
+	largeAllocs uint64
+	largeFrees  uint64
+	tinyAllocs  uint64
+
+	// Derived from the above. Tiny allocations are counted as both
+	// allocated and freed, as in MemStats.
+	totalAllocs, totalAllocBytes uint64
+	totalFrees, totalFreeBytes   uint64
+
+	heapSys, heapInuse, heapIdle uint64
+	heapReleased                 uint64
+	stacksInuse, stacksSys       uint64
+	mspanInuse, mspanSys         uint64
+	mcacheInuse, mcacheSys       uint64
+	buckhashSys, gcSys, otherSys uint64
+}
+
+// read fills in a without stopping the world.
+func (a *heapStatsAggregate) read() {
+	var largeAllocBytes, largeFreeBytes uint64
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for spc := range mheap_.central {
+			c := &mheap_.central[spc].mcentral
+			a.smallAllocs[spanClass(spc).sizeclass()] += atomic.Load64(&c.nmalloc)
+		}
+		copy(a.smallFrees[:], mheap_.nsmallfree[:])
+		a.largeAllocs = mheap_.nlargealloc
+		largeAllocBytes = mheap_.largealloc
+		a.largeFrees = mheap_.nlargefree
+		largeFreeBytes = mheap_.largefree
+		a.tinyAllocs = memstats.tinyallocs
+
+		// allp cannot change while we hold a lock, since that
+		// keeps the world from stopping.
+		for _, p := range allp {
+			c := p.mcache
+			if c == nil {
+				continue
+			}
+			for _, s := range c.alloc {
+				// s may be replaced meanwhile, but spans are
+				// never freed, so reading it is safe.
+				if s == nil || s == &emptymspan {
+					continue
+				}
+				i := s.spanclass.sizeclass()
+				nelems, allocated := uint64(s.nelems), uint64(s.allocCount)
+				if allocated < nelems && nelems-allocated <= a.smallAllocs[i] {
+					a.smallAllocs[i] -= nelems - allocated
+				}
+			}
+			for i := range c.local_nsmallfree {
+				a.smallFrees[i] += uint64(atomic.Loaduintptr(&c.local_nsmallfree[i]))
+			}
+			a.largeFrees += uint64(atomic.Loaduintptr(&c.local_nlargefree))
+			largeFreeBytes += uint64(atomic.Loaduintptr(&c.local_largefree))
+			a.tinyAllocs += uint64(atomic.Loaduintptr(&c.local_tinyallocs))
+		}
+
+		a.heapSys = memstats.heap_sys
+		a.heapInuse = memstats.heap_inuse
+		a.heapIdle = memstats.heap_idle
+		a.heapReleased = memstats.heap_released
+		a.stacksInuse = memstats.stacks_inuse
+		a.mspanInuse = uint64(mheap_.spanalloc.inuse)
+		a.mcacheInuse = uint64(mheap_.cachealloc.inuse)
+		unlock(&mheap_.lock)
+	})
+	a.stacksSys = atomic.Load64(&memstats.stacks_sys)
+	a.mspanSys = atomic.Load64(&memstats.mspan_sys)
+	a.mcacheSys = atomic.Load64(&memstats.mcache_sys)
+	a.buckhashSys = atomic.Load64(&memstats.buckhash_sys)
+	a.gcSys = atomic.Load64(&memstats.gc_sys)
+	a.otherSys = atomic.Load64(&memstats.other_sys)
+
+	a.totalAllocs = a.largeAllocs + a.tinyAllocs
+	a.totalAllocBytes = largeAllocBytes
+	a.totalFrees = a.largeFrees + a.tinyAllocs
+	a.totalFreeBytes = largeFreeBytes
+	for i := 1; i < _NumSizeClasses; i++ {
+		a.totalAllocs += a.smallAllocs[i]
+		a.totalAllocBytes += a.smallAllocs[i] * uint64(class_to_size[i])
+		a.totalFrees += a.smallFrees[i]
+		a.totalFreeBytes += a.smallFrees[i] * uint64(class_to_size[i])
+	}
+	// Skew can make the frees overtake the allocations.
+	if a.totalFrees > a.totalAllocs {
+		a.totalFrees = a.totalAllocs
+	}
+	if a.totalFreeBytes > a.totalAllocBytes {
+		a.totalFreeBytes = a.totalAllocBytes
+	}
+}
+
+// objectBytes returns the bytes of allocated heap objects, which are
+// never more than the bytes of in-use spans.
+func (a *heapStatsAggregate) objectBytes() uint64 {
+	n := a.totalAllocBytes - a.totalFreeBytes
+	if n > a.heapInuse {
+		n = a.heapInuse
+	}
+	return n
+}
+
+// float64HistOrInit returns the histogram of v, reusing the one in v if
+// there is one, with counts zeroed and buckets set to buckets.
+//
+// The by-size histograms have one bucket per small size class, holding
+// the sizes that round up to it, and one for large objects. The time
+// histograms have the buckets of a timeHistogram, in seconds.
+func (v *metricValue) float64HistOrInit(buckets []float64) *float64Histogram {
+	var hist *float64Histogram
+	if v.kind == metricKindFloat64Histogram && v.pointer != nil {
+		hist = (*float64Histogram)(v.pointer)
+	} else {
+		v.kind = metricKindFloat64Histogram
+		hist = new(float64Histogram)
+		v.pointer = unsafe.Pointer(hist)
+	}
+	n := len(buckets) - 1
+	if cap(hist.counts) < n {
+		hist.counts = make([]uint64, n)
+	}
+	hist.counts = hist.counts[:n]
+	for i := range hist.counts {
+		hist.counts[i] = 0
+	}
+	hist.buckets = buckets
+	return hist
+}
+
+// A timeHistogram counts durations in power-of-two buckets: bucket i
+// counts durations in [2^i, 2^(i+1)) nanoseconds. The first bucket also
+// counts shorter durations and the last one longer durations. It is
+// updated atomically.
+type timeHistogram struct {
+	counts [timeHistogramLen]uint64
+}
+
+const timeHistogramLen = 40
+
+// gcPauseHist counts the total stop-the-world time of each GC cycle, as
+// recorded in memstats.pause_ns.
+var gcPauseHist timeHistogram
+
+func (h *timeHistogram) record(ns int64) {
+	i := 0
+	for ; ns >= 2 && i < timeHistogramLen-1; ns >>= 1 {
+		i++
+	}
+	atomic.Xadd64(&h.counts[i], 1)
+}
+
+func (h *timeHistogram) read(counts []uint64) {
+	for i := range h.counts {
+		counts[i] = atomic.Load64(&h.counts[i])
+	}
+}
+
+// readMetrics fills in the values of samples. Samples of unknown
+// metrics get metricKindBad.
+//
+//go:linkname readMetrics runtime/debug.readMetrics
+func readMetrics(samples []metricSample) {
+	semacquire(&metricsSema)
+	initMetrics()
+	semrelease(&metricsSema)
+
+	var a heapStatsAggregate
+	heapRead := false
+	for i := range samples {
+		s := &samples[i]
+		data, ok := metricsTable[s.name]
+		if !ok {
+			s.value.kind = metricKindBad
+			continue
+		}
+		if data.heap && !heapRead {
+			a.read()
+			heapRead = true
+		}
+		if data.kind != metricKindFloat64Histogram {
+			s.value.kind = data.kind
+			s.value.pointer = nil
+		}
+		data.compute(&a, &s.value)
+	}
+}
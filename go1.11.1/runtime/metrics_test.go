@@ -0,0 +1,184 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"testing"
+)
+
+func readAllMetrics() (map[string]debug.MetricValue, []debug.MetricSample) {
+	descs := debug.AllMetrics()
+	samples := make([]debug.MetricSample, len(descs))
+	for i := range descs {
+		samples[i].Name = descs[i].Name
+	}
+	debug.ReadMetrics(samples)
+	m := make(map[string]debug.MetricValue)
+	for _, s := range samples {
+		m[s.Name] = s.Value
+	}
+	return m, samples
+}
+
+func TestMetricDescriptions(t *testing.T) {
+	descs := debug.AllMetrics()
+	var names []string
+	for i, d := range descs {
+		if i > 0 && descs[i-1].Name >= d.Name {
+			t.Errorf("AllMetrics not sorted: %q before %q", descs[i-1].Name, d.Name)
+		}
+		if d.Description == "" {
+			t.Errorf("%s has no description", d.Name)
+		}
+		names = append(names, d.Name)
+	}
+	runtimeNames := runtime.MetricNames()
+	sort.Strings(runtimeNames)
+	if len(names) != len(runtimeNames) {
+		t.Fatalf("runtime/debug describes %d metrics, the runtime supports %d:\n%q\n%q", len(names), len(runtimeNames), names, runtimeNames)
+	}
+	for i := range names {
+		if names[i] != runtimeNames[i] {
+			t.Errorf("runtime/debug describes %q, the runtime supports %q", names[i], runtimeNames[i])
+		}
+	}
+}
+
+func TestReadMetrics(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	runtime.GC()
+
+	m, samples := readAllMetrics()
+	descs := debug.AllMetrics()
+	for i, s := range samples {
+		if s.Value.Kind() != descs[i].Kind {
+			t.Errorf("%s: kind %d, want %d", s.Name, s.Value.Kind(), descs[i].Kind)
+			continue
+		}
+		if s.Value.Kind() != debug.MetricKindFloat64Histogram {
+			continue
+		}
+		h := s.Value.Float64Histogram()
+		if len(h.Buckets) != len(h.Counts)+1 {
+			t.Errorf("%s: %d buckets for %d counts", s.Name, len(h.Buckets), len(h.Counts))
+		}
+		for j := 1; j < len(h.Buckets); j++ {
+			if h.Buckets[j-1] >= h.Buckets[j] {
+				t.Errorf("%s: buckets not increasing: %v", s.Name, h.Buckets)
+				break
+			}
+		}
+	}
+
+	u := func(name string) uint64 { return m[name].Uint64() }
+	sum := func(name string) uint64 {
+		var n uint64
+		for _, c := range m[name].Float64Histogram().Counts {
+			n += c
+		}
+		return n
+	}
+	if got, want := u("/gc/cycles/automatic:gc-cycles")+u("/gc/cycles/forced:gc-cycles"), u("/gc/cycles/total:gc-cycles"); got != want {
+		t.Errorf("automatic+forced GC cycles = %d, want total %d", got, want)
+	}
+	if got, want := sum("/gc/pauses:seconds"), u("/gc/cycles/total:gc-cycles"); got != want {
+		t.Errorf("GC pauses recorded = %d, want one per cycle, %d", got, want)
+	}
+	if got, want := u("/gc/heap/allocs:objects")-u("/gc/heap/frees:objects"), u("/gc/heap/objects:objects"); got != want {
+		t.Errorf("allocs-frees = %d, want live objects %d", got, want)
+	}
+	if got, want := sum("/gc/heap/allocs-by-size:bytes")+u("/gc/heap/tiny/allocs:objects"), u("/gc/heap/allocs:objects"); got != want {
+		t.Errorf("allocs by size + tiny allocs = %d, want allocs %d", got, want)
+	}
+	var classes uint64
+	for _, d := range descs {
+		if len(d.Name) > len("/memory/classes/") && d.Name[:len("/memory/classes/")] == "/memory/classes/" && d.Name != "/memory/classes/total:bytes" {
+			classes += u(d.Name)
+		}
+	}
+	if total := u("/memory/classes/total:bytes"); classes != total {
+		t.Errorf("memory classes add up to %d, want total %d", classes, total)
+	}
+	if f := m["/gc/cpu-fraction:ratio"].Float64(); f < 0 || f > 1 || math.IsNaN(f) {
+		t.Errorf("GC CPU fraction = %v, want in [0, 1]", f)
+	}
+	if n := u("/sched/goroutines:goroutines"); n == 0 {
+		t.Errorf("no goroutines")
+	}
+	if n := u("/sched/gomaxprocs:threads"); n != uint64(runtime.GOMAXPROCS(-1)) {
+		t.Errorf("gomaxprocs = %d, want %d", n, runtime.GOMAXPROCS(-1))
+	}
+
+	// Allocations are counted by size class as they happen.
+	const n = 1000
+	before := m["/gc/heap/allocs-by-size:bytes"].Float64Histogram().Counts
+	before = append([]uint64(nil), before...)
+	sink := make([]*[100]byte, n)
+	for i := range sink {
+		sink[i] = new([100]byte)
+	}
+	m, _ = readAllMetrics()
+	h := m["/gc/heap/allocs-by-size:bytes"].Float64Histogram()
+	for i, c := range h.Counts {
+		if h.Buckets[i] <= 100 && 100 < h.Buckets[i+1] {
+			if c-before[i] < n {
+				t.Errorf("%d allocations in the 100-byte size class, want at least %d", c-before[i], n)
+			}
+		}
+	}
+	runtime.KeepAlive(sink)
+}
+
+func TestReadMetricsCumulative(t *testing.T) {
+	_, first := readAllMetrics()
+	var sink [][]byte
+	for i := 0; i < 100; i++ {
+		sink = append(sink, make([]byte, 64<<10))
+	}
+	runtime.GC()
+	runtime.KeepAlive(sink)
+	_, second := readAllMetrics()
+	for i, d := range debug.AllMetrics() {
+		if !d.Cumulative {
+			continue
+		}
+		a, b := first[i].Value, second[i].Value
+		switch d.Kind {
+		case debug.MetricKindUint64:
+			if b.Uint64() < a.Uint64() {
+				t.Errorf("%s decreased from %d to %d", d.Name, a.Uint64(), b.Uint64())
+			}
+		case debug.MetricKindFloat64:
+			if b.Float64() < a.Float64() {
+				t.Errorf("%s decreased from %v to %v", d.Name, a.Float64(), b.Float64())
+			}
+		case debug.MetricKindFloat64Histogram:
+			ha, hb := a.Float64Histogram(), b.Float64Histogram()
+			for j := range ha.Counts {
+				if hb.Counts[j] < ha.Counts[j] {
+					t.Errorf("%s: bucket %d decreased from %d to %d", d.Name, j, ha.Counts[j], hb.Counts[j])
+				}
+			}
+		}
+	}
+}
+
+func TestReadMetricsUnknown(t *testing.T) {
+	samples := []debug.MetricSample{
+		{Name: "/not/a/metric:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+	}
+	debug.ReadMetrics(samples)
+	if k := samples[0].Value.Kind(); k != debug.MetricKindBad {
+		t.Errorf("unknown metric has kind %d, want MetricKindBad", k)
+	}
+	if k := samples[1].Value.Kind(); k != debug.MetricKindUint64 {
+		t.Errorf("known metric has kind %d, want MetricKindUint64", k)
+	}
+}
@@ -87,28 +87,7 @@ func queuefinalizer(p unsafe.Pointer, fn *funcval, nret uintptr, fint *_type, ot
 
 	lock(&finlock)
 	if finq == nil || finq.cnt == uint32(len(finq.fin)) {
-		if finc == nil {
-			finc = (*finblock)(persistentalloc(_FinBlockSize, 0, &memstats.gc_sys))
-			finc.alllink = allfin
-			allfin = finc
-			if finptrmask[0] == 0 {
-				// Build pointer mask for Finalizer array in block.
-				// Check assumptions made in finalizer1 array above.
-				if (unsafe.Sizeof(finalizer{}) != 5*sys.PtrSize ||
-					unsafe.Offsetof(finalizer{}.fn) != 0 ||
-					unsafe.Offsetof(finalizer{}.arg) != sys.PtrSize ||
-					unsafe.Offsetof(finalizer{}.nret) != 2*sys.PtrSize ||
-					unsafe.Offsetof(finalizer{}.fint) != 3*sys.PtrSize ||
-					unsafe.Offsetof(finalizer{}.ot) != 4*sys.PtrSize) {
-					throw("finalizer out of sync")
-				}
-				for i := range finptrmask {
-					finptrmask[i] = finalizer1[i%len(finalizer1)]
-				}
-			}
-		}
-		block := finc
-		finc = block.next
+		block := getfinblock()
 		block.next = finq
 		finq = block
 	}
@@ -120,9 +99,41 @@ func queuefinalizer(p unsafe.Pointer, fn *funcval, nret uintptr, fint *_type, ot
 	f.ot = ot
 	f.arg = p
 	fingwake = true
+	atomic.Xadd64(&finstats.queued, 1)
 	unlock(&finlock)
 }
 
+// getfinblock returns an empty finblock from the cache, allocating one
+// if it is empty. finlock must be held.
+func getfinblock() *finblock {
+	if finc == nil {
+		finc = (*finblock)(persistentalloc(_FinBlockSize, 0, &memstats.gc_sys))
+		finc.alllink = allfin
+		allfin = finc
+		if finptrmask[0] == 0 {
+			// Build pointer mask for Finalizer array in block.
+			// Check assumptions made in finalizer1 array above.
+			if (unsafe.Sizeof(finalizer{}) != 5*sys.PtrSize ||
+				unsafe.Offsetof(finalizer{}.fn) != 0 ||
+				unsafe.Offsetof(finalizer{}.arg) != sys.PtrSize ||
+				unsafe.Offsetof(finalizer{}.nret) != 2*sys.PtrSize ||
+				unsafe.Offsetof(finalizer{}.fint) != 3*sys.PtrSize ||
+				unsafe.Offsetof(finalizer{}.ot) != 4*sys.PtrSize) {
+				throw("finalizer out of sync")
+			}
+			for i := range finptrmask {
+				finptrmask[i] = finalizer1[i%len(finalizer1)]
+			}
+		}
+	}
+	block := finc
+	finc = block.next
+	return block
+}
+
+// iterate_finq calls callback for each queued finalizer, and for each
+// queued cleanup, with only fn set.
+//
 //go:nowritebarrier
 func iterate_finq(callback func(*funcval, unsafe.Pointer, uintptr, *_type, *ptrtype)) {
 	for fb := allfin; fb != nil; fb = fb.alllink {
@@ -164,6 +175,11 @@ func runfinq() {
 		framecap uintptr
 	)
 
+	// Set fing before running any finalizer, for finWarnCheck.
+	lock(&finlock)
+	fing = getg()
+	unlock(&finlock)
+
 	for {
 		lock(&finlock)
 		fb := finq
@@ -219,7 +235,9 @@ func runfinq() {
 					throw("bad kind in runfinq")
 				}
 				fingRunning = true
+				finStatsStart(f.fn)
 				reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
+				finStatsDone()
 				fingRunning = false
 
 				// Drop finalizer queue heap references
@@ -305,7 +323,7 @@ func runfinq() {
 //
 // A single goroutine runs all finalizers for a program, sequentially.
 // If a finalizer must run for a long time, it should do so by starting
-// a new goroutine.
+// a new goroutine. See AddCleanup for a more efficient alternative.
 func SetFinalizer(obj interface{}, finalizer interface{}) {
 	if debug.sbrk != 0 {
 		// debug.sbrk never frees memory, so no finalizers run
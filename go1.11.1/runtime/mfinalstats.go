@@ -0,0 +1,165 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Finalizer queue statistics and the slow finalizer watchdog.
+//
+// queuefinalizer and queuecleanup count the finalizers and cleanups
+// queued, and runfinq and the cleanup workers count those run, so the
+// length of each queue is the difference. runfinq also records when
+// the running finalizer started and the longest run so far. These are
+// read by runtime/debug.ReadFinalizerStats.
+//
+// Since a single goroutine runs all finalizers, one that blocks holds
+// up the whole queue. With a warning threshold set (GODEBUG=finwarn=N
+// in milliseconds, or runtime/debug.SetFinalizerWarning), sysmon looks
+// for a finalizer that has been running for longer than the threshold
+// and prints its function and the stack of the finalizer goroutine,
+// once per finalizer. A stack can only be printed while the goroutine
+// is stopped, so if it is running sysmon asks it to yield and prints
+// the stack at a later check. A finalizer looping without function
+// calls never yields, so if it is still running at the next check the
+// warning is printed without the stack.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// The 64-bit fields of finstats come first, to keep them aligned for
+// atomic access on 32-bit systems.
+var finstats struct {
+	queued         uint64 // finalizers queued
+	run            uint64 // finalizers run
+	cleanupsQueued uint64 // cleanups queued
+	cleanupsRun    uint64 // cleanups run
+
+	warnNS   int64 // warning threshold; 0 disables warnings
+	lastwarn int64 // last sysmon check, only used by sysmon
+	warned   int64 // start of the finalizer last warned about, only used by sysmon
+	asked    int64 // start of the finalizer last asked to yield, only used by sysmon
+
+	// Written by runfinq only.
+	start int64   // nanotime the running finalizer started, 0 if none
+	maxNS uint64  // longest finalizer run
+	fn    uintptr // entry PC of the running finalizer
+	maxFn uintptr // entry PC of the longest running finalizer
+}
+
+// finStatsInit applies the GODEBUG settings. Called from schedinit
+// after parsedebugvars.
+func finStatsInit() {
+	if debug.finwarn > 0 {
+		setFinalizerWarning(int64(debug.finwarn) * 1e6)
+	}
+}
+
+//go:linkname setFinalizerWarning runtime/debug.setFinalizerWarning
+func setFinalizerWarning(ns int64) (old int64) {
+	if ns < 0 {
+		ns = 0
+	}
+	return int64(atomic.Xchg64((*uint64)(unsafe.Pointer(&finstats.warnNS)), uint64(ns)))
+}
+
+// finStatsStart records that runfinq is about to call the finalizer fn.
+func finStatsStart(fn *funcval) {
+	atomic.Storeuintptr(&finstats.fn, fn.fn)
+	atomic.Store64((*uint64)(unsafe.Pointer(&finstats.start)), uint64(nanotime()))
+}
+
+// finStatsDone records that the running finalizer has returned.
+func finStatsDone() {
+	dur := uint64(nanotime() - finstats.start)
+	fn := finstats.fn
+	atomic.Store64((*uint64)(unsafe.Pointer(&finstats.start)), 0)
+	if dur > finstats.maxNS {
+		atomic.Storeuintptr(&finstats.maxFn, fn)
+		atomic.Store64(&finstats.maxNS, dur)
+	}
+	atomic.Xadd64(&finstats.run, 1)
+}
+
+// finWarnCheck prints a warning if the running finalizer has been
+// running for longer than the warning threshold. It is called by
+// sysmon.
+func finWarnCheck(now int64) {
+	threshold := int64(atomic.Load64((*uint64)(unsafe.Pointer(&finstats.warnNS))))
+	if threshold == 0 {
+		return
+	}
+	if !sysmonWarnDue(now, threshold, &finstats.lastwarn) {
+		return
+	}
+
+	start := int64(atomic.Load64((*uint64)(unsafe.Pointer(&finstats.start))))
+	if start == 0 || start == finstats.warned || now-start < threshold {
+		return
+	}
+	gp := fing
+	if gp == nil {
+		return
+	}
+	s := readgstatus(gp)
+	switch s {
+	case _Grunning:
+		if finstats.asked != start {
+			// Ask it to stop at its next function call, and
+			// try again next time.
+			finstats.asked = start
+			gp.preempt = true
+			gp.stackguard0 = stackPreempt
+			return
+		}
+		// Still running. It may be looping without calls and
+		// never stop, so don't wait for the stack.
+		finstats.warned = start
+		printlock()
+		finWarn(now, start)
+		print("stack unavailable: finalizer goroutine is running\n")
+		printunlock()
+	case _Grunnable, _Gwaiting, _Gsyscall:
+		// If someone else holds the scan bit, try again next time.
+		sysmonWarnStack(gp, s, func() bool {
+			// The finalizer may have returned meanwhile.
+			if int64(atomic.Load64((*uint64)(unsafe.Pointer(&finstats.start)))) != start {
+				return false
+			}
+			finstats.warned = start
+			finWarn(now, start)
+			return true
+		})
+	}
+}
+
+// finWarn prints the warning about the finalizer that started at start.
+func finWarn(now, start int64) {
+	print("runtime: finalizer ")
+	if f := findfunc(atomic.Loaduintptr(&finstats.fn)); f.valid() {
+		print(funcname(f))
+	} else {
+		print("?")
+	}
+	print(" running for ", (now-start)/1e6, "ms, ", atomic.Load64(&finstats.queued)-atomic.Load64(&finstats.run), " finalizers queued\n")
+}
+
+//go:linkname readFinalizerStats runtime/debug.readFinalizerStats
+func readFinalizerStats(stats *[8]uint64) {
+	// The counters are read one by one, so they may be slightly
+	// inconsistent. Read the run counts first, so a queue is never
+	// seen shorter than empty.
+	stats[1] = atomic.Load64(&finstats.run)
+	stats[0] = atomic.Load64(&finstats.queued)
+	stats[5] = atomic.Load64(&finstats.cleanupsRun)
+	stats[4] = atomic.Load64(&finstats.cleanupsQueued)
+	stats[2] = atomic.Load64(&finstats.maxNS)
+	stats[3] = uint64(atomic.Loaduintptr(&finstats.maxFn))
+	if start := int64(atomic.Load64((*uint64)(unsafe.Pointer(&finstats.start)))); start != 0 {
+		stats[6] = uint64(nanotime() - start)
+		stats[7] = uint64(atomic.Loaduintptr(&finstats.fn))
+	} else {
+		stats[6], stats[7] = 0, 0
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type finStatsObj struct {
+	p *int
+}
+
+func slowFinalizer(release chan struct{}, started chan bool) func(*finStatsObj) {
+	return func(*finStatsObj) {
+		started <- true
+		<-release
+	}
+}
+
+func TestFinalizerStats(t *testing.T) {
+	var before debug.FinalizerStats
+	debug.ReadFinalizerStats(&before)
+
+	release := make(chan struct{})
+	started := make(chan bool, 1)
+	runtime.SetFinalizer(&finStatsObj{}, slowFinalizer(release, started))
+	done := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		runtime.SetFinalizer(&finStatsObj{}, func(*finStatsObj) { done <- true })
+	}
+	runtime.GC()
+
+	// Wait for the slow finalizer. It may not run first.
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("finalizer did not run")
+	}
+	time.Sleep(10 * time.Millisecond)
+	var s debug.FinalizerStats
+	debug.ReadFinalizerStats(&s)
+	if s.Queued-before.Queued != 11 {
+		t.Errorf("%d finalizers queued, want 11", s.Queued-before.Queued)
+	}
+	if s.Pending() == 0 {
+		t.Errorf("no finalizers pending while one is blocked")
+	}
+	if s.Running < 10*time.Millisecond || !strings.Contains(s.RunningFunc, "slowFinalizer") {
+		t.Errorf("running finalizer %q for %v, want slowFinalizer for at least 10ms", s.RunningFunc, s.Running)
+	}
+
+	close(release)
+	for i := 0; i < 10; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("finalizers did not run")
+		}
+	}
+	// The last finalizer may still be returning.
+	for i := 0; ; i++ {
+		debug.ReadFinalizerStats(&s)
+		if s.Run-before.Run == 11 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("%d finalizers run, want 11", s.Run-before.Run)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Longest < 10*time.Millisecond {
+		t.Errorf("longest finalizer ran for %v, want at least 10ms", s.Longest)
+	}
+}
+
+func TestFinalizerWarningHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	release := make(chan struct{})
+	started := make(chan bool, 1)
+	runtime.SetFinalizer(&finStatsObj{}, slowFinalizer(release, started))
+	runtime.GC()
+	<-started
+	time.Sleep(300 * time.Millisecond)
+	close(release)
+}
+
+func TestFinalizerWarning(t *testing.T) {
+	out, err := runHelper(t, "TestFinalizerWarningHelper", "GODEBUG=finwarn=50")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+	// The warning names the finalizer and prints its stack.
+	if !strings.Contains(out, "runtime: finalizer runtime_test.slowFinalizer.func1 running for") ||
+		!strings.Contains(out, "runtime_test.slowFinalizer.func1(") {
+		t.Errorf("no warning with a stack for the blocked finalizer:\n%s", out)
+	}
+}
+
+func TestFinalizerWarningRunningHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	runtime.GOMAXPROCS(2)
+	var stop int32
+	started := make(chan bool, 1)
+	runtime.SetFinalizer(&finStatsObj{}, func(*finStatsObj) {
+		started <- true
+		// No calls, so no preemption.
+		for atomic.LoadInt32(&stop) == 0 {
+		}
+	})
+	runtime.GC()
+	<-started
+	time.Sleep(300 * time.Millisecond)
+	atomic.StoreInt32(&stop, 1)
+}
+
+func TestFinalizerWarningRunning(t *testing.T) {
+	out, err := runHelper(t, "TestFinalizerWarningRunningHelper", "GODEBUG=finwarn=50")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "runtime: finalizer runtime_test.TestFinalizerWarningRunningHelper.func1 running for") ||
+		!strings.Contains(out, "stack unavailable") {
+		t.Errorf("no warning for the running finalizer:\n%s", out)
+	}
+}
@@ -181,8 +181,9 @@ func gcinit() {
 	// This will go into computing the initial GC goal.
 	memstats.heap_marked = uint64(float64(heapminimum) / (1 + memstats.triggerRatio))
 
-	// Set gcpercent from the environment. This will also compute
-	// and set the GC trigger and goal.
+	// Set the memory limit and gcpercent from the environment.
+	// The latter will also compute and set the GC trigger and goal.
+	memoryLimitInit()
 	_ = setGCPercent(readgogc())
 
 	work.startSema = 1
@@ -202,10 +203,13 @@ func readgogc() int32 {
 
 // gcenable is called after the bulk of the runtime initialization,
 // just before we're about to start letting user code run.
-// It kicks off the background sweeper goroutine and enables GC.
+// It kicks off the background sweeper and scavenger goroutines and
+// enables GC.
 func gcenable() {
 	c := make(chan int, 1)
 	go bgsweep(c)
+	go bgscavenge(c)
+	<-c
 	<-c
 	memstats.enablegc = true // now that runtime is initialized, GC is okay
 }
@@ -275,7 +279,7 @@ const (
 //go:nosplit
 func setGCPhase(x uint32) {
 	atomic.Store(&gcphase, x)
-	writeBarrier.needed = gcphase == _GCmark || gcphase == _GCmarktermination
+	writeBarrier.needed = gcphase == _GCmark || gcphase == _GCmarktermination || gcgen.enabled
 	writeBarrier.enabled = writeBarrier.needed || writeBarrier.cgo
 }
 
@@ -429,10 +433,11 @@ func (c *gcControllerState) startCycle() {
 
 	// Re-compute the heap goal for this cycle in case something
 	// changed. This is the same calculation we use elsewhere.
-	memstats.next_gc = memstats.heap_marked + memstats.heap_marked*uint64(gcpercent)/100
+	memstats.next_gc = memstats.heap_marked + memstats.heap_marked*uint64(gcGoalPercent())/100
 	if gcpercent < 0 {
 		memstats.next_gc = ^uint64(0)
 	}
+	memstats.next_gc, memlimit.limited = memoryLimitGoal(memstats.next_gc)
 
 	// Ensure that the heap goal is at least a little larger than
 	// the current live heap size. This may not be the case if GC
@@ -471,6 +476,7 @@ func (c *gcControllerState) startCycle() {
 	// Clear per-P state
 	for _, p := range allp {
 		p.gcAssistTime = 0
+		p.gcAssistTimeCycle = 0
 		p.gcFractionalMarkTime = 0
 	}
 
@@ -478,13 +484,16 @@ func (c *gcControllerState) startCycle() {
 	// throughout the cycle.
 	c.revise()
 
-	if debug.gcpacertrace > 0 {
-		print("pacer: assist ratio=", c.assistWorkPerByte,
-			" (scan ", memstats.heap_scan>>20, " MB in ",
-			work.initialHeapLive>>20, "->",
-			memstats.next_gc>>20, " MB)",
-			" workers=", c.dedicatedMarkWorkersNeeded,
-			"+", c.fractionalUtilizationGoal, "\n")
+	if pacerTracing() {
+		pacerRecord(&pacerEvent{
+			kind:              pacerEvStart,
+			heapLive:          work.initialHeapLive,
+			heapGoal:          memstats.next_gc,
+			heapScan:          memstats.heap_scan,
+			assistWorkPerByte: c.assistWorkPerByte,
+			dedicatedWorkers:  c.dedicatedMarkWorkersNeeded,
+			fractionalGoal:    c.fractionalUtilizationGoal,
+		})
 	}
 }
 
@@ -497,12 +506,17 @@ func (c *gcControllerState) startCycle() {
 // is when assists are enabled and the necessary statistics are
 // available).
 func (c *gcControllerState) revise() {
-	gcpercent := gcpercent
+	gcpercent := gcGoalPercent()
 	if gcpercent < 0 {
 		// If GC is disabled but we're running a forced GC,
 		// act like GOGC is huge for the below calculations.
 		gcpercent = 100000
 	}
+	if memlimit.limited && memstats.heap_marked > 0 {
+		// The memory limit lowered the goal. Use the
+		// growth it allows instead of GOGC.
+		gcpercent = int32((float64(memstats.next_gc)/float64(memstats.heap_marked) - 1) * 100)
+	}
 	live := atomic.Load64(&memstats.heap_live)
 
 	var heapGoal, scanWorkExpected int64
@@ -564,6 +578,18 @@ func (c *gcControllerState) revise() {
 	// have done (or stolen) the remaining amount of scan work.
 	c.assistWorkPerByte = float64(scanWorkRemaining) / float64(heapRemaining)
 	c.assistBytesPerWork = float64(heapRemaining) / float64(scanWorkRemaining)
+
+	if pacerTracing() && pacerReviseDue() {
+		pacerRecord(&pacerEvent{
+			kind:              pacerEvRevise,
+			heapLive:          live,
+			heapGoal:          uint64(heapGoal),
+			heapScan:          memstats.heap_scan,
+			scanWork:          c.scanWork,
+			scanWorkExpected:  scanWorkExpected,
+			assistWorkPerByte: c.assistWorkPerByte,
+		})
+	}
 }
 
 // endCycle computes the trigger ratio for the next cycle.
@@ -575,6 +601,11 @@ func (c *gcControllerState) endCycle() float64 {
 		// Just leave it where it is.
 		return memstats.triggerRatio
 	}
+	if gcgen.minor {
+		// Minor cycles are paced for a fraction of the heap
+		// growth. Only learn the trigger from major cycles.
+		return memstats.triggerRatio
+	}
 
 	// Proportional response gain for the trigger controller. Must
 	// be in [0, 1]. Lower values smooth out transient effects but
@@ -593,6 +624,10 @@ func (c *gcControllerState) endCycle() float64 {
 	// difference between this estimate and the GOGC-based goal
 	// heap growth is the error.
 	goalGrowthRatio := float64(gcpercent) / 100
+	if memlimit.limited {
+		// The memory limit lowered the goal.
+		goalGrowthRatio = float64(memstats.next_gc)/float64(memstats.heap_marked) - 1
+	}
 	actualGrowthRatio := float64(memstats.heap_live)/float64(memstats.heap_marked) - 1
 	assistDuration := nanotime() - c.markStartTime
 
@@ -609,29 +644,20 @@ func (c *gcControllerState) endCycle() float64 {
 	// damped by the proportional gain.
 	triggerRatio := memstats.triggerRatio + triggerGain*triggerError
 
-	if debug.gcpacertrace > 0 {
-		// Print controller state in terms of the design
-		// document.
-		H_m_prev := memstats.heap_marked
-		h_t := memstats.triggerRatio
-		H_T := memstats.gc_trigger
-		h_a := actualGrowthRatio
-		H_a := memstats.heap_live
-		h_g := goalGrowthRatio
-		H_g := int64(float64(H_m_prev) * (1 + h_g))
-		u_a := utilization
-		u_g := gcGoalUtilization
-		W_a := c.scanWork
-		print("pacer: H_m_prev=", H_m_prev,
-			" h_t=", h_t, " H_T=", H_T,
-			" h_a=", h_a, " H_a=", H_a,
-			" h_g=", h_g, " H_g=", H_g,
-			" u_a=", u_a, " u_g=", u_g,
-			" W_a=", W_a,
-			" goalΔ=", goalGrowthRatio-h_t,
-			" actualΔ=", h_a-h_t,
-			" u_a/u_g=", u_a/u_g,
-			"\n")
+	if pacerTracing() {
+		pacerRecord(&pacerEvent{
+			kind:             pacerEvEndCycle,
+			heapLive:         memstats.heap_live,
+			heapGoal:         memstats.next_gc,
+			scanWork:         c.scanWork,
+			heapMarked:       memstats.heap_marked,
+			trigger:          memstats.gc_trigger,
+			triggerRatio:     memstats.triggerRatio,
+			goalGrowth:       goalGrowthRatio,
+			actualGrowth:     actualGrowthRatio,
+			utilization:      utilization,
+			nextTriggerRatio: triggerRatio,
+		})
 	}
 
 	return triggerRatio
@@ -767,8 +793,8 @@ func pollFractionalWorkerExit() bool {
 // This can be called any time. If GC is the in the middle of a
 // concurrent phase, it will adjust the pacing of that phase.
 //
-// This depends on gcpercent, memstats.heap_marked, and
-// memstats.heap_live. These must be up to date.
+// This depends on gcpercent, the memory limit, memstats.heap_marked,
+// and memstats.heap_live. These must be up to date.
 //
 // mheap_.lock must be held or the world must be stopped.
 func gcSetTriggerRatio(triggerRatio float64) {
@@ -792,8 +818,15 @@ func gcSetTriggerRatio(triggerRatio float64) {
 	// We trigger the next GC cycle when the allocated heap has
 	// grown by the trigger ratio over the marked heap size.
 	trigger := ^uint64(0)
+	goalPercent := gcGoalPercent()
 	if gcpercent >= 0 {
-		trigger = uint64(float64(memstats.heap_marked) * (1 + triggerRatio))
+		// Minor cycles of the generational mode trigger after
+		// the same fraction of their smaller heap growth.
+		ratio := triggerRatio
+		if goalPercent != gcpercent {
+			ratio *= float64(goalPercent) / float64(gcpercent)
+		}
+		trigger = uint64(float64(memstats.heap_marked) * (1 + ratio))
 		// Don't trigger below the minimum heap size.
 		minTrigger := heapminimum
 		if !gosweepdone() {
@@ -815,14 +848,13 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			throw("gc_trigger underflow")
 		}
 	}
-	memstats.gc_trigger = trigger
 
 	// Compute the next GC goal, which is when the allocated heap
 	// has grown by GOGC/100 over the heap marked by the last
 	// cycle.
 	goal := ^uint64(0)
 	if gcpercent >= 0 {
-		goal = memstats.heap_marked + memstats.heap_marked*uint64(gcpercent)/100
+		goal = memstats.heap_marked + memstats.heap_marked*uint64(goalPercent)/100
 		if goal < trigger {
 			// The trigger ratio is always less than GOGC/100, but
 			// other bounds on the trigger may have raised it.
@@ -830,6 +862,17 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			goal = trigger
 		}
 	}
+
+	// Lower the goal, and the trigger with it, if the heap would
+	// not fit under the memory limit.
+	goal, memlimit.limited = memoryLimitGoal(goal)
+	if memlimit.limited {
+		max := memstats.heap_marked + uint64(float64(goal-memstats.heap_marked)*memoryLimitTriggerFraction)
+		if trigger > max {
+			trigger = max
+		}
+	}
+	memstats.gc_trigger = trigger
 	memstats.next_gc = goal
 	if trace.enabled {
 		traceNextGC()
@@ -960,8 +1003,8 @@ var work struct {
 	helperDrainBlock bool
 
 	// Number of roots of various root types. Set by gcMarkRootPrepare.
-	nFlushCacheRoots                               int
-	nDataRoots, nBSSRoots, nSpanRoots, nStackRoots int
+	nFlushCacheRoots                                           int
+	nDataRoots, nBSSRoots, nSpanRoots, nCardRoots, nStackRoots int
 
 	// markrootDone indicates that roots have been marked at least
 	// once during the current GC cycle. This is checked by root
@@ -1286,6 +1329,7 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 	}
 
 	gcResetMarkState()
+	gcStatsPrealloc()
 
 	work.stwprocs, work.maxprocs = gomaxprocs, gomaxprocs
 	if work.stwprocs > ncpu {
@@ -1308,6 +1352,7 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 	systemstack(func() {
 		finishsweep_m()
 	})
+	gcGenStartCycle(mode)
 	// clearpools before we start the GC. If we wait they memory will not be
 	// reclaimed until the next GC cycle.
 	clearpools()
@@ -1537,6 +1582,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 
 	systemstack(func() {
 		work.heap2 = work.bytesMarked
+		gcComputeStartingStackSize()
 		if debug.gccheckmark > 0 {
 			// Run a full stop-the-world mark using checkmark bits,
 			// to check that we didn't forget to mark anything during
@@ -1584,7 +1630,9 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	}
 
 	// Update GC trigger and pacing for the next cycle.
+	gcGenEndCycle()
 	gcSetTriggerRatio(nextTriggerRatio)
+	gcPaceScavenger()
 
 	// Update timing memstats
 	now := nanotime()
@@ -1592,11 +1640,13 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	unixNow := sec*1e9 + int64(nsec)
 	work.pauseNS += now - work.pauseStart
 	work.tEnd = now
+	gcPauseSeqBegin()
 	atomic.Store64(&memstats.last_gc_unix, uint64(unixNow)) // must be Unix time to make sense to user
 	atomic.Store64(&memstats.last_gc_nanotime, uint64(now)) // monotonic time for us
 	memstats.pause_ns[memstats.numgc%uint32(len(memstats.pause_ns))] = uint64(work.pauseNS)
 	memstats.pause_end[memstats.numgc%uint32(len(memstats.pause_end))] = uint64(unixNow)
 	memstats.pause_total_ns += uint64(work.pauseNS)
+	gcPauseHist.record(work.pauseNS)
 
 	// Update work.totaltime.
 	sweepTermCpu := int64(work.stwprocs) * (work.tMark - work.tSweepTerm)
@@ -1606,6 +1656,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	markTermCpu := int64(work.stwprocs) * (work.tEnd - work.tMarkTerm)
 	cycleCpu := sweepTermCpu + markCpu + markTermCpu
 	work.totaltime += cycleCpu
+	memoryLimitEndCycle(cycleCpu, now)
 
 	// Compute overall GC CPU utilization.
 	totalCpu := sched.totaltime + (now-sched.procresizetime)*int64(gomaxprocs)
@@ -1625,6 +1676,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	injectglist(work.sweepWaiters.head.ptr())
 	work.sweepWaiters.head = 0
 	unlock(&work.sweepWaiters.lock)
+	gcPauseSeqEnd()
 
 	// Finish the current heap profiling cycle and start a new
 	// heap profiling cycle. We do this before starting the world
@@ -1645,44 +1697,12 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	// Free stack spans. This must be done between GC cycles.
 	systemstack(freeStackSpans)
 
-	// Print gctrace before dropping worldsema. As soon as we drop
-	// worldsema another cycle could start and smash the stats
-	// we're trying to print.
+	// Record the cycle and print gctrace before dropping
+	// worldsema. As soon as we drop worldsema another cycle could
+	// start and smash the stats we're recording.
+	stats := gcRecordCycle(unixNow, sweepTermCpu, markTermCpu, nextTriggerRatio)
 	if debug.gctrace > 0 {
-		util := int(memstats.gc_cpu_fraction * 100)
-
-		var sbuf [24]byte
-		printlock()
-		print("gc ", memstats.numgc,
-			" @", string(itoaDiv(sbuf[:], uint64(work.tSweepTerm-runtimeInitTime)/1e6, 3)), "s ",
-			util, "%: ")
-		prev := work.tSweepTerm
-		for i, ns := range []int64{work.tMark, work.tMarkTerm, work.tEnd} {
-			if i != 0 {
-				print("+")
-			}
-			print(string(fmtNSAsMS(sbuf[:], uint64(ns-prev))))
-			prev = ns
-		}
-		print(" ms clock, ")
-		for i, ns := range []int64{sweepTermCpu, gcController.assistTime, gcController.dedicatedMarkTime + gcController.fractionalMarkTime, gcController.idleMarkTime, markTermCpu} {
-			if i == 2 || i == 3 {
-				// Separate mark time components with /.
-				print("/")
-			} else if i != 0 {
-				print("+")
-			}
-			print(string(fmtNSAsMS(sbuf[:], uint64(ns))))
-		}
-		print(" ms cpu, ",
-			work.heap0>>20, "->", work.heap1>>20, "->", work.heap2>>20, " MB, ",
-			work.heapGoal>>20, " MB goal, ",
-			work.maxprocs, " P")
-		if work.userForced {
-			print(" (forced)")
-		}
-		print("\n")
-		printunlock()
+		printGCCycle(stats)
 	}
 
 	semrelease(&worldsema)
@@ -1947,7 +1967,7 @@ func gcMark(start_time int64) {
 	work.ndone = 0
 	work.nproc = uint32(gcprocs())
 
-	if work.full == 0 && work.nDataRoots+work.nBSSRoots+work.nSpanRoots+work.nStackRoots == 0 {
+	if work.full == 0 && work.nDataRoots+work.nBSSRoots+work.nSpanRoots+work.nCardRoots+work.nStackRoots == 0 {
 		// There's no work on the work queue and no root jobs
 		// that can produce work, so don't bother entering the
 		// getfull() barrier.
@@ -2015,6 +2035,12 @@ func gcMark(start_time int64) {
 
 	cachestats()
 
+	// A minor cycle only marked the young generation. The sweeper
+	// keeps all of the old generation.
+	if gcgen.minor {
+		work.bytesMarked += gcgen.oldBytes
+	}
+
 	// Update the marked heap stat.
 	memstats.heap_marked = work.bytesMarked
 
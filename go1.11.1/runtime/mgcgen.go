@@ -0,0 +1,242 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Generational mode (experimental).
+//
+// With GODEBUG=gcgen=1, most GC cycles are minor cycles, which mark
+// only the young generation: the objects allocated since the previous
+// cycle. Old objects, those that survived the previous cycle, are
+// neither marked nor scanned, and the sweeper keeps them. This suits
+// programs with a large, mostly unchanging heap, such as a cache, and a
+// high rate of short-lived allocation.
+//
+// The collector does not move objects, so the generations are defined
+// by the allocation bitmaps: after a span is swept, its allocBits are
+// the objects that survived the cycle, and an object allocated since
+// then is one whose allocBits bit is clear. No per-object state is
+// needed.
+//
+// Since old objects are not scanned, a minor cycle must find young
+// objects that are only reachable through old ones some other way. In
+// generational mode write barriers stay on between cycles, and
+// wbBufFlush1 marks the card of every heap pointer in the write
+// barrier buffer in a card table kept per heap arena. A minor cycle
+// treats the young objects in dirty cards as roots (markrootCards).
+// Cards are indexed by the target of a write rather than its slot,
+// because the write barrier buffer does not record slots. Any pointer
+// from an old object to a young one was written by a barriered store
+// after the young object was allocated, so its card is dirty. Writes
+// during a cycle shade their targets as usual.
+//
+// Minor cycles are paced for a fraction, 1/gcGenMinorDivisor, of the
+// heap growth GOGC allows, so they run more often than full cycles. A
+// full (major) cycle runs after gcGenMaxMinor minor cycles, once the
+// old generation has grown by GOGC percent since the last major cycle,
+// and whenever the GC is forced or runs with the world stopped. Dead
+// old objects are only freed by major cycles.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+const (
+	// gcCardShift is the log2 of the size of a card.
+	gcCardShift = 9
+	gcCardBytes = 1 << gcCardShift
+
+	// heapArenaCards is the number of cards in a heap arena.
+	heapArenaCards = heapArenaBytes / gcCardBytes
+
+	// gcGenMinorDivisor divides the heap growth GOGC allows for
+	// the heap growth a minor cycle is paced for.
+	gcGenMinorDivisor = 4
+
+	// gcGenMaxMinor is the number of minor cycles after which the
+	// next cycle is a major one.
+	gcGenMaxMinor = 8
+)
+
+var gcgen struct {
+	// enabled is set by gcGenInit if generational mode is on.
+	enabled bool
+
+	// minor is set if the current cycle, or the previous one
+	// between cycles, is minor. It tells the sweeper to keep old
+	// objects. Set by gcStart after the previous sweep finished.
+	minor bool
+
+	// nextMinor is set if the next cycle, or the current one once
+	// it has started, is minor. The pacer uses it.
+	nextMinor bool
+
+	// The following are updated by gcGenEndCycle with the world
+	// stopped.
+	nminor      int    // minor cycles since the last major cycle
+	oldBytes    uint64 // bytes retained by the last cycle
+	majorMarked uint64 // heap marked by the last major cycle
+}
+
+// gcGenInit turns generational mode on if GODEBUG=gcgen=1. It is
+// called by schedinit after procresize, because write barriers need a
+// P.
+func gcGenInit() {
+	if debug.gcgen == 0 {
+		return
+	}
+	if debug.gccheckmark > 0 || debug.gctrace > 1 {
+		// Both mark again with the world stopped after the
+		// card table has been consumed.
+		print("runtime: GODEBUG=gcgen=1 ignored with gccheckmark or gctrace=2\n")
+		return
+	}
+	gcgen.enabled = true
+	// Keep write barriers on between cycles.
+	writeBarrier.needed = true
+	writeBarrier.enabled = true
+}
+
+// gcGenStartCycle decides whether the cycle being started is minor. It
+// is called by gcStart with the world stopped, after the previous
+// cycle's sweep has finished.
+func gcGenStartCycle(mode gcMode) {
+	gcgen.minor = gcgen.nextMinor && mode == gcBackgroundMode && !work.userForced
+	gcgen.nextMinor = gcgen.minor
+}
+
+// gcGenEndCycle decides whether the next cycle is minor. It is called
+// by gcMarkTermination with the world stopped, after heap_marked has
+// been updated and before the trigger for the next cycle is set.
+func gcGenEndCycle() {
+	if !gcgen.enabled {
+		return
+	}
+	if gcgen.minor {
+		gcgen.nminor++
+	} else {
+		gcgen.nminor = 0
+		gcgen.majorMarked = memstats.heap_marked
+	}
+	gcgen.oldBytes = memstats.heap_marked
+	gcgen.nextMinor = gcpercent > 0 && gcgen.nminor < gcGenMaxMinor &&
+		gcgen.oldBytes < gcgen.majorMarked+gcgen.majorMarked*uint64(gcpercent)/100
+}
+
+// gcGoalPercent returns the heap growth over the marked heap, in
+// percent, that the next or current cycle is paced for. This is
+// gcpercent, or a fraction of it for minor cycles.
+func gcGoalPercent() int32 {
+	if !gcgen.nextMinor || gcpercent <= 0 {
+		return gcpercent
+	}
+	if p := gcpercent / gcGenMinorDivisor; p > 0 {
+		return p
+	}
+	return 1
+}
+
+// isOld reports whether the object at index in s is in the old
+// generation. It is only meaningful while s is swept, and so during
+// the mark phase.
+//
+//go:nosplit
+func (s *mspan) isOld(index uintptr) bool {
+	return s.allocBitsForIndex(index).isMarked()
+}
+
+// keepOld marks the old objects of s, so that sweeping after a minor
+// cycle does not free them.
+func (s *mspan) keepOld() {
+	n := (uintptr(s.nelems) + 7) / 8
+	for i := uintptr(0); i < n; i++ {
+		*s.gcmarkBits.bytep(i) |= *s.allocBits.bytep(i)
+	}
+}
+
+// gcGenMarkCards marks the cards of the heap pointers in ptrs dirty. It
+// is called by wbBufFlush1 between cycles.
+//
+//go:nowritebarrierrec
+//go:nosplit
+func gcGenMarkCards(ptrs []uintptr) {
+	for _, p := range ptrs {
+		if p < minLegalPointer {
+			continue
+		}
+		ri := arenaIndex(p)
+		if arenaL1Bits == 0 {
+			if ri.l2() >= uint(len(mheap_.arenas[0])) {
+				continue
+			}
+		} else if ri.l1() >= uint(len(mheap_.arenas)) {
+			continue
+		}
+		l2 := mheap_.arenas[ri.l1()]
+		if arenaL1Bits != 0 && l2 == nil {
+			continue
+		}
+		ha := l2[ri.l2()]
+		if ha == nil {
+			continue
+		}
+		card := (p - arenaBase(ri)) / gcCardBytes
+		b, mask := &ha.cards[card/8], uint8(1)<<(card%8)
+		if *b&mask == 0 {
+			atomic.Or8(b, mask)
+		}
+	}
+}
+
+// markrootCards greys the young objects in the dirty cards of the
+// shard'th heap arena, and cleans the cards. In major cycles it only
+// cleans them.
+//
+//go:nowritebarrier
+func markrootCards(gcw *gcWork, shard int) {
+	ai := mheap_.allArenas[shard]
+	ha := mheap_.arenas[ai.l1()][ai.l2()]
+	if !gcgen.minor {
+		memclrNoHeapPointers(unsafe.Pointer(&ha.cards), uintptr(len(ha.cards)))
+		return
+	}
+	base := arenaBase(ai)
+	for i := range ha.cards {
+		bits := ha.cards[i]
+		if bits == 0 {
+			continue
+		}
+		ha.cards[i] = 0
+		for j := uintptr(0); bits != 0; j, bits = j+1, bits>>1 {
+			if bits&1 != 0 {
+				markrootCard(base+(uintptr(i)*8+j)*gcCardBytes, gcw)
+			}
+		}
+	}
+}
+
+// markrootCard greys the young objects that overlap the card at p.
+//
+//go:nowritebarrier
+func markrootCard(p uintptr, gcw *gcWork) {
+	// A card never spans pages, so it is in at most one span.
+	s := spanOfHeap(p)
+	if s == nil {
+		return
+	}
+	end := p + gcCardBytes
+	if end > s.limit {
+		end = s.limit
+	}
+	if p >= end {
+		return
+	}
+	for i := s.objIndex(p); i <= s.objIndex(end-1); i++ {
+		if s.isFree(i) || s.isOld(i) {
+			continue
+		}
+		greyobject(s.base()+i*s.elemsize, 0, 0, s, gcw, i)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type genNode struct {
+	id    int
+	child *genNode
+	data  [8]int
+}
+
+func newGenNode(id int) *genNode {
+	n := &genNode{id: id}
+	for i := range n.data {
+		n.data[i] = id
+	}
+	return n
+}
+
+func (n *genNode) ok(id int) bool {
+	if n == nil || n.id != id {
+		return false
+	}
+	for _, v := range n.data {
+		if v != id {
+			return false
+		}
+	}
+	return true
+}
+
+var genGarbage []*genNode
+
+func TestGCGenOldToYoungHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	const nold = 1 << 12
+	old := make([]*genNode, nold)
+	for i := range old {
+		old[i] = newGenNode(i)
+	}
+	// A forced GC is a major cycle; everything above is old after it.
+	runtime.GC()
+
+	var freed int32
+	for round := 0; round < 50; round++ {
+		// Store young objects, and young objects reachable only
+		// from those, into old ones. A finalizer reports any
+		// that a minor cycle wrongly frees.
+		for i, o := range old {
+			id := round*nold + i
+			y := newGenNode(id)
+			y.child = newGenNode(-id)
+			runtime.SetFinalizer(y.child, func(*genNode) { atomic.AddInt32(&freed, 1) })
+			if o.child != nil {
+				runtime.SetFinalizer(o.child.child, nil)
+			}
+			o.child = y
+		}
+		// Allocate enough garbage to run a few cycles, which
+		// are minor ones.
+		for i := 0; i < 1<<14; i++ {
+			genGarbage = append(genGarbage, newGenNode(-1))
+			if len(genGarbage) == 1<<10 {
+				genGarbage = nil
+			}
+		}
+		for i, o := range old {
+			id := round*nold + i
+			if !o.ok(i) || !o.child.ok(id) || !o.child.child.ok(-id) {
+				t.Fatalf("round %d: object %d reachable from an old object was freed", round, i)
+			}
+		}
+		if n := atomic.LoadInt32(&freed); n != 0 {
+			t.Fatalf("round %d: %d reachable objects finalized", round, n)
+		}
+	}
+	runtime.KeepAlive(old)
+}
+
+func TestGCGenOldToYoung(t *testing.T) {
+	out, err := runHelper(t, "TestGCGenOldToYoungHelper", "GODEBUG=gcgen=1,gctrace=1")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "(minor)") {
+		t.Fatalf("no minor cycles ran:\n%s", out)
+	}
+}
+
+// The large cache benchmarks model a server with a large, long-lived
+// cache and short-lived per-request garbage. The generational mode is
+// chosen at startup, so compare runs with and without GODEBUG=gcgen=1.
+// Run with -v to see the GC counts and pause times.
+
+type cacheEntry struct {
+	key   int
+	value []byte
+}
+
+var cacheSink []*cacheEntry
+
+func BenchmarkGCLargeCache(b *testing.B) {
+	for _, entries := range []int{1 << 16, 1 << 20} {
+		b.Run(fmt.Sprint(entries), func(b *testing.B) {
+			benchmarkGCLargeCache(b, entries)
+		})
+	}
+}
+
+func benchmarkGCLargeCache(b *testing.B, entries int) {
+	cache := make([]*cacheEntry, entries)
+	for k := range cache {
+		cache[k] = newCacheEntry(k)
+	}
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A request allocates some garbage.
+		req := make([]*cacheEntry, 16)
+		for j := range req {
+			req[j] = &cacheEntry{key: -1, value: make([]byte, 128)}
+		}
+		cacheSink = req
+
+		// Now and then, it updates the cache. This stores
+		// young objects into old ones.
+		if i%64 == 0 {
+			k := (i / 64) % entries
+			if i%128 == 0 {
+				cache[k] = newCacheEntry(k)
+			} else {
+				cache[k].value = newCacheEntry(k).value
+			}
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	cacheSink = nil
+
+	// A young object only reachable from the cache must not have
+	// been freed.
+	for k, e := range cache {
+		if e.key != k || len(e.value) != 64 || e.value[0] != byte(k) || e.value[63] != byte(k) {
+			b.Fatalf("cache entry %d was corrupted", k)
+		}
+	}
+	if n := after.NumGC - before.NumGC; n > 0 {
+		b.Logf("%d GCs, %d ns average pause, %d MB heap in use",
+			n, (after.PauseTotalNs-before.PauseTotalNs)/uint64(n), after.HeapAlloc>>20)
+	}
+}
+
+func newCacheEntry(k int) *cacheEntry {
+	e := &cacheEntry{key: k, value: make([]byte, 64)}
+	for i := range e.value {
+		e.value[i] = byte(k)
+	}
+	return e
+}
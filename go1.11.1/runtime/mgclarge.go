@@ -251,6 +251,25 @@ func scavengetreap(treap *treapNode, now, limit uint64) uintptr {
 		scavengetreap(treap.right, now, limit)
 }
 
+// scavengetreapBytes is like scavengetreap, but ignores how long spans
+// have been unused and stops once nbytes have been released.
+func scavengetreapBytes(treap *treapNode, nbytes uintptr) uintptr {
+	if treap == nil {
+		return 0
+	}
+	var released uintptr
+	if s := treap.spanKey; s.npreleased != s.npages {
+		released = s.scavenge()
+	}
+	if released < nbytes {
+		released += scavengetreapBytes(treap.left, nbytes-released)
+	}
+	if released < nbytes {
+		released += scavengetreapBytes(treap.right, nbytes-released)
+	}
+	return released
+}
+
 // rotateLeft rotates the tree rooted at node x.
 // turning (x a (y b c)) into (y (x a b) c).
 func (root *mTreap) rotateLeft(x *treapNode) {
@@ -0,0 +1,240 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Soft memory limit.
+//
+// GOMEMLIMIT, or runtime/debug.SetMemoryLimit, limits the total amount
+// of memory mapped by the runtime: the heap, less what has been
+// released to the OS, plus stacks and runtime metadata. The limit is
+// soft. No allocation ever fails because of it, but as the total gets
+// close to the limit
+//
+//	- gcSetTriggerRatio lowers the heap goal, and the trigger with it,
+//	  so that the heap fits in what the limit leaves after all other
+//	  memory; this works with GOGC=off as well;
+//	- sysmon returns free heap memory to the OS without waiting for it
+//	  to be unused for the usual five minutes.
+//
+// If the live heap itself does not fit under the limit, pacing by the
+// limit alone would run the GC back to back and leave little CPU for
+// the program. To avoid that death spiral, memoryLimitEndCycle measures
+// the GC CPU utilization of every cycle. While it is above
+// memoryLimitMaxCPU, the heap goal is allowed to exceed the limit by a
+// minimum growth over the marked heap, which doubles every cycle until
+// utilization drops again.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+const (
+	// memoryLimitNone is the limit when none is set.
+	memoryLimitNone = 1<<63 - 1
+
+	// memoryLimitHeadroom is the fraction of the limit kept free
+	// to absorb fragmentation and errors in the estimates.
+	memoryLimitHeadroom = 0.03
+
+	// memoryLimitTriggerFraction places the trigger of a cycle
+	// whose goal was lowered by the limit at this fraction of the
+	// way from the marked heap to the goal, at most.
+	memoryLimitTriggerFraction = 0.9
+
+	// memoryLimitMaxCPU is the GC CPU utilization, excluding idle
+	// marking, above which the limit is relaxed.
+	memoryLimitMaxCPU = 0.5
+
+	// memoryLimitMinGrowth and memoryLimitMaxGrowth bound the heap
+	// growth allowed over the limit while it is relaxed. The upper
+	// bound is that of GOGC=100.
+	memoryLimitMinGrowth = 1.0 / 16
+	memoryLimitMaxGrowth = 1.0
+
+	// memoryLimitScavengePeriod is how often sysmon checks whether
+	// memory must be returned to the OS to meet the limit.
+	memoryLimitScavengePeriod = 10 * 1000 * 1000
+)
+
+var memlimit struct {
+	// limit is the limit in bytes, or memoryLimitNone. It is
+	// written with mheap_.lock held and read atomically.
+	limit int64
+
+	// limited is set if the current heap goal was lowered to
+	// meet the limit. Protected by mheap_.lock.
+	limited bool
+
+	// The following are updated by memoryLimitEndCycle with the
+	// world stopped.
+	lastEnd int64   // end of the previous cycle
+	cpu     float64 // smoothed GC CPU utilization
+	growth  float64 // allowed heap growth over the limit; 0 if none
+
+	lastScavenge int64 // only used by sysmon
+}
+
+// memoryLimitInit sets the limit from $GOMEMLIMIT. Called by gcinit
+// before the first gcSetTriggerRatio.
+func memoryLimitInit() {
+	memlimit.limit = readgomemlimit()
+}
+
+func readgomemlimit() int64 {
+	p := gogetenv("GOMEMLIMIT")
+	if p == "" || p == "off" {
+		return memoryLimitNone
+	}
+	n, ok := parseByteCount(p)
+	if !ok {
+		print("runtime: GOMEMLIMIT=", p, "\n")
+		throw("malformed GOMEMLIMIT; want a byte count such as 512MiB")
+	}
+	return n
+}
+
+// parseByteCount parses a non-negative number of bytes with an
+// optional unit suffix: B, KiB, MiB, GiB or TiB.
+func parseByteCount(s string) (int64, bool) {
+	i := 0
+	n := int64(0)
+	for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		d := int64(s[i] - '0')
+		if n > (memoryLimitNone-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
+	}
+	if i == 0 {
+		return 0, false
+	}
+	shift := uint(0)
+	switch s[i:] {
+	case "", "B":
+	case "KiB":
+		shift = 10
+	case "MiB":
+		shift = 20
+	case "GiB":
+		shift = 30
+	case "TiB":
+		shift = 40
+	default:
+		return 0, false
+	}
+	if n > memoryLimitNone>>shift {
+		return 0, false
+	}
+	return n << shift, true
+}
+
+//go:linkname setMemoryLimit runtime/debug.setMemoryLimit
+func setMemoryLimit(in int64) (out int64) {
+	lock(&mheap_.lock)
+	out = memlimit.limit
+	if in >= 0 {
+		atomic.Store64((*uint64)(unsafe.Pointer(&memlimit.limit)), uint64(in))
+		// Update pacing in response to the new limit.
+		gcSetTriggerRatio(memstats.triggerRatio)
+	}
+	unlock(&mheap_.lock)
+	return out
+}
+
+// memoryLimitMapped returns the total memory counted against the limit
+// and how much of it is heap. The statistics are read without
+// synchronization, so the result is approximate.
+func memoryLimitMapped() (total, heap uint64) {
+	heap = memstats.heap_sys - memstats.heap_released
+	total = heap + memstats.stacks_inuse + memstats.stacks_sys +
+		memstats.mspan_sys + memstats.mcache_sys + memstats.buckhash_sys +
+		memstats.gc_sys + memstats.other_sys
+	return
+}
+
+// memoryLimitGoal returns goal, lowered if necessary so that a heap of
+// that size fits under the memory limit, and whether it was lowered.
+//
+// mheap_.lock must be held or the world must be stopped.
+func memoryLimitGoal(goal uint64) (uint64, bool) {
+	limit := int64(atomic.Load64((*uint64)(unsafe.Pointer(&memlimit.limit))))
+	if limit == memoryLimitNone {
+		return goal, false
+	}
+	total, heap := memoryLimitMapped()
+	return memoryLimitHeapGoal(uint64(limit), total-heap, memstats.heap_marked, memlimit.growth, goal)
+}
+
+// memoryLimitHeapGoal computes the result of memoryLimitGoal for a
+// limit, an amount of non-heap memory, a marked heap size, and the
+// heap growth that must be allowed regardless of the limit.
+func memoryLimitHeapGoal(limit, overhead, marked uint64, growth float64, goal uint64) (uint64, bool) {
+	avail := int64(float64(limit)*(1-memoryLimitHeadroom)) - int64(overhead)
+	if min := int64(marked + uint64(float64(marked)*growth)); avail < min {
+		avail = min
+	}
+	if uint64(avail) >= goal {
+		return goal, false
+	}
+	return uint64(avail), true
+}
+
+// memoryLimitEndCycle updates the GC CPU utilization at the end of a
+// cycle and with it the heap growth allowed over the limit. cycleCPU
+// is the CPU time the cycle used, excluding idle marking. The result
+// applies from the next call of gcSetTriggerRatio.
+//
+// The world must be stopped.
+func memoryLimitEndCycle(cycleCPU, now int64) {
+	last := memlimit.lastEnd
+	memlimit.lastEnd = now
+	if last == 0 || now <= last {
+		return
+	}
+	// Utilization over the whole time since the previous cycle
+	// ended, so back-to-back cycles show up as high utilization.
+	util := float64(cycleCPU) / (float64(now-last) * float64(gomaxprocs))
+	memlimit.cpu = (memlimit.cpu + util) / 2
+	memlimit.growth = memoryLimitNextGrowth(memlimit.growth, memlimit.cpu)
+}
+
+// memoryLimitNextGrowth returns the heap growth allowed over the limit
+// for the next cycle, given the current one and GC CPU utilization.
+func memoryLimitNextGrowth(growth, cpu float64) float64 {
+	switch {
+	case cpu > memoryLimitMaxCPU:
+		if growth == 0 {
+			growth = memoryLimitMinGrowth
+		} else if growth *= 2; growth > memoryLimitMaxGrowth {
+			growth = memoryLimitMaxGrowth
+		}
+	case cpu < memoryLimitMaxCPU/2:
+		if growth /= 2; growth < memoryLimitMinGrowth {
+			growth = 0
+		}
+	}
+	return growth
+}
+
+// memoryLimitScavenge returns free heap memory to the OS, regardless of
+// how long it has been unused, if the total is over the limit less the
+// headroom. It is called by sysmon.
+func memoryLimitScavenge(now int64) {
+	limit := int64(atomic.Load64((*uint64)(unsafe.Pointer(&memlimit.limit))))
+	if limit == memoryLimitNone || now-memlimit.lastScavenge < memoryLimitScavengePeriod {
+		return
+	}
+	memlimit.lastScavenge = now
+	target := uint64(float64(limit) * (1 - memoryLimitHeadroom))
+	total, _ := memoryLimitMapped()
+	if total <= target {
+		return
+	}
+	released := mheap_.scavengeBytes(uintptr(total - target))
+	if debug.gctrace > 0 && released > 0 {
+		print("scvg: ", released>>20, " MB released for memory limit (", total>>20, " MB mapped, limit ", limit>>20, " MB)\n")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestParseByteCount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"4096", 4096, true},
+		{"100B", 100, true},
+		{"64KiB", 64 << 10, true},
+		{"512MiB", 512 << 20, true},
+		{"3GiB", 3 << 30, true},
+		{"2TiB", 2 << 40, true},
+		{"9223372036854775807", 1<<63 - 1, true},
+		{"9223372036854775808", 0, false},
+		{"8388608TiB", 0, false},
+		{"", 0, false},
+		{"MiB", 0, false},
+		{"-1", 0, false},
+		{"10MB", 0, false},
+		{"1.5GiB", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := runtime.ParseByteCount(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseByteCount(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMemoryLimitHeapGoal(t *testing.T) {
+	const MiB = 1 << 20
+	tests := []struct {
+		name                    string
+		limit, overhead, marked uint64
+		growth                  float64
+		goal                    uint64
+		want                    uint64
+		limited                 bool
+	}{
+		{"far below limit", 1024 * MiB, 10 * MiB, 100 * MiB, 0, 200 * MiB, 200 * MiB, false},
+		{"goal over limit", 256 * MiB, 16 * MiB, 100 * MiB, 0, 200 * MiB, 256*MiB - 256*MiB*3/100 - 16*MiB, true},
+		{"gc off", 256 * MiB, 16 * MiB, 100 * MiB, 0, ^uint64(0), 256*MiB - 256*MiB*3/100 - 16*MiB, true},
+		{"live heap over limit", 128 * MiB, 16 * MiB, 200 * MiB, 0, 400 * MiB, 200 * MiB, true},
+		{"relaxed", 128 * MiB, 16 * MiB, 192 * MiB, 0.25, 384 * MiB, 240 * MiB, true},
+		{"overhead over limit", 64 * MiB, 100 * MiB, 8 * MiB, 0, 16 * MiB, 8 * MiB, true},
+	}
+	for _, tt := range tests {
+		got, limited := runtime.MemoryLimitHeapGoal(tt.limit, tt.overhead, tt.marked, tt.growth, tt.goal)
+		// Allow for rounding in the float computation of the headroom.
+		if d := int64(got - tt.want); d < -1024 || d > 1024 || limited != tt.limited {
+			t.Errorf("%s: got goal %d, limited %v; want %d, %v", tt.name, got, limited, tt.want, tt.limited)
+		}
+	}
+}
+
+func TestMemoryLimitGrowth(t *testing.T) {
+	// The growth allowed over the limit starts when the GC uses too
+	// much CPU, doubles up to the maximum while it does, and decays
+	// back to zero once it doesn't.
+	growth := 0.0
+	high := runtime.MemoryLimitMaxCPU + 0.1
+	growth = runtime.MemoryLimitNextGrowth(growth, high)
+	if growth != runtime.MemoryLimitMinGrowth {
+		t.Fatalf("growth after first busy cycle = %v, want %v", growth, runtime.MemoryLimitMinGrowth)
+	}
+	for i := 0; i < 20; i++ {
+		growth = runtime.MemoryLimitNextGrowth(growth, high)
+	}
+	if growth != runtime.MemoryLimitMaxGrowth {
+		t.Fatalf("growth after many busy cycles = %v, want %v", growth, runtime.MemoryLimitMaxGrowth)
+	}
+	// In between the thresholds, nothing changes.
+	if g := runtime.MemoryLimitNextGrowth(growth, runtime.MemoryLimitMaxCPU*0.75); g != growth {
+		t.Fatalf("growth changed from %v to %v at moderate utilization", growth, g)
+	}
+	for i := 0; i < 20; i++ {
+		growth = runtime.MemoryLimitNextGrowth(growth, 0.01)
+	}
+	if growth != 0 {
+		t.Fatalf("growth after many idle cycles = %v, want 0", growth)
+	}
+}
+
+var memoryLimitSink [][]byte
+
+func TestMemoryLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	const MiB = 1 << 20
+
+	// Each pattern keeps live MiB reachable while allocating
+	// garbage MiB in chunk-sized objects. With GC otherwise off, only
+	// the memory limit keeps memory use bounded.
+	patterns := []struct {
+		name          string
+		live, garbage int
+		chunk         int
+		growLiveHeap  bool
+	}{
+		{"churn", 16, 1024, 64 << 10, false},
+		{"large objects", 16, 1024, 4 << 20, false},
+		{"growing live heap", 48, 512, 32 << 10, true},
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			defer func() { memoryLimitSink = nil }()
+
+			var ms runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&ms)
+			limit := int64(ms.Sys-ms.HeapReleased) + 128*MiB
+			defer debug.SetMemoryLimit(debug.SetMemoryLimit(limit))
+			numGC := ms.NumGC
+
+			live := p.live * MiB / p.chunk
+			if !p.growLiveHeap {
+				for i := 0; i < live; i++ {
+					memoryLimitSink = append(memoryLimitSink, make([]byte, p.chunk))
+				}
+			}
+			var peak uint64
+			n := p.garbage * MiB / p.chunk
+			for i := 0; i < n; i++ {
+				b := make([]byte, p.chunk)
+				if p.growLiveHeap {
+					if len(memoryLimitSink) < live && i%(n/live+1) == 0 {
+						memoryLimitSink = append(memoryLimitSink, b)
+					}
+				} else {
+					memoryLimitSink[i%live] = b
+				}
+				if (i*p.chunk)%(16*MiB) < p.chunk {
+					runtime.ReadMemStats(&ms)
+					if used := ms.Sys - ms.HeapReleased; used > peak {
+						peak = used
+					}
+				}
+			}
+			runtime.ReadMemStats(&ms)
+			if ms.NumGC == numGC {
+				t.Errorf("no GC ran")
+			}
+			// The limit is soft. Allow for the time sysmon takes to
+			// return memory and for the heap overshooting its goal
+			// while a cycle runs.
+			if max := uint64(limit + limit/4); peak > max {
+				t.Errorf("peak memory use %d MiB over limit %d MiB", peak/MiB, limit/MiB)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 const (
 	fixedRootFinalizers = iota
 	fixedRootFreeGStacks
+	fixedRootUserArenas
 	fixedRootCount
 
 	// rootBlockBytes is the number of bytes to scan per data or
@@ -98,6 +99,13 @@ func gcMarkRootPrepare() {
 		// this mark phase.
 		work.nSpanRoots = mheap_.sweepSpans[mheap_.sweepgen/2%2].numBlocks()
 
+		// In generational mode, the card table is consumed
+		// once per cycle, before any new cards are dirtied.
+		work.nCardRoots = 0
+		if gcgen.enabled {
+			work.nCardRoots = len(mheap_.allArenas)
+		}
+
 		// On the first markroot, we need to scan all Gs. Gs
 		// may be created after this point, but it's okay that
 		// we ignore them because they begin life without any
@@ -111,6 +119,7 @@ func gcMarkRootPrepare() {
 		// We've already scanned span roots and kept the scan
 		// up-to-date during concurrent mark.
 		work.nSpanRoots = 0
+		work.nCardRoots = 0
 
 		// The hybrid barrier ensures that stacks can't
 		// contain pointers to unmarked objects, so on the
@@ -124,7 +133,7 @@ func gcMarkRootPrepare() {
 	}
 
 	work.markrootNext = 0
-	work.markrootJobs = uint32(fixedRootCount + work.nFlushCacheRoots + work.nDataRoots + work.nBSSRoots + work.nSpanRoots + work.nStackRoots)
+	work.markrootJobs = uint32(fixedRootCount + work.nFlushCacheRoots + work.nDataRoots + work.nBSSRoots + work.nSpanRoots + work.nCardRoots + work.nStackRoots)
 }
 
 // gcMarkRootCheck checks that all roots have been scanned. It is
@@ -182,7 +191,8 @@ func markroot(gcw *gcWork, i uint32) {
 	baseData := baseFlushCache + uint32(work.nFlushCacheRoots)
 	baseBSS := baseData + uint32(work.nDataRoots)
 	baseSpans := baseBSS + uint32(work.nBSSRoots)
-	baseStacks := baseSpans + uint32(work.nSpanRoots)
+	baseCards := baseSpans + uint32(work.nSpanRoots)
+	baseStacks := baseCards + uint32(work.nCardRoots)
 	end := baseStacks + uint32(work.nStackRoots)
 
 	// Note: if you add a case here, please also update heapdump.go:dumproots.
@@ -220,10 +230,20 @@ func markroot(gcw *gcWork, i uint32) {
 			systemstack(markrootFreeGStacks)
 		}
 
-	case baseSpans <= i && i < baseStacks:
+	case i == fixedRootUserArenas:
+		// Like globals, user arenas are scanned once per
+		// cycle. Later writes to them have write barriers.
+		if !work.markrootDone {
+			markrootUserArenas(gcw)
+		}
+
+	case baseSpans <= i && i < baseCards:
 		// mark MSpan.specials
 		markrootSpans(gcw, int(i-baseSpans))
 
+	case baseCards <= i && i < baseStacks:
+		markrootCards(gcw, int(i-baseCards))
+
 	default:
 		// the rest is scanning goroutine stacks
 		var gp *g
@@ -338,6 +358,10 @@ func markrootSpans(gcw *gcWork, shard int) {
 	// collected heap) are roots. In practice, this means the fn
 	// field must be scanned.
 	//
+	// Weak handle and cleanup specials are roots too, for their
+	// handle and function, but the object they are attached to is
+	// not marked.
+	//
 	// TODO(austin): There are several ideas for making this more
 	// efficient in issue #11485.
 
@@ -379,6 +403,16 @@ func markrootSpans(gcw *gcWork, shard int) {
 		lock(&s.speciallock)
 
 		for sp := s.specials; sp != nil; sp = sp.next {
+			if sp.kind == _KindSpecialWeak {
+				spw := (*specialweakhandle)(unsafe.Pointer(sp))
+				scanblock(uintptr(unsafe.Pointer(&spw.handle)), sys.PtrSize, &oneptrmask[0], gcw)
+				continue
+			}
+			if sp.kind == _KindSpecialCleanup {
+				spc := (*specialcleanup)(unsafe.Pointer(sp))
+				scanblock(uintptr(unsafe.Pointer(&spc.fn)), sys.PtrSize, &oneptrmask[0], gcw)
+				continue
+			}
 			if sp.kind != _KindSpecialFinalizer {
 				continue
 			}
@@ -591,6 +625,7 @@ func gcAssistAlloc1(gp *g, scanWork int64) {
 	duration := nanotime() - startTime
 	_p_ := gp.m.p.ptr()
 	_p_.gcAssistTime += duration
+	_p_.gcAssistTimeCycle += duration
 	if _p_.gcAssistTime > gcAssistTimeSlack {
 		atomic.Xaddint64(&gcController.assistTime, _p_.gcAssistTime)
 		_p_.gcAssistTime = 0
@@ -770,6 +805,9 @@ func scanstack(gp *g, gcw *gcWork) {
 		shrinkstack(gp)
 	}
 
+	// Account the used stack for the initial stack size.
+	stackStartScanned(gp)
+
 	// Scan the saved context register. This is effectively a live
 	// register that gets moved back and forth between the
 	// register and sched.ctxt without a write barrier.
@@ -1222,6 +1260,10 @@ func greyobject(obj, base, off uintptr, span *mspan, gcw *gcWork, objIndex uintp
 		if mbits.isMarked() {
 			return
 		}
+		// Minor cycles do not mark the old generation.
+		if gcgen.minor && span.isOld(objIndex) {
+			return
+		}
 		// mbits.setMarked() // Avoid extra call overhead with manual inlining.
 		atomic.Or8(mbits.bytep, mbits.mask)
 		// If this is a noscan object, fast-track it to black
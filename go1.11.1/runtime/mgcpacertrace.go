@@ -0,0 +1,256 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// GC pacer trace.
+//
+// The decisions of gcControllerState are recorded as pacer events:
+// one when a cycle starts (startCycle), one every time the assist
+// ratio is revised during the cycle (revise), and one when the trigger
+// for the next cycle is chosen (endCycle). Each event carries the
+// inputs the controller used and what it decided, so that tools can
+// replay the pacer offline.
+//
+// Events go to up to three places:
+//
+//	- the execution trace, as traceEvGCPacerStart, traceEvGCPacerRevise
+//	  and traceEvGCPacerEndCycle, while tracing is on with
+//	  GODEBUG=tracepacer=1;
+//	- a ring buffer read by runtime/debug.ReadPacerEvents, while
+//	  enabled by runtime/debug.SetPacerTrace;
+//	- standard error, for start and end of cycle events, with
+//	  GODEBUG=gcpacertrace=1.
+//
+// The Go 1.11 trace parser rejects event types it does not know, so
+// the trace events are only written when asked for, as GC STW events
+// of kind 2 are with GODEBUG=tracestw=1.
+//
+// revise runs every time heap_live changes during a cycle, which
+// includes the allocation path, so revise events are recorded at most
+// once every pacerReviseInterval. The first revise of a cycle is
+// always recorded.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// Pacer event kinds. These are also the values of
+// runtime/debug.PacerEventKind.
+const (
+	pacerEvStart    = 1 + iota // startCycle
+	pacerEvRevise              // revise
+	pacerEvEndCycle            // endCycle
+)
+
+// pacerTraceRing is the number of events kept for ReadPacerEvents.
+const pacerTraceRing = 4096
+
+// pacerReviseInterval is the minimum time in nanoseconds between two
+// recorded revise events.
+const pacerReviseInterval = 1e6
+
+// pacerEvent is a decision of the GC pacer. Fields not listed for a
+// kind are zero.
+type pacerEvent struct {
+	kind  uint8
+	cycle uint32 // work.cycles
+	when  int64  // nanotime()
+
+	// All kinds.
+	heapLive uint64 // memstats.heap_live
+	heapGoal uint64 // memstats.next_gc; for revise, the goal paced for
+
+	// Start and revise.
+	heapScan          uint64  // memstats.heap_scan
+	scanWork          int64   // scan work done so far
+	scanWorkExpected  int64   // revise: total scan work expected
+	assistWorkPerByte float64 // the resulting assist ratio
+
+	// Start.
+	dedicatedWorkers int64   // dedicated mark workers needed
+	fractionalGoal   float64 // fractional worker utilization goal
+
+	// End of cycle, in the terms of the pacer design document.
+	heapMarked       uint64  // H_m_prev, heap marked by the previous cycle
+	trigger          uint64  // H_T, absolute trigger of this cycle
+	triggerRatio     float64 // h_t, trigger ratio of this cycle
+	goalGrowth       float64 // h_g, goal heap growth ratio
+	actualGrowth     float64 // h_a, actual heap growth ratio
+	utilization      float64 // u_a, actual GC CPU utilization
+	nextTriggerRatio float64 // trigger ratio chosen for the next cycle
+}
+
+var pacertrace struct {
+	// lastRevise is the nanotime of the last revise event
+	// recorded, or 0 if none was recorded in this cycle. It is
+	// first to be 64-bit aligned for atomic access.
+	lastRevise uint64
+
+	enabled uint32 // record to ring; set by runtime/debug.SetPacerTrace
+
+	lock mutex
+	ring *[pacerTraceRing]pacerEvent // allocated when first enabled
+	n    uint64                      // number of events recorded
+}
+
+// pacerTracing reports whether pacer events should be recorded at all.
+func pacerTracing() bool {
+	return atomic.Load(&pacertrace.enabled) != 0 || debug.gcpacertrace > 0 || pacerTraceEvents()
+}
+
+// pacerTraceEvents reports whether pacer events should be written to
+// the execution trace.
+func pacerTraceEvents() bool {
+	return trace.enabled && debug.tracepacer != 0
+}
+
+// pacerReviseDue reports whether a revise event should be recorded
+// now, and if so claims it. Callers check pacerTracing first.
+func pacerReviseDue() bool {
+	if atomic.Load(&pacertrace.enabled) == 0 && !pacerTraceEvents() {
+		// Revise events are not printed.
+		return false
+	}
+	last := atomic.Load64(&pacertrace.lastRevise)
+	now := nanotime()
+	if last != 0 && now-int64(last) < pacerReviseInterval {
+		return false
+	}
+	return atomic.Cas64(&pacertrace.lastRevise, last, uint64(now))
+}
+
+// pacerRecord records e. The caller should check pacerTracing first
+// to avoid building events no one will see.
+func pacerRecord(e *pacerEvent) {
+	e.cycle = work.cycles
+	e.when = nanotime()
+	if e.kind == pacerEvStart {
+		atomic.Store64(&pacertrace.lastRevise, 0)
+	}
+	if pacerTraceEvents() {
+		tracePacerEvent(e)
+	}
+	if atomic.Load(&pacertrace.enabled) != 0 {
+		lock(&pacertrace.lock)
+		if pacertrace.ring != nil {
+			pacertrace.ring[pacertrace.n%pacerTraceRing] = *e
+			pacertrace.n++
+		}
+		unlock(&pacertrace.lock)
+	}
+	if debug.gcpacertrace > 0 && e.kind != pacerEvRevise {
+		printPacerEvent(e)
+	}
+}
+
+func tracePacerEvent(e *pacerEvent) {
+	switch e.kind {
+	case pacerEvStart:
+		traceEvent(traceEvGCPacerStart, -1, e.heapLive, e.heapGoal, e.heapScan,
+			float64bits(e.assistWorkPerByte), uint64(e.dedicatedWorkers), float64bits(e.fractionalGoal))
+	case pacerEvRevise:
+		traceEvent(traceEvGCPacerRevise, -1, e.heapLive, e.heapGoal, e.heapScan,
+			uint64(e.scanWork), uint64(e.scanWorkExpected), float64bits(e.assistWorkPerByte))
+	case pacerEvEndCycle:
+		traceEvent(traceEvGCPacerEndCycle, -1, e.heapMarked, e.trigger, e.heapLive, uint64(e.scanWork),
+			float64bits(e.triggerRatio), float64bits(e.goalGrowth), float64bits(e.actualGrowth),
+			float64bits(e.utilization), float64bits(e.nextTriggerRatio))
+	}
+}
+
+// printPacerEvent prints e in the format of GODEBUG=gcpacertrace=1.
+func printPacerEvent(e *pacerEvent) {
+	switch e.kind {
+	case pacerEvStart:
+		print("pacer: assist ratio=", e.assistWorkPerByte,
+			" (scan ", e.heapScan>>20, " MB in ",
+			e.heapLive>>20, "->",
+			e.heapGoal>>20, " MB)",
+			" workers=", e.dedicatedWorkers,
+			"+", e.fractionalGoal, "\n")
+	case pacerEvEndCycle:
+		// Print controller state in terms of the design
+		// document.
+		h_t := e.triggerRatio
+		h_a := e.actualGrowth
+		h_g := e.goalGrowth
+		u_a := e.utilization
+		u_g := gcGoalUtilization
+		print("pacer: H_m_prev=", e.heapMarked,
+			" h_t=", h_t, " H_T=", e.trigger,
+			" h_a=", h_a, " H_a=", e.heapLive,
+			" h_g=", h_g, " H_g=", int64(float64(e.heapMarked)*(1+h_g)),
+			" u_a=", u_a, " u_g=", u_g,
+			" W_a=", e.scanWork,
+			" goalΔ=", h_g-h_t,
+			" actualΔ=", h_a-h_t,
+			" u_a/u_g=", u_a/u_g,
+			"\n")
+	}
+}
+
+//go:linkname setPacerTrace runtime/debug.setPacerTrace
+func setPacerTrace(enable bool) (old bool) {
+	lock(&pacertrace.lock)
+	if enable && pacertrace.ring == nil {
+		pacertrace.ring = (*[pacerTraceRing]pacerEvent)(persistentalloc(unsafe.Sizeof(*pacertrace.ring), sys.CacheLineSize, &memstats.other_sys))
+	}
+	old = pacertrace.enabled != 0
+	if enable {
+		atomic.Store(&pacertrace.enabled, 1)
+	} else {
+		atomic.Store(&pacertrace.enabled, 0)
+	}
+	unlock(&pacertrace.lock)
+	return
+}
+
+// pacerEventFields is the number of uint64s per event in the buffer
+// filled by readPacerEvents.
+const pacerEventFields = 18
+
+// readPacerEvents fills buf with the recorded events after the first
+// after events, oldest first, and returns the number of events
+// recorded so far. Events that were overwritten are skipped.
+//
+//go:linkname readPacerEvents runtime/debug.readPacerEvents
+func readPacerEvents(buf *[]uint64, after uint64) uint64 {
+	p := (*buf)[:0]
+	// Copy under the lock without allocating: the lock is taken
+	// by revise, which may be called from inside the allocator.
+	var tmp [64]pacerEvent
+	for {
+		lock(&pacertrace.lock)
+		n := pacertrace.n
+		if after > n {
+			after = n
+		}
+		if n-after > pacerTraceRing {
+			after = n - pacerTraceRing
+		}
+		k := 0
+		for ; after < n && k < len(tmp); after, k = after+1, k+1 {
+			tmp[k] = pacertrace.ring[after%pacerTraceRing]
+		}
+		unlock(&pacertrace.lock)
+		for i := range tmp[:k] {
+			e := &tmp[i]
+			p = append(p,
+				uint64(e.kind), uint64(e.cycle), uint64(e.when-runtimeInitTime),
+				e.heapLive, e.heapGoal, e.heapScan,
+				uint64(e.scanWork), uint64(e.scanWorkExpected), float64bits(e.assistWorkPerByte),
+				uint64(e.dedicatedWorkers), float64bits(e.fractionalGoal),
+				e.heapMarked, e.trigger, float64bits(e.triggerRatio),
+				float64bits(e.goalGrowth), float64bits(e.actualGrowth),
+				float64bits(e.utilization), float64bits(e.nextTriggerRatio))
+		}
+		if k < len(tmp) {
+			*buf = p
+			return n
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+var pacerSink [][]byte
+
+func TestPacerEvents(t *testing.T) {
+	defer debug.SetPacerTrace(debug.SetPacerTrace(true))
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+
+	_, n := debug.ReadPacerEvents(nil, ^uint64(0))
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	// Allocate enough for the GC to start on its own a few times,
+	// so that the pacer revises the assist ratio and ends cycles
+	// it started.
+	for i := 0; i < 1<<16; i++ {
+		pacerSink = append(pacerSink, make([]byte, 1<<10))
+		if len(pacerSink) == 1<<12 {
+			pacerSink = nil
+		}
+	}
+	pacerSink = nil
+	events, _ := debug.ReadPacerEvents(nil, n)
+
+	var starts, ends int
+	cycleRevise := make(map[uint32]time.Duration)
+	for _, e := range events {
+		if e.Cycle <= m.NumGC {
+			continue
+		}
+		switch e.Kind {
+		case debug.PacerStart:
+			starts++
+			if e.HeapGoal == 0 {
+				t.Errorf("cycle %d started with no heap goal", e.Cycle)
+			}
+		case debug.PacerRevise:
+			if last, ok := cycleRevise[e.Cycle]; ok && e.When-last < runtime.PacerReviseInterval/2 {
+				t.Errorf("cycle %d: revise events %v apart, want at most one per %v",
+					e.Cycle, e.When-last, time.Duration(runtime.PacerReviseInterval))
+			}
+			cycleRevise[e.Cycle] = e.When
+			if e.AssistWorkPerByte < 0 {
+				t.Errorf("cycle %d: negative assist ratio %v", e.Cycle, e.AssistWorkPerByte)
+			}
+		case debug.PacerEndCycle:
+			ends++
+			if e.TriggerRatio <= 0 || e.NextTriggerRatio <= 0 {
+				t.Errorf("cycle %d: trigger ratio %v, next %v", e.Cycle, e.TriggerRatio, e.NextTriggerRatio)
+			}
+		default:
+			t.Errorf("unknown event kind %d", e.Kind)
+		}
+	}
+	if starts == 0 || ends == 0 {
+		t.Fatalf("%d start and %d end of cycle events recorded in %d events", starts, ends, len(events))
+	}
+	if len(cycleRevise) == 0 {
+		t.Errorf("no revise events recorded")
+	}
+}
+
+func TestPacerTraceOff(t *testing.T) {
+	old := debug.SetPacerTrace(false)
+	defer debug.SetPacerTrace(old)
+
+	_, n := debug.ReadPacerEvents(nil, ^uint64(0))
+	runtime.GC()
+	if events, _ := debug.ReadPacerEvents(nil, n); len(events) != 0 {
+		t.Errorf("%d events recorded while recording was off", len(events))
+	}
+}
+
+func TestPacerTraceEvents(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	count := func(data []byte) map[byte]int {
+		events, _ := parseTrace(t, data)
+		n := make(map[byte]int)
+		for _, ev := range events {
+			switch ev.typ {
+			case traceEvGCPacerStart, traceEvGCPacerEndCycle:
+				// args[2] is the heap goal or the trigger.
+				if ev.args[2] == 0 {
+					t.Errorf("pacer event %d with a zero goal or trigger: %v", ev.typ, ev.args)
+				}
+				fallthrough
+			case traceEvGCPacerRevise:
+				n[ev.typ]++
+			}
+		}
+		return n
+	}
+	gc := func() {
+		for i := 0; i < 1<<14; i++ {
+			pacerSink = append(pacerSink, make([]byte, 1<<10))
+		}
+		runtime.GC()
+		pacerSink = nil
+		runtime.GC()
+	}
+
+	if n := count(traceOf(t, gc)); len(n) != 0 {
+		t.Errorf("pacer events %v in the trace without tracepacer", n)
+	}
+	defer runtime.SetTracePacer(runtime.SetTracePacer(true))
+	n := count(traceOf(t, gc))
+	if n[traceEvGCPacerStart] == 0 || n[traceEvGCPacerEndCycle] == 0 {
+		t.Errorf("pacer events %v in the trace, want start and end of cycle events", n)
+	}
+}
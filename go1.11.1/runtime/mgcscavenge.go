@@ -0,0 +1,157 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Background scavenger.
+//
+// The background scavenger returns idle heap memory to the OS a little
+// at a time, so that the memory retained by the heap, heap_sys less
+// heap_released, follows the heap goal down after a spike instead of
+// staying at the peak until sysmon's periodic scavenge finds spans
+// that have been unused for five minutes.
+//
+// At the end of every GC cycle, gcPaceScavenger sets the retained
+// memory goal to the next heap goal plus retainExtraPercent and wakes
+// the scavenger goroutine if retained memory is above it. The
+// scavenger then releases about scavengeChunk bytes at a time until it
+// reaches the goal, and parks until the next cycle. After each chunk
+// it sleeps for long enough that it uses at most scavengePercent of
+// one CPU. Free spans are released whole, so a chunk may be larger
+// than scavengeChunk if a large span is released.
+//
+// sysmon's periodic scavenge and debug.FreeOSMemory work as before.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// retainExtraPercent is how far above the heap goal, in
+	// percent, retained heap memory may be before the background
+	// scavenger starts releasing it.
+	retainExtraPercent = 10
+
+	// scavengeChunk is how much memory the background scavenger
+	// tries to release between sleeps.
+	scavengeChunk = 64 << 10
+
+	// scavengePercent is the share of one CPU, in percent, the
+	// background scavenger may use.
+	scavengePercent = 1
+
+	// scavengeMinSleep is the shortest time the scavenger sleeps
+	// between chunks.
+	scavengeMinSleep = 100 * 1000
+
+	// scavengeSweepWait is how long the scavenger waits for
+	// sweeping to free more spans if it has run out of idle memory
+	// before reaching the goal.
+	scavengeSweepWait = 10 * 1000 * 1000
+)
+
+var scavenge struct {
+	lock   mutex
+	g      *g
+	parked bool
+
+	// Work done since the last gctrace report. Only used by the
+	// scavenger goroutine.
+	released uint64
+	time     int64
+}
+
+// gcPaceScavenger sets the background scavenger's goal from the next
+// heap goal and wakes the scavenger if retained memory is above it.
+// It is called at the end of each GC cycle, after gcSetTriggerRatio,
+// with the world stopped.
+func gcPaceScavenger() {
+	goal := ^uint64(0)
+	if memstats.next_gc != ^uint64(0) {
+		goal = memstats.next_gc + memstats.next_gc/100*retainExtraPercent
+	}
+	memstats.heap_retain_goal = goal
+	if memstats.heap_sys-memstats.heap_released <= goal || scavenge.g == nil {
+		return
+	}
+	lock(&scavenge.lock)
+	if scavenge.parked {
+		scavenge.parked = false
+		goready(scavenge.g, 0)
+	}
+	unlock(&scavenge.lock)
+}
+
+// bgscavenge is the background scavenger goroutine, started by
+// gcenable.
+func bgscavenge(c chan int) {
+	scavenge.g = getg()
+
+	lock(&scavenge.lock)
+	scavenge.parked = true
+	c <- 1
+	goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+
+	for {
+		start := nanotime()
+		var released uintptr
+		var done bool
+		systemstack(func() {
+			released, done = mheap_.scavengeStep(scavengeChunk)
+		})
+		crit := nanotime() - start
+		atomic.Xadd64(&memstats.scavenge_total_ns, crit)
+		scavenge.released += uint64(released)
+		scavenge.time += crit
+
+		if released == 0 {
+			if !done && !gosweepdone() {
+				// Out of idle memory, but sweeping may
+				// free some more.
+				timeSleep(scavengeSweepWait)
+				continue
+			}
+			if debug.gctrace > 0 && scavenge.released > 0 {
+				var sbuf [24]byte
+				print("scvg: background: ", scavenge.released>>10, " KB released in ",
+					string(fmtNSAsMS(sbuf[:], uint64(scavenge.time))), " ms, retained: ",
+					(memstats.heap_sys-memstats.heap_released)>>20, ", goal: ", memstats.heap_retain_goal>>20, " (MB)\n")
+			}
+			scavenge.released = 0
+			scavenge.time = 0
+
+			lock(&scavenge.lock)
+			scavenge.parked = true
+			goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+			continue
+		}
+
+		// Sleep so that the time spent releasing memory is at most
+		// scavengePercent of the total.
+		sleep := crit * (100/scavengePercent - 1)
+		if sleep < scavengeMinSleep {
+			sleep = scavengeMinSleep
+		}
+		timeSleep(sleep)
+	}
+}
+
+// scavengeStep releases up to about nbytes of idle memory to the OS,
+// but no more than needed to bring retained memory down to the
+// background scavenger's goal. It returns the number of bytes released
+// and whether retained memory is at the goal.
+func (h *mheap) scavengeStep(nbytes uintptr) (released uintptr, done bool) {
+	// The statistics are read without the heap lock, so this is
+	// approximate, but there is no harm in releasing a little too
+	// much or too little.
+	retained := memstats.heap_sys - memstats.heap_released
+	goal := memstats.heap_retain_goal
+	if retained <= goal {
+		return 0, true
+	}
+	if need := retained - goal; need < uint64(nbytes) {
+		nbytes = uintptr(need)
+	}
+	released = h.scavengeBytes(nbytes)
+	atomic.Xadd64(&memstats.heap_scavenged, int64(released))
+	return released, false
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestScavengerGoal(t *testing.T) {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	want := m.NextGC + m.NextGC/100*runtime.RetainExtraPercent
+	if m.HeapRetainedGoal != want {
+		t.Errorf("HeapRetainedGoal = %d with NextGC %d, want %d", m.HeapRetainedGoal, m.NextGC, want)
+	}
+
+	// With the GC off there is no heap goal and so nothing to
+	// scavenge towards.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	if m.HeapRetainedGoal != ^uint64(0) {
+		t.Errorf("HeapRetainedGoal = %d with the GC off, want no goal", m.HeapRetainedGoal)
+	}
+}
+
+var scavengeSink [][]byte
+
+func TestScavengerReleasesSpike(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	scavenged := m.HeapScavenged
+
+	// Grow the heap well past its goal, then drop everything. The
+	// memory stays mapped and idle until someone releases it.
+	for i := 0; i < 64; i++ {
+		scavengeSink = append(scavengeSink, make([]byte, 1<<20))
+	}
+	scavengeSink = nil
+	runtime.GC()
+	runtime.GC()
+
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		runtime.ReadMemStats(&m)
+		retained := m.HeapSys - m.HeapReleased
+		if retained <= m.HeapRetainedGoal {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("retained heap %d MB still above the goal %d MB", retained>>20, m.HeapRetainedGoal>>20)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.HeapScavenged <= scavenged {
+		t.Errorf("HeapScavenged did not grow: %d, then %d", scavenged, m.HeapScavenged)
+	}
+	if m.ScavengeTotalNs == 0 {
+		t.Errorf("ScavengeTotalNs = 0 after the scavenger ran")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Per-cycle GC statistics.
+//
+// At the end of every GC cycle, gcMarkTermination records what the
+// cycle did in a gcCycleStats: the phase times, the CPU time spent on
+// assists and by the background and idle mark workers, the heap sizes
+// and goal, the assist time of every P, and the trigger ratio endCycle
+// chose for the next cycle. The last gcStatsRing records are kept in a
+// ring buffer, which runtime/debug.ReadGCCycles reads. GODEBUG=gctrace=1
+// prints the same record.
+//
+// Goroutines waiting in waitGCCycle, such as the one runtime/debug
+// starts to run the callback registered with SetGCCycleCallback, are
+// woken after each record is added.
+
+package runtime
+
+// gcStatsRing is the number of cycles kept.
+const gcStatsRing = 256
+
+// gcCycleStats describes one completed GC cycle. All times are in
+// nanoseconds.
+type gcCycleStats struct {
+	num     uint32 // cycle number, as in memstats.numgc
+	forced  bool   // forced by the user
+	minor   bool   // a minor cycle of the generational mode
+	procs   int32  // GOMAXPROCS during the cycle
+	start   int64  // nanotime() at the start of sweep termination
+	endUnix int64  // end of mark termination, in Unix time
+	pause   int64  // total stop-the-world time
+
+	// nanotime() at the start of the concurrent mark phase, at the
+	// start of mark termination, and at its end.
+	tMark, tMarkTerm, tEnd int64
+
+	// CPU time of the phases. Mark time is split into assists,
+	// dedicated and fractional workers (bg) and idle workers.
+	sweepTermCPU, assistCPU, bgCPU, idleCPU, markTermCPU int64
+
+	// Heap size at the start of the cycle, at the start of mark
+	// termination, marked heap, and the heap goal.
+	heap0, heap1, heap2, heapGoal uint64
+
+	triggerRatio   float64 // trigger ratio for the next cycle
+	cpuFraction    float64 // memstats.gc_cpu_fraction after the cycle
+	assistTimePerP []int64 // mark assist time of each P
+}
+
+var gcstats struct {
+	lock    mutex
+	ring    [gcStatsRing]gcCycleStats
+	waiters guintptr // goroutines in waitGCCycle, linked through schedlink
+
+	// spare holds the assist times of the next record. It is
+	// only used with worldsema held.
+	spare []int64
+}
+
+// gcStatsPrealloc makes room for the per-P assist times of the cycle
+// that is starting, so that recording the cycle does not allocate. It
+// is called by gcStart with worldsema held, before the world is
+// stopped; allp cannot change until the cycle is recorded.
+func gcStatsPrealloc() {
+	if cap(gcstats.spare) < len(allp) {
+		gcstats.spare = make([]int64, len(allp))
+	}
+}
+
+// gcRecordCycle records the cycle that just finished and wakes the
+// goroutines in waitGCCycle. It is called by gcMarkTermination after
+// the world has been restarted, but before worldsema is released, so
+// work and gcController still describe the cycle.
+func gcRecordCycle(endUnix, sweepTermCPU, markTermCPU int64, triggerRatio float64) *gcCycleStats {
+	assist := gcstats.spare[:len(allp)]
+	for i, p := range allp {
+		assist[i] = p.gcAssistTimeCycle
+	}
+
+	lock(&gcstats.lock)
+	s := &gcstats.ring[memstats.numgc%gcStatsRing]
+	// The record being replaced gives its assist times to the
+	// next cycle.
+	gcstats.spare = s.assistTimePerP
+	*s = gcCycleStats{
+		num:            memstats.numgc,
+		forced:         work.userForced,
+		minor:          gcgen.minor,
+		procs:          work.maxprocs,
+		start:          work.tSweepTerm,
+		endUnix:        endUnix,
+		pause:          work.pauseNS,
+		tMark:          work.tMark,
+		tMarkTerm:      work.tMarkTerm,
+		tEnd:           work.tEnd,
+		sweepTermCPU:   sweepTermCPU,
+		assistCPU:      gcController.assistTime,
+		bgCPU:          gcController.dedicatedMarkTime + gcController.fractionalMarkTime,
+		idleCPU:        gcController.idleMarkTime,
+		markTermCPU:    markTermCPU,
+		heap0:          work.heap0,
+		heap1:          work.heap1,
+		heap2:          work.heap2,
+		heapGoal:       work.heapGoal,
+		triggerRatio:   triggerRatio,
+		cpuFraction:    memstats.gc_cpu_fraction,
+		assistTimePerP: assist,
+	}
+	waiters := gcstats.waiters.ptr()
+	gcstats.waiters = 0
+	unlock(&gcstats.lock)
+
+	if waiters != nil {
+		injectglist(waiters)
+	}
+	return s
+}
+
+// printGCCycle prints s in the format of GODEBUG=gctrace=1.
+func printGCCycle(s *gcCycleStats) {
+	util := int(s.cpuFraction * 100)
+
+	var sbuf [24]byte
+	printlock()
+	print("gc ", s.num,
+		" @", string(itoaDiv(sbuf[:], uint64(s.start-runtimeInitTime)/1e6, 3)), "s ",
+		util, "%: ")
+	prev := s.start
+	for i, ns := range []int64{s.tMark, s.tMarkTerm, s.tEnd} {
+		if i != 0 {
+			print("+")
+		}
+		print(string(fmtNSAsMS(sbuf[:], uint64(ns-prev))))
+		prev = ns
+	}
+	print(" ms clock, ")
+	for i, ns := range []int64{s.sweepTermCPU, s.assistCPU, s.bgCPU, s.idleCPU, s.markTermCPU} {
+		if i == 2 || i == 3 {
+			// Separate mark time components with /.
+			print("/")
+		} else if i != 0 {
+			print("+")
+		}
+		print(string(fmtNSAsMS(sbuf[:], uint64(ns))))
+	}
+	print(" ms cpu, ",
+		s.heap0>>20, "->", s.heap1>>20, "->", s.heap2>>20, " MB, ",
+		s.heapGoal>>20, " MB goal, ",
+		s.procs, " P")
+	if s.forced {
+		print(" (forced)")
+	}
+	if s.minor {
+		print(" (minor)")
+	}
+	print("\n")
+	printunlock()
+}
+
+// gcCycleFields is the number of uint64s that precede the per-P
+// assist times in a record returned by readGCCycles.
+const gcCycleFields = 21
+
+//go:linkname readGCCycles runtime/debug.readGCCycles
+func readGCCycles(buf *[]uint64, after uint32) {
+	p := (*buf)[:0]
+	lock(&gcstats.lock)
+	n := memstats.numgc
+	if n-after > gcStatsRing {
+		after = n - gcStatsRing
+	}
+	for num := after + 1; int32(num-n) <= 0; num++ {
+		s := &gcstats.ring[num%gcStatsRing]
+		if s.num != num {
+			continue
+		}
+		forced := uint64(0)
+		if s.forced {
+			forced = 1
+		}
+		p = append(p,
+			uint64(s.num), forced, uint64(s.procs),
+			uint64(s.endUnix-(s.tEnd-s.start)), uint64(s.endUnix), uint64(s.pause),
+			uint64(s.tMark-s.start), uint64(s.tMarkTerm-s.tMark), uint64(s.tEnd-s.tMarkTerm),
+			uint64(s.sweepTermCPU), uint64(s.assistCPU), uint64(s.bgCPU), uint64(s.idleCPU), uint64(s.markTermCPU),
+			s.heap0, s.heap1, s.heap2, s.heapGoal,
+			float64bits(s.triggerRatio), float64bits(s.cpuFraction),
+			uint64(len(s.assistTimePerP)))
+		for _, t := range s.assistTimePerP {
+			p = append(p, uint64(t))
+		}
+	}
+	unlock(&gcstats.lock)
+	*buf = p
+}
+
+// waitGCCycle blocks until a cycle after n has been recorded and
+// returns the number of the latest recorded cycle.
+//
+//go:linkname waitGCCycle runtime/debug.waitGCCycle
+func waitGCCycle(n uint32) uint32 {
+	gp := getg()
+	for {
+		lock(&gcstats.lock)
+		// memstats.numgc is incremented before the cycle is
+		// recorded; use the ring to see what is there.
+		last := gcstats.ring[memstats.numgc%gcStatsRing].num
+		if int32(last-n) > 0 {
+			unlock(&gcstats.lock)
+			return last
+		}
+		gp.schedlink = gcstats.waiters
+		gcstats.waiters.set(gp)
+		goparkunlock(&gcstats.lock, waitReasonWaitForGCCycle, traceEvGoBlock, 1)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestReadGCCycles(t *testing.T) {
+	runtime.GC()
+	before := debug.ReadGCCycles(nil)
+	if len(before) == 0 {
+		t.Fatal("no cycles recorded after runtime.GC")
+	}
+	start := time.Now()
+	runtime.GC()
+	runtime.GC()
+	cycles := debug.ReadGCCycles(nil)
+	if len(cycles) > 256 {
+		t.Fatalf("%d cycles kept, want at most 256", len(cycles))
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	last := cycles[len(cycles)-1]
+	if last.Num != m.NumGC {
+		t.Errorf("last cycle is %d, NumGC is %d", last.Num, m.NumGC)
+	}
+	for i, c := range cycles {
+		if i > 0 && c.Num != cycles[i-1].Num+1 {
+			t.Errorf("cycle %d follows cycle %d", c.Num, cycles[i-1].Num)
+		}
+		if c.Procs <= 0 || len(c.AssistCPUPerP) != c.Procs {
+			t.Errorf("cycle %d: %d procs, %d assist times", c.Num, c.Procs, len(c.AssistCPUPerP))
+		}
+		if c.End.Before(c.Start) || c.SweepTerm < 0 || c.Mark < 0 || c.MarkTerm < 0 {
+			t.Errorf("cycle %d: bad phase times %+v", c.Num, c)
+		}
+		if c.Pause <= 0 || c.Pause > c.End.Sub(c.Start) {
+			t.Errorf("cycle %d: pause %v in a cycle of %v", c.Num, c.Pause, c.End.Sub(c.Start))
+		}
+	}
+	for _, c := range cycles[len(cycles)-2:] {
+		if !c.Forced {
+			t.Errorf("cycle %d, started by runtime.GC, not forced", c.Num)
+		}
+		if c.Start.Before(start.Add(-time.Second)) {
+			t.Errorf("cycle %d started at %v, before the test", c.Num, c.Start)
+		}
+	}
+}
+
+func TestReadGCCyclesProcs(t *testing.T) {
+	procs := runtime.GOMAXPROCS(-1)
+	defer runtime.GOMAXPROCS(procs)
+	for _, n := range []int{procs + 2, 1} {
+		runtime.GOMAXPROCS(n)
+		runtime.GC()
+		cycles := debug.ReadGCCycles(nil)
+		c := cycles[len(cycles)-1]
+		if c.Procs != n || len(c.AssistCPUPerP) != n {
+			t.Errorf("GOMAXPROCS=%d: cycle records %d procs, %d assist times", n, c.Procs, len(c.AssistCPUPerP))
+		}
+	}
+}
+
+func TestGCCycleRecordNoAlloc(t *testing.T) {
+	// Recording a cycle reuses the buffers of the record it
+	// replaces, so a forced GC allocates nothing.
+	runtime.GC()
+	if n := testing.AllocsPerRun(20, runtime.GC); n >= 1 {
+		t.Errorf("runtime.GC allocated %v times per cycle", n)
+	}
+}
+
+// TestSetGCCycleCallback must run last in this file: the goroutine that
+// calls the callback keeps running, and allocating, after the test.
+func TestSetGCCycleCallback(t *testing.T) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	got := make(chan debug.GCCycle, 10)
+	debug.SetGCCycleCallback(func(c debug.GCCycle) {
+		select {
+		case got <- c:
+		default:
+		}
+	})
+	defer debug.SetGCCycleCallback(nil)
+
+	// The callback goroutine starts with the cycles after the
+	// latest one when it starts, so keep collecting until it
+	// reports one.
+	timeout := time.After(10 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case c := <-got:
+			if c.Num <= m.NumGC {
+				t.Fatalf("callback called for cycle %d, before it was set in cycle %d", c.Num, m.NumGC)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("callback not called")
+		}
+	}
+}
@@ -210,6 +210,12 @@ func (s *mspan) sweep(preserve bool) bool {
 	// since the last GC.
 	// This situation is analogous to being on a freelist.
 
+	// A minor cycle only marked the young generation. Keep the
+	// objects that survived the previous cycle.
+	if gcgen.minor {
+		s.keepOld()
+	}
+
 	// Unlink & free special records for any objects we're about to free.
 	// Two complications here:
 	// 1. An object can have both finalizer and profile special records.
@@ -218,6 +224,10 @@ func (s *mspan) sweep(preserve bool) bool {
 	// 2. A tiny object can have several finalizers setup for different offsets.
 	//    If such object is not marked, we need to queue all finalizers at once.
 	// Both 1 and 2 are possible at the same time.
+	// Weak handles are cleared along with queueing the finalizers, so weak
+	// pointers to an object are nil by the time its finalizer runs, even
+	// if the finalizer resurrects it. Cleanups, like profile records, are
+	// kept until the object is actually freed.
 	specialp := &s.specials
 	special := *specialp
 	for special != nil {
@@ -238,12 +248,12 @@ func (s *mspan) sweep(preserve bool) bool {
 					break
 				}
 			}
-			// Pass 2: queue all finalizers _or_ handle profile record.
+			// Pass 2: queue all finalizers and clear weak handles _or_ handle profile record.
 			for special != nil && uintptr(special.offset) < endOffset {
 				// Find the exact byte for which the special was setup
 				// (as opposed to object beginning).
 				p := s.base() + uintptr(special.offset)
-				if special.kind == _KindSpecialFinalizer || !hasFin {
+				if special.kind == _KindSpecialFinalizer || special.kind == _KindSpecialWeak || !hasFin {
 					// Splice out special record.
 					y := special
 					special = special.next
@@ -332,7 +342,8 @@ func (s *mspan) sweep(preserve bool) bool {
 	}
 
 	if nfreed > 0 && spc.sizeclass() != 0 {
-		c.local_nsmallfree[spc.sizeclass()] += uintptr(nfreed)
+		i := spc.sizeclass()
+		atomic.Storeuintptr(&c.local_nsmallfree[i], c.local_nsmallfree[i]+uintptr(nfreed))
 		res = mheap_.central[spc].mcentral.freeSpan(s, preserve, wasempty)
 		// MCentral_FreeSpan updates sweepgen
 	} else if freeToHeap {
@@ -358,8 +369,8 @@ func (s *mspan) sweep(preserve bool) bool {
 		} else {
 			mheap_.freeSpan(s, 1)
 		}
-		c.local_nlargefree++
-		c.local_largefree += size
+		atomic.Storeuintptr(&c.local_nlargefree, c.local_nlargefree+1)
+		atomic.Storeuintptr(&c.local_largefree, c.local_largefree+size)
 		res = true
 	}
 	if !res {
@@ -111,6 +111,15 @@ type mheap struct {
 	// will never be nil.
 	arenas [1 << arenaL1Bits]*[1 << arenaL2Bits]*heapArena
 
+	// allArenas is the arenaIndex of every mapped arena. This can
+	// be used to iterate through the address space.
+	//
+	// Access is protected by mheap_.lock. However, since this is
+	// append-only and old backing arrays are never freed, it is
+	// safe to acquire mheap_.lock, copy the slice header, and
+	// then release mheap_.lock.
+	allArenas []arenaIdx
+
 	// heapArenaAlloc is pre-reserved space for allocating heapArena
 	// objects. This is only used on 32-bit, where we pre-reserve
 	// this space to avoid interleaving it with the heap itself.
@@ -143,6 +152,9 @@ type mheap struct {
 	treapalloc            fixalloc // allocator for treapNodes* used by large objects
 	specialfinalizeralloc fixalloc // allocator for specialfinalizer*
 	specialprofilealloc   fixalloc // allocator for specialprofile*
+	specialallochookalloc fixalloc // allocator for specialallochook*
+	specialweakalloc      fixalloc // allocator for specialweakhandle*
+	specialcleanupalloc   fixalloc // allocator for specialcleanup*
 	speciallock           mutex    // lock for special record allocators.
 	arenaHintAlloc        fixalloc // allocator for arenaHints
 
@@ -170,6 +182,11 @@ type heapArena struct {
 	// 但仅限于那些已知包含正在使用的 span 或栈 span。
 	// 也就是说在确定地址是活跃的 和 在 span 数组中查找地址之间是不安全的。
 	spans [pagesPerArena]*mspan
+
+	// cards is the card table of the generational mode. Bit i
+	// is set if a pointer to the i'th gcCardBytes of this arena
+	// was written outside of a GC cycle. See mgcgen.go.
+	cards [heapArenaCards / 8]uint8
 }
 
 // arenaHint 是一个用于增长 heap arena 的 hint，见 mheap_.arenaHints
@@ -311,6 +328,7 @@ type mspan struct { // 双向链表
 	incache     bool       // being used by an mcache
 	state       mSpanState // mspaninuse etc
 	needzero    uint8      // needs to be zeroed before allocation
+	userArena   bool       // _MSpanManual chunk of a user arena (see arena.go)
 	divShift    uint8      // for divide by elemsize - divMagic.shift
 	divShift2   uint8      // for divide by elemsize - divMagic.shift2
 	elemsize    uintptr    // computed from sizeclass or from npages
@@ -319,6 +337,10 @@ type mspan struct { // 双向链表
 	limit       uintptr    // end of data in span
 	speciallock mutex      // guards specials list
 	specials    *special   // linked list of special records sorted by offset.
+
+	// types records the type of each object for heap dumps, if the
+	// span was allocated with GODEBUG=heaptypes=1. See heaptypes.go.
+	types *spanTypes
 }
 
 func (s *mspan) base() uintptr {
@@ -541,6 +563,9 @@ func (h *mheap) init() {
 	h.cachealloc.init(unsafe.Sizeof(mcache{}), nil, nil, &memstats.mcache_sys)
 	h.specialfinalizeralloc.init(unsafe.Sizeof(specialfinalizer{}), nil, nil, &memstats.other_sys)
 	h.specialprofilealloc.init(unsafe.Sizeof(specialprofile{}), nil, nil, &memstats.other_sys)
+	h.specialallochookalloc.init(unsafe.Sizeof(specialallochook{}), nil, nil, &memstats.other_sys)
+	h.specialweakalloc.init(unsafe.Sizeof(specialweakhandle{}), nil, nil, &memstats.other_sys)
+	h.specialcleanupalloc.init(unsafe.Sizeof(specialcleanup{}), nil, nil, &memstats.other_sys)
 	h.arenaHintAlloc.init(unsafe.Sizeof(arenaHint{}), nil, nil, &memstats.other_sys)
 
 	// Don't zero mspan allocations. Background sweeping can
@@ -696,6 +721,9 @@ func (h *mheap) alloc_m(npage uintptr, spanclass spanClass, large bool) *mspan {
 			s.divShift2 = m.shift2
 			s.baseMask = m.baseMask
 		}
+		if debug.heaptypes != 0 {
+			s.types = h.allocSpanTypes(s)
+		}
 
 		// update stats, sweep lists
 		h.pagesInUse += uint64(npage)
@@ -979,6 +1007,9 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 			throw("MHeap_FreeSpanLocked - invalid free")
 		}
 		h.pagesInUse -= uint64(s.npages)
+		if s.types != nil {
+			h.freeSpanTypes(s)
+		}
 	default:
 		throw("MHeap_FreeSpanLocked - invalid span state")
 	}
@@ -1056,37 +1087,45 @@ func (h *mheap) busyList(npages uintptr) *mSpanList {
 	return &h.busylarge
 }
 
+// scavenge returns the pages of free span s that have not been
+// released yet to the OS and reports how many bytes it released.
+//
+// h.lock must be held.
+func (s *mspan) scavenge() uintptr {
+	start := s.base()
+	end := start + s.npages<<_PageShift
+	if physPageSize > _PageSize {
+		// We can only release pages in
+		// physPageSize blocks, so round start
+		// and end in. (Otherwise, madvise
+		// will round them *out* and release
+		// more memory than we want.)
+		start = (start + physPageSize - 1) &^ (physPageSize - 1)
+		end &^= physPageSize - 1
+		if end <= start {
+			// start and end don't span a
+			// whole physical page.
+			return 0
+		}
+	}
+	len := end - start
+
+	released := len - (s.npreleased << _PageShift)
+	if physPageSize > _PageSize && released == 0 {
+		return 0
+	}
+	memstats.heap_released += uint64(released)
+	s.npreleased = len >> _PageShift
+	sysUnused(unsafe.Pointer(start), len)
+	return released
+}
+
 func scavengeTreapNode(t *treapNode, now, limit uint64) uintptr {
 	s := t.spanKey
-	var sumreleased uintptr
 	if (now-uint64(s.unusedsince)) > limit && s.npreleased != s.npages {
-		start := s.base()
-		end := start + s.npages<<_PageShift
-		if physPageSize > _PageSize {
-			// We can only release pages in
-			// physPageSize blocks, so round start
-			// and end in. (Otherwise, madvise
-			// will round them *out* and release
-			// more memory than we want.)
-			start = (start + physPageSize - 1) &^ (physPageSize - 1)
-			end &^= physPageSize - 1
-			if end <= start {
-				// start and end don't span a
-				// whole physical page.
-				return sumreleased
-			}
-		}
-		len := end - start
-		released := len - (s.npreleased << _PageShift)
-		if physPageSize > _PageSize && released == 0 {
-			return sumreleased
-		}
-		memstats.heap_released += uint64(released)
-		sumreleased += released
-		s.npreleased = len >> _PageShift
-		sysUnused(unsafe.Pointer(start), len)
+		return s.scavenge()
 	}
-	return sumreleased
+	return 0
 }
 
 func scavengelist(list *mSpanList, now, limit uint64) uintptr {
@@ -1099,32 +1138,7 @@ func scavengelist(list *mSpanList, now, limit uint64) uintptr {
 		if (now-uint64(s.unusedsince)) <= limit || s.npreleased == s.npages {
 			continue
 		}
-		start := s.base()
-		end := start + s.npages<<_PageShift
-		if physPageSize > _PageSize {
-			// We can only release pages in
-			// physPageSize blocks, so round start
-			// and end in. (Otherwise, madvise
-			// will round them *out* and release
-			// more memory than we want.)
-			start = (start + physPageSize - 1) &^ (physPageSize - 1)
-			end &^= physPageSize - 1
-			if end <= start {
-				// start and end don't span a
-				// whole physical page.
-				continue
-			}
-		}
-		len := end - start
-
-		released := len - (s.npreleased << _PageShift)
-		if physPageSize > _PageSize && released == 0 {
-			continue
-		}
-		memstats.heap_released += uint64(released)
-		sumreleased += released
-		s.npreleased = len >> _PageShift
-		sysUnused(unsafe.Pointer(start), len)
+		sumreleased += s.scavenge()
 	}
 	return sumreleased
 }
@@ -1152,6 +1166,28 @@ func (h *mheap) scavenge(k int32, now, limit uint64) {
 	}
 }
 
+// scavengeBytes returns free memory to the OS until at least nbytes
+// have been released or there is nothing left to release, regardless
+// of how long the memory has been unused. It prefers large spans,
+// which take fewer system calls per byte. It returns the number of
+// bytes released.
+func (h *mheap) scavengeBytes(nbytes uintptr) uintptr {
+	gp := getg()
+	gp.m.mallocing++
+	lock(&h.lock)
+	released := scavengetreapBytes(h.freelarge.treap, nbytes)
+	for i := len(h.free) - 1; i >= 0 && released < nbytes; i-- {
+		for s := h.free[i].first; s != nil && released < nbytes; s = s.next {
+			if s.npreleased != s.npages {
+				released += s.scavenge()
+			}
+		}
+	}
+	unlock(&h.lock)
+	gp.m.mallocing--
+	return released
+}
+
 //go:linkname runtime_debug_freeOSMemory runtime/debug.freeOSMemory
 func runtime_debug_freeOSMemory() {
 	GC()
@@ -1179,6 +1215,7 @@ func (span *mspan) init(base uintptr, npages uintptr) {
 	span.freeindex = 0
 	span.allocBits = nil
 	span.gcmarkBits = nil
+	span.types = nil
 }
 
 func (span *mspan) inList() bool {
@@ -1279,6 +1316,9 @@ func (list *mSpanList) takeAll(other *mSpanList) {
 const (
 	_KindSpecialFinalizer = 1
 	_KindSpecialProfile   = 2
+	_KindSpecialAllocHook = 3
+	_KindSpecialWeak      = 4
+	_KindSpecialCleanup   = 5
 	// Note: The finalizer special must be first because if we're freeing
 	// an object, a finalizer special will cause the freeing operation
 	// to abort, and we want to keep the other special records around
@@ -1297,7 +1337,8 @@ type special struct {
 // offset & next, which this routine will fill in.
 // Returns true if the special was successfully added, false otherwise.
 // (The add will fail only if a record with the same p and s->kind
-//  already exists.)
+//  already exists, and never for cleanups, of which an object may
+//  have several.)
 func addspecial(p unsafe.Pointer, s *special) bool {
 	span := spanOfHeap(uintptr(p))
 	if span == nil {
@@ -1322,7 +1363,7 @@ func addspecial(p unsafe.Pointer, s *special) bool {
 		if x == nil {
 			break
 		}
-		if offset == uintptr(x.offset) && kind == x.kind {
+		if offset == uintptr(x.offset) && kind == x.kind && kind != _KindSpecialCleanup {
 			unlock(&span.speciallock)
 			releasem(mp)
 			return false // already exists
@@ -1467,6 +1508,163 @@ func setprofilebucket(p unsafe.Pointer, b *bucket) {
 	}
 }
 
+// The described object was sampled by the allocation hook.
+//
+//go:notinheap
+type specialallochook struct {
+	special special
+	typ     *_type
+}
+
+// Set the allocation hook special of p, so that its free is reported.
+func setallochook(p unsafe.Pointer, typ *_type) {
+	lock(&mheap_.speciallock)
+	s := (*specialallochook)(mheap_.specialallochookalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialAllocHook
+	s.typ = typ
+	if !addspecial(p, &s.special) {
+		throw("setallochook: allocation hook already set")
+	}
+}
+
+// The described object has weak pointers to it.
+//
+// The handle is a heap object that holds the object's address as a
+// uintptr, so the GC does not follow it. It is shared by all weak
+// pointers to the object and is kept alive by markrootSpans, since the
+// special isn't part of the GC'd heap. The sweeper clears it when the
+// object becomes unreachable.
+//
+//go:notinheap
+type specialweakhandle struct {
+	special special
+	handle  *uintptr // May be a heap pointer.
+}
+
+// getWeakHandle returns the weak handle of the object p, adding one if
+// there is none and add is set. It returns nil if there is none and add
+// is not set.
+func getWeakHandle(p unsafe.Pointer, add bool) *uintptr {
+	span := spanOfHeap(uintptr(p))
+	if span == nil {
+		throw("getWeakHandle on invalid pointer")
+	}
+	mp := acquirem()
+	span.ensureSwept()
+	offset := uintptr(p) - span.base()
+	lock(&span.speciallock)
+	for s := span.specials; s != nil; s = s.next {
+		if offset == uintptr(s.offset) && s.kind == _KindSpecialWeak {
+			h := (*specialweakhandle)(unsafe.Pointer(s)).handle
+			unlock(&span.speciallock)
+			releasem(mp)
+			return h
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+	if !add {
+		return nil
+	}
+
+	// Allocate the handle before the special, since new may
+	// sweep and GC. While the GC is marking, the handle is
+	// allocated black, so it needn't be scanned here as
+	// addfinalizer scans the finalizer.
+	h := new(uintptr)
+	*h = uintptr(p)
+	lock(&mheap_.speciallock)
+	s := (*specialweakhandle)(mheap_.specialweakalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialWeak
+	s.handle = h
+	if addspecial(p, &s.special) {
+		return h
+	}
+
+	// Another goroutine added a handle first. Use it.
+	lock(&mheap_.speciallock)
+	mheap_.specialweakalloc.free(unsafe.Pointer(s))
+	unlock(&mheap_.speciallock)
+	return getWeakHandle(p, false)
+}
+
+// The described object has a cleanup function to run once it is freed.
+//
+// specialcleanup is allocated from non-GC'd memory, so fn, which is a
+// heap pointer, is kept alive by markrootSpans.
+//
+//go:notinheap
+type specialcleanup struct {
+	special special
+	fn      *funcval // May be a heap pointer.
+	id      uint64   // identifies the cleanup for removecleanup
+}
+
+// cleanupID is the id of the last cleanup added. Protected by
+// mheap_.speciallock.
+var cleanupID uint64
+
+// Adds a cleanup to the object p and returns its id.
+func addcleanup(p unsafe.Pointer, f *funcval) uint64 {
+	lock(&mheap_.speciallock)
+	s := (*specialcleanup)(mheap_.specialcleanupalloc.alloc())
+	cleanupID++
+	id := cleanupID
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialCleanup
+	s.fn = f
+	s.id = id
+	addspecial(p, &s.special)
+	// This is responsible for maintaining the same GC-related
+	// invariants as markrootSpans in any situation where it's
+	// possible that markrootSpans has already run but mark
+	// termination hasn't yet. Unlike a finalizer, a cleanup does
+	// not retain the object.
+	if gcphase != _GCoff {
+		mp := acquirem()
+		gcw := &mp.p.ptr().gcw
+		scanblock(uintptr(unsafe.Pointer(&s.fn)), sys.PtrSize, &oneptrmask[0], gcw)
+		if gcBlackenPromptly {
+			gcw.dispose()
+		}
+		releasem(mp)
+	}
+	return id
+}
+
+// Removes the cleanup with the given id from the object at p, if it
+// has not run yet. p need not refer to a live object.
+func removecleanup(p uintptr, id uint64) {
+	span := spanOfHeap(p)
+	if span == nil {
+		return
+	}
+	mp := acquirem()
+	span.ensureSwept()
+	offset := p - span.base()
+	var found *specialcleanup
+	lock(&span.speciallock)
+	for t := &span.specials; *t != nil; t = &(*t).next {
+		s := *t
+		if offset == uintptr(s.offset) && s.kind == _KindSpecialCleanup &&
+			(*specialcleanup)(unsafe.Pointer(s)).id == id {
+			*t = s.next
+			found = (*specialcleanup)(unsafe.Pointer(s))
+			break
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+	if found == nil {
+		return
+	}
+	lock(&mheap_.speciallock)
+	mheap_.specialcleanupalloc.free(unsafe.Pointer(found))
+	unlock(&mheap_.speciallock)
+}
+
 // Do whatever cleanup needs to be done to deallocate s. It has
 // already been unlinked from the MSpan specials list.
 func freespecial(s *special, p unsafe.Pointer, size uintptr) {
@@ -1483,6 +1681,25 @@ func freespecial(s *special, p unsafe.Pointer, size uintptr) {
 		lock(&mheap_.speciallock)
 		mheap_.specialprofilealloc.free(unsafe.Pointer(sp))
 		unlock(&mheap_.speciallock)
+	case _KindSpecialAllocHook:
+		sh := (*specialallochook)(unsafe.Pointer(s))
+		allocHookWrite(allocHookFree, uintptr(p), size, sh.typ, nil)
+		lock(&mheap_.speciallock)
+		mheap_.specialallochookalloc.free(unsafe.Pointer(sh))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialWeak:
+		sw := (*specialweakhandle)(unsafe.Pointer(s))
+		atomic.Storeuintptr(sw.handle, 0)
+		atomic.Xadd64(&weakHandlesCleared, 1)
+		lock(&mheap_.speciallock)
+		mheap_.specialweakalloc.free(unsafe.Pointer(sw))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialCleanup:
+		sc := (*specialcleanup)(unsafe.Pointer(s))
+		queuecleanup(sc.fn)
+		lock(&mheap_.speciallock)
+		mheap_.specialcleanupalloc.free(unsafe.Pointer(sc))
+		unlock(&mheap_.speciallock)
 	default:
 		throw("bad special kind")
 		panic("not reached")
@@ -718,6 +718,23 @@ func ThreadCreateProfile(p []StackRecord) (n int, ok bool) {
 // Most clients should use the runtime/pprof package instead
 // of calling GoroutineProfile directly.
 func GoroutineProfile(p []StackRecord) (n int, ok bool) {
+	return goroutineProfileWithLabels(p, nil)
+}
+
+// runtime_goroutineProfileWithLabels is like GoroutineProfile, but also
+// records the profiling labels of each goroutine in labels, which must
+// be nil or have the same length as p. labels[i] is the value passed
+// to runtime_setProfLabel for the goroutine recorded in p[i].
+//
+//go:linkname runtime_goroutineProfileWithLabels runtime/pprof.runtime_goroutineProfileWithLabels
+func runtime_goroutineProfileWithLabels(p []StackRecord, labels []unsafe.Pointer) (n int, ok bool) {
+	return goroutineProfileWithLabels(p, labels)
+}
+
+func goroutineProfileWithLabels(p []StackRecord, labels []unsafe.Pointer) (n int, ok bool) {
+	if labels != nil && len(labels) != len(p) {
+		labels = nil
+	}
 	gp := getg()
 
 	isOK := func(gp1 *g) bool {
@@ -746,6 +763,11 @@ func GoroutineProfile(p []StackRecord) (n int, ok bool) {
 			saveg(pc, sp, gp, &r[0])
 		})
 		r = r[1:]
+		lbl := labels
+		if lbl != nil {
+			lbl[0] = gp.labels
+			lbl = lbl[1:]
+		}
 
 		// Save other goroutines.
 		for _, gp1 := range allgs {
@@ -757,6 +779,10 @@ func GoroutineProfile(p []StackRecord) (n int, ok bool) {
 				}
 				saveg(^uintptr(0), ^uintptr(0), gp1, &r[0])
 				r = r[1:]
+				if lbl != nil {
+					lbl[0] = gp1.labels
+					lbl = lbl[1:]
+				}
 			}
 		}
 	}
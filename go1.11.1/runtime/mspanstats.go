@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Span class utilization.
+//
+// readSpanClassStats, behind runtime/debug.ReadSpanClassStats, reports
+// for each span class how many spans are in use, how many objects they
+// can hold and how many are allocated, and how much memory is lost to
+// internal fragmentation: at the end of spans (tail waste, fixed per
+// size class, see sizeclasses.go) and in objects larger than the size
+// requested (rounding waste).
+//
+// The spans are walked with the world stopped, in batches, so that a
+// large heap does not cause a long pause. Spans change between batches,
+// so the report is not a consistent snapshot, but each span is counted
+// once as it was when visited. Allocated objects are counted from the
+// allocation bitmaps, so they include garbage not yet swept.
+//
+// The size requested for an object is not recorded, so rounding waste
+// is reported as an upper bound, computed during the walk: an object of
+// a size class was requested at least one byte more than the size of
+// the class below, and a large object at least one byte more than its
+// span less a page. Nothing is counted on the allocation path.
+
+package runtime
+
+// spanClassStatsFields is the number of uint64s per span class in the
+// buffer filled in by readSpanClassStats. runtime/debug gets it from
+// readSpanClassStats rather than keeping a copy.
+const spanClassStatsFields = 11
+
+type spanClassStats struct {
+	spans          uint64 // spans in use
+	cached         uint64 // spans cached by an mcache
+	bytes          uint64 // bytes in spans
+	objects        uint64 // object slots
+	allocated      uint64 // allocated objects
+	tailWaste      uint64 // bytes after the last object slot
+	allocatedBytes uint64 // bytes in allocated objects
+	roundWasteMax  uint64 // upper bound of the rounding waste of allocated objects
+}
+
+// readSpanClassStats fills buf with the statistics of every span class,
+// spanClassStatsFields values each, looking at batch spans per
+// stop-the-world pause, or all at once if batch <= 0. It returns
+// spanClassStatsFields.
+//
+//go:linkname readSpanClassStats runtime/debug.readSpanClassStats
+func readSpanClassStats(buf *[]uint64, batch int) (fields int) {
+	stats := new([numSpanClasses]spanClassStats)
+	for i := 0; ; {
+		stopTheWorld("span class stats")
+		// allspans may be reallocated while the world runs, so
+		// index it afresh each time.
+		n := len(mheap_.allspans)
+		end := n
+		if batch > 0 && i+batch < n {
+			end = i + batch
+		}
+		for ; i < end; i++ {
+			s := mheap_.allspans[i]
+			if s.state != mSpanInUse {
+				continue
+			}
+			st := &stats[s.spanclass]
+			st.spans++
+			if s.incache {
+				st.cached++
+			}
+			nbytes := s.npages << _PageShift
+			st.bytes += uint64(nbytes)
+			st.objects += uint64(s.nelems)
+			nalloc := s.allocatedObjects()
+			st.allocated += uint64(nalloc)
+			st.tailWaste += uint64(nbytes - s.nelems*s.elemsize)
+			st.allocatedBytes += uint64(nalloc * s.elemsize)
+			st.roundWasteMax += uint64(nalloc * (s.elemsize - minRequestSize(s)))
+		}
+		startTheWorld()
+		if i >= n {
+			break
+		}
+	}
+
+	p := (*buf)[:0]
+	for i := range stats {
+		st := &stats[i]
+		if st.spans == 0 {
+			continue
+		}
+		spc := spanClass(i)
+		noscan := uint64(0)
+		if spc.noscan() {
+			noscan = 1
+		}
+		p = append(p,
+			uint64(spc.sizeclass()), noscan, uint64(class_to_size[spc.sizeclass()]),
+			st.spans, st.cached, st.bytes, st.objects, st.allocated, st.tailWaste,
+			st.allocatedBytes, st.roundWasteMax)
+	}
+	*buf = p
+	return spanClassStatsFields
+}
+
+// minRequestSize returns the smallest allocation that can have been
+// rounded up to the objects of in-use span s.
+func minRequestSize(s *mspan) uintptr {
+	if c := s.spanclass.sizeclass(); c != 0 {
+		return uintptr(class_to_size[c-1]) + 1
+	}
+	if s.elemsize < _PageSize {
+		return 1
+	}
+	return s.elemsize - _PageSize + 1
+}
+
+// allocatedObjects returns the number of objects in s that are allocated
+// according to its allocation bitmap.
+func (s *mspan) allocatedObjects() uintptr {
+	n := s.freeindex
+	i := s.freeindex
+	for ; i < s.nelems && i%8 != 0; i++ {
+		if !s.isFree(i) {
+			n++
+		}
+	}
+	for ; i+8 <= s.nelems; i += 8 {
+		n += uintptr(oneBitCount[*s.allocBits.bytep(i / 8)])
+	}
+	for ; i < s.nelems; i++ {
+		if !s.isFree(i) {
+			n++
+		}
+	}
+	return n
+}
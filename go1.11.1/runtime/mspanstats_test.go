@@ -0,0 +1,322 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+var spanStatsSink [][]byte
+
+func TestSpanClassStatsLayout(t *testing.T) {
+	buf, fields := runtime.ReadSpanClassStatsRaw(0)
+	if fields < 11 || len(buf) == 0 || len(buf)%fields != 0 {
+		t.Fatalf("%d values, %d per span class", len(buf), fields)
+	}
+	for ; len(buf) > 0; buf = buf[fields:] {
+		f := buf[:fields]
+		class, size, bytes, objects, allocated, tail := f[0], f[2], f[5], f[6], f[7], f[8]
+		allocBytes, roundWaste := f[9], f[10]
+		if f[1] > 1 {
+			t.Errorf("class %d: noscan is %d", class, f[1])
+		}
+		if roundWaste > allocBytes {
+			t.Errorf("class %d: rounding waste %d in %d allocated bytes", class, roundWaste, allocBytes)
+		}
+		if class == 0 {
+			if size != 0 {
+				t.Errorf("large objects have size %d", size)
+			}
+			continue
+		}
+		if allocBytes != allocated*size {
+			t.Errorf("class %d: %d allocated bytes for %d objects of %d bytes", class, allocBytes, allocated, size)
+		}
+		if objects*size+tail != bytes {
+			t.Errorf("class %d: %d objects of %d bytes and %d tail bytes in %d span bytes",
+				class, objects, size, tail, bytes)
+		}
+	}
+}
+
+func TestReadSpanClassStats(t *testing.T) {
+	// 40 bytes rounds up to the 48 byte size class, which holds
+	// allocations of 33 bytes and more.
+	const n, size, objSize, minSize = 1 << 12, 40, 48, 33
+	find := func(stats []debug.SpanClassStats) debug.SpanClassStats {
+		for _, s := range stats {
+			if s.ObjectSize == objSize && s.NoScan {
+				return s
+			}
+		}
+		return debug.SpanClassStats{}
+	}
+
+	for i := 0; i < n; i++ {
+		spanStatsSink = append(spanStatsSink, make([]byte, size))
+	}
+	for _, batch := range []int{0, 16} {
+		stats := debug.ReadSpanClassStats(nil, batch)
+		s := find(stats)
+		if s.Allocated < n || s.Objects < s.Allocated {
+			t.Errorf("batch %d: %d of %d objects allocated, want at least %d", batch, s.Allocated, s.Objects, n)
+		}
+		if s.AllocatedBytes != s.Allocated*objSize {
+			t.Errorf("batch %d: %d allocated bytes for %d objects", batch, s.AllocatedBytes, s.Allocated)
+		}
+		if s.RoundingWaste != s.Allocated*(objSize-minSize) {
+			t.Errorf("batch %d: rounding waste %d for %d objects, want %d", batch, s.RoundingWaste, s.Allocated, s.Allocated*(objSize-minSize))
+		}
+		if s.FreeBytes != (s.Objects-s.Allocated)*objSize {
+			t.Errorf("batch %d: %d free bytes for %d free objects", batch, s.FreeBytes, s.Objects-s.Allocated)
+		}
+		if s.CachedSpans > s.Spans {
+			t.Errorf("batch %d: %d of %d spans cached", batch, s.CachedSpans, s.Spans)
+		}
+		for i := 1; i < len(stats); i++ {
+			a, b := stats[i-1], stats[i]
+			if a.SizeClass > b.SizeClass || a.SizeClass == b.SizeClass && a.NoScan == b.NoScan {
+				t.Errorf("batch %d: class %d/%v before %d/%v", batch, a.SizeClass, a.NoScan, b.SizeClass, b.NoScan)
+			}
+		}
+	}
+	spanStatsSink = nil
+}
+
+func TestWriteSpanClassProfile(t *testing.T) {
+	stats := debug.ReadSpanClassStats(nil, 0)
+	var buf bytes.Buffer
+	if err := debug.WriteSpanClassProfile(&buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	p, err := decodeProfile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTypes := []string{"span_space/bytes", "spans/count", "objects/count", "inuse_objects/count",
+		"free_space/bytes", "tail_waste/bytes", "rounding_waste/bytes"}
+	if fmt.Sprint(p.sampleTypes) != fmt.Sprint(wantTypes) {
+		t.Errorf("sample types %v, want %v", p.sampleTypes, wantTypes)
+	}
+	if p.defaultType != "span_space" {
+		t.Errorf("default sample type %q, want span_space", p.defaultType)
+	}
+	if len(p.samples) != len(stats) {
+		t.Fatalf("%d samples for %d span classes", len(p.samples), len(stats))
+	}
+	for i, s := range stats {
+		ps := p.samples[i]
+		want := []int64{int64(s.SpanBytes), int64(s.Spans), int64(s.Objects), int64(s.Allocated),
+			int64(s.FreeBytes), int64(s.TailWaste), int64(s.RoundingWaste)}
+		if fmt.Sprint(ps.values) != fmt.Sprint(want) {
+			t.Errorf("sample %d: values %v, want %v", i, ps.values, want)
+		}
+		kind := "scan"
+		if s.NoScan {
+			kind = "noscan"
+		}
+		if len(ps.stack) != 2 || ps.stack[1] != kind {
+			t.Errorf("sample %d: stack %q, want a class over %s", i, ps.stack, kind)
+		}
+		if ps.labels["size_class"] != int64(s.SizeClass) || ps.labels["object_size"] != int64(s.ObjectSize) {
+			t.Errorf("sample %d: labels %v for size class %d of %d bytes", i, ps.labels, s.SizeClass, s.ObjectSize)
+		}
+	}
+}
+
+// A decodedProfile is the part of a profile.proto message written by
+// the runtime/debug profile writers.
+type decodedProfile struct {
+	sampleTypes []string // type/unit
+	defaultType string
+	samples     []decodedSample
+}
+
+type decodedSample struct {
+	stack  []string // function names, leaf first
+	values []int64
+	labels map[string]int64 // numeric labels
+}
+
+// decodeProfile decodes an uncompressed profile whose locations each
+// have one line naming a function.
+func decodeProfile(data []byte) (*decodedProfile, error) {
+	var (
+		strs       []string
+		types      [][2]uint64
+		defType    uint64
+		rawSamples [][]byte
+		funcs      = map[uint64]uint64{} // function ID to name index
+		locs       = map[uint64]uint64{} // location ID to function ID
+	)
+	err := decodeMessage(data, func(tag int, x uint64, b []byte) error {
+		switch tag {
+		case 1: // sample_type
+			var vt [2]uint64
+			decodeMessage(b, func(tag int, x uint64, _ []byte) error {
+				if tag == 1 || tag == 2 {
+					vt[tag-1] = x
+				}
+				return nil
+			})
+			types = append(types, vt)
+		case 2: // sample
+			rawSamples = append(rawSamples, b)
+		case 4: // location
+			var id, fn uint64
+			decodeMessage(b, func(tag int, x uint64, b []byte) error {
+				switch tag {
+				case 1:
+					id = x
+				case 4:
+					decodeMessage(b, func(tag int, x uint64, _ []byte) error {
+						if tag == 1 {
+							fn = x
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			locs[id] = fn
+		case 5: // function
+			var id, name uint64
+			decodeMessage(b, func(tag int, x uint64, _ []byte) error {
+				switch tag {
+				case 1:
+					id = x
+				case 2:
+					name = x
+				}
+				return nil
+			})
+			funcs[id] = name
+		case 6: // string_table
+			strs = append(strs, string(b))
+		case 14: // default_sample_type
+			defType = x
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	str := func(i uint64) string {
+		if i < uint64(len(strs)) {
+			return strs[i]
+		}
+		return fmt.Sprintf("<bad string %d>", i)
+	}
+
+	p := &decodedProfile{defaultType: str(defType)}
+	for _, vt := range types {
+		p.sampleTypes = append(p.sampleTypes, str(vt[0])+"/"+str(vt[1]))
+	}
+	for _, raw := range rawSamples {
+		s := decodedSample{labels: map[string]int64{}}
+		err := decodeMessage(raw, func(tag int, x uint64, b []byte) error {
+			switch tag {
+			case 1: // location_id, packed
+				for _, id := range decodePacked(b) {
+					fn, ok := locs[id]
+					if !ok {
+						return fmt.Errorf("sample refers to unknown location %d", id)
+					}
+					s.stack = append(s.stack, str(funcs[fn]))
+				}
+			case 2: // value, packed
+				for _, v := range decodePacked(b) {
+					s.values = append(s.values, int64(v))
+				}
+			case 3: // label
+				var key, num uint64
+				decodeMessage(b, func(tag int, x uint64, _ []byte) error {
+					switch tag {
+					case 1:
+						key = x
+					case 3:
+						num = x
+					}
+					return nil
+				})
+				s.labels[str(key)] = int64(num)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.samples = append(p.samples, s)
+	}
+	return p, nil
+}
+
+// decodeMessage calls f for each field of the protocol buffer message
+// data, with the value of varint fields and the contents of
+// length-delimited ones.
+func decodeMessage(data []byte, f func(tag int, x uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := decodeVarint(data)
+		if n == 0 {
+			return fmt.Errorf("bad field key")
+		}
+		data = data[n:]
+		var x uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			x, n = decodeVarint(data)
+			if n == 0 {
+				return fmt.Errorf("bad varint in field %d", key>>3)
+			}
+			data = data[n:]
+		case 2:
+			l, n := decodeVarint(data)
+			if n == 0 || uint64(len(data)-n) < l {
+				return fmt.Errorf("bad length in field %d", key>>3)
+			}
+			b = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return fmt.Errorf("unexpected wire type %d in field %d", key&7, key>>3)
+		}
+		if err := f(int(key>>3), x, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeVarint(data []byte) (uint64, int) {
+	var x uint64
+	for i, c := range data {
+		if i == 10 {
+			break
+		}
+		x |= uint64(c&0x7f) << (7 * uint(i))
+		if c < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func decodePacked(data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		x, n := decodeVarint(data)
+		if n == 0 {
+			break
+		}
+		xs = append(xs, x)
+		data = data[n:]
+	}
+	return xs
+}
@@ -42,6 +42,11 @@ type mstats struct {
 	heap_released uint64 // bytes released to the os
 	heap_objects  uint64 // total number of allocated objects
 
+	// Statistics about the background scavenger.
+	heap_scavenged    uint64 // bytes released to the os by the background scavenger; updated atomically
+	heap_retain_goal  uint64 // background scavenger's goal for heap_sys - heap_released
+	scavenge_total_ns uint64 // time spent in the background scavenger; updated atomically
+
 	// TODO(austin): heap_released is both useless and inaccurate
 	// in its current form. It's useless because, from the user's
 	// and OS's perspectives, there's no difference between a page
@@ -282,6 +287,28 @@ type MemStats struct {
 	// freed.
 	HeapObjects uint64
 
+	// HeapScavenged is the cumulative bytes of physical memory
+	// returned to the OS by the background scavenger.
+	//
+	// Unlike HeapReleased, this never decreases. Memory returned
+	// to the OS for other reasons, such as by FreeOSMemory, is
+	// not counted.
+	HeapScavenged uint64
+
+	// HeapRetainedGoal is the background scavenger's goal for the
+	// heap memory retained from the OS, HeapSys minus
+	// HeapReleased.
+	//
+	// The goal is set at the end of each GC cycle to a little
+	// more than NextGC. While retained memory is above the goal,
+	// the scavenger returns idle memory to the OS at a bounded
+	// CPU cost.
+	HeapRetainedGoal uint64
+
+	// ScavengeTotalNs is the cumulative nanoseconds spent by the
+	// background scavenger returning memory to the OS.
+	ScavengeTotalNs uint64
+
 	// Stack memory statistics.
 	//
 	// Stacks are not considered part of the heap, but the runtime
@@ -426,19 +453,29 @@ type MemStats struct {
 		// in this size class.
 		Frees uint64
 	}
+
+	// StackHist is a histogram of the stack sizes of goroutines.
+	//
+	// Goroutine stacks are a power of two in size. StackHist[N]
+	// counts the goroutines whose stack is 2048<<N bytes, and
+	// StackHist[19] counts all goroutines with larger stacks.
+	// This is not part of the runtime's statistics and is
+	// computed afresh by ReadMemStats.
+	StackHist [stackHistBuckets]uint64
 }
 
 // Size of the trailing by_size array differs between mstats and MemStats,
 // and all data after by_size is local to runtime, not exported.
 // NumSizeClasses was changed, but we cannot change MemStats because of backward compatibility.
 // sizeof_C_MStats is the size of the prefix of mstats that
-// corresponds to MemStats. It should match Sizeof(MemStats{}).
+// corresponds to MemStats. It should match the offset of the fields of
+// MemStats not kept in mstats, from StackHist on.
 var sizeof_C_MStats = unsafe.Offsetof(memstats.by_size) + 61*unsafe.Sizeof(memstats.by_size[0])
 
 func init() {
 	var memStats MemStats
-	if sizeof_C_MStats != unsafe.Sizeof(memStats) {
-		println(sizeof_C_MStats, unsafe.Sizeof(memStats))
+	if sizeof_C_MStats != unsafe.Offsetof(memStats.StackHist) {
+		println(sizeof_C_MStats, unsafe.Offsetof(memStats.StackHist))
 		throw("MStats vs MemStatsType size mismatch")
 	}
 
@@ -454,66 +491,152 @@ func init() {
 // call to ReadMemStats. This is in contrast with a heap profile,
 // which is a snapshot as of the most recently completed garbage
 // collection cycle.
+//
+// ReadMemStats does not stop the world. The statistics are read while
+// the program runs, so they may be slightly inconsistent with each
+// other. The runtime/debug package's ReadMetrics function reads many of
+// the same statistics, and others, by name.
 func ReadMemStats(m *MemStats) {
-	stopTheWorld("read mem stats")
+	var a heapStatsAggregate
+	a.read()
+	readmemstats(m, &a)
+}
+
+// readmemstats fills in stats from the heap statistics a and the other
+// statistics, without stopping the world.
+func readmemstats(stats *MemStats, a *heapStatsAggregate) {
+	stats.Alloc = a.objectBytes()
+	stats.TotalAlloc = a.totalAllocBytes
+	stats.Sys = a.heapSys + a.stacksInuse + a.stacksSys + a.mspanSys +
+		a.mcacheSys + a.buckhashSys + a.gcSys + a.otherSys
+	stats.Lookups = 0
+	stats.Mallocs = a.totalAllocs
+	stats.Frees = a.totalFrees
+
+	stats.HeapAlloc = stats.Alloc
+	stats.HeapSys = a.heapSys
+	stats.HeapIdle = a.heapIdle
+	stats.HeapInuse = a.heapInuse
+	stats.HeapReleased = a.heapReleased
+	stats.HeapObjects = a.totalAllocs - a.totalFrees
+	stats.HeapScavenged = atomic.Load64(&memstats.heap_scavenged)
+	stats.HeapRetainedGoal = atomic.Load64(&memstats.heap_retain_goal)
+	stats.ScavengeTotalNs = atomic.Load64(&memstats.scavenge_total_ns)
 
-	systemstack(func() {
-		readmemstats_m(m)
-	})
+	// memstats.stacks_sys is only memory mapped directly for OS stacks.
+	// Add in heap-allocated stack memory for user consumption.
+	stats.StackInuse = a.stacksInuse
+	stats.StackSys = a.stacksInuse + a.stacksSys
+
+	stats.MSpanInuse = a.mspanInuse
+	stats.MSpanSys = a.mspanSys
+	stats.MCacheInuse = a.mcacheInuse
+	stats.MCacheSys = a.mcacheSys
+	stats.BuckHashSys = a.buckhashSys
+	stats.GCSys = a.gcSys
+	stats.OtherSys = a.otherSys
+
+	stats.NextGC = atomic.Load64(&memstats.next_gc)
+	readGCPauses(stats)
+	stats.EnableGC = memstats.enablegc
+	stats.DebugGC = memstats.debuggc
+
+	for i := range stats.BySize {
+		stats.BySize[i].Size = memstats.by_size[i].size
+		stats.BySize[i].Mallocs = a.smallAllocs[i]
+		stats.BySize[i].Frees = a.smallFrees[i]
+	}
 
-	startTheWorld()
+	stackHist(&stats.StackHist)
 }
 
-func readmemstats_m(stats *MemStats) {
-	updatememstats()
+// gcPauseSeq is a sequence lock for the statistics gcMarkTermination
+// updates at the end of each GC cycle: the pause ring buffers, the pause
+// total, the cycle counts and the GC CPU fraction. It is odd while they
+// are being updated, with the world stopped. Readers, which run with the
+// world started, copy the statistics and retry if gcPauseSeq was odd or
+// changed meanwhile.
+var gcPauseSeq uint32
+
+// gcPauseSeqBegin and gcPauseSeqEnd bracket the updates of the
+// statistics covered by gcPauseSeq.
+func gcPauseSeqBegin() {
+	if atomic.Xadd(&gcPauseSeq, 1)%2 != 1 {
+		throw("gcPauseSeqBegin: update in progress")
+	}
+}
 
-	// The size of the trailing by_size array differs between
-	// mstats and MemStats. NumSizeClasses was changed, but we
-	// cannot change MemStats because of backward compatibility.
-	memmove(unsafe.Pointer(stats), unsafe.Pointer(&memstats), sizeof_C_MStats)
+func gcPauseSeqEnd() {
+	if atomic.Xadd(&gcPauseSeq, 1)%2 != 0 {
+		throw("gcPauseSeqEnd: no update in progress")
+	}
+}
 
-	// memstats.stacks_sys is only memory mapped directly for OS stacks.
-	// Add in heap-allocated stack memory for user consumption.
-	stats.StackSys += stats.StackInuse
+// readGCPauses fills in the GC cycle statistics of stats.
+func readGCPauses(stats *MemStats) {
+	for {
+		seq := atomic.Load(&gcPauseSeq)
+		if seq%2 != 0 {
+			// The world is stopped for an update. We
+			// can only get here if we were stopped
+			// midway, so let it finish.
+			Gosched()
+			continue
+		}
+		stats.LastGC = atomic.Load64(&memstats.last_gc_unix)
+		stats.PauseTotalNs = memstats.pause_total_ns
+		stats.PauseNs = memstats.pause_ns
+		stats.PauseEnd = memstats.pause_end
+		stats.NumGC = memstats.numgc
+		stats.NumForcedGC = memstats.numforcedgc
+		stats.GCCPUFraction = memstats.gc_cpu_fraction
+		if atomic.Load(&gcPauseSeq) == seq {
+			return
+		}
+	}
 }
 
 //go:linkname readGCStats runtime/debug.readGCStats
 func readGCStats(pauses *[]uint64) {
-	systemstack(func() {
-		readGCStats_m(pauses)
-	})
-}
-
-func readGCStats_m(pauses *[]uint64) {
 	p := *pauses
 	// Calling code in runtime/debug should make the slice large enough.
 	if cap(p) < len(memstats.pause_ns)+3 {
 		throw("short slice passed to readGCStats")
 	}
+	p = p[:cap(p)]
 
 	// Pass back: pauses, pause ends, last gc (absolute time), number of gc, total pause ns.
-	lock(&mheap_.lock)
+	var n uint32
+	for {
+		seq := atomic.Load(&gcPauseSeq)
+		if seq%2 != 0 {
+			Gosched()
+			continue
+		}
 
-	n := memstats.numgc
-	if n > uint32(len(memstats.pause_ns)) {
-		n = uint32(len(memstats.pause_ns))
-	}
+		numgc := memstats.numgc
+		n = numgc
+		if n > uint32(len(memstats.pause_ns)) {
+			n = uint32(len(memstats.pause_ns))
+		}
 
-	// The pause buffer is circular. The most recent pause is at
-	// pause_ns[(numgc-1)%len(pause_ns)], and then backward
-	// from there to go back farther in time. We deliver the times
-	// most recent first (in p[0]).
-	p = p[:cap(p)]
-	for i := uint32(0); i < n; i++ {
-		j := (memstats.numgc - 1 - i) % uint32(len(memstats.pause_ns))
-		p[i] = memstats.pause_ns[j]
-		p[n+i] = memstats.pause_end[j]
-	}
+		// The pause buffer is circular. The most recent pause is at
+		// pause_ns[(numgc-1)%len(pause_ns)], and then backward
+		// from there to go back farther in time. We deliver the times
+		// most recent first (in p[0]).
+		for i := uint32(0); i < n; i++ {
+			j := (numgc - 1 - i) % uint32(len(memstats.pause_ns))
+			p[i] = memstats.pause_ns[j]
+			p[n+i] = memstats.pause_end[j]
+		}
 
-	p[n+n] = memstats.last_gc_unix
-	p[n+n+1] = uint64(memstats.numgc)
-	p[n+n+2] = memstats.pause_total_ns
-	unlock(&mheap_.lock)
+		p[n+n] = atomic.Load64(&memstats.last_gc_unix)
+		p[n+n+1] = uint64(numgc)
+		p[n+n+2] = memstats.pause_total_ns
+		if atomic.Load(&gcPauseSeq) == seq {
+			break
+		}
+	}
 	*pauses = p[:n+n+3]
 }
 
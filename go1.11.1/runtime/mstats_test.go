@@ -0,0 +1,255 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Trace event types and layout, as written by runtime/trace.go.
+const (
+	traceEvGCSTWStart      = 9
+	traceEvGCSTWDone       = 10
+	traceEvString          = 37
+	traceEvGoStartLabel    = 41
+	traceEvUserLog         = 48
+	traceEvGoSysStat       = 49
+	traceEvGCPacerStart    = 50
+	traceEvGCPacerRevise   = 51
+	traceEvGCPacerEndCycle = 52
+	traceHeaderLen         = 16
+)
+
+// traceTestEvent is a trace event decoded by parseTrace. args[0] is the
+// timestamp, for the events that have one.
+type traceTestEvent struct {
+	typ  byte
+	args []uint64
+}
+
+// parseTrace decodes the events of the trace data, and its string table.
+func parseTrace(t *testing.T, data []byte) (events []traceTestEvent, strs map[uint64]string) {
+	t.Helper()
+	if len(data) < traceHeaderLen {
+		t.Fatalf("trace too short: %d bytes", len(data))
+	}
+	data = data[traceHeaderLen:]
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatalf("truncated trace")
+			}
+			b := data[0]
+			data = data[1:]
+			v |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return v
+			}
+		}
+	}
+	skip := func(n uint64) []byte {
+		if uint64(len(data)) < n {
+			t.Fatalf("truncated trace")
+		}
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+	strs = make(map[uint64]string)
+	for len(data) > 0 {
+		ev := traceTestEvent{typ: data[0] & 0x3f}
+		narg := data[0] >> 6
+		data = data[1:]
+		switch {
+		case ev.typ == traceEvString:
+			id := varint()
+			strs[id] = string(skip(varint()))
+			continue
+		case narg == 3:
+			// The arguments are prefixed with their length.
+			body := skip(varint())
+			rest := data
+			for data = body; len(data) > 0; {
+				ev.args = append(ev.args, varint())
+			}
+			data = rest
+			if ev.typ == traceEvUserLog {
+				skip(varint())
+			}
+		default:
+			for i := byte(0); i <= narg; i++ {
+				ev.args = append(ev.args, varint())
+			}
+		}
+		events = append(events, ev)
+	}
+	return events, strs
+}
+
+// traceSTWStarts returns the number of stop-the-world start events of
+// the given kind in the trace data, and the number of start events of
+// any kind without a done event.
+func traceSTWStarts(t *testing.T, data []byte, kind uint64) (n, open int) {
+	events, _ := parseTrace(t, data)
+	for _, ev := range events {
+		switch ev.typ {
+		case traceEvGCSTWStart:
+			open++
+			if ev.args[1] == kind {
+				n++
+			}
+		case traceEvGCSTWDone:
+			open--
+		}
+	}
+	return n, open
+}
+
+// traceOf returns the execution trace of f.
+func traceOf(t *testing.T, f func()) []byte {
+	t.Helper()
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	var data []byte
+	done := make(chan bool)
+	go func() {
+		for {
+			b := runtime.ReadTrace()
+			if b == nil {
+				break
+			}
+			data = append(data, b...)
+		}
+		done <- true
+	}()
+	f()
+	runtime.StopTrace()
+	<-done
+	return data
+}
+
+// countSTW returns the number of times the world is stopped outside the
+// GC while tracing f.
+func countSTW(t *testing.T, f func()) int {
+	t.Helper()
+	defer runtime.SetTraceSTW(runtime.SetTraceSTW(true))
+	data := traceOf(t, f)
+	n, open := traceSTWStarts(t, data, 2)
+	if open != 0 {
+		t.Errorf("%d stop-the-world trace events without a done event", open)
+	}
+	return n
+}
+
+func TestReadMemStatsNoSTW(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	var m runtime.MemStats
+	procs := runtime.GOMAXPROCS(-1)
+	if n := countSTW(t, func() {
+		runtime.GOMAXPROCS(procs + 1)
+		runtime.GOMAXPROCS(procs)
+	}); n != 2 {
+		t.Fatalf("GOMAXPROCS stopped the world %d times, want 2", n)
+	}
+	if n := countSTW(t, func() {
+		for i := 0; i < 10; i++ {
+			runtime.ReadMemStats(&m)
+		}
+	}); n != 0 {
+		t.Errorf("ReadMemStats stopped the world %d times", n)
+	}
+}
+
+func TestReadMemStats(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	runtime.GC()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc != m.Alloc || m.HeapObjects != m.Mallocs-m.Frees {
+		t.Errorf("inconsistent heap statistics: Alloc %d HeapAlloc %d, Mallocs-Frees %d HeapObjects %d",
+			m.Alloc, m.HeapAlloc, m.Mallocs-m.Frees, m.HeapObjects)
+	}
+	if m.HeapAlloc > m.HeapInuse {
+		t.Errorf("HeapAlloc %d > HeapInuse %d", m.HeapAlloc, m.HeapInuse)
+	}
+	if sys := m.HeapSys + m.StackSys + m.MSpanSys + m.MCacheSys + m.BuckHashSys + m.GCSys + m.OtherSys; sys != m.Sys {
+		t.Errorf("Sys = %d, want the sum of the XSys fields, %d", m.Sys, sys)
+	}
+	var bySize uint64
+	for _, c := range m.BySize {
+		bySize += c.Mallocs
+	}
+	if bySize > m.Mallocs {
+		t.Errorf("BySize counts %d allocations, more than Mallocs %d", bySize, m.Mallocs)
+	}
+
+	// The pause ring buffers agree with ReadGCStats.
+	var s debug.GCStats
+	debug.ReadGCStats(&s)
+	if int64(m.NumGC) != s.NumGC {
+		t.Fatalf("NumGC = %d, ReadGCStats says %d", m.NumGC, s.NumGC)
+	}
+	if len(s.Pause) > 0 && uint64(s.Pause[0]) != m.PauseNs[(m.NumGC+255)%256] {
+		t.Errorf("last pause %d, ReadGCStats says %d", m.PauseNs[(m.NumGC+255)%256], s.Pause[0])
+	}
+
+	// Allocations are counted without flushing the mcaches.
+	const n = 1000
+	before := m.Mallocs
+	sink := make([]*[100]byte, n)
+	for i := range sink {
+		sink[i] = new([100]byte)
+	}
+	runtime.ReadMemStats(&m)
+	if m.Mallocs-before < n {
+		t.Errorf("%d allocations counted, want at least %d", m.Mallocs-before, n)
+	}
+	runtime.KeepAlive(sink)
+}
+
+func TestReadMemStatsDuringGC(t *testing.T) {
+	// The GC cycle statistics, read with a sequence lock, are
+	// consistent with each other while GCs complete.
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	var m runtime.MemStats
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		runtime.ReadMemStats(&m)
+		if m.NumGC == 0 {
+			continue
+		}
+		last := (m.NumGC + 255) % 256
+		if m.PauseEnd[last] != m.LastGC {
+			t.Fatalf("PauseEnd of the last cycle %d, LastGC %d", m.PauseEnd[last], m.LastGC)
+		}
+		if m.NumForcedGC > m.NumGC {
+			t.Fatalf("NumForcedGC %d > NumGC %d", m.NumForcedGC, m.NumGC)
+		}
+	}
+}
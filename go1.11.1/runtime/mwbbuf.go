@@ -214,6 +214,13 @@ func wbBufFlush1(_p_ *p) {
 	// Reset the buffer.
 	_p_.wbBuf.reset()
 
+	if gcphase == _GCoff {
+		// Between cycles, write barriers are only on in
+		// generational mode, to maintain the card table.
+		gcGenMarkCards(ptrs)
+		return
+	}
+
 	if useCheckmark {
 		// Slow path for checkmark mode.
 		for _, ptr := range ptrs {
@@ -258,6 +265,9 @@ func wbBufFlush1(_p_ *p) {
 		if mbits.isMarked() {
 			continue
 		}
+		if gcgen.minor && span.isOld(objIndex) {
+			continue
+		}
 		mbits.setMarked()
 		if span.spanclass.noscan() {
 			gcw.bytesMarked += uint64(span.elemsize)
@@ -0,0 +1,109 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Weak pointers.
+//
+// runtime/debug.WeakPointer refers to an object without keeping it
+// alive. A weak pointer is a pointer to the object's weak handle, a
+// heap-allocated word holding the object's address as a uintptr, which
+// the GC does not follow. The handle is attached to the object by a
+// _KindSpecialWeak special, so all weak pointers to an object share
+// it, and weak pointers to the same object compare equal.
+//
+// When the sweeper finds the object unmarked, it clears the handle. If
+// the object has a finalizer, the handle is cleared when the finalizer
+// is queued, before it runs, so a finalizer that resurrects its object
+// does not resurrect weak pointers to it.
+//
+// Between the end of mark and the sweep of the object's span, the
+// handle of a dead object is not yet cleared, so weakPointerValue
+// sweeps the span before loading it. During mark, it shades the object
+// it returns, as the write barrier would, since the result may be the
+// only pointer to it.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// weakHandlesCleared counts the weak handles cleared by the sweeper.
+var weakHandlesCleared uint64
+
+// weakPointerMake returns the weak handle of the object p points to,
+// or nil if p is nil. If the object has no handle, one is added if add
+// is set, otherwise weakPointerMake returns nil. typ is p with a nil
+// data word.
+//
+//go:linkname weakPointerMake runtime/debug.weakPointerMake
+func weakPointerMake(p interface{}, add bool) (h unsafe.Pointer, typ interface{}) {
+	e := efaceOf(&p)
+	t := e._type
+	if t == nil {
+		panic(plainError("debug.MakeWeak: argument is nil"))
+	}
+	if t.kind&kindMask != kindPtr {
+		panic(plainError("debug.MakeWeak: argument is not a pointer"))
+	}
+	et := efaceOf(&typ)
+	et._type = t
+	if e.data == nil {
+		return nil, typ
+	}
+	if spanOfHeap(uintptr(e.data)) == nil {
+		panic(plainError("debug.MakeWeak: pointer not in the garbage-collected heap"))
+	}
+	h = unsafe.Pointer(getWeakHandle(e.data, add))
+	KeepAlive(p)
+	return h, typ
+}
+
+// weakPointerValue returns the object the weak handle h refers to, as a
+// value of the type of typ, or a nil value of that type if the object
+// is unreachable.
+//
+//go:linkname weakPointerValue runtime/debug.weakPointerValue
+func weakPointerValue(h unsafe.Pointer, typ interface{}) (r interface{}) {
+	er := efaceOf(&r)
+	er._type = efaceOf(&typ)._type
+	if h == nil {
+		return
+	}
+	handle := (*uintptr)(h)
+
+	// Don't let a GC cycle start while we look at the object.
+	mp := acquirem()
+	p := atomic.Loaduintptr(handle)
+	if p == 0 {
+		releasem(mp)
+		return
+	}
+	// The object may be dead but not yet swept, or its span may
+	// have been swept and freed already, in which case the handle
+	// is clear. It's always safe to sweep a span.
+	span := spanOfHeap(p)
+	if span == nil {
+		releasem(mp)
+		return
+	}
+	span.ensureSwept()
+
+	// Now the handle is either clear or refers to a live object.
+	p = atomic.Loaduintptr(handle)
+	if p != 0 && gcphase != _GCoff {
+		shade(p)
+	}
+	releasem(mp)
+	er.data = unsafe.Pointer(p)
+	return
+}
+
+// weakPointersCleared returns the number of weak handles cleared so
+// far.
+//
+//go:linkname weakPointersCleared runtime/debug.weakPointersCleared
+func weakPointersCleared() uint64 {
+	return atomic.Load64(&weakHandlesCleared)
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// NUMA-aware scheduling.
+//
+// On machines with more than one NUMA node, Ps are grouped by node:
+// procresize spreads them over the nodes in proportion to the number
+// of CPUs each node has, and findrunnable tries to steal from Ps on
+// its own node before it looks at the others, so that goroutines and
+// the memory they allocated from their P's mcache tend to stay on one
+// node.
+//
+// This is off by default and enabled by GODEBUG=numa=1. With
+// GODEBUG=numapin=1 as well, an M that acquires a P is also bound to
+// the CPUs of the P's node, so the OS scheduler keeps it there too.
+//
+// The topology is discovered once at startup by numadiscover, which
+// is OS-specific. On systems where it finds less than two nodes, the
+// scheduler behaves as if this file did not exist. Binding threads
+// needs numabind, which is only implemented on some ports.
+
+package runtime
+
+import "unsafe"
+
+// numaMaxCPUs is the number of CPUs a node's CPU mask can describe.
+// It matches the affinity buffer used by getproccount.
+const numaMaxCPUs = 64 * 1024
+
+// numaNode describes a single NUMA node.
+type numaNode struct {
+	id   int32    // node number as reported by the OS
+	ncpu int32    // number of CPUs in cpus
+	cpus []uint64 // CPU mask, in the layout expected by sched_setaffinity
+}
+
+var numa struct {
+	// enabled is set if there is more than one node and the user
+	// turned NUMA scheduling on. It is only written during
+	// schedinit.
+	enabled bool
+
+	nodes []numaNode
+
+	// allcpus is the CPU mask of all the nodes, which numaUnpin
+	// restores. It is only written during schedinit.
+	allcpus []uint64
+
+	// The following are rebuilt by procresize while the world is
+	// stopped.
+	nodeps     [][]int32     // IDs of the Ps on each node, indexed like nodes
+	stealOrder []randomOrder // random enumeration of nodeps[i]
+}
+
+// numainit discovers the NUMA topology. It is called by schedinit
+// before the first procresize.
+func numainit() {
+	if debug.numa == 0 {
+		return
+	}
+	numa.nodes = numadiscover()
+	numa.enabled = len(numa.nodes) > 1
+	if !numa.enabled {
+		return
+	}
+	numa.allcpus = make([]uint64, numaMaxCPUs/64)
+	for i := range numa.nodes {
+		for j, w := range numa.nodes[i].cpus {
+			numa.allcpus[j] |= w
+		}
+	}
+}
+
+// numaAssign assigns each of the nprocs Ps in allp to a NUMA node.
+// Ps are dealt out in proportion to the number of CPUs on each node,
+// so with GOMAXPROCS equal to the number of CPUs, every node gets as
+// many Ps as it has CPUs. Called by procresize with the world stopped.
+func numaAssign(nprocs int32) {
+	if !numa.enabled {
+		return
+	}
+	total := int32(0)
+	for i := range numa.nodes {
+		total += numa.nodes[i].ncpu
+	}
+	if cap(numa.nodeps) < len(numa.nodes) {
+		numa.nodeps = make([][]int32, len(numa.nodes))
+		numa.stealOrder = make([]randomOrder, len(numa.nodes))
+	}
+	for i := range numa.nodeps {
+		numa.nodeps[i] = numa.nodeps[i][:0]
+	}
+	node, end := 0, numa.nodes[0].ncpu
+	for i := int32(0); i < nprocs; i++ {
+		// Position of P i in the concatenated CPU lists.
+		cpu := int32(int64(i) * int64(total) / int64(nprocs))
+		for cpu >= end && node < len(numa.nodes)-1 {
+			node++
+			end += numa.nodes[node].ncpu
+		}
+		allp[i].numaID = int32(node)
+		numa.nodeps[node] = append(numa.nodeps[node], i)
+	}
+	for i := range numa.stealOrder {
+		if n := len(numa.nodeps[i]); n > 0 {
+			numa.stealOrder[i].reset(uint32(n))
+		} else {
+			numa.stealOrder[i].count = 0
+		}
+	}
+}
+
+// numaSteal tries to steal work for _p_ from the other Ps on its own
+// node. See runqsteal for the meaning of stealRunNextG. It gives up
+// and returns nil if the world is being stopped.
+func numaSteal(_p_ *p, stealRunNextG bool) *g {
+	ord := &numa.stealOrder[_p_.numaID]
+	if ord.count == 0 {
+		return nil
+	}
+	ps := numa.nodeps[_p_.numaID]
+	for enum := ord.start(fastrand()); !enum.done(); enum.next() {
+		if sched.gcwaiting != 0 {
+			return nil
+		}
+		p2 := allp[ps[enum.position()]]
+		if p2 == _p_ {
+			continue
+		}
+		if gp := runqsteal(_p_, p2, stealRunNextG); gp != nil {
+			return gp
+		}
+	}
+	return nil
+}
+
+// numaPin binds the current thread to the CPUs of _p_'s node if
+// GODEBUG=numapin=1. It is called after the M acquires _p_, and by
+// procresize if the node of the M's P changes. _p_ must have been
+// assigned a node by numaAssign.
+func numaPin(_p_ *p) {
+	if !numa.enabled || debug.numapin == 0 {
+		return
+	}
+	mp := getg().m
+	if mp.numaID == _p_.numaID+1 || mp.lockedg != 0 {
+		// Already there, or the thread belongs to a goroutine that
+		// called LockOSThread and may have set its own affinity.
+		return
+	}
+	mp.numaID = _p_.numaID + 1
+	cpus := numa.nodes[_p_.numaID].cpus
+	numabind(unsafe.Pointer(&cpus[0]), uintptr(len(cpus))*8)
+}
+
+// numaUnpin undoes numaPin for the current thread, binding it to the
+// CPUs of all nodes again. It is called by LockOSThread, so that a
+// locked thread does not keep the mask of the node it last ran on.
+func numaUnpin() {
+	mp := getg().m
+	if mp.numaID == 0 {
+		return
+	}
+	mp.numaID = 0
+	numabind(unsafe.Pointer(&numa.allcpus[0]), uintptr(len(numa.allcpus))*8)
+}
+
+// numaParseCPUList calls f for every CPU listed in s, which is in the
+// kernel's "cpulist" format, e.g. "0-3,8,10-11\n". It reports
+// whether s was well formed.
+func numaParseCPUList(s []byte, f func(cpu int)) bool {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == ' ') {
+		s = s[:len(s)-1]
+	}
+	if len(s) == 0 {
+		return true
+	}
+	for len(s) > 0 {
+		lo, n := numaParseInt(s)
+		if n == 0 {
+			return false
+		}
+		s = s[n:]
+		hi := lo
+		if len(s) > 0 && s[0] == '-' {
+			hi, n = numaParseInt(s[1:])
+			if n == 0 || hi < lo {
+				return false
+			}
+			s = s[1+n:]
+		}
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return false
+			}
+			s = s[1:]
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			f(cpu)
+		}
+	}
+	return true
+}
+
+// numaParseInt parses the decimal number at the start of s. It
+// returns the number and how many bytes it used, or 0 bytes if s
+// does not start with a digit or the number is too large.
+func numaParseInt(s []byte) (v, n int) {
+	for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+		v = v*10 + int(s[n]-'0')
+		if v >= numaMaxCPUs {
+			return 0, 0
+		}
+		n++
+	}
+	return v, n
+}
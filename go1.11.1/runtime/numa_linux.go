@@ -0,0 +1,94 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "unsafe"
+
+const numaSysfsDir = "/sys/devices/system/node"
+
+// numadiscover reads the NUMA topology from sysfs. Only CPUs the
+// process may run on are taken into account, and nodes without any
+// such CPU are left out.
+func numadiscover() []numaNode {
+	allowed := make([]byte, numaMaxCPUs/8)
+	r := sched_getaffinity(0, uintptr(len(allowed)), &allowed[0])
+	if r < 0 {
+		allowed = nil
+	} else {
+		allowed = allowed[:r]
+	}
+	return numaDiscoverDir(numaSysfsDir, allowed)
+}
+
+// numaDiscoverDir reads the NUMA topology from a sysfs node directory
+// laid out like /sys/devices/system/node: a file "online" listing the
+// node numbers, and a file "node<N>/cpulist" for each node. If allowed
+// is not nil, it is a CPU affinity mask and only the CPUs in it are
+// used. It returns nil if the topology cannot be read.
+func numaDiscoverDir(dir string, allowed []byte) []numaNode {
+	buf := make([]byte, 4096)
+	n := numaReadFile(dir+"/online", buf)
+	if n < 0 {
+		return nil
+	}
+	var ids []int
+	if !numaParseCPUList(buf[:n], func(id int) { ids = append(ids, id) }) {
+		return nil
+	}
+
+	var nodes []numaNode
+	for _, id := range ids {
+		n := numaReadFile(dir+"/node"+numaItoa(id)+"/cpulist", buf)
+		if n < 0 {
+			return nil
+		}
+		node := numaNode{
+			id:   int32(id),
+			cpus: make([]uint64, numaMaxCPUs/64),
+		}
+		ok := numaParseCPUList(buf[:n], func(cpu int) {
+			if allowed != nil && (cpu/8 >= len(allowed) || allowed[cpu/8]&(1<<uint(cpu%8)) == 0) {
+				return
+			}
+			node.cpus[cpu/64] |= 1 << uint(cpu%64)
+			node.ncpu++
+		})
+		if !ok {
+			return nil
+		}
+		if node.ncpu > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// numaReadFile reads up to len(buf) bytes of the named file into buf.
+// It returns the number of bytes read, or -1 on error.
+func numaReadFile(name string, buf []byte) int32 {
+	path := make([]byte, len(name)+1)
+	copy(path, name)
+	fd := open(&path[0], _O_RDONLY, 0)
+	if fd < 0 {
+		return -1
+	}
+	n := read(fd, unsafe.Pointer(&buf[0]), int32(len(buf)))
+	closefd(fd)
+	return n
+}
+
+func numaItoa(v int) string {
+	var buf [20]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = byte('0' + v%10)
+		v /= 10
+		if v == 0 {
+			break
+		}
+	}
+	return string(buf[i:])
+}
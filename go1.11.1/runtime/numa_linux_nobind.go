@@ -0,0 +1,13 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,!386,!amd64
+
+package runtime
+
+import "unsafe"
+
+// numabind does nothing: sched_setaffinity is only implemented for
+// linux/386 and linux/amd64.
+func numabind(mask unsafe.Pointer, size uintptr) {}
@@ -0,0 +1,115 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// writeSysfs creates a fake /sys/devices/system/node tree in a
+// temporary directory. files maps paths relative to the root to
+// their contents.
+func writeSysfs(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "numa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNUMADiscover(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		allowed []byte
+		ids     []int
+		cpus    [][]int
+	}{
+		{
+			name: "two nodes",
+			files: map[string]string{
+				"online":        "0-1\n",
+				"node0/cpulist": "0-3,8-11\n",
+				"node1/cpulist": "4-7,12-15\n",
+			},
+			ids:  []int{0, 1},
+			cpus: [][]int{{0, 1, 2, 3, 8, 9, 10, 11}, {4, 5, 6, 7, 12, 13, 14, 15}},
+		},
+		{
+			name: "sparse node numbers",
+			files: map[string]string{
+				"online":        "0,2\n",
+				"node0/cpulist": "0-1\n",
+				"node2/cpulist": "2,3\n",
+			},
+			ids:  []int{0, 2},
+			cpus: [][]int{{0, 1}, {2, 3}},
+		},
+		{
+			name: "memory-only node",
+			files: map[string]string{
+				"online":        "0-1\n",
+				"node0/cpulist": "0-3\n",
+				"node1/cpulist": "\n",
+			},
+			ids:  []int{0},
+			cpus: [][]int{{0, 1, 2, 3}},
+		},
+		{
+			name: "affinity mask",
+			files: map[string]string{
+				"online":        "0-1\n",
+				"node0/cpulist": "0-3\n",
+				"node1/cpulist": "4-7\n",
+			},
+			allowed: []byte{0x06}, // CPUs 1 and 2
+			ids:     []int{0},
+			cpus:    [][]int{{1, 2}},
+		},
+		{
+			name:  "no online file",
+			files: map[string]string{"node0/cpulist": "0-3\n"},
+		},
+		{
+			name: "missing cpulist",
+			files: map[string]string{
+				"online":        "0-1\n",
+				"node0/cpulist": "0-3\n",
+			},
+		},
+		{
+			name: "malformed cpulist",
+			files: map[string]string{
+				"online":        "0-1\n",
+				"node0/cpulist": "0-3\n",
+				"node1/cpulist": "7-4\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSysfs(t, tt.files)
+			defer os.RemoveAll(dir)
+			ids, cpus := runtime.NUMADiscover(dir, tt.allowed)
+			if !reflect.DeepEqual(ids, tt.ids) || !reflect.DeepEqual(cpus, tt.cpus) {
+				t.Errorf("got nodes %v with CPUs %v, want %v with %v", ids, cpus, tt.ids, tt.cpus)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+// +build 386 amd64
+
+package runtime
+
+import "unsafe"
+
+//go:noescape
+func sched_setaffinity(pid, len uintptr, buf *byte) int32
+
+// numabind restricts the current thread to the CPUs in the given mask.
+func numabind(mask unsafe.Pointer, size uintptr) {
+	sched_setaffinity(0, size, (*byte)(mask))
+}
@@ -0,0 +1,17 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// numadiscover returns nil: NUMA topology discovery is only
+// implemented on Linux.
+func numadiscover() []numaNode {
+	return nil
+}
+
+func numabind(mask unsafe.Pointer, size uintptr) {}
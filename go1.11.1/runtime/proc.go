@@ -574,6 +574,10 @@ func schedinit() {
 
 	// 处理 GODEBUG、GOTRACEBACK 调试相关的环境变量设置
 	parsedebugvars()
+	syscallStatsInit()
+	finStatsInit()
+	thpInit()
+	stackStartInit()
 
 	// 垃圾回收器初始化
 	gcinit()
@@ -586,6 +590,9 @@ func schedinit() {
 		procs = n
 	}
 
+	// 发现 NUMA 拓扑，procresize 会据此将 P 分配到各个节点
+	numainit()
+
 	// 调整 P 的数量
 	// 这时所有 P 均为新建的 P，因此不能返回有本地任务的 P
 	if procresize(procs) != nil {
@@ -603,6 +610,7 @@ func schedinit() {
 			p.wbBuf.reset()
 		}
 	}
+	gcGenInit()
 
 	if buildVersion == "" {
 		// 该条件永远不会被触发，此处只是为了防止 buildVersion 不会被编译器优化移除掉。
@@ -1057,6 +1065,16 @@ func restartg(gp *g) {
 // 这个函数也会被 stack dump 的 routine 使用。如果系统处于 panic 或 exit 状态，
 // 这可能无法可靠地停止所有的 goroutine。
 func stopTheWorld(reason string) {
+	stopTheWorldNoTrace(reason)
+	if trace.enabled && debug.tracestw != 0 {
+		stwTraced = true
+		traceGCSTWStart(2)
+	}
+}
+
+// stopTheWorldNoTrace is stopTheWorld without the trace event. StopTrace
+// uses it, since tracing is off by the time it starts the world again.
+func stopTheWorldNoTrace(reason string) {
 	// 抢占 worldsema
 	semacquire(&worldsema)
 	// 在当前 g 的 m 上保存禁止抢占的原因
@@ -1067,6 +1085,10 @@ func stopTheWorld(reason string) {
 
 // startTheWorld undoes the effects of stopTheWorld.
 func startTheWorld() {
+	if stwTraced {
+		stwTraced = false
+		traceGCSTWDone()
+	}
 	systemstack(func() { startTheWorldWithSema(false) })
 	// worldsema must be held over startTheWorldWithSema to ensure
 	// gomaxprocs cannot change while worldsema is held.
@@ -1077,6 +1099,12 @@ func startTheWorld() {
 // 持有 worldsema 会授权 M stop the world 的权利，并阻止 gomaxprocs 的并发修改。
 var worldsema uint32 = 1
 
+// stwTraced is set if stopTheWorld emitted a trace event for the stop,
+// so that startTheWorld emits the matching event only then: StartTrace
+// turns tracing on with the world stopped, and tracestw may change.
+// Protected by worldsema.
+var stwTraced bool
+
 // stopTheWorldWithSema 是 stopTheWorld 的核心实现。调用方负责抢占 worldsema
 // 并经用其可抢占的属性，然后再系统栈上调用 stopTheWorldWithSema：
 //
@@ -2291,6 +2319,9 @@ func execute(gp *g, inheritTime bool) {
 		if gp.syscallsp != 0 && gp.sysblocktraced {
 			traceGoSysExit(gp.sysexitticks)
 		}
+		if gp.syscallsp != 0 && gp.syscalldur != 0 {
+			traceGoSysStat(gp)
+		}
 		traceGoStart()
 	}
 
@@ -2320,6 +2351,14 @@ top:
 			ready(gp, 0, true)
 		}
 	}
+	if cleanupq.nidle > 0 && cleanupq.q != nil {
+		if gp := wakecleanup(); gp != nil {
+			ready(gp, 0, true)
+		}
+	}
+	if cleanupq.nspawn > 0 {
+		startcleanupworkers()
+	}
 	if *cgo_yield != nil {
 		asmcgocall(*cgo_yield, nil)
 	}
@@ -2377,12 +2416,25 @@ top:
 		atomic.Xadd(&sched.nmspinning, 1)
 	}
 	for i := 0; i < 4; i++ {
+		stealRunNextG := i > 2 // first look for ready queues with more than 1 g
+		// Prefer victims on our own NUMA node (see numa.go).
+		if numa.enabled {
+			if gp := numaSteal(_p_, stealRunNextG); gp != nil {
+				return gp, false
+			}
+			if sched.gcwaiting != 0 {
+				goto top
+			}
+		}
 		for enum := stealOrder.start(fastrand()); !enum.done(); enum.next() {
 			if sched.gcwaiting != 0 {
 				goto top
 			}
-			stealRunNextG := i > 2 // first look for ready queues with more than 1 g
-			if gp := runqsteal(_p_, allp[enum.position()], stealRunNextG); gp != nil {
+			p2 := allp[enum.position()]
+			if numa.enabled && p2.numaID == _p_.numaID {
+				continue // already tried by numaSteal
+			}
+			if gp := runqsteal(_p_, p2, stealRunNextG); gp != nil {
 				return gp, false
 			}
 		}
@@ -2693,6 +2745,7 @@ func park_m(gp *g) {
 		traceGoPark(_g_.m.waittraceev, _g_.m.waittraceskip)
 	}
 
+	gAllocFlush(_g_.m.mcache, gp)
 	casgstatus(gp, _Grunning, _Gwaiting)
 	dropg()
 
@@ -2718,6 +2771,7 @@ func goschedImpl(gp *g) {
 		dumpgstatus(gp)
 		throw("bad g status")
 	}
+	gAllocFlush(gp.m.mcache, gp)
 	casgstatus(gp, _Grunning, _Grunnable)
 	dropg()
 	lock(&sched.lock)
@@ -2770,7 +2824,12 @@ func goexit1() {
 func goexit0(gp *g) {
 	_g_ := getg()
 
+	gAllocFlush(_g_.m.mcache, gp)
 	casgstatus(gp, _Grunning, _Gdead)
+	// A worker that called Goexit from user code is a system
+	// goroutine again, as it was when it was counted in ngsys.
+	gp.cleanupRunning = false
+	gp.lockedRunning = false
 	if isSystemGoroutine(gp) {
 		atomic.Xadd(&sched.ngsys, -1)
 	}
@@ -2785,6 +2844,8 @@ func goexit0(gp *g) {
 	gp.waitreason = 0
 	gp.param = nil
 	gp.labels = nil
+	gp.labelsTraceID = 0
+	gp.allocLabels = nil
 	gp.timer = nil
 
 	if gcBlackenEnabled != 0 && gp.gcAssistBytes > 0 {
@@ -2801,6 +2862,10 @@ func goexit0(gp *g) {
 	gp.gcscanvalid = true
 	dropg()
 
+	// Release gp's share of the goroutine limit, possibly admitting
+	// pending goroutines.
+	glimitExit(gp)
+
 	if GOARCH == "wasm" { // no threads yet on wasm
 		gfput(_g_.m.p.ptr(), gp)
 		schedule() // never returns
@@ -2813,6 +2878,12 @@ func goexit0(gp *g) {
 	_g_.m.lockedExt = 0
 	gfput(_g_.m.p.ptr(), gp)
 	if locked {
+		// A LockedThread worker that called Goexit is replaced
+		// by a new worker on the same thread (see lockedthread.go).
+		if t := _g_.m.lockedThread; t != nil {
+			lockedThreadRespawn(t) // returns only if t is closed
+		}
+
 		// The goroutine may have locked this thread because
 		// it put it in an unusual kernel state. Kill it
 		// rather than returning it to the thread pool.
@@ -2905,6 +2976,12 @@ func reentersyscall(pc, sp uintptr) {
 	save(pc, sp)
 	_g_.syscallsp = sp
 	_g_.syscallpc = pc
+	_g_.syscalldur = 0
+	if syscallstats.enabled != 0 {
+		_g_.syscallwhen = nanotime()
+	}
+	// The P may run other goroutines during the call.
+	gAllocFlush(_g_.m.mcache, _g_)
 	casgstatus(_g_, _Grunning, _Gsyscall)
 	if _g_.syscallsp < _g_.stack.lo || _g_.stack.hi < _g_.syscallsp {
 		systemstack(func() {
@@ -2948,6 +3025,13 @@ func reentersyscall(pc, sp uintptr) {
 // Standard syscall entry used by the go syscall library and normal cgo calls.
 //go:nosplit
 func entersyscall() {
+	_g_ := getg()
+	_g_.syscallnum = syscallStatsUnknown
+	if syscallstats.enabled != 0 {
+		// Read the number before anything can move the stack.
+		arg := *(*uintptr)(unsafe.Pointer(getcallersp() + sys.PtrSize))
+		_g_.syscallnum = syscallStatsNum(getcallerpc(), arg)
+	}
 	reentersyscall(getcallerpc(), getcallersp())
 }
 
@@ -3005,6 +3089,12 @@ func entersyscallblock() {
 			throw("entersyscallblock")
 		})
 	}
+	_g_.syscallnum = syscallStatsUnknown
+	if syscallstats.enabled != 0 {
+		_g_.syscallwhen = nanotime()
+	}
+	// The P may run other goroutines during the call.
+	gAllocFlush(_g_.m.mcache, _g_)
 	casgstatus(_g_, _Grunning, _Gsyscall)
 	if _g_.syscallsp < _g_.stack.lo || _g_.stack.hi < _g_.syscallsp {
 		systemstack(func() {
@@ -3047,6 +3137,9 @@ func exitsyscall() {
 	}
 
 	_g_.waitsince = 0
+	if _g_.syscallwhen != 0 {
+		syscallRecord(_g_)
+	}
 	oldp := _g_.m.p.ptr()
 	if exitsyscallfast() {
 		if _g_.m.mcache == nil {
@@ -3056,6 +3149,11 @@ func exitsyscall() {
 			if oldp != _g_.m.p.ptr() || _g_.m.syscalltick != _g_.m.p.ptr().syscalltick {
 				systemstack(traceGoStart)
 			}
+			if _g_.syscalldur != 0 {
+				systemstack(func() {
+					traceGoSysStat(_g_)
+				})
+			}
 		}
 		// There's a cpu for us, so we can run.
 		_g_.m.p.ptr().syscalltick++
@@ -3348,11 +3446,15 @@ func newproc(siz int32, fn *funcval) {
 	systemstack(func() {
 		newproc1(fn, (*uint8)(argp), siz, gp, pc)
 	})
+	// 触及 goroutine 数量限制时，可能需要 panic 或阻塞当前 goroutine（见 glimit.go）
+	if gp.glimitState != glimitSpawnerNone {
+		glimitSpawned(gp)
+	}
 }
 
 // 创建一个运行 fn 的新 g，具有 narg 字节大小的参数，从 argp 开始。
 // callerps 是 go 语句的起始地址。新创建的 g 会被放入 g 的队列中等待运行。
-func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintptr) {
+func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintptr) *g {
 	_g_ := getg() // 因为是在系统栈运行所以此时的 g 为 g0
 
 	if fn == nil {
@@ -3380,8 +3482,9 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 	// 初始化阶段，gfget 是不可能找到 g 的
 	// 也可能运行中本来就已经耗尽了
 	if newg == nil {
-		// 创建一个拥有 _StackMin 大小的栈的 g
-		newg = malg(_StackMin)
+		// 创建一个拥有 startingStackSize 大小的栈的 g
+		// malg 会再加上 _StackSystem
+		newg = malg(int32(atomic.Load(&startingStackSize)) - _StackSystem)
 		// 将新创建的 g 从 _Gidle 更新为 _Gdead 状态
 		casgstatus(newg, _Gidle, _Gdead)
 		allgadd(newg) // 将 Gdead 状态的 g 添加到 allg，这样 GC 不会扫描未初始化的栈
@@ -3439,20 +3542,46 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 
 	// 初始化 g 的基本状态
 	newg.gopc = callerpc
+	newg.stackMax = newg.stack.hi - newg.stack.lo
 	newg.ancestors = saveAncestors(callergp) // 调试相关，追踪调用方
 	newg.startpc = fn.fn                     // 入口 pc
 	if _g_.m.curg != nil {
 		newg.labels = _g_.m.curg.labels // 增加 profiler 标签
+		newg.labelsTraceID = _g_.m.curg.labelsTraceID
+		newg.allocLabels = _g_.m.curg.allocLabels
 	}
+	newg.allocBytes = 0
+	newg.allocObjects = 0
 
 	// 调试相关
 	if isSystemGoroutine(newg) {
 		atomic.Xadd(&sched.ngsys, +1)
 	}
 
+	// 检查 goroutine 数量限制
+	glimitAction := glimitRun
+	if atomic.Load(&glimit.enabled) != 0 && callergp != _g_.m.g0 && !isSystemGoroutine(newg) && !isSystemGoroutine(callergp) {
+		glimitAction = glimitCheck(newg, callergp)
+	}
+	if glimitAction == glimitDrop {
+		newg.labels = nil
+		newg.labelsTraceID = 0
+		gfput(_p_, newg)
+		_g_.m.locks--
+		if _g_.m.locks == 0 && _g_.preempt {
+			_g_.stackguard0 = stackPreempt
+		}
+		return nil
+	}
+
 	newg.gcscanvalid = false
-	// 现在将 g 更换为 _Grunnable 状态
-	casgstatus(newg, _Gdead, _Grunnable)
+	// 现在将 g 更换为 _Grunnable 状态，被限制的 g 则等待被准入
+	if glimitAction == glimitPend {
+		newg.waitreason = waitReasonGoroutineLimitPending
+		casgstatus(newg, _Gdead, _Gwaiting)
+	} else {
+		casgstatus(newg, _Gdead, _Grunnable)
+	}
 
 	// 分配 goid
 	if _p_.goidcache == _p_.goidcacheend {
@@ -3474,6 +3603,25 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 		traceGoCreate(newg, newg.startpc)
 	}
 
+	if glimitAction == glimitPend {
+		glimitAddPending(newg, callergp)
+		_g_.m.locks--
+		if _g_.m.locks == 0 && _g_.preempt {
+			_g_.stackguard0 = stackPreempt
+		}
+		return nil
+	}
+
+	if _g_.m.spawnlocked {
+		// The caller (lockedThreadRespawn) runs newg itself on
+		// this M, so lock it here instead of queueing it.
+		newg.lockedm.set(_g_.m)
+		_g_.m.lockedg.set(newg)
+		_g_.m.lockedExt = 1
+		_g_.m.locks--
+		return newg
+	}
+
 	// 将这里新创建的 g 放入 p 的本地队列或直接放入全局队列
 	// true 表示放入执行队列的下一个，false 表示放入队尾
 	runqput(_p_, newg, true)
@@ -3487,6 +3635,7 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 	if _g_.m.locks == 0 && _g_.preempt { // 恢复可抢占的请求，注意我们已经在 newstack 的时候已经被清理掉了
 		_g_.stackguard0 = stackPreempt
 	}
+	return newg
 }
 
 // saveAncestors 复制给定调用者 g 的先前 ancestors
@@ -3531,7 +3680,7 @@ func gfput(_p_ *p, gp *g) {
 
 	stksize := gp.stack.hi - gp.stack.lo
 
-	if stksize != _FixedStack {
+	if stksize != uintptr(atomic.Load(&startingStackSize)) {
 		// non-standard stack size - free it.
 		stackfree(gp.stack)
 		gp.stack.lo = 0
@@ -3598,12 +3747,22 @@ retry:
 		_p_.gfree = gp.schedlink.ptr()
 		_p_.gfreecnt--
 
+		// 初始栈大小可能在 gfput 之后变了，释放旧的栈
+		size := uintptr(atomic.Load(&startingStackSize))
+		if gp.stack.lo != 0 && gp.stack.hi-gp.stack.lo != size {
+			systemstack(func() {
+				stackfree(gp.stack)
+			})
+			gp.stack.lo = 0
+			gp.stack.hi = 0
+			gp.stackguard0 = 0
+		}
 		// 查看是否需要分配运行栈
 		if gp.stack.lo == 0 {
 			// 栈会被 gfput 给释放，所以需要分配一个新的
 			// 栈分配发生在系统栈上
 			systemstack(func() {
-				gp.stack = stackalloc(_FixedStack)
+				gp.stack = stackalloc(uint32(size))
 			})
 			// 计算栈边界
 			gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -3689,6 +3848,9 @@ func LockOSThread() {
 		panic("LockOSThread nesting overflow")
 	}
 	dolockOSThread()
+	// The goroutine may set its own affinity, or rely on the one
+	// the process started with.
+	numaUnpin()
 }
 
 //go:nosplit
@@ -3711,6 +3873,8 @@ func dounlockOSThread() {
 	}
 	_g_.m.lockedg = 0
 	_g_.lockedm = 0
+	// A LockedThread worker that unlocked gives up the thread.
+	_g_.m.lockedThread = nil
 }
 
 //go:nosplit
@@ -4125,6 +4289,9 @@ func procresize(nprocs int32) *p {
 				pp.mcache = allocmcache()
 			}
 		}
+		if allocHook.logs && pp.allocHookLog == nil {
+			pp.allocHookLog = newAllocHookLog()
+		}
 
 		// race 检测相关
 		if raceenabled && pp.racectx == 0 {
@@ -4177,6 +4344,9 @@ func procresize(nprocs int32) *p {
 		if gcphase != _GCoff {
 			wbBufFlush1(p)
 			p.gcw.dispose()
+		} else if gcgen.enabled {
+			// Keep the cards it would dirty.
+			wbBufFlush1(p)
 		}
 		for i := range p.sudogbuf {
 			p.sudogbuf[i] = nil
@@ -4200,6 +4370,7 @@ func procresize(nprocs int32) *p {
 			p.racectx = 0
 		}
 		p.gcAssistTime = 0
+		p.gcAssistTimeCycle = 0
 		p.status = _Pdead
 		// 这里不能释放 P，因为它可能被一个正在系统调用中的 M 引用
 	}
@@ -4211,12 +4382,18 @@ func procresize(nprocs int32) *p {
 		unlock(&allpLock)
 	}
 
+	// Assign the Ps to nodes before the current M acquires one, so
+	// that acquirep pins it to the right node.
+	numaAssign(nprocs)
+
 	_g_ := getg()
 	// 如果当前正在使用的 P 应该被释放，则更换为 allp[0]
 	// 否则是初始化阶段，没有 P 绑定当前 P allp[0]
 	if _g_.m.p != 0 && _g_.m.p.ptr().id < nprocs {
 		// 继续使用当前 P
 		_g_.m.p.ptr().status = _Prunning
+		// Its node may have changed.
+		numaPin(_g_.m.p.ptr())
 	} else {
 		// 释放当前 P，因为已失效
 		if _g_.m.p != 0 {
@@ -4279,6 +4456,9 @@ func acquirep(_p_ *p) {
 	// Do the part that isn't allowed to have write barriers.
 	acquirep1(_p_)
 
+	// Keep the thread on the P's NUMA node if requested.
+	numaPin(_p_)
+
 	// have p; write barriers now allowed
 	_g_ := getg()
 	_g_.m.mcache = _p_.mcache
@@ -4481,6 +4661,12 @@ func sysmon() {
 				if scavengelimit < forcegcperiod {
 					maxsleep = scavengelimit / 2
 				}
+				if w := syscallstats.warnNS; w != 0 && w/2 < maxsleep {
+					maxsleep = w / 2
+				}
+				if w := finstats.warnNS; w != 0 && w/2 < maxsleep {
+					maxsleep = w / 2
+				}
 				shouldRelax := true
 				if osRelaxMinNS > 0 {
 					next := timeSleepUntil()
@@ -4534,6 +4720,14 @@ func sysmon() {
 		} else {
 			idle++
 		}
+		// warn about goroutines stuck in syscalls
+		if syscallstats.warnNS != 0 {
+			syscallWarnCheck(now)
+		}
+		// warn about slow finalizers
+		if finstats.warnNS != 0 {
+			finWarnCheck(now)
+		}
 		// check if we need to force a GC
 		if t := (gcTrigger{kind: gcTriggerTime, now: now}); t.test() && atomic.Load(&forcegc.idle) != 0 {
 			lock(&forcegc.lock)
@@ -4548,6 +4742,8 @@ func sysmon() {
 			lastscavenge = now
 			nscavenge++
 		}
+		// return free memory to the OS to meet the memory limit
+		memoryLimitScavenge(now)
 		if debug.schedtrace > 0 && lasttrace+int64(debug.schedtrace)*1000000 <= now {
 			lasttrace = now
 			schedtrace(debug.scheddetail > 0)
@@ -4555,6 +4751,43 @@ func sysmon() {
 	}
 }
 
+// sysmonWarnDue reports whether a sysmon warning check with the given
+// threshold is due, given the time of the last one in *last, and if so
+// sets *last to now. Checks run every threshold/2, so warnings come
+// reasonably close to the threshold, but at least every 10ms.
+func sysmonWarnDue(now, threshold int64, last *int64) bool {
+	interval := threshold / 2
+	if interval > 10*1000*1000 {
+		interval = 10 * 1000 * 1000
+	}
+	if now-*last < interval {
+		return false
+	}
+	*last = now
+	return true
+}
+
+// sysmonWarnStack prints a sysmon warning about gp, which is stopped
+// in status s: warn prints the message, or returns false if the warning
+// no longer applies, and then gp's stack is printed. gp is kept from
+// running, and moving its stack, meanwhile. sysmonWarnStack reports
+// whether it printed, which it does not if someone else holds the scan
+// bit.
+func sysmonWarnStack(gp *g, s uint32, warn func() bool) bool {
+	if !castogscanstatus(gp, s, s|_Gscan) {
+		return false
+	}
+	printlock()
+	ok := warn()
+	if ok {
+		goroutineheader(gp)
+		traceback(^uintptr(0), ^uintptr(0), 0, gp)
+	}
+	printunlock()
+	casfrom_Gscanstatus(gp, s|_Gscan, s)
+	return ok
+}
+
 type sysmontick struct {
 	schedtick   uint32
 	schedwhen   int64
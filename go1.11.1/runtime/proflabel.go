@@ -31,10 +31,104 @@ func runtime_setProfLabel(labels unsafe.Pointer) {
 	if raceenabled {
 		racereleasemerge(unsafe.Pointer(&labelSync))
 	}
-	getg().labels = labels
+	gp := getg()
+	// Charge the allocations so far to the old label set, if label
+	// accounting is on.
+	r := labelAllocRecordFor(labels)
+	if r != nil || gp.allocLabels != nil {
+		mp := acquirem()
+		gAllocFlush(mp.mcache, gp)
+		gp.allocLabels = r
+		releasem(mp)
+	}
+	gp.labels = labels
+	gp.labelsTraceID = 0
+	if trace.enabled {
+		gp.labelsTraceID = traceLabelsID(labels)
+	}
 }
 
 //go:linkname runtime_getProfLabel runtime/pprof.runtime_getProfLabel
 func runtime_getProfLabel() unsafe.Pointer {
 	return getg().labels
 }
+
+// labelsString formats the profiling labels as a comma-separated
+// list of key=value pairs sorted by key, e.g. "a=1,b=2".
+//
+// labels must be nil or a pointer previously passed to
+// runtime_setProfLabel. runtime/pprof always passes a pointer to its
+// labelMap, which has the same layout as map[string]string.
+func labelsString(labels unsafe.Pointer) string {
+	if labels == nil {
+		return ""
+	}
+	m := *(*map[string]string)(labels)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	// Label sets are small, insertion sort is fine.
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	var buf []byte
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, k...)
+		buf = append(buf, '=')
+		buf = append(buf, m[k]...)
+	}
+	return string(buf)
+}
+
+// printLabelsMax is the most labels printlabels prints, since it takes
+// time quadratic in their number.
+const printLabelsMax = 64
+
+// printlabels prints the profiling labels of gp in the form used by
+// goroutine headers, sorted by key. It does not allocate, so it is safe
+// to call during a traceback. runtime/pprof never writes a label map
+// once it is set, but the crashing program may have corrupted it, so
+// the map is only walked if it is in the heap and not being written.
+func printlabels(gp *g) {
+	labels := gp.labels
+	if labels == nil || spanOfHeap(uintptr(labels)) == nil {
+		return
+	}
+	h := *(**hmap)(labels)
+	if h == nil || spanOfHeap(uintptr(unsafe.Pointer(h))) == nil ||
+		h.flags&hashWriting != 0 || h.count <= 0 {
+		return
+	}
+	if h.count > printLabelsMax {
+		print(", labels: {", h.count, " labels}")
+		return
+	}
+	m := *(*map[string]string)(labels)
+	print(", labels: {")
+	// Select the keys in order, as sorting them would allocate.
+	var last string
+	for i := 0; i < h.count; i++ {
+		var k, v string
+		found := false
+		for k1, v1 := range m {
+			if (i == 0 || k1 > last) && (!found || k1 < k) {
+				k, v, found = k1, v1, true
+			}
+		}
+		if !found {
+			break
+		}
+		if i > 0 {
+			print(", ")
+		}
+		print(`"`, k, `":"`, v, `"`)
+		last = k
+	}
+	print("}")
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+// runHelper runs the test named name in a new copy of the test binary,
+// with GO_RUNTIME_TEST_HELPER=1 and env added to the environment, and
+// returns its combined output. The helper tests do nothing unless
+// isHelper reports true.
+func runHelper(t *testing.T, name string, env ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], "-test.run=^"+name+"$", "-test.v")
+	cmd.Env = append(append(os.Environ(), "GO_RUNTIME_TEST_HELPER=1"), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// isHelper reports whether the test binary was started by runHelper.
+func isHelper() bool {
+	return os.Getenv("GO_RUNTIME_TEST_HELPER") == "1"
+}
+
+func TestTracebackLabelsHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("worker", "w1", "job", "42"))
+	pprof.SetGoroutineLabels(ctx)
+	ready := make(chan bool)
+	go func() {
+		pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels("worker", "w2")))
+		ready <- true
+		select {}
+	}()
+	<-ready
+	panic("crash")
+}
+
+func TestTracebackLabels(t *testing.T) {
+	out, err := runHelper(t, "TestTracebackLabelsHelper", "GOTRACEBACK=all")
+	if err == nil {
+		t.Fatalf("helper did not crash:\n%s", out)
+	}
+	for _, want := range []string{
+		`labels: {"job":"42", "worker":"w1"}]:`,
+		`labels: {"job":"42", "worker":"w2"}]:`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("traceback does not contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestGoroutineProfileLabels(t *testing.T) {
+	ready := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	go func() {
+		runtime.SetProfLabel(map[string]string{"job": "profile", "worker": "w1"})
+		ready <- true
+		<-release
+	}()
+	<-ready
+	p := make([]runtime.StackRecord, runtime.NumGoroutine()+10)
+	n, labels, ok := runtime.GoroutineProfileWithLabels(p)
+	if !ok {
+		t.Fatalf("GoroutineProfileWithLabels: %d goroutines, room for %d", n, len(p))
+	}
+	found := 0
+	for _, l := range labels {
+		if l["job"] == "profile" && l["worker"] == "w1" && len(l) == 2 {
+			found++
+		}
+	}
+	if found != 1 {
+		t.Errorf("%d goroutines with labels job=profile,worker=w1 in the profile, want 1", found)
+	}
+}
+
+func TestTraceLabels(t *testing.T) {
+	// One goroutine has labels when tracing starts, the other
+	// sets them while tracing.
+	before := make(chan bool)
+	during := make(chan bool)
+	ready := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		runtime.SetProfLabel(map[string]string{"job": "before"})
+		ready <- true
+		<-before
+		done <- true
+	}()
+	go func() {
+		<-during
+		runtime.SetProfLabel(map[string]string{"job": "during", "a": "1"})
+		runtime.Gosched()
+		done <- true
+	}()
+	<-ready
+
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	var data []byte
+	read := make(chan bool)
+	go func() {
+		for {
+			b := runtime.ReadTrace()
+			if b == nil {
+				break
+			}
+			data = append(data, b...)
+		}
+		read <- true
+	}()
+	close(before)
+	close(during)
+	<-done
+	<-done
+	runtime.StopTrace()
+	<-read
+
+	events, strs := parseTrace(t, data)
+	seen := make(map[string]bool)
+	for _, ev := range events {
+		if ev.typ == traceEvGoStartLabel {
+			seen[strs[ev.args[3]]] = true
+		}
+	}
+	for _, want := range []string{"job=before", "a=1,job=during"} {
+		if !seen[want] {
+			t.Errorf("no goroutine start with labels %q in the trace; have %v", want, seen)
+		}
+	}
+}
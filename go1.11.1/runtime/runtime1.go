@@ -317,36 +317,56 @@ var debug struct {
 	allocfreetrace     int32
 	cgocheck           int32
 	efence             int32
+	finwarn            int32
 	gccheckmark        int32
+	gcgen              int32
 	gcpacertrace       int32
 	gcshrinkstackoff   int32
 	gcrescanstacks     int32
 	gcstoptheworld     int32
 	gctrace            int32
+	heaptypes          int32
 	invalidptr         int32
+	numa               int32
+	numapin            int32
 	sbrk               int32
 	scavenge           int32
 	scheddetail        int32
 	schedtrace         int32
+	syscallstats       int32
+	syscallwarn        int32
 	tracebackancestors int32
+	tracepacer         int32
+	tracestw           int32
+	tracesyscall       int32
 }
 
 var dbgvars = []dbgVar{
 	{"allocfreetrace", &debug.allocfreetrace},
 	{"cgocheck", &debug.cgocheck},
 	{"efence", &debug.efence},
+	{"finwarn", &debug.finwarn},
 	{"gccheckmark", &debug.gccheckmark},
+	{"gcgen", &debug.gcgen},
 	{"gcpacertrace", &debug.gcpacertrace},
 	{"gcshrinkstackoff", &debug.gcshrinkstackoff},
 	{"gcrescanstacks", &debug.gcrescanstacks},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"heaptypes", &debug.heaptypes},
 	{"invalidptr", &debug.invalidptr},
+	{"numa", &debug.numa},
+	{"numapin", &debug.numapin},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
 	{"scheddetail", &debug.scheddetail},
 	{"schedtrace", &debug.schedtrace},
+	{"syscallstats", &debug.syscallstats},
+	{"syscallwarn", &debug.syscallwarn},
 	{"tracebackancestors", &debug.tracebackancestors},
+	{"tracepacer", &debug.tracepacer},
+	{"tracestw", &debug.tracestw},
+	{"tracesyscall", &debug.tracesyscall},
 }
 
 func parsedebugvars() {
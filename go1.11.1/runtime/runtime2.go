@@ -329,6 +329,10 @@ type g struct {
 	sched          gobuf
 	syscallsp      uintptr        // if status==Gsyscall, syscallsp = sched.sp to use during gc
 	syscallpc      uintptr        // if status==Gsyscall, syscallpc = sched.pc to use during gc
+	syscallnum     uintptr        // number of the current or last system call, see syscallstat.go
+	syscallwhen    int64          // nanotime when the current system call started, if accounted
+	syscalldur     int64          // duration of the last accounted system call, for the tracer
+	syscallwarned  bool           // sysmon has warned about the current system call
 	stktopsp       uintptr        // expected sp at top of stack, to check in traceback
 	param          unsafe.Pointer // passed parameter on wakeup
 	atomicstatus   uint32
@@ -361,8 +365,20 @@ type g struct {
 	waiting        *sudog         // sudog structures this g is waiting on (that have a valid elem ptr); in lock order
 	cgoCtxt        []uintptr      // cgo traceback context
 	labels         unsafe.Pointer // profiler 的标签
+	labelsTraceID  uint64         // trace string ID of labels; only meaningful while tracing
+	glimitQuotas   uint64         // goroutine label quotas charged for this g, see glimit.go
+	glimitSpawner  guintptr       // g blocked in a go statement until this g is admitted
+	glimitState    uint8          // state of a go statement that hit the goroutine limit
 	timer          *timer         // 为 time.Sleep 缓存的计时器
 	selectDone     uint32         // are we participating in a select and did someone win the race?
+	cleanupRunning bool           // cleanup worker calling a cleanup, see mcleanup.go
+	lockedRunning  bool           // LockedThread worker calling a submitted function, see lockedthread.go
+	stackMax       uintptr        // largest stack size since the goroutine started, see stackprof.go
+
+	// Allocation counts, see mallocacct.go.
+	allocBytes   uint64
+	allocObjects uint64
+	allocLabels  *labelAllocRecord // record of the label set, if accounted
 
 	// Per-G GC 状态
 
@@ -429,8 +445,11 @@ type m struct {
 	waittraceskip int
 	startingtrace bool
 	syscalltick   uint32
-	thread        uintptr // 线程处理
-	freelink      *m      // 在 sched.freem 上
+	thread        uintptr       // 线程处理
+	freelink      *m            // 在 sched.freem 上
+	numaID        int32         // 1 + NUMA node the thread is bound to, 0 if unbound (see numaPin)
+	lockedThread  *LockedThread // LockedThread this thread belongs to, if any
+	spawnlocked   bool          // newproc1 locks the new g to this m instead of queueing it
 
 	// 下面这些字段因为它们太大而不能放在低级的 NOSPLIT 函数的堆栈上。
 	libcall   libcall
@@ -449,6 +468,7 @@ type p struct {
 	lock mutex
 
 	id          int32
+	numaID      int32  // index of the NUMA node in numa.nodes this P is assigned to
 	status      uint32 // p 的状态 pidle/prunning/...
 	link        puintptr
 	schedtick   uint32     // 每次调度器调用都会增加
@@ -501,6 +521,7 @@ type p struct {
 
 	// Per-P GC state
 	gcAssistTime         int64 // Nanoseconds in assistAlloc
+	gcAssistTimeCycle    int64 // Nanoseconds in assistAlloc this cycle, for gcstats
 	gcFractionalMarkTime int64 // Nanoseconds in fractional mark worker
 	gcBgMarkWorker       guintptr
 	gcMarkWorkerMode     gcMarkWorkerMode
@@ -519,6 +540,10 @@ type p struct {
 	// TODO: Consider caching this in the running G.
 	wbBuf wbBuf
 
+	// allocHookLog holds the allocation hook events recorded on
+	// this P. See mallochook.go.
+	allocHookLog *profBuf
+
 	runSafePointFn uint32 // 如果为 1, 则在下一个 safe-point 运行 sched.safePointFn
 
 	pad [sys.CacheLineSize]byte
@@ -761,6 +786,11 @@ const (
 	waitReasonTraceReaderBlocked                      // "trace reader (blocked)"
 	waitReasonWaitForGCCycle                          // "wait for GC cycle"
 	waitReasonGCWorkerIdle                            // "GC worker (idle)"
+	waitReasonGoroutineLimit                          // "goroutine limit"
+	waitReasonGoroutineLimitPending                   // "goroutine limit (not started)"
+	waitReasonLockedThreadIdle                        // "locked thread (idle)"
+	waitReasonGCScavengeWait                          // "GC scavenge wait"
+	waitReasonCleanupWait                             // "cleanup wait"
 )
 
 var waitReasonStrings = [...]string{
@@ -788,6 +818,11 @@ var waitReasonStrings = [...]string{
 	waitReasonTraceReaderBlocked:    "trace reader (blocked)",
 	waitReasonWaitForGCCycle:        "wait for GC cycle",
 	waitReasonGCWorkerIdle:          "GC worker (idle)",
+	waitReasonGoroutineLimit:        "goroutine limit",
+	waitReasonGoroutineLimitPending: "goroutine limit (not started)",
+	waitReasonLockedThreadIdle:      "locked thread (idle)",
+	waitReasonGCScavengeWait:        "GC scavenge wait",
+	waitReasonCleanupWait:           "cleanup wait",
 }
 
 func (w waitReason) String() string {
@@ -1020,6 +1020,9 @@ func newstack() {
 	// The concurrent GC will not scan the stack while we are doing the copy since
 	// the gp is in a Gcopystack status.
 	copystack(gp, newsize, true)
+	if newsize > gp.stackMax {
+		gp.stackMax = newsize
+	}
 	if stackDebug >= 1 {
 		print("stack grow done\n")
 	}
@@ -0,0 +1,132 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Goroutine stack sizes.
+//
+// Every g records in stackMax the largest stack it has had since it
+// started: newproc1 sets it to the initial stack size and newstack
+// raises it after doubling the stack, which is one compare per growth
+// on top of the copy. shrinkstack does not lower it, so it is the high
+// water mark of the goroutine's stack.
+//
+// readStackProfile, behind runtime/debug.ReadStackProfile, records
+// with the world stopped the stack size, high water mark, used bytes,
+// creation site (gopc) and a few frames of every user goroutine, which
+// runtime/debug aggregates by creation site and current function.
+// ReadMemStats counts the stacks of all goroutines by size in
+// MemStats.StackHist.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// stackProfileDepth is the number of frames recorded per
+	// goroutine, enough to find the first frame outside the
+	// runtime.
+	stackProfileDepth = 16
+
+	// stackProfileFields is the number of words per goroutine in
+	// the buffer filled by readStackProfile: gopc, stack size,
+	// high water mark, used bytes and the frames, zero-terminated
+	// if fewer than stackProfileDepth.
+	stackProfileFields = 4 + stackProfileDepth
+
+	// stackHistBuckets is the length of MemStats.StackHist.
+	stackHistBuckets = 20
+)
+
+// readStackProfile fills buf with stackProfileFields words for every
+// goroutine that GoroutineProfile would report.
+//
+//go:linkname readStackProfile runtime/debug.readStackProfile
+func readStackProfile(buf *[]uintptr) {
+	gp := getg()
+	isOK := func(gp1 *g) bool {
+		return gp1 != gp && readgstatus(gp1) != _Gdead && !isSystemGoroutine(gp1)
+	}
+	for {
+		// Don't allocate with the world stopped. Leave room
+		// for goroutines started meanwhile.
+		n := int(atomic.Loaduintptr(&allglen)) + 10
+		if cap(*buf) < n*stackProfileFields {
+			*buf = make([]uintptr, 0, n*stackProfileFields)
+		}
+		p := (*buf)[:0]
+
+		stopTheWorld("stack profile")
+		if int(allglen) >= n {
+			startTheWorld()
+			continue
+		}
+
+		// The calling goroutine.
+		sp := getcallersp()
+		pc := getcallerpc()
+		p = p[:stackProfileFields]
+		systemstack(func() {
+			stackProfileRecord(p, pc, sp, gp, gp.stack.hi-sp)
+		})
+		for _, gp1 := range allgs {
+			if !isOK(gp1) {
+				continue
+			}
+			i := len(p)
+			p = p[:i+stackProfileFields]
+			stackProfileRecord(p[i:], ^uintptr(0), ^uintptr(0), gp1, gp1.stack.hi-stoppedsp(gp1))
+		}
+		startTheWorld()
+		*buf = p
+		return
+	}
+}
+
+// stoppedsp returns the bottom of the stack in use by gp, which is not
+// running. For a goroutine in a system call, that is syscallsp, as in
+// gentraceback: sched.sp may have been changed since entersyscall
+// saved it.
+func stoppedsp(gp *g) uintptr {
+	if gp.syscallsp != 0 {
+		return gp.syscallsp
+	}
+	return gp.sched.sp
+}
+
+// stackProfileRecord fills r with the record of gp, whose stack is used
+// down to used bytes from the top.
+func stackProfileRecord(r []uintptr, pc, sp uintptr, gp *g, used uintptr) {
+	r[0] = gp.gopc
+	r[1] = gp.stack.hi - gp.stack.lo
+	r[2] = gp.stackMax
+	r[3] = used
+	stk := r[4:stackProfileFields]
+	n := gentraceback(pc, sp, 0, gp, 0, &stk[0], len(stk), nil, nil, 0)
+	for i := n; i < len(stk); i++ {
+		stk[i] = 0
+	}
+}
+
+// stackHist fills h with the number of goroutine stacks of each size:
+// h[i] counts stacks of _FixedStack<<i bytes, and the last entry all
+// larger stacks. It does not stop the world, so a stack that is copied
+// meanwhile may be counted at either size, and one being copied right
+// now is not counted.
+func stackHist(h *[stackHistBuckets]uint64) {
+	*h = [stackHistBuckets]uint64{}
+	lock(&allglock)
+	for _, gp := range allgs {
+		s := readgstatus(gp)
+		if s == _Gdead || s == _Gcopystack || gp.stack.lo == 0 {
+			continue
+		}
+		size := gp.stack.hi - gp.stack.lo
+		i := 0
+		for size > _FixedStack && i < stackHistBuckets-1 {
+			size >>= 1
+			i++
+		}
+		h[i]++
+	}
+	unlock(&allglock)
+}
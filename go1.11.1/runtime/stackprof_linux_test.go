@@ -0,0 +1,53 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//go:noinline
+func syscallStackGoroutine(n, fd int, ready chan struct{}) {
+	var pad [1024]byte
+	if n > 0 {
+		syscallStackGoroutine(n-1, fd, ready)
+		pad[0]++
+		return
+	}
+	close(ready)
+	syscall.Read(fd, pad[:1])
+}
+
+func TestStackProfileSyscall(t *testing.T) {
+	var p [2]int
+	if err := syscall.Pipe(p[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+	ready := make(chan struct{})
+	go syscallStackGoroutine(40, p[0], ready)
+	<-ready
+	defer syscall.Write(p[1], []byte{0})
+
+	// The goroutine blocks in read with 40KB of its stack in use.
+	var used uint64
+	for i := 0; i < 500; i++ {
+		for _, r := range debug.ReadStackProfile() {
+			if strings.HasSuffix(r.Function, "runtime_test.syscallStackGoroutine") {
+				used = r.UsedBytes
+			}
+		}
+		if used >= 40<<10 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("goroutine in a system call uses %d bytes of stack, want at least %d", used, 40<<10)
+}
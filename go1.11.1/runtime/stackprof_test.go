@@ -0,0 +1,106 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//go:noinline
+func growStack(n int) {
+	var pad [1024]byte
+	if n > 0 {
+		growStack(n - 1)
+		pad[0]++
+	}
+}
+
+func deepStackGoroutine(ready *sync.WaitGroup, release chan struct{}) {
+	// Grow the stack to 64KB or more, then return to a shallow
+	// frame. The stack is not shrunk until a GC.
+	growStack(40)
+	ready.Done()
+	<-release
+}
+
+func (r *stackTestWaiter) wait(ready *sync.WaitGroup, release chan struct{}) {
+	ready.Done()
+	<-release
+}
+
+type stackTestWaiter struct{}
+
+func TestStackProfile(t *testing.T) {
+	const n = 5
+	var ready sync.WaitGroup
+	release := make(chan struct{})
+	defer close(release)
+	ready.Add(2 * n)
+	for i := 0; i < n; i++ {
+		go deepStackGoroutine(&ready, release)
+		go new(stackTestWaiter).wait(&ready, release)
+	}
+	ready.Wait()
+
+	var deep, shallow *debug.StackProfileRecord
+	records := debug.ReadStackProfile()
+	for i := range records {
+		r := &records[i]
+		switch {
+		case strings.HasSuffix(r.Function, "runtime_test.deepStackGoroutine"):
+			deep = r
+		case strings.HasSuffix(r.Function, "runtime_test.(*stackTestWaiter).wait"):
+			shallow = r
+		}
+	}
+	if deep == nil || shallow == nil {
+		t.Fatalf("goroutines missing from stack profile: %+v", records)
+	}
+	if deep.Goroutines != n || shallow.Goroutines != n {
+		t.Errorf("got %d and %d goroutines, want %d", deep.Goroutines, shallow.Goroutines, n)
+	}
+	if !strings.Contains(deep.CreatedBy, "runtime_test.TestStackProfile:") {
+		t.Errorf("goroutines created by %q, want TestStackProfile", deep.CreatedBy)
+	}
+	if deep.LargestStack < 64<<10 || deep.MaxStackBytes < n*64<<10 {
+		t.Errorf("deep stacks: largest %d, total high water mark %d, want at least 64KB each", deep.LargestStack, deep.MaxStackBytes)
+	}
+	if deep.UsedBytes > deep.StackBytes || deep.StackBytes > deep.MaxStackBytes {
+		t.Errorf("deep stacks: used %d, size %d, high water mark %d out of order", deep.UsedBytes, deep.StackBytes, deep.MaxStackBytes)
+	}
+	if shallow.LargestStack > 8<<10 {
+		t.Errorf("shallow stacks: largest %d, want at most 8KB", shallow.LargestStack)
+	}
+
+	// The high water mark survives shrinking.
+	runtime.GC()
+	for _, r := range debug.ReadStackProfile() {
+		if r.Function == deep.Function && r.LargestStack < 64<<10 {
+			t.Errorf("high water mark %d after GC, want at least 64KB", r.LargestStack)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := debug.WriteStackProfile(&buf, records); err != nil || buf.Len() == 0 {
+		t.Errorf("WriteStackProfile: %d bytes, %v", buf.Len(), err)
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var large uint64
+	for i, c := range ms.StackHist {
+		if 2048<<uint(i) >= 64<<10 {
+			large += c
+		}
+	}
+	if large < n {
+		t.Errorf("MemStats.StackHist = %v, want at least %d stacks of 64KB or more", ms.StackHist, n)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Initial goroutine stack size.
+//
+// New goroutines used to start with a _FixedStack stack. Goroutines
+// that always need more, such as server handlers, then copy their
+// stacks a few times as they grow, which is costly. Instead, the
+// initial size is learned from the program's goroutines: scanstack
+// adds the stack space in use by every goroutine it scans, and at the
+// end of every mark phase startingStackSize is set to the average, plus
+// _StackGuard for headroom, rounded up to a power of two. It is never
+// larger than maxStartingStackSize, the largest size the per-P stack
+// caches serve, so that starting a goroutine never allocates a stack
+// from the heap, and a goroutine that needs a large stack only for a
+// while does not inflate all the others.
+//
+// GOSTACKSTART sets a fixed initial size instead, rounded up to a power
+// of two in the same range.
+//
+// gfget and gfput only keep free stacks of the current initial size.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+// maxStartingStackSize is the largest initial goroutine stack size: the
+// largest stack size with a stack cache order.
+const maxStartingStackSize = _FixedStack << (_NumStackOrders - 1)
+
+// startingStackSize is the size of the stacks of new goroutines, a power
+// of two in [_FixedStack, maxStartingStackSize].
+var startingStackSize uint32 = _FixedStack
+
+var stackStart struct {
+	// Used stack bytes and number of the stacks scanned this cycle.
+	scannedBytes  uint64
+	scannedStacks uint64
+
+	fixed bool // set by GOSTACKSTART
+}
+
+// stackStartInit applies $GOSTACKSTART. Called from schedinit.
+func stackStartInit() {
+	p := gogetenv("GOSTACKSTART")
+	if p == "" || p == "adaptive" {
+		return
+	}
+	size, ok := parseStackStart(p)
+	if !ok {
+		print("runtime: GOSTACKSTART=", p, "\n")
+		throw("malformed GOSTACKSTART; want adaptive or a byte count such as 8KiB")
+	}
+	startingStackSize = size
+	stackStart.fixed = true
+}
+
+// parseStackStart parses a GOSTACKSTART byte count and returns the
+// initial stack size it selects.
+func parseStackStart(s string) (uint32, bool) {
+	n, ok := parseByteCount(s)
+	if !ok {
+		return 0, false
+	}
+	return clampStartingStackSize(uint64(n)), true
+}
+
+// clampStartingStackSize rounds n up to a power of two in
+// [_FixedStack, maxStartingStackSize].
+func clampStartingStackSize(n uint64) uint32 {
+	if n > maxStartingStackSize {
+		n = maxStartingStackSize
+	}
+	if n < _FixedStack {
+		n = _FixedStack
+	}
+	return uint32(round2(int32(n)))
+}
+
+// stackStartScanned accounts the stack of gp, which is being scanned.
+func stackStartScanned(gp *g) {
+	if stackStart.fixed || useCheckmark {
+		return
+	}
+	atomic.Xadd64(&stackStart.scannedBytes, int64(gp.stack.hi-stoppedsp(gp)))
+	atomic.Xadd64(&stackStart.scannedStacks, 1)
+}
+
+// gcComputeStartingStackSize sets startingStackSize from the stacks
+// scanned in the mark phase that just ended. The world is stopped.
+func gcComputeStartingStackSize() {
+	if stackStart.fixed {
+		return
+	}
+	bytes, n := stackStart.scannedBytes, stackStart.scannedStacks
+	stackStart.scannedBytes, stackStart.scannedStacks = 0, 0
+	if n == 0 {
+		// A minor cycle, or one with no goroutines to speak of.
+		return
+	}
+	atomic.Store(&startingStackSize, startingStackSizeFor(bytes, n))
+}
+
+// startingStackSizeFor returns the initial stack size for goroutines
+// that use bytes of stack in n stacks.
+func startingStackSizeFor(bytes, n uint64) uint32 {
+	return clampStartingStackSize(bytes/n + _StackGuard)
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// checkStackSize checks that n is a stack size stackalloc serves from
+// its per-P caches: a power of two in [FixedStack, MaxStartingStackSize].
+func checkStackSize(t *testing.T, what string, n uint64) {
+	t.Helper()
+	if n < runtime.FixedStack || n > runtime.MaxStartingStackSize || n&(n-1) != 0 {
+		t.Errorf("%s = %d, want a power of two in [%d, %d]", what, n, runtime.FixedStack, runtime.MaxStartingStackSize)
+	}
+}
+
+func TestStartingStackSizeFor(t *testing.T) {
+	last := uint32(0)
+	for _, avg := range []uint64{0, 1, 100, 1000, 2000, 3000, 5000, 8000, 12000, 20000, 1 << 20, 1 << 40} {
+		size := runtime.StartingStackSizeFor(avg*10, 10)
+		checkStackSize(t, "StartingStackSizeFor", uint64(size))
+		if size < last {
+			t.Errorf("StartingStackSizeFor(%d average) = %d, less than %d for a smaller average", avg, size, last)
+		}
+		last = size
+	}
+	if size := runtime.StartingStackSizeFor(0, 1); size != runtime.FixedStack {
+		t.Errorf("StartingStackSizeFor with no used stack = %d, want %d", size, runtime.FixedStack)
+	}
+	if size := runtime.StartingStackSizeFor(1<<40, 1); size != runtime.MaxStartingStackSize {
+		t.Errorf("StartingStackSizeFor with huge stacks = %d, want %d", size, runtime.MaxStartingStackSize)
+	}
+}
+
+func TestParseStackStart(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want uint32
+		ok   bool
+	}{
+		{"0", runtime.FixedStack, true},
+		{"1", runtime.FixedStack, true},
+		{"8KiB", 8 << 10, true},
+		{"5000", 8 << 10, true},
+		{"1MiB", runtime.MaxStartingStackSize, true},
+		{"1TiB", runtime.MaxStartingStackSize, true},
+		{"", 0, false},
+		{"8K", 0, false},
+		{"-1", 0, false},
+		{"adaptive", 0, false},
+	} {
+		got, ok := runtime.ParseStackStart(test.in)
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("ParseStackStart(%q) = %d, %v, want %d, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestStartingStackSizeHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	// Deep stacks make the initial size grow, but no further than
+	// the stack caches allow.
+	const n = 100
+	var ready sync.WaitGroup
+	release := make(chan struct{})
+	defer close(release)
+	ready.Add(n)
+	for i := 0; i < n; i++ {
+		go deepStackGoroutine(&ready, release)
+	}
+	ready.Wait()
+	runtime.GC()
+	runtime.GC()
+	size := runtime.StartingStackSize()
+	checkStackSize(t, "StartingStackSize", uint64(size))
+	if size == runtime.FixedStack {
+		t.Errorf("StartingStackSize = %d with %d deep stacks, want larger", size, n)
+	}
+
+	// New goroutines start with stacks of the new size. Retry if
+	// a GC changed it meanwhile.
+	for i := 0; ; i++ {
+		size := runtime.StartingStackSize()
+		got := runtime.NewGoroutineStackSize()
+		if got == size {
+			break
+		}
+		if i == 10 {
+			t.Fatalf("new goroutine has a %d byte stack, want StartingStackSize = %d", got, size)
+		}
+	}
+}
+
+func TestStartingStackSize(t *testing.T) {
+	if os.Getenv("GOSTACKSTART") != "" {
+		t.Skip("initial stack size set by GOSTACKSTART")
+	}
+	// The learned size would outlive the test, so learn it in a
+	// process of its own.
+	out, err := runHelper(t, "TestStartingStackSizeHelper")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
@@ -50,6 +50,7 @@
 #define SYS_gettid		224
 #define SYS_tkill		238
 #define SYS_futex		240
+#define SYS_sched_setaffinity	241
 #define SYS_sched_getaffinity	242
 #define SYS_set_thread_area	243
 #define SYS_exit_group		252
@@ -441,6 +442,18 @@ TEXT runtime·futex(SB),NOSPLIT,$0
 	MOVL	AX, ret+24(FP)
 	RET
 
+// int32 rawfork(void);
+TEXT runtime·rawfork(SB),NOSPLIT,$0-4
+	MOVL	$SYS_clone, AX
+	MOVL	$17, BX	// SIGCHLD, and no CLONE_ flags
+	MOVL	$0, CX	// same stack
+	MOVL	$0, DX	// parent tid ptr
+	MOVL	$0, SI	// tls
+	MOVL	$0, DI	// child tid ptr
+	INT	$0x80
+	MOVL	AX, ret+0(FP)
+	RET
+
 // int32 clone(int32 flags, void *stack, M *mp, G *gp, void (*fn)(void));
 TEXT runtime·clone(SB),NOSPLIT,$0
 	MOVL	$SYS_clone, AX
@@ -648,6 +661,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0
 	MOVL	AX, ret+12(FP)
 	RET
 
+TEXT runtime·sched_setaffinity(SB),NOSPLIT,$0
+	MOVL	$SYS_sched_setaffinity, AX
+	MOVL	pid+0(FP), BX
+	MOVL	len+4(FP), CX
+	MOVL	buf+8(FP), DX
+	INVOKE_SYSCALL
+	MOVL	AX, ret+12(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$0
 	MOVL    $SYS_epoll_create, AX
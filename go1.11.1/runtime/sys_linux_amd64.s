@@ -38,6 +38,7 @@
 #define SYS_gettid		186
 #define SYS_tkill		200
 #define SYS_futex		202
+#define SYS_sched_setaffinity	203
 #define SYS_sched_getaffinity	204
 #define SYS_epoll_create	213
 #define SYS_exit_group		231
@@ -532,6 +533,18 @@ TEXT runtime·futex(SB),NOSPLIT,$0
 	MOVL	AX, ret+40(FP)
 	RET
 
+// int32 rawfork(void);
+TEXT runtime·rawfork(SB),NOSPLIT,$0-4
+	MOVL	$17, DI	// SIGCHLD, and no CLONE_ flags
+	MOVQ	$0, SI	// same stack
+	MOVQ	$0, DX	// parent tid ptr
+	MOVQ	$0, R10	// child tid ptr
+	MOVQ	$0, R8	// tls
+	MOVL	$SYS_clone, AX
+	SYSCALL
+	MOVL	AX, ret+0(FP)
+	RET
+
 // int32 clone(int32 flags, void *stk, M *mp, G *gp, void (*fn)(void));
 TEXT runtime·clone(SB),NOSPLIT,$0
 	MOVL	flags+0(FP), DI
@@ -631,6 +644,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0
 	MOVL	AX, ret+24(FP)
 	RET
 
+TEXT runtime·sched_setaffinity(SB),NOSPLIT,$0
+	MOVQ	pid+0(FP), DI
+	MOVQ	len+8(FP), SI
+	MOVQ	buf+16(FP), DX
+	MOVL	$SYS_sched_setaffinity, AX
+	SYSCALL
+	MOVL	AX, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$0
 	MOVL    size+0(FP), DI
@@ -0,0 +1,285 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// System call latency accounting.
+//
+// When enabled (GODEBUG=syscallstats=1 or runtime/debug.SetSyscallStats),
+// entersyscall records the number and start time of every system call,
+// and exitsyscall adds its duration to the latency histogram of that
+// number. The number is the first argument of syscall.Syscall and
+// syscall.Syscall6, which call entersyscall directly, found above the
+// caller's return address on 386 and amd64. Other calls, such as cgo
+// calls, and all calls on other architectures, are accounted under
+// syscallStatsUnknown.
+//
+// Independently, a warning threshold can be set (GODEBUG=syscallwarn=N
+// in milliseconds, or runtime/debug.SetSyscallWarning). sysmon then
+// looks for goroutines that have been in a system call for longer than
+// the threshold and prints the system call number and the goroutine's
+// stack, once per call.
+//
+// The histograms are read by runtime/debug.ReadSyscallStats. With
+// GODEBUG=tracesyscall=1, every accounted call also emits
+// traceEvGoSysStat while tracing, after its traceEvGoSysExit, if any.
+// The event is not understood by the Go 1.11 trace parser, hence the
+// setting.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const (
+	// syscallStatsMax is the number of histograms. System call
+	// numbers at or above syscallStatsUnknown share the last one
+	// with calls whose number is not known.
+	syscallStatsMax     = 512
+	syscallStatsUnknown = syscallStatsMax - 1
+
+	// syscallStatsBuckets is the number of latency buckets. Bucket
+	// 0 counts calls shorter than 1µs, bucket i counts calls in
+	// [2^(i-1), 2^i) µs, and the last bucket counts everything
+	// longer, from about 4s on.
+	syscallStatsBuckets = 24
+
+	// syscallStatsWidth is the number of uint64s per histogram in
+	// the buffer filled in by readSyscallStats: number, count, total
+	// ns, max ns and the buckets.
+	syscallStatsWidth = 4 + syscallStatsBuckets
+)
+
+type syscallStat struct {
+	count   uint64
+	totalNS uint64
+	maxNS   uint64
+	buckets [syscallStatsBuckets]uint64
+}
+
+var syscallstats struct {
+	// enabled is non-zero if entersyscall should record start
+	// times, that is, if accounting is on or warnNS is set.
+	enabled  uint32
+	account  uint32 // update the histograms
+	warnNS   int64  // warning threshold; 0 disables warnings
+	lastwarn int64  // last sysmon check, only used by sysmon
+
+	// entries are the entry PCs of syscall.Syscall and
+	// syscall.Syscall6, once seen by syscallStatsNum.
+	entries [2]uintptr
+
+	lock  mutex // serializes changes to the settings and allocation of table
+	table *[syscallStatsMax]syscallStat
+}
+
+// syscallStatsInit applies the GODEBUG settings. Called from schedinit
+// after parsedebugvars.
+func syscallStatsInit() {
+	if debug.syscallstats > 0 {
+		setSyscallStats(true)
+	}
+	if debug.syscallwarn > 0 {
+		setSyscallWarning(int64(debug.syscallwarn) * 1e6)
+	}
+}
+
+func syscallStatsUpdate() {
+	if atomic.Load(&syscallstats.account) != 0 || atomic.Load64((*uint64)(unsafe.Pointer(&syscallstats.warnNS))) != 0 {
+		atomic.Store(&syscallstats.enabled, 1)
+	} else {
+		atomic.Store(&syscallstats.enabled, 0)
+	}
+}
+
+//go:linkname setSyscallStats runtime/debug.setSyscallStats
+func setSyscallStats(enable bool) (old bool) {
+	lock(&syscallstats.lock)
+	if enable && syscallstats.table == nil {
+		syscallstats.table = (*[syscallStatsMax]syscallStat)(persistentalloc(unsafe.Sizeof(*syscallstats.table), sys.CacheLineSize, &memstats.other_sys))
+	}
+	old = syscallstats.account != 0
+	if enable {
+		atomic.Store(&syscallstats.account, 1)
+	} else {
+		atomic.Store(&syscallstats.account, 0)
+	}
+	syscallStatsUpdate()
+	unlock(&syscallstats.lock)
+	return
+}
+
+//go:linkname setSyscallWarning runtime/debug.setSyscallWarning
+func setSyscallWarning(ns int64) (old int64) {
+	if ns < 0 {
+		ns = 0
+	}
+	lock(&syscallstats.lock)
+	old = syscallstats.warnNS
+	atomic.Store64((*uint64)(unsafe.Pointer(&syscallstats.warnNS)), uint64(ns))
+	syscallStatsUpdate()
+	unlock(&syscallstats.lock)
+	return
+}
+
+// syscallStatsNum returns the number of the system call about to be
+// made by pc, the caller of entersyscall, if that is syscall.Syscall
+// or syscall.Syscall6, or syscallStatsUnknown. arg is the word above
+// the caller's return address, where these functions, which have no
+// frame, keep their first argument, the number.
+func syscallStatsNum(pc, arg uintptr) uintptr {
+	if GOARCH != "amd64" && GOARCH != "386" {
+		return syscallStatsUnknown
+	}
+	f := findfunc(pc)
+	if !f.valid() {
+		return syscallStatsUnknown
+	}
+	e := &syscallstats.entries
+	if f.entry == atomic.Loaduintptr(&e[0]) || f.entry == atomic.Loaduintptr(&e[1]) {
+		return syscallStatsIndex(arg)
+	}
+	switch funcname(f) {
+	case "syscall.Syscall":
+		atomic.Storeuintptr(&e[0], f.entry)
+	case "syscall.Syscall6":
+		atomic.Storeuintptr(&e[1], f.entry)
+	default:
+		return syscallStatsUnknown
+	}
+	return syscallStatsIndex(arg)
+}
+
+// syscallStatsIndex returns the histogram for system call trap.
+//
+//go:nosplit
+func syscallStatsIndex(trap uintptr) uintptr {
+	if trap >= syscallStatsUnknown {
+		return syscallStatsUnknown
+	}
+	return trap
+}
+
+// syscallStatsBucket returns the latency bucket for a call that took
+// ns nanoseconds.
+//
+//go:nosplit
+func syscallStatsBucket(ns int64) int {
+	us := uint64(ns) / 1000
+	b := 0
+	for us != 0 && b < syscallStatsBuckets-1 {
+		us >>= 1
+		b++
+	}
+	return b
+}
+
+// syscallRecord accounts the system call gp is returning from. It is
+// called by exitsyscall with g.syscallwhen set, before exitsyscall
+// reacquires a P, so it must not split the stack or have write
+// barriers. With GODEBUG=tracesyscall=1, it leaves the duration in
+// gp.syscalldur for traceGoSysStat.
+//
+//go:nosplit
+//go:nowritebarrierrec
+func syscallRecord(gp *g) {
+	dur := nanotime() - gp.syscallwhen
+	if dur < 0 {
+		dur = 0
+	}
+	gp.syscallwhen = 0
+	gp.syscallwarned = false
+	t := syscallstats.table
+	if t == nil || atomic.Load(&syscallstats.account) == 0 {
+		return
+	}
+	if debug.tracesyscall != 0 {
+		gp.syscalldur = dur
+	}
+	s := &t[gp.syscallnum]
+	atomic.Xadd64(&s.count, 1)
+	atomic.Xadd64(&s.totalNS, int64(dur))
+	atomic.Xadd64(&s.buckets[syscallStatsBucket(dur)], 1)
+	for {
+		max := atomic.Load64(&s.maxNS)
+		if uint64(dur) <= max || atomic.Cas64(&s.maxNS, max, uint64(dur)) {
+			break
+		}
+	}
+}
+
+// syscallWarnCheck prints a warning for every goroutine that has been
+// in a system call for longer than the warning threshold. It is
+// called by sysmon.
+func syscallWarnCheck(now int64) {
+	threshold := int64(atomic.Load64((*uint64)(unsafe.Pointer(&syscallstats.warnNS))))
+	if threshold == 0 {
+		return
+	}
+	// Don't walk allgs more often than needed.
+	if !sysmonWarnDue(now, threshold, &syscallstats.lastwarn) {
+		return
+	}
+
+	lock(&allglock)
+	for _, gp := range allgs {
+		if readgstatus(gp)&^_Gscan != _Gsyscall || gp.syscallwarned {
+			continue
+		}
+		when := gp.syscallwhen
+		if when == 0 || now-when < threshold {
+			continue
+		}
+		// Holding the scan bit keeps gp from leaving the system
+		// call. If someone else holds it, try again next time.
+		sysmonWarnStack(gp, _Gsyscall, func() bool {
+			gp.syscallwarned = true
+			print("runtime: goroutine ", gp.goid, " in system call ")
+			if gp.syscallnum == syscallStatsUnknown {
+				print("(unknown)")
+			} else {
+				print(gp.syscallnum)
+			}
+			print(" for ", (now-when)/1e6, "ms\n")
+			return true
+		})
+	}
+	unlock(&allglock)
+}
+
+// traceGoSysStat emits the accounting of gp's last system call. It is
+// called once gp has a P again.
+func traceGoSysStat(gp *g) {
+	traceEvent(traceEvGoSysStat, -1, uint64(gp.goid), uint64(gp.syscallnum), uint64(gp.syscalldur))
+	gp.syscalldur = 0
+}
+
+//go:linkname readSyscallStats runtime/debug.readSyscallStats
+func readSyscallStats(stats *[]uint64) {
+	p := (*stats)[:0]
+	t := syscallstats.table
+	if t == nil {
+		*stats = p
+		return
+	}
+	// The counters are updated with atomics and read one by one,
+	// so a histogram may be slightly inconsistent with itself.
+	for i := range t {
+		s := &t[i]
+		count := atomic.Load64(&s.count)
+		if count == 0 {
+			continue
+		}
+		num := uint64(i)
+		if i == syscallStatsUnknown {
+			num = ^uint64(0)
+		}
+		p = append(p, num, count, atomic.Load64(&s.totalNS), atomic.Load64(&s.maxNS))
+		for j := range s.buckets {
+			p = append(p, atomic.Load64(&s.buckets[j]))
+		}
+	}
+	*stats = p
+}
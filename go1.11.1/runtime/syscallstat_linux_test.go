@@ -0,0 +1,143 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// syscallStat returns the statistics for system call number num.
+func syscallStat(num uintptr) debug.SyscallStats {
+	for _, s := range debug.ReadSyscallStats() {
+		if s.Num == num {
+			return s
+		}
+	}
+	return debug.SyscallStats{Num: num}
+}
+
+func TestSyscallStats(t *testing.T) {
+	defer debug.SetSyscallStats(debug.SetSyscallStats(true))
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const n = 100
+	before := syscallStat(syscall.SYS_READ)
+	buf := make([]byte, 1)
+	for i := 0; i < n; i++ {
+		f.Read(buf)
+	}
+	after := syscallStat(syscall.SYS_READ)
+
+	if after.Count-before.Count < n {
+		t.Errorf("%d reads counted, want at least %d", after.Count-before.Count, n)
+	}
+	if after.Total <= before.Total {
+		t.Errorf("total time did not grow: %v, then %v", before.Total, after.Total)
+	}
+	if after.Max < before.Max || after.Max > after.Total {
+		t.Errorf("max %v, was %v, total %v", after.Max, before.Max, after.Total)
+	}
+	var sum uint64
+	for _, c := range after.Buckets {
+		sum += c
+	}
+	if sum != after.Count {
+		t.Errorf("buckets hold %d calls, count is %d", sum, after.Count)
+	}
+
+	// Calls made while accounting is off are not counted.
+	debug.SetSyscallStats(false)
+	before = syscallStat(syscall.SYS_READ)
+	for i := 0; i < n; i++ {
+		f.Read(buf)
+	}
+	if after = syscallStat(syscall.SYS_READ); after.Count != before.Count {
+		t.Errorf("%d reads counted while accounting was off", after.Count-before.Count)
+	}
+}
+
+func TestSyscallStatsNumbers(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "386" {
+		t.Skipf("system call numbers are not known on %s", runtime.GOARCH)
+	}
+	defer debug.SetSyscallStats(debug.SetSyscallStats(true))
+
+	// Syscall and Syscall6 are accounted under their own numbers.
+	const n = 50
+	pid0, uid0 := syscallStat(syscall.SYS_GETPID), syscallStat(syscall.SYS_GETUID)
+	for i := 0; i < n; i++ {
+		syscall.Syscall(syscall.SYS_GETPID, 0, 0, 0)
+	}
+	for i := 0; i < 2*n; i++ {
+		syscall.Syscall6(syscall.SYS_GETUID, 0, 0, 0, 0, 0, 0)
+	}
+	pid1, uid1 := syscallStat(syscall.SYS_GETPID), syscallStat(syscall.SYS_GETUID)
+	if d := pid1.Count - pid0.Count; d < n || d >= 2*n {
+		t.Errorf("%d getpid calls counted, want %d", d, n)
+	}
+	if d := uid1.Count - uid0.Count; d < 2*n {
+		t.Errorf("%d getuid calls counted, want %d", d, 2*n)
+	}
+
+	stats := debug.ReadSyscallStats()
+	for i := 1; i < len(stats); i++ {
+		if stats[i].Num <= stats[i-1].Num {
+			t.Errorf("stats for %#x after %#x", stats[i].Num, stats[i-1].Num)
+		}
+	}
+}
+
+func TestSyscallStatsTrace(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "386" {
+		t.Skipf("system call numbers are not known on %s", runtime.GOARCH)
+	}
+	defer debug.SetSyscallStats(debug.SetSyscallStats(true))
+	defer runtime.SetTraceSyscall(runtime.SetTraceSyscall(true))
+	const n = 10
+	data := traceOf(t, func() {
+		for i := 0; i < n; i++ {
+			syscall.Syscall(syscall.SYS_GETPID, 0, 0, 0)
+		}
+	})
+
+	events, _ := parseTrace(t, data)
+	count := 0
+	for _, ev := range events {
+		if ev.typ == traceEvGoSysStat && ev.args[2] == syscall.SYS_GETPID {
+			count++
+		}
+	}
+	if count < n {
+		t.Errorf("%d syscall events for getpid, want at least %d", count, n)
+	}
+}
+
+func TestSyscallWarningHelper(t *testing.T) {
+	if !isHelper() {
+		t.Skip("helper process")
+	}
+	ts := syscall.NsecToTimespec(500e6)
+	syscall.Nanosleep(&ts, nil)
+}
+
+func TestSyscallWarning(t *testing.T) {
+	out, err := runHelper(t, "TestSyscallWarningHelper", "GODEBUG=syscallwarn=50")
+	if err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "in system call ") || !strings.Contains(out, "syscall.Nanosleep") {
+		t.Errorf("no warning for the slow system call:\n%s", out)
+	}
+}
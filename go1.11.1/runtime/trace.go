@@ -28,7 +28,7 @@ const (
 	traceEvProcStop          = 6  // stop of P [timestamp]
 	traceEvGCStart           = 7  // GC start [timestamp, seq, stack id]
 	traceEvGCDone            = 8  // GC done [timestamp]
-	traceEvGCSTWStart        = 9  // GC STW start [timestamp, kind]
+	traceEvGCSTWStart        = 9  // GC STW start [timestamp, kind: 0 mark termination, 1 sweep termination, 2 stopTheWorld outside the GC, only with GODEBUG=tracestw=1]
 	traceEvGCSTWDone         = 10 // GC STW done [timestamp]
 	traceEvGCSweepStart      = 11 // GC sweep start [timestamp, stack id]
 	traceEvGCSweepDone       = 12 // GC sweep done [timestamp, swept, reclaimed]
@@ -68,7 +68,11 @@ const (
 	traceEvUserTaskEnd       = 46 // end of a task [timestamp, internal task id, stack]
 	traceEvUserRegion        = 47 // trace.WithRegion [timestamp, internal task id, mode(0:start, 1:end), stack, name string]
 	traceEvUserLog           = 48 // trace.Log [timestamp, internal task id, key string id, stack, value string]
-	traceEvCount             = 49
+	traceEvGoSysStat         = 49 // syscall accounting, only with GODEBUG=tracesyscall=1 [timestamp, goroutine id, syscall number, duration]
+	traceEvGCPacerStart      = 50 // GC pacer cycle start, only with GODEBUG=tracepacer=1 [timestamp, heap_live, next_gc, heap_scan, assist work per byte, dedicated workers, fractional goal]
+	traceEvGCPacerRevise     = 51 // GC pacer revise, only with GODEBUG=tracepacer=1 [timestamp, heap_live, heap goal, heap_scan, scan work, expected scan work, assist work per byte]
+	traceEvGCPacerEndCycle   = 52 // GC pacer end of cycle, only with GODEBUG=tracepacer=1 [timestamp, heap_marked, trigger, heap_live, scan work, trigger ratio, goal growth, actual growth, utilization, next trigger ratio]
+	traceEvCount             = 53
 	// Byte is used but only 6 bits are available for event type.
 	// The remaining 2 bits are used to specify the number of arguments.
 	// That means, the max event type value is 63.
@@ -180,9 +184,15 @@ func traceBufPtrOf(b *traceBuf) traceBufPtr {
 // Most clients should use the runtime/trace package or the testing package's
 // -test.trace flag instead of calling StartTrace directly.
 func StartTrace() error {
+	// Format the profiling labels of existing goroutines while the
+	// world runs, since it allocates. Only labels set meanwhile are
+	// left for after the stop.
+	labels := traceFormatLabels(nil)
+
 	// Stop the world, so that we can take a consistent snapshot
 	// of all goroutines at the beginning of the trace.
 	stopTheWorld("start tracing")
+	labels = traceFormatLabels(labels)
 
 	// We are in stop-the-world, but syscalls can finish and write to trace concurrently.
 	// Exitsyscall could check trace.enabled long before and then suddenly wake up
@@ -260,6 +270,15 @@ func StartTrace() error {
 	for i, label := range gcMarkWorkerModeStrings[:] {
 		trace.markWorkerLabels[i], bufp = traceString(bufp, pid, label)
 	}
+
+	// Register profiling labels of existing goroutines. IDs from a
+	// previous trace are stale because the string table was reset.
+	for _, gp := range allgs {
+		gp.labelsTraceID = 0
+		if gp.labels != nil && readgstatus(gp) != _Gdead {
+			gp.labelsTraceID, bufp = traceString(bufp, pid, labels[gp.labels])
+		}
+	}
 	traceReleaseBuffer(pid)
 
 	unlock(&trace.bufLock)
@@ -272,8 +291,9 @@ func StartTrace() error {
 // StopTrace only returns after all the reads for the trace have completed.
 func StopTrace() {
 	// Stop the world so that we can collect the trace buffers from all p's below,
-	// and also to avoid races with traceEvent.
-	stopTheWorld("stop tracing")
+	// and also to avoid races with traceEvent. The stop is not traced, since
+	// the trace ends before the world starts again.
+	stopTheWorldNoTrace("stop tracing")
 
 	// See the comment in StartTrace.
 	lock(&trace.bufLock)
@@ -1056,6 +1076,11 @@ func traceGoStart() {
 	_g_.traceseq++
 	if _g_ == _p_.ptr().gcBgMarkWorker.ptr() {
 		traceEvent(traceEvGoStartLabel, -1, uint64(_g_.goid), _g_.traceseq, trace.markWorkerLabels[_p_.ptr().gcMarkWorkerMode])
+	} else if _g_.labelsTraceID != 0 {
+		// Goroutines with profiling labels always use the
+		// labelled form so every start carries its labels.
+		_g_.tracelastp = _p_
+		traceEvent(traceEvGoStartLabel, -1, uint64(_g_.goid), _g_.traceseq, _g_.labelsTraceID)
 	} else if _g_.tracelastp == _p_ {
 		traceEvent(traceEvGoStartLocal, -1, uint64(_g_.goid))
 	} else {
@@ -1064,6 +1089,50 @@ func traceGoStart() {
 	}
 }
 
+// traceFormatLabels adds the string form of the profiling labels of
+// every goroutine to labels, unless they are already there, and returns
+// labels, which is allocated if nil.
+func traceFormatLabels(labels map[unsafe.Pointer]string) map[unsafe.Pointer]string {
+	if labels == nil {
+		labels = make(map[unsafe.Pointer]string)
+	}
+	// allgs only grows, so a copy of the slice can be walked
+	// without the lock.
+	lock(&allglock)
+	gs := allgs
+	unlock(&allglock)
+	for _, gp := range gs {
+		l := gp.labels
+		if l == nil || readgstatus(gp) == _Gdead {
+			continue
+		}
+		if _, ok := labels[l]; !ok {
+			labels[l] = labelsString(l)
+		}
+	}
+	return labels
+}
+
+// traceLabelsID registers the string form of the profiling labels
+// with the tracer and returns its string ID, or 0 if labels is nil or
+// tracing is not enabled.
+func traceLabelsID(labels unsafe.Pointer) uint64 {
+	if labels == nil {
+		return 0
+	}
+	// Format before acquiring the buffer: allocation may emit
+	// trace events itself.
+	s := labelsString(labels)
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return 0
+	}
+	id, _ := traceString(bufp, pid, s)
+	traceReleaseBuffer(pid)
+	return id
+}
+
 func traceGoEnd() {
 	traceEvent(traceEvGoEnd, -1)
 }
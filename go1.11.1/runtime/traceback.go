@@ -908,6 +908,7 @@ func goroutineheader(gp *g) {
 	if gp.lockedm != 0 {
 		print(", locked to thread")
 	}
+	printlabels(gp)
 	print("]:\n")
 }
 
@@ -1023,6 +1024,14 @@ func isSystemGoroutine(gp *g) bool {
 		// back into user code.
 		return !fingRunning
 	}
+	if gp.startpc == funcPC(runcleanupq) {
+		// Likewise cleanup workers.
+		return !gp.cleanupRunning
+	}
+	if gp.startpc == funcPC(lockedThreadMain) {
+		// And LockedThread workers.
+		return !gp.lockedRunning
+	}
 	return hasprefix(funcname(f), "runtime.")
 }
 
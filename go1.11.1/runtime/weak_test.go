@@ -0,0 +1,144 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+type weakObj struct {
+	id   int
+	next *weakObj // has pointers, so it's not a tiny allocation
+}
+
+func TestWeakPointer(t *testing.T) {
+	p := &weakObj{id: 1}
+	w := debug.MakeWeak(p)
+	if w != debug.MakeWeak(p) {
+		t.Errorf("weak pointers to the same object are not equal")
+	}
+	if w == debug.MakeWeak(&weakObj{id: 2}) {
+		t.Errorf("weak pointers to different objects are equal")
+	}
+	runtime.GC()
+	if q := w.Value().(*weakObj); q != p || q.id != 1 {
+		t.Fatalf("weak pointer to a reachable object = %p, want %p", q, p)
+	}
+	runtime.KeepAlive(p)
+
+	p = nil
+	runtime.GC()
+	if q := w.Value().(*weakObj); q != nil {
+		t.Fatalf("weak pointer to an unreachable object = %p, want nil", q)
+	}
+	if w.Value() == nil {
+		t.Errorf("cleared weak pointer lost its type")
+	}
+
+	var zero debug.WeakPointer
+	if zero.Value() != nil {
+		t.Errorf("zero weak pointer = %v, want nil", zero.Value())
+	}
+	if q := debug.MakeWeak((*weakObj)(nil)).Value().(*weakObj); q != nil {
+		t.Errorf("weak nil pointer = %p, want nil", q)
+	}
+}
+
+func TestWeakPointerFinalizer(t *testing.T) {
+	var resurrected *weakObj
+	type result struct {
+		weak *weakObj
+	}
+	done := make(chan result)
+	p := &weakObj{id: 1}
+	w := debug.MakeWeak(p)
+	runtime.SetFinalizer(p, func(p *weakObj) {
+		// Weak pointers are cleared before the finalizer runs.
+		resurrected = p
+		done <- result{w.Value().(*weakObj)}
+	})
+	p = nil
+	runtime.GC()
+	select {
+	case r := <-done:
+		if r.weak != nil {
+			t.Fatalf("weak pointer not cleared before the finalizer ran")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("finalizer did not run")
+	}
+
+	// The finalizer resurrected the object, but not the weak
+	// pointer.
+	runtime.GC()
+	if resurrected.id != 1 {
+		t.Fatalf("resurrected object corrupted")
+	}
+	if q := w.Value().(*weakObj); q != nil {
+		t.Fatalf("weak pointer resurrected along with its object")
+	}
+
+	// A new weak pointer to the resurrected object works, and is
+	// cleared when it becomes unreachable again.
+	w2 := debug.MakeWeak(resurrected)
+	if w2 == w {
+		t.Errorf("new weak pointer to resurrected object equals the cleared one")
+	}
+	if q := w2.Value().(*weakObj); q != resurrected {
+		t.Fatalf("weak pointer to resurrected object = %p, want %p", q, resurrected)
+	}
+	resurrected = nil
+	runtime.GC()
+	if q := w2.Value().(*weakObj); q != nil {
+		t.Fatalf("weak pointer to unreachable resurrected object = %p, want nil", q)
+	}
+}
+
+func TestWeakMap(t *testing.T) {
+	var m debug.WeakMap
+	keys := make([]*weakObj, 10)
+	for i := range keys {
+		keys[i] = &weakObj{id: i}
+		m.Store(keys[i], i)
+	}
+	if n := m.Len(); n != len(keys) {
+		t.Fatalf("Len() = %d, want %d", n, len(keys))
+	}
+	if v, ok := m.Load(keys[3]); !ok || v.(int) != 3 {
+		t.Fatalf("Load(keys[3]) = %v, %v, want 3, true", v, ok)
+	}
+	if _, ok := m.Load(&weakObj{}); ok {
+		t.Fatalf("Load of a missing key succeeded")
+	}
+	m.Delete(keys[3])
+	if _, ok := m.Load(keys[3]); ok {
+		t.Fatalf("Load of a deleted key succeeded")
+	}
+
+	// Drop the odd keys.
+	for i := 1; i < len(keys); i += 2 {
+		keys[i] = nil
+	}
+	runtime.GC()
+	if n, want := m.Len(), 4; n != want {
+		t.Fatalf("Len() after the GC = %d, want %d", n, want)
+	}
+	seen := 0
+	m.Range(func(k, v interface{}) bool {
+		p := k.(*weakObj)
+		if p.id%2 != 0 || v.(int) != p.id || keys[p.id] != p {
+			t.Errorf("Range: unexpected entry %d: %v", p.id, v)
+		}
+		seen++
+		return true
+	})
+	if seen != 4 {
+		t.Errorf("Range saw %d entries, want 4", seen)
+	}
+	runtime.KeepAlive(keys)
+}